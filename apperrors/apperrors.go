@@ -0,0 +1,130 @@
+// Package apperrors defines the typed errors shared by the repositories,
+// services and controllers. Every error that should reach clients as anything
+// other than an internal error is an *Error, whose Kind decides how it is
+// reported. Callers classify errors with errors.Is and errors.As:
+//
+//	errors.Is(err, apperrors.NotFound)
+//	errors.Is(err, repositories.ErrUserNotFound)
+package apperrors
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-playground/validator"
+)
+
+// Kind classifies errors. A Kind is itself an error so that errors.Is(err,
+// kind) reports whether err is an *Error of that kind.
+type Kind string
+
+func (k Kind) Error() string { return string(k) }
+
+const (
+	Internal             Kind = "internal"
+	Invalid              Kind = "invalid"
+	Unauthenticated      Kind = "unauthenticated"
+	Forbidden            Kind = "forbidden"
+	NotFound             Kind = "not found"
+	Conflict             Kind = "conflict"
+	PreconditionFailed   Kind = "precondition failed"
+	PreconditionRequired Kind = "precondition required"
+	UnsupportedMediaType Kind = "unsupported media type"
+)
+
+// Error is a classified error. Message is safe to show to clients; Err, if
+// set, is the underlying cause and is not.
+type Error struct {
+	Kind    Kind
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+// FieldError describes why a single field failed validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// New returns an error of the given kind.
+func New(kind Kind, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+// Wrap returns an error of the given kind caused by err.
+func Wrap(err error, kind Kind, message string) *Error {
+	return &Error{Kind: kind, Message: message, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is reports whether target is the Kind of e.
+func (e *Error) Is(target error) bool {
+	kind, ok := target.(Kind)
+	return ok && kind == e.Kind
+}
+
+// KindOf returns the Kind of the first *Error in err's chain, or Internal.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return Internal
+}
+
+// PublicMessage returns the message of err that is safe to show to clients.
+// The outermost message describes the error best, but the cause of an *Error
+// is meant for logs, not clients, so an *Error reports only its Message. An
+// error without an *Error in its chain is internal and reports nothing of
+// itself.
+func PublicMessage(err error) string {
+	var e *Error
+	if !errors.As(err, &e) {
+		return "internal error"
+	}
+	if err == error(e) {
+		return e.Message
+	}
+	return err.Error()
+}
+
+// Validation converts the validator.ValidationErrors in err into an Invalid
+// error with one FieldError per failed field. Other errors are wrapped as
+// Invalid without field details.
+func Validation(err error) *Error {
+	e := Wrap(err, Invalid, "validation failed")
+	var errs validator.ValidationErrors
+	if errors.As(err, &errs) {
+		for _, fe := range errs {
+			e.Fields = append(e.Fields, FieldError{
+				Field:   fe.Field(),
+				Rule:    fe.Tag(),
+				Message: fieldMessage(fe),
+			})
+		}
+	}
+	return e
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(fe.Param()), ", ")
+	}
+	return fmt.Sprintf("failed the %q check", fe.Tag())
+}
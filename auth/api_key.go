@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// APIKeyPrefix starts every API key, which tells them apart from JWTs.
+const APIKeyPrefix = "usk_"
+
+// displayedKeyLength is the number of leading characters of a key kept in
+// plain text to identify it in listings.
+const displayedKeyLength = len(APIKeyPrefix) + 8
+
+// GenerateAPIKey returns a new random API key along with the prefix and hash
+// to store for it.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return key, key[:displayedKeyLength], HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hex-encoded SHA-256 hash under which key is stored.
+// API keys carry 256 bits of entropy, so a fast unsalted hash suffices.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// isAPIKey reports whether credential looks like an API key.
+func isAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeySet holds the JWT verification keys of a JSON Web Key Set (RFC 7517).
+// RSA keys verify RS256 tokens and symmetric ("oct") keys HS256 tokens.
+type KeySet struct {
+	keys map[string]verificationKey
+}
+
+type verificationKey struct {
+	alg string
+	key interface{}
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+// LoadKeySet reads a JWKS file.
+func LoadKeySet(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+	set, err := ParseKeySet(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS file %s: %w", path, err)
+	}
+	return set, nil
+}
+
+// ParseKeySet parses a JWKS document. Keys whose use is not "sig" are
+// ignored.
+func ParseKeySet(data []byte) (*KeySet, error) {
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	set := &KeySet{keys: map[string]verificationKey{}}
+	for i, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.verificationKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d (kid %q): %w", i, jwk.Kid, err)
+		}
+		if _, dup := set.keys[jwk.Kid]; dup {
+			return nil, fmt.Errorf("duplicate kid %q", jwk.Kid)
+		}
+		set.keys[jwk.Kid] = key
+	}
+	if len(set.keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	return set, nil
+}
+
+func (k jsonWebKey) verificationKey() (verificationKey, error) {
+	switch k.Kty {
+	case "RSA":
+		if k.Alg != "" && k.Alg != "RS256" {
+			return verificationKey{}, fmt.Errorf("unsupported algorithm %q for RSA key", k.Alg)
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return verificationKey{}, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return verificationKey{}, fmt.Errorf("invalid exponent: %w", err)
+		}
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return verificationKey{}, errors.New("invalid RSA public key")
+		}
+		return verificationKey{alg: "RS256", key: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}}, nil
+	case "oct":
+		if k.Alg != "" && k.Alg != "HS256" {
+			return verificationKey{}, fmt.Errorf("unsupported algorithm %q for symmetric key", k.Alg)
+		}
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil || len(secret) < 32 {
+			return verificationKey{}, errors.New("symmetric key must be at least 256 bits")
+		}
+		return verificationKey{alg: "HS256", key: secret}, nil
+	}
+	return verificationKey{}, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// keyFunc selects the key that verifies token, by kid when the token names
+// one and otherwise only if the set holds a single key. The key must be meant
+// for the token's algorithm, so that an RSA public key is never used as an
+// HMAC secret.
+func (s *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.keys[kid]
+	if !ok && kid == "" && len(s.keys) == 1 {
+		for _, only := range s.keys {
+			key, ok = only, true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.alg {
+		return nil, fmt.Errorf("signing key %q does not accept %s", kid, token.Method.Alg())
+	}
+	return key.key, nil
+}
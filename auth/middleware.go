@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/repositories"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderAPIKey carries an API key. API keys are also accepted as bearer
+// tokens.
+const HeaderAPIKey = "X-API-Key"
+
+var (
+	ErrMissingCredentials = apperrors.New(apperrors.Unauthenticated, "authentication required")
+	ErrInvalidCredentials = apperrors.New(apperrors.Unauthenticated, "invalid credentials")
+)
+
+// Authenticator verifies the credentials of a request.
+type Authenticator struct {
+	// APIKeys looks up API keys; nil disables API key authentication.
+	APIKeys repositories.APIKeyStore
+	// KeySet verifies JWTs; nil disables JWT authentication.
+	KeySet *KeySet
+	// Issuer and Audience, when set, are required in the iss and aud claims.
+	Issuer   string
+	Audience string
+	// Leeway is the clock skew tolerated when checking exp and nbf.
+	Leeway time.Duration
+	// Roles loads the role assignments of authenticated subjects; nil leaves
+	// principals without roles.
+	Roles repositories.RoleStore
+}
+
+// Authenticate returns the principal authenticated by the X-API-Key or
+// Authorization header of c, together with its roles.
+func (a *Authenticator) Authenticate(c echo.Context) (*Principal, error) {
+	header := c.Request().Header
+	return a.AuthenticateCredentials(c.Request().Context(), header.Get(HeaderAPIKey), header.Get(echo.HeaderAuthorization))
+}
+
+// AuthenticateCredentials returns the principal authenticated by an API key,
+// or else by an Authorization value carrying a bearer token, together with
+// its roles. It serves transports other than HTTP, which pass the values of
+// their own headers.
+func (a *Authenticator) AuthenticateCredentials(ctx context.Context, apiKey, authorization string) (*Principal, error) {
+	principal, err := a.authenticate(ctx, apiKey, authorization)
+	if err != nil || a.Roles == nil {
+		return principal, err
+	}
+	if principal.Roles, err = a.Roles.ListRoleAssignments(ctx, principal.Subject); err != nil {
+		return nil, err
+	}
+	return principal, nil
+}
+
+func (a *Authenticator) authenticate(ctx context.Context, apiKey, authorization string) (*Principal, error) {
+	credential := apiKey
+	if credential == "" {
+		scheme, token, ok := strings.Cut(authorization, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return nil, ErrMissingCredentials
+		}
+		credential = strings.TrimSpace(token)
+	}
+
+	if isAPIKey(credential) {
+		return a.authenticateAPIKey(ctx, credential)
+	}
+	return a.authenticateJWT(credential)
+}
+
+func (a *Authenticator) authenticateAPIKey(ctx context.Context, credential string) (*Principal, error) {
+	if a.APIKeys == nil {
+		return nil, ErrInvalidCredentials
+	}
+	key, err := a.APIKeys.GetAPIKeyByHash(ctx, HashAPIKey(credential))
+	if errors.Is(err, repositories.ErrAPIKeyNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{Subject: key.Subject, Method: MethodAPIKey, KeyID: strconv.Itoa(key.ID)}, nil
+}
+
+func (a *Authenticator) authenticateJWT(credential string) (*Principal, error) {
+	if a.KeySet == nil {
+		return nil, ErrInvalidCredentials
+	}
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "HS256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(a.Leeway),
+	}
+	if a.Issuer != "" {
+		options = append(options, jwt.WithIssuer(a.Issuer))
+	}
+	if a.Audience != "" {
+		options = append(options, jwt.WithAudience(a.Audience))
+	}
+
+	var claims jwt.RegisteredClaims
+	token, err := jwt.ParseWithClaims(credential, &claims, a.KeySet.keyFunc, options...)
+	if err != nil {
+		return nil, apperrors.Wrap(err, apperrors.Unauthenticated, ErrInvalidCredentials.Message)
+	}
+	if claims.Subject == "" {
+		return nil, apperrors.New(apperrors.Unauthenticated, "token has no subject")
+	}
+	kid, _ := token.Header["kid"].(string)
+	return &Principal{Subject: claims.Subject, Method: MethodJWT, KeyID: kid}, nil
+}
+
+// Middleware rejects requests that a does not authenticate and stores the
+// principal of the others on the context.
+func Middleware(a *Authenticator) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, err := a.Authenticate(c)
+			if err != nil {
+				return err
+			}
+			SetPrincipal(c, principal)
+			return next(c)
+		}
+	}
+}
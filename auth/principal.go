@@ -0,0 +1,58 @@
+// Package auth authenticates requests with API keys and JWT bearer tokens,
+// makes the authenticated Principal available to handlers and authorizes it
+// by the roles assigned to its subject.
+package auth
+
+import (
+	"context"
+
+	"user-service/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Authentication methods recorded on a Principal.
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// Subject identifies the caller: the subject of an API key or the sub
+	// claim of a JWT.
+	Subject string
+	// Method is MethodAPIKey or MethodJWT.
+	Method string
+	// KeyID is the ID of the API key, or the kid of the JWT signing key.
+	KeyID string
+	// Roles are the roles assigned to Subject.
+	Roles []models.RoleAssignment
+}
+
+const principalKey = "auth.principal"
+
+// SetPrincipal stores the authenticated principal on c.
+func SetPrincipal(c echo.Context, p *Principal) {
+	c.Set(principalKey, p)
+}
+
+// PrincipalFrom returns the principal stored on c, if any.
+func PrincipalFrom(c echo.Context) (*Principal, bool) {
+	p, ok := c.Get(principalKey).(*Principal)
+	return p, ok && p != nil
+}
+
+type principalContextKey struct{}
+
+// NewContext returns a copy of ctx carrying p, for transports without an
+// echo.Context.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, p)
+}
+
+// FromContext returns the principal carried by ctx, if any.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return p, ok && p != nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"user-service/config"
+	"user-service/db"
+	"user-service/repositories"
+	"user-service/services"
+)
+
+const apikeyUsage = "usage: user-service [flags] apikey create <subject> <name> | list | revoke <id>"
+
+// runAPIKey implements the apikey command, which manages API keys directly in
+// the configured database so that the first key can be minted before any
+// caller is able to authenticate.
+func runAPIKey(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(apikeyUsage)
+	}
+	if cfg.DB.Driver == "memory" {
+		return errors.New("the memory driver does not persist API keys")
+	}
+
+	database := db.Connect(cfg.DB)
+	defer database.Close()
+	var repo *repositories.APIKeyRepository
+	if cfg.DB.Driver == "postgres" {
+		repo = repositories.NewPostgresAPIKeyRepository(database)
+	} else {
+		repo = repositories.NewAPIKeyRepository(database)
+	}
+	service := services.NewAPIKeyService(repo)
+	ctx := context.Background()
+
+	switch args[0] {
+	case "create":
+		if len(args) != 3 {
+			return errors.New(apikeyUsage)
+		}
+		key, err := service.CreateAPIKey(ctx, args[1], args[2])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created API key %d for %s. Store it now; it cannot be shown again:\n%s\n", key.ID, key.Subject, key.Key)
+		return nil
+	case "list":
+		keys, err := service.ListAPIKeys(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tPREFIX\tSUBJECT\tNAME\tCREATED AT\tREVOKED AT")
+		for _, k := range keys {
+			revoked := "-"
+			if k.RevokedAt != nil {
+				revoked = k.RevokedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Prefix, k.Subject, k.Name,
+				k.CreatedAt.Format("2006-01-02 15:04:05 MST"), revoked)
+		}
+		return w.Flush()
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New(apikeyUsage)
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid API key ID %q", args[1])
+		}
+		if err := service.RevokeAPIKey(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked API key %d\n", id)
+		return nil
+	}
+	return errors.New(apikeyUsage)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"user-service/config"
+	"user-service/db"
+	"user-service/repositories"
+	"user-service/services"
+)
+
+const departmentUsage = "usage: user-service [flags] department map-users [-dry-run] [-map file.csv]"
+
+// runDepartment implements the department command. Its only subcommand,
+// map-users, is a one-time migration of the free-text departments that users
+// had before departments were managed: it creates a department for every
+// distinct value and points the users at it.
+func runDepartment(cfg *config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "map-users" {
+		return errors.New(departmentUsage)
+	}
+	fs := flag.NewFlagSet("map-users", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "print the mapping without applying it")
+	mapFile := fs.String("map", "", "CSV file of value,code pairs overriding the derived codes")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if cfg.DB.Driver == "memory" {
+		return errors.New("the memory driver has no existing users to map")
+	}
+
+	overrides := map[string]string{}
+	if *mapFile != "" {
+		var err error
+		if overrides, err = readDepartmentOverrides(*mapFile); err != nil {
+			return err
+		}
+	}
+
+	database := db.Connect(cfg.DB)
+	defer database.Close()
+	var repo *repositories.DepartmentRepository
+	if cfg.DB.Driver == "postgres" {
+		repo = repositories.NewPostgresDepartmentRepository(database)
+	} else {
+		repo = repositories.NewDepartmentRepository(database)
+	}
+	service := services.NewDepartmentService(repo)
+	ctx := context.Background()
+
+	plan, err := service.PlanUserDepartmentMapping(ctx, overrides)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VALUE\tCODE\tUSERS\tNEW DEPARTMENT")
+	for _, m := range plan {
+		created := ""
+		if m.Create {
+			created = m.Name
+		}
+		fmt.Fprintf(w, "%q\t%s\t%d\t%s\n", m.Value, m.Code, m.Users, created)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if *dryRun {
+		return nil
+	}
+
+	mapped, err := service.MapUserDepartments(ctx, plan)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Mapped the departments of %d user(s)\n", mapped)
+	return nil
+}
+
+func readDepartmentOverrides(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	overrides := map[string]string{}
+	for _, record := range records {
+		overrides[record[0]] = record[1]
+	}
+	return overrides, nil
+}
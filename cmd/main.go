@@ -1,37 +1,368 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	echoSwagger "github.com/swaggo/echo-swagger"
+	"user-service/auth"
+	"user-service/config"
 	"user-service/controllers"
 	"user-service/db"
 	_ "user-service/docs"
+	"user-service/migrations"
+	"user-service/models"
 	"user-service/repositories"
+	"user-service/rpc"
 	"user-service/services"
 
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"google.golang.org/grpc"
 )
 
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description API key or JWT as "Bearer <token>"
 func main() {
-	// Initialize SQLite database connection
-	database := db.ConnectDB()
-	defer database.Close()
+	cfg, args, err := config.Load(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	level, _ := config.ParseLevel(cfg.Log.Level)
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			if err := runMigrate(cfg, args[1:]); err != nil {
+				log.Fatal(err)
+			}
+		case "apikey":
+			if err := runAPIKey(cfg, args[1:]); err != nil {
+				log.Fatal(err)
+			}
+		case "department":
+			if err := runDepartment(cfg, args[1:]); err != nil {
+				log.Fatal(err)
+			}
+		case "role":
+			if err := runRole(cfg, args[1:]); err != nil {
+				log.Fatal(err)
+			}
+		default:
+			log.Fatalf("Unknown command %q", args[0])
+		}
+		return
+	}
+	serve(cfg)
+}
+
+func serve(cfg *config.Config) {
+	log.Printf("Effective configuration: %s", cfg)
 
 	// Set up repository and service
-	userRepo := repositories.NewUserRepository(database)
+	var userRepo repositories.UserStore
+	var apiKeyRepo repositories.APIKeyStore
+	var roleRepo repositories.RoleStore
+	var groupRepo repositories.GroupStore
+	var departmentRepo repositories.DepartmentStore
+	var transitionRepo repositories.TransitionStore
+	var unitOfWork repositories.UnitOfWork
+	var outboxRepo repositories.OutboxStore
+	var webhookRepo repositories.WebhookStore
+	var changeRepo repositories.ChangeStore
+	switch cfg.DB.Driver {
+	case "sqlite", "postgres":
+		database := db.Connect(cfg.DB)
+		defer database.Close()
+		migrator, err := migrations.New(database, cfg.DB.Driver)
+		if err != nil {
+			log.Fatal(err)
+		}
+		migrateOnStartup(cfg, migrator)
+		if cfg.DB.Driver == "postgres" {
+			userRepo = repositories.NewPostgresUserRepository(database)
+			apiKeyRepo = repositories.NewPostgresAPIKeyRepository(database)
+			roleRepo = repositories.NewPostgresRoleRepository(database)
+			groupRepo = repositories.NewPostgresGroupRepository(database)
+			departmentRepo = repositories.NewPostgresDepartmentRepository(database)
+			transitionRepo = repositories.NewPostgresTransitionRepository(database)
+			unitOfWork = repositories.NewPostgresTransactor(database)
+			outboxRepo = repositories.NewPostgresOutboxRepository(database)
+			webhookRepo = repositories.NewPostgresWebhookRepository(database)
+			changeRepo = repositories.NewPostgresChangeRepository(database)
+		} else {
+			userRepo = repositories.NewUserRepository(database)
+			apiKeyRepo = repositories.NewAPIKeyRepository(database)
+			roleRepo = repositories.NewRoleRepository(database)
+			groupRepo = repositories.NewGroupRepository(database)
+			departmentRepo = repositories.NewDepartmentRepository(database)
+			transitionRepo = repositories.NewTransitionRepository(database)
+			unitOfWork = repositories.NewTransactor(database)
+			outboxRepo = repositories.NewOutboxRepository(database)
+			webhookRepo = repositories.NewWebhookRepository(database)
+			changeRepo = repositories.NewChangeRepository(database)
+		}
+	case "memory":
+		log.Println("Using in-memory user store; data will not be persisted")
+		memoryUsers := repositories.NewMemoryUserRepository()
+		userRepo = memoryUsers
+		groupRepo = repositories.NewMemoryGroupRepository(memoryUsers)
+		departmentRepo = repositories.NewMemoryDepartmentRepository(memoryUsers)
+		apiKeyRepo = repositories.NewMemoryAPIKeyRepository()
+		roleRepo = repositories.NewMemoryRoleRepository()
+		memoryTransitions := repositories.NewMemoryTransitionRepository()
+		transitionRepo = memoryTransitions
+		unitOfWork = repositories.NewMemoryTransactor(memoryUsers, memoryTransitions)
+		outboxRepo = repositories.NewMemoryOutboxRepository(memoryUsers)
+		webhookRepo = repositories.NewMemoryWebhookRepository()
+		changeRepo = repositories.NewMemoryChangeRepository(memoryUsers)
+	}
 	userService := services.NewUserService(userRepo)
+	userService.Departments = departmentRepo
+	userService.Transitions = transitionRepo
+	userService.ImportBatchSize = cfg.Users.ImportBatchSize
+	userService.UnitOfWork = unitOfWork
+	apiKeyService := services.NewAPIKeyService(apiKeyRepo)
+	roleService := services.NewRoleService(roleRepo)
+	groupService := services.NewGroupService(groupRepo)
+	departmentService := services.NewDepartmentService(departmentRepo)
+	webhookService := services.NewWebhookService(webhookRepo)
+	webhookService.Client.Timeout = cfg.Webhooks.Timeout
+	webhookService.Workers = cfg.Webhooks.Workers
+	webhookService.MaxAttempts = cfg.Webhooks.MaxAttempts
+	webhookService.RetryDelay = cfg.Webhooks.RetryDelay
+	webhookService.MaxRetryDelay = cfg.Webhooks.MaxRetryDelay
+	webhookService.Retention = cfg.Webhooks.Retention
+	changeBroker := services.NewChangeBroker(changeRepo)
+	changeBroker.Buffer = cfg.Users.ChangesBuffer
 
 	// Initialize Echo
 	e := echo.New()
+	e.HideBanner = true
+	e.HTTPErrorHandler = controllers.ErrorHandler
+	e.Server.ReadTimeout = cfg.Server.ReadTimeout
+	e.Server.WriteTimeout = cfg.Server.WriteTimeout
+	e.Server.IdleTimeout = cfg.Server.IdleTimeout
+	// Request IDs are returned in X-Request-Id and recorded in the audit log
+	e.Use(middleware.RequestID())
+	timeout := controllers.Timeout(cfg.DB.RequestTimeout)
 
-	// Routes
+	// Routes; everything but the API docs requires authentication, and each
+	// route a permission granted by the caller's roles
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
-	e.GET("/users", controllers.GetUsers(userService))
-	e.POST("/users", controllers.CreateUser(userService))
-	e.PUT("/users/:id", controllers.UpdateUser(userService))
-	e.DELETE("/users/:id", controllers.DeleteUser(userService))
+	var authenticator *auth.Authenticator
+	authenticate := func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	require := auth.Require
+	if cfg.Auth.Enabled {
+		authenticator = newAuthenticator(cfg, apiKeyService, roleService)
+		authenticate = auth.Middleware(authenticator)
+	} else {
+		log.Println("Authentication is disabled; every route is open")
+		require = func(auth.Permission) echo.MiddlewareFunc {
+			return func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+		}
+	}
+	// The change feed streams for as long as the client stays, and exports
+	// for up to their own timeout; the request timeout would cut them off
+	// after their 200 has been sent
+	e.GET("/users/changes", controllers.StreamUserChanges(changeBroker), authenticate, require(auth.PermReadUsers))
+	e.GET("/users/export", controllers.ExportUsers(userService), controllers.Timeout(cfg.Users.ExportTimeout), authenticate, require(auth.PermReadUsers))
+	api := e.Group("", timeout, authenticate)
+	api.GET("/users", controllers.GetUsers(userService), require(auth.PermReadUsers))
+	api.POST("/users", controllers.CreateUser(userService), require(auth.PermWriteUsers))
+	api.POST("/users/import", controllers.ImportUsers(userService), require(auth.PermWriteUsers))
+	api.GET("/users/:id", controllers.GetUser(userService), require(auth.PermReadUsers))
+	api.PUT("/users/:id", controllers.UpdateUser(userService), require(auth.PermWriteUsers))
+	api.PATCH("/users/:id", controllers.PatchUser(userService), require(auth.PermWriteUsers))
+	api.DELETE("/users/:id", controllers.DeleteUser(userService), require(auth.PermWriteUsers))
+	api.POST("/users/:id/restore", controllers.RestoreUser(userService), require(auth.PermWriteUsers))
+	api.POST("/users/:id/activate", controllers.ActivateUser(userService), require(auth.PermWriteUsers))
+	api.POST("/users/:id/deactivate", controllers.DeactivateUser(userService), require(auth.PermWriteUsers))
+	api.POST("/users/:id/terminate", controllers.TerminateUser(userService), require(auth.PermWriteUsers))
+	api.GET("/users/:id/transitions", controllers.GetUserTransitions(userService), require(auth.PermReadUsers))
+	api.DELETE("/users/:id/transitions/:transition_id", controllers.CancelUserTransition(userService), require(auth.PermWriteUsers))
+	api.GET("/users/:id/history", controllers.GetUserHistory(userService), require(auth.PermReadAudit))
+	api.GET("/users/:id/groups", controllers.GetUserGroups(groupService), require(auth.PermReadUsers))
+	api.GET("/users/:id/chain", controllers.GetReportingChain(userService), require(auth.PermReadUsers))
+	api.GET("/users/:id/reports", controllers.GetDirectReports(userService), require(auth.PermReadUsers))
+	api.GET("/users/:id/subtree", controllers.GetSubtree(userService), require(auth.PermReadUsers))
+	api.GET("/org-chart", controllers.GetOrgChart(userService), require(auth.PermReadUsers))
+	api.GET("/departments", controllers.GetDepartments(departmentService), require(auth.PermReadUsers))
+	api.POST("/departments", controllers.CreateDepartment(departmentService), require(auth.PermManageDepartments))
+	api.GET("/departments/:code", controllers.GetDepartment(departmentService), require(auth.PermReadUsers))
+	api.PUT("/departments/:code", controllers.UpdateDepartment(departmentService), require(auth.PermManageDepartments))
+	api.DELETE("/departments/:code", controllers.DeleteDepartment(departmentService), require(auth.PermManageDepartments))
+	api.GET("/groups", controllers.GetGroups(groupService), require(auth.PermReadUsers))
+	api.POST("/groups", controllers.CreateGroup(groupService), require(auth.PermManageGroups))
+	api.GET("/groups/:id", controllers.GetGroup(groupService), require(auth.PermReadUsers))
+	api.PUT("/groups/:id", controllers.UpdateGroup(groupService), require(auth.PermManageGroups))
+	api.DELETE("/groups/:id", controllers.DeleteGroup(groupService), require(auth.PermManageGroups))
+	api.GET("/groups/:id/members", controllers.GetGroupMembers(groupService), require(auth.PermReadUsers))
+	api.PUT("/groups/:id/members/:user_id", controllers.AddGroupMember(groupService), require(auth.PermManageGroups))
+	api.DELETE("/groups/:id/members/:user_id", controllers.RemoveGroupMember(groupService), require(auth.PermManageGroups))
+	api.DELETE("/admin/users/:id", controllers.PurgeUser(userService), require(auth.PermPurgeUsers))
+	api.GET("/admin/api-keys", controllers.ListAPIKeys(apiKeyService), require(auth.PermManageAPIKeys))
+	api.POST("/admin/api-keys", controllers.CreateAPIKey(apiKeyService), require(auth.PermManageAPIKeys))
+	api.DELETE("/admin/api-keys/:id", controllers.RevokeAPIKey(apiKeyService), require(auth.PermManageAPIKeys))
+	api.GET("/admin/roles", controllers.ListRoleAssignments(roleService), require(auth.PermManageRoles))
+	api.POST("/admin/roles", controllers.AssignRole(roleService), require(auth.PermManageRoles))
+	api.DELETE("/admin/roles/:id", controllers.UnassignRole(roleService), require(auth.PermManageRoles))
+	api.GET("/audit", controllers.GetAuditEvents(userService), require(auth.PermReadAudit))
+	api.GET("/webhooks", controllers.ListWebhooks(webhookService), require(auth.PermManageWebhooks))
+	api.POST("/webhooks", controllers.CreateWebhook(webhookService), require(auth.PermManageWebhooks))
+	api.GET("/webhooks/:id", controllers.GetWebhook(webhookService), require(auth.PermManageWebhooks))
+	api.PUT("/webhooks/:id", controllers.UpdateWebhook(webhookService), require(auth.PermManageWebhooks))
+	api.DELETE("/webhooks/:id", controllers.DeleteWebhook(webhookService), require(auth.PermManageWebhooks))
+	api.GET("/webhooks/:id/deliveries", controllers.GetWebhookDeliveries(webhookService), require(auth.PermManageWebhooks))
+	api.POST("/webhooks/:id/deliveries/:delivery_id/redeliver", controllers.RedeliverWebhook(webhookService), require(auth.PermManageWebhooks))
+
+	// SCIM 2.0 provisioning, with errors in the SCIM format
+	scimService := services.NewSCIMService(userService, groupService)
+	scimAPI := e.Group("/scim/v2", timeout, controllers.SCIMErrors, authenticate)
+	scimAPI.GET("/ServiceProviderConfig", controllers.GetSCIMServiceProviderConfig)
+	scimAPI.GET("/Schemas", controllers.GetSCIMSchemas)
+	scimAPI.GET("/Schemas/:id", controllers.GetSCIMSchema)
+	scimAPI.GET("/ResourceTypes", controllers.GetSCIMResourceTypes)
+	scimAPI.GET("/ResourceTypes/:id", controllers.GetSCIMResourceType)
+	scimAPI.GET("/Users", controllers.GetSCIMUsers(scimService), require(auth.PermReadUsers))
+	scimAPI.POST("/Users", controllers.CreateSCIMUser(scimService), require(auth.PermWriteUsers))
+	scimAPI.GET("/Users/:id", controllers.GetSCIMUser(scimService), require(auth.PermReadUsers))
+	scimAPI.PUT("/Users/:id", controllers.ReplaceSCIMUser(scimService), require(auth.PermWriteUsers))
+	scimAPI.PATCH("/Users/:id", controllers.PatchSCIMUser(scimService), require(auth.PermWriteUsers))
+	scimAPI.DELETE("/Users/:id", controllers.DeleteSCIMUser(scimService), require(auth.PermWriteUsers))
+	scimAPI.GET("/Groups", controllers.GetSCIMGroups(scimService), require(auth.PermReadUsers))
+	scimAPI.POST("/Groups", controllers.CreateSCIMGroup(scimService), require(auth.PermManageGroups))
+	scimAPI.GET("/Groups/:id", controllers.GetSCIMGroup(scimService), require(auth.PermReadUsers))
+	scimAPI.PUT("/Groups/:id", controllers.ReplaceSCIMGroup(scimService), require(auth.PermManageGroups))
+	scimAPI.PATCH("/Groups/:id", controllers.PatchSCIMGroup(scimService), require(auth.PermManageGroups))
+	scimAPI.DELETE("/Groups/:id", controllers.DeleteSCIMGroup(scimService), require(auth.PermManageGroups))
+
+	// Start server and shut down gracefully on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.Users.PurgeRetention > 0 {
+		go userService.RunPurger(ctx, cfg.Users.PurgeInterval, cfg.Users.PurgeRetention)
+	}
+	if cfg.Users.TransitionInterval > 0 {
+		go userService.RunStatusScheduler(ctx, cfg.Users.TransitionInterval)
+	}
+	// The relay always runs, since webhooks can be subscribed at any time
+	sinks := map[string]services.EventSink{"webhooks": webhookService.Sink()}
+	if sink := newEventSink(cfg); sink != nil {
+		sinks["events"] = sink
+	}
+	relay := services.NewEventRelay(outboxRepo, sinks)
+	relay.BatchSize = cfg.Events.BatchSize
+	relay.Retention = cfg.Events.Retention
+	go relay.Run(ctx, cfg.Events.Interval)
+	go webhookService.Run(ctx, cfg.Webhooks.Interval)
+	go changeBroker.Run(ctx, cfg.Users.ChangesInterval)
+	go func() {
+		if err := e.Start(cfg.Server.Addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// The gRPC API shares the services, authenticator and request timeout
+	// of the REST API, on a port of its own
+	var grpcServer *grpc.Server
+	if cfg.Server.GRPCAddr != "" {
+		grpcServer = rpc.NewServer(userService, changeBroker, rpc.Options{
+			Authenticator: authenticator,
+			Timeout:       cfg.DB.RequestTimeout,
+		})
+		lis, err := net.Listen("tcp", cfg.Server.GRPCAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("gRPC server started on %s", lis.Addr())
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if grpcServer != nil {
+		go func() {
+			// Calls still running when the shutdown times out are cut off
+			<-shutdownCtx.Done()
+			grpcServer.Stop()
+		}()
+	}
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown error: %v", err)
+	}
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+}
+
+// newEventSink returns the sink configured to receive user change events, or
+// nil if there is none.
+func newEventSink(cfg *config.Config) services.EventSink {
+	switch cfg.Events.Sink {
+	case "file":
+		sink, err := services.NewFileSink(cfg.Events.File)
+		if err != nil {
+			log.Fatalf("Failed to open event file: %v", err)
+		}
+		return sink
+	case "http":
+		return services.NewHTTPSink(cfg.Events.URL, cfg.Events.Timeout)
+	}
+	return nil
+}
 
-	// Start server
-	log.Fatal(e.Start(":3002"))
+// newAuthenticator builds the authenticator configured by cfg. With the memory
+// driver no API key can exist yet, so an admin key is minted and logged at
+// startup.
+func newAuthenticator(cfg *config.Config, apiKeys *services.APIKeyService, roles *services.RoleService) *auth.Authenticator {
+	authenticator := &auth.Authenticator{
+		APIKeys:  apiKeys.Repo,
+		Roles:    roles.Repo,
+		Issuer:   cfg.Auth.JWTIssuer,
+		Audience: cfg.Auth.JWTAudience,
+		Leeway:   cfg.Auth.JWTLeeway,
+	}
+	if cfg.Auth.JWKSFile != "" {
+		keySet, err := auth.LoadKeySet(cfg.Auth.JWKSFile)
+		if err != nil {
+			log.Fatalf("Failed to load JWKS: %v", err)
+		}
+		authenticator.KeySet = keySet
+	}
+	if cfg.DB.Driver == "memory" {
+		ctx := context.Background()
+		key, err := apiKeys.CreateAPIKey(ctx, "admin", "bootstrap")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := roles.AssignRole(ctx, &models.RoleAssignment{Subject: "admin", Role: models.RoleAdmin}); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Minted in-memory API key for subject admin: %s", key.Key)
+	}
+	return authenticator
 }
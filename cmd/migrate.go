@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"user-service/config"
+	"user-service/db"
+	"user-service/migrations"
+)
+
+const migrateUsage = "usage: user-service [flags] migrate up | down [n] | status | goto <version>"
+
+// runMigrate implements the migrate command against the configured database.
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	if cfg.DB.Driver == "memory" {
+		return errors.New("the memory driver has no schema to migrate")
+	}
+
+	database := db.Connect(cfg.DB)
+	defer database.Close()
+	migrator, err := migrations.New(database, cfg.DB.Driver)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	switch args[0] {
+	case "up":
+		count, err = migrator.Up()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		count, err = migrator.Down(steps)
+	case "goto":
+		if len(args) < 2 {
+			return errors.New(migrateUsage)
+		}
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		count, err = migrator.Goto(version)
+	case "status":
+		return printMigrationStatus(migrator)
+	default:
+		return errors.New(migrateUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err := migrator.Version()
+	if err != nil {
+		return err
+	}
+	log.Printf("Ran %d migration(s); schema is at version %d", count, version)
+	return nil
+}
+
+func printMigrationStatus(migrator *migrations.Migrator) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range statuses {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	return w.Flush()
+}
+
+// migrateOnStartup applies pending migrations when auto-migration is enabled,
+// and otherwise warns if the schema is behind.
+func migrateOnStartup(cfg *config.Config, migrator *migrations.Migrator) {
+	if cfg.DB.AutoMigrate {
+		count, err := migrator.Up()
+		if err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+		log.Printf("Applied %d migration(s)", count)
+		return
+	}
+
+	version, err := migrator.Version()
+	if err != nil {
+		log.Fatalf("Failed to read schema version: %v", err)
+	}
+	if version < migrator.Latest() {
+		log.Printf("Database schema is at version %d but %d is available; run \"migrate up\" or set db.auto_migrate", version, migrator.Latest())
+	}
+}
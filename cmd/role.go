@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"user-service/config"
+	"user-service/db"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/services"
+)
+
+const roleUsage = "usage: user-service [flags] role assign <subject> <role> [department] | list [subject] | unassign <id>"
+
+// runRole implements the role command, which manages role assignments
+// directly in the configured database so that the first administrator can be
+// appointed.
+func runRole(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(roleUsage)
+	}
+	if cfg.DB.Driver == "memory" {
+		return errors.New("the memory driver does not persist role assignments")
+	}
+
+	database := db.Connect(cfg.DB)
+	defer database.Close()
+	var repo *repositories.RoleRepository
+	if cfg.DB.Driver == "postgres" {
+		repo = repositories.NewPostgresRoleRepository(database)
+	} else {
+		repo = repositories.NewRoleRepository(database)
+	}
+	service := services.NewRoleService(repo)
+	ctx := context.Background()
+
+	switch args[0] {
+	case "assign":
+		if len(args) < 3 || len(args) > 4 {
+			return errors.New(roleUsage)
+		}
+		assignment := models.RoleAssignment{Subject: args[1], Role: args[2]}
+		if len(args) == 4 {
+			assignment.Department = args[3]
+		}
+		if err := service.AssignRole(ctx, &assignment); err != nil {
+			return err
+		}
+		fmt.Printf("Assigned role %s to %s (assignment %d)\n", assignment.Role, assignment.Subject, assignment.ID)
+		return nil
+	case "list":
+		subject := ""
+		if len(args) > 1 {
+			subject = args[1]
+		}
+		assignments, err := service.ListRoleAssignments(ctx, subject)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSUBJECT\tROLE\tDEPARTMENT\tCREATED AT")
+		for _, a := range assignments {
+			department := a.Department
+			if department == "" {
+				department = "*"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", a.ID, a.Subject, a.Role, department,
+				a.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+		}
+		return w.Flush()
+	case "unassign":
+		if len(args) != 2 {
+			return errors.New(roleUsage)
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid role assignment ID %q", args[1])
+		}
+		if err := service.UnassignRole(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("Removed role assignment %d\n", id)
+		return nil
+	}
+	return errors.New(roleUsage)
+}
@@ -0,0 +1,254 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix prefixes the environment variable of every setting, e.g.
+// USER_SERVICE_DB_DSN for db.dsn.
+const EnvPrefix = "USER_SERVICE_"
+
+// setting binds a dotted configuration key to a field of Config. The same key
+// is used in configuration files, and is mapped to an environment variable
+// and a command-line flag by envName and flagName.
+type setting struct {
+	key    string
+	usage  string
+	target any // *string, *int, *bool or *time.Duration
+	redact func(string) string
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "server.addr", usage: "address to listen on", target: &c.Server.Addr},
+		{key: "server.grpc_addr", usage: "address the gRPC API listens on (empty disables it)", target: &c.Server.GRPCAddr},
+		{key: "server.read_timeout", usage: "maximum duration for reading a request", target: &c.Server.ReadTimeout},
+		{key: "server.write_timeout", usage: "maximum duration for writing a response", target: &c.Server.WriteTimeout},
+		{key: "server.idle_timeout", usage: "maximum keep-alive idle time", target: &c.Server.IdleTimeout},
+		{key: "server.shutdown_timeout", usage: "grace period for in-flight requests on shutdown", target: &c.Server.ShutdownTimeout},
+		{key: "db.driver", usage: "storage backend: sqlite, postgres or memory", target: &c.DB.Driver},
+		{key: "db.dsn", usage: "data source name of the database", target: &c.DB.DSN, redact: redactDSN},
+		{key: "db.max_open_conns", usage: "maximum open database connections (0 is unlimited)", target: &c.DB.MaxOpenConns},
+		{key: "db.max_idle_conns", usage: "maximum idle database connections", target: &c.DB.MaxIdleConns},
+		{key: "db.conn_max_lifetime", usage: "maximum lifetime of a database connection (0 is unlimited)", target: &c.DB.ConnMaxLifetime},
+		{key: "db.conn_max_idle_time", usage: "maximum idle time of a database connection (0 is unlimited)", target: &c.DB.ConnMaxIdleTime},
+		{key: "db.auto_migrate", usage: "apply pending schema migrations at startup", target: &c.DB.AutoMigrate},
+		{key: "db.request_timeout", usage: "cancel the database work of a request after this long (0 is unlimited)", target: &c.DB.RequestTimeout},
+		{key: "users.purge_retention", usage: "purge soft-deleted users after this long (0 disables purging)", target: &c.Users.PurgeRetention},
+		{key: "users.purge_interval", usage: "how often to purge soft-deleted users", target: &c.Users.PurgeInterval},
+		{key: "users.transition_interval", usage: "how often to apply scheduled status transitions (0 disables the scheduler)", target: &c.Users.TransitionInterval},
+		{key: "users.import_batch_size", usage: "writes per transaction of bulk imports (0 applies each import in one)", target: &c.Users.ImportBatchSize},
+		{key: "users.export_timeout", usage: "cancel exports still running after this long (0 is unlimited)", target: &c.Users.ExportTimeout},
+		{key: "users.changes_interval", usage: "how often to poll the change feed for streaming clients", target: &c.Users.ChangesInterval},
+		{key: "users.changes_buffer", usage: "changes a streaming client can fall behind by before it catches up from the database", target: &c.Users.ChangesBuffer},
+		{key: "events.sink", usage: "where to deliver user change events: file, http or empty for none", target: &c.Events.Sink},
+		{key: "events.file", usage: "NDJSON file the file sink appends events to", target: &c.Events.File},
+		{key: "events.url", usage: "URL the http sink posts events to", target: &c.Events.URL, redact: redactDSN},
+		{key: "events.timeout", usage: "timeout of the http sink's requests", target: &c.Events.Timeout},
+		{key: "events.interval", usage: "how often to deliver new events", target: &c.Events.Interval},
+		{key: "events.batch_size", usage: "events read from the outbox at a time", target: &c.Events.BatchSize},
+		{key: "events.retention", usage: "keep delivered events in the outbox this long (0 keeps them)", target: &c.Events.Retention},
+		{key: "webhooks.timeout", usage: "timeout of each delivery to a webhook", target: &c.Webhooks.Timeout},
+		{key: "webhooks.interval", usage: "how often to attempt due webhook deliveries", target: &c.Webhooks.Interval},
+		{key: "webhooks.workers", usage: "webhook deliveries attempted at once", target: &c.Webhooks.Workers},
+		{key: "webhooks.max_attempts", usage: "attempts of a webhook delivery before it is dead-lettered", target: &c.Webhooks.MaxAttempts},
+		{key: "webhooks.retry_delay", usage: "delay before the first retry of a webhook delivery, doubled for each further one", target: &c.Webhooks.RetryDelay},
+		{key: "webhooks.max_retry_delay", usage: "longest delay between retries of a webhook delivery", target: &c.Webhooks.MaxRetryDelay},
+		{key: "webhooks.retention", usage: "keep finished webhook deliveries this long (0 keeps them)", target: &c.Webhooks.Retention},
+		{key: "auth.enabled", usage: "require an API key or JWT on every API request", target: &c.Auth.Enabled},
+		{key: "auth.jwks_file", usage: "JSON Web Key Set file used to verify JWTs", target: &c.Auth.JWKSFile},
+		{key: "auth.jwt_issuer", usage: "required iss claim of JWTs", target: &c.Auth.JWTIssuer},
+		{key: "auth.jwt_audience", usage: "required aud claim of JWTs", target: &c.Auth.JWTAudience},
+		{key: "auth.jwt_leeway", usage: "clock skew tolerated when checking JWT expiry", target: &c.Auth.JWTLeeway},
+		{key: "log.level", usage: "log level: debug, info, warn or error", target: &c.Log.Level},
+	}
+}
+
+func (s setting) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(s.key, ".", "_"))
+}
+
+func (s setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.key)
+}
+
+func (s setting) set(value string) error {
+	switch t := s.target.(type) {
+	case *string:
+		*t = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid integer %q", s.key, value)
+		}
+		*t = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", s.key, value)
+		}
+		*t = b
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid duration %q", s.key, value)
+		}
+		*t = d
+	default:
+		panic(fmt.Sprintf("config: unsupported type %T for %s", s.target, s.key))
+	}
+	return nil
+}
+
+func (s setting) String() string {
+	switch t := s.target.(type) {
+	case *string:
+		return *t
+	case *int:
+		return strconv.Itoa(*t)
+	case *bool:
+		return strconv.FormatBool(*t)
+	case *time.Duration:
+		return t.String()
+	}
+	return ""
+}
+
+// Load builds the configuration from, in increasing order of precedence,
+// built-in defaults, an optional YAML or TOML file, USER_SERVICE_* environment
+// variables and command-line flags. The file is named by the -config flag or
+// the USER_SERVICE_CONFIG environment variable. Load validates the result and
+// returns it along with the arguments remaining after the flags. It returns
+// flag.ErrHelp if args ask for usage.
+func Load(name string, args []string) (*Config, []string, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(EnvPrefix+"CONFIG"), "path to a YAML or TOML configuration file")
+	flagValues := map[string]string{}
+	for _, s := range settings {
+		_, isBool := s.target.(*bool)
+		fs.Var(&flagValue{key: s.key, values: flagValues, isBool: isBool},
+			s.flagName(), fmt.Sprintf("%s (env %s, default %q)", s.usage, s.envName(), s.String()))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *configFile != "" {
+		fileValues, err := readFile(*configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := apply(settings, fileValues, *configFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	envValues := map[string]string{}
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.envName()); ok {
+			envValues[s.key] = value
+		}
+	}
+	if err := apply(settings, envValues, "environment"); err != nil {
+		return nil, nil, err
+	}
+	if err := apply(settings, flagValues, "flags"); err != nil {
+		return nil, nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return &cfg, fs.Args(), nil
+}
+
+// flagValue records a flag's raw value so that flags can be applied after
+// the configuration file and environment, whatever their position.
+type flagValue struct {
+	key    string
+	values map[string]string
+	isBool bool
+}
+
+func (f *flagValue) String() string { return "" }
+
+func (f *flagValue) Set(value string) error {
+	f.values[f.key] = value
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool { return f.isBool }
+
+func apply(settings []setting, values map[string]string, source string) error {
+	byKey := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s, ok := byKey[key]
+		if !ok {
+			return fmt.Errorf("%s: unknown setting %q", source, key)
+		}
+		if err := s.set(values[key]); err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+	}
+	return nil
+}
+
+// readFile parses a YAML or TOML file, chosen by extension, into a flat map of
+// dotted keys to string values.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var tree map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("unsupported config file extension %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	flatten("", tree, values)
+	return values, nil
+}
+
+func flatten(prefix string, tree map[string]any, out map[string]string) {
+	for key, value := range tree {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]any); ok {
+			flatten(key, nested, out)
+			continue
+		}
+		out[key] = fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"user-service/auth"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// API keys are the keys to every other route, so the handlers below check
+// the permission to manage them themselves instead of trusting the routes
+// they are mounted on to.
+
+// apiKeyRequest is the body of CreateAPIKey.
+type apiKeyRequest struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+}
+
+// @Summary Create an API key
+// @Description Mint an API key that authenticates as the given subject. The key is only returned in this response.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param key body controllers.apiKeyRequest true "Name and subject of the key"
+// @Success 201 {object} models.NewAPIKey
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/api-keys [post]
+func CreateAPIKey(service *services.APIKeyService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := auth.Check(c, auth.PermManageAPIKeys); err != nil {
+			return err
+		}
+		var req apiKeyRequest
+		if err := c.Bind(&req); err != nil {
+			return invalidInput(err)
+		}
+
+		key, err := service.CreateAPIKey(c.Request().Context(), req.Subject, req.Name)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusCreated, key)
+	}
+}
+
+// @Summary List API keys
+// @Description List all API keys, including revoked ones. Secrets are never returned.
+// @Tags Admin
+// @Produce json
+// @Success 200 {array} models.APIKey
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/api-keys [get]
+func ListAPIKeys(service *services.APIKeyService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := auth.Check(c, auth.PermManageAPIKeys); err != nil {
+			return err
+		}
+		keys, err := service.ListAPIKeys(c.Request().Context())
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, keys)
+	}
+}
+
+// @Summary Revoke an API key
+// @Description Revoke an API key so that it no longer authenticates.
+// @Tags Admin
+// @Produce json
+// @Param id path int true "API key ID"
+// @Success 204
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/api-keys/{id} [delete]
+func RevokeAPIKey(service *services.APIKeyService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := auth.Check(c, auth.PermManageAPIKeys); err != nil {
+			return err
+		}
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return invalidParam("API key ID")
+		}
+
+		if err := service.RevokeAPIKey(c.Request().Context(), id); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"user-service/auth"
+	"user-service/models"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// headerActor names the caller that changes are attributed to in the audit
+// log when the request is not authenticated.
+const headerActor = "X-Actor"
+
+// auditContext identifies the caller and request of c for the audit log. The
+// actor is the authenticated principal, or else the X-Actor header. The
+// request ID is the one assigned by the RequestID middleware, or else the one
+// sent by the client.
+func auditContext(c echo.Context) models.AuditContext {
+	requestID := c.Response().Header().Get(echo.HeaderXRequestID)
+	if requestID == "" {
+		requestID = c.Request().Header.Get(echo.HeaderXRequestID)
+	}
+	actor := c.Request().Header.Get(headerActor)
+	if principal, ok := auth.PrincipalFrom(c); ok {
+		actor = principal.Subject
+	}
+	return models.AuditContext{
+		Actor:     actor,
+		RequestID: requestID,
+	}
+}
+
+// timeQueryParam parses an optional RFC 3339 query parameter, returning the
+// zero time when it is absent.
+func timeQueryParam(c echo.Context, name string) (time.Time, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// @Summary Get the history of a user
+// @Description List the audit events of a user, newest first, including those of deleted and purged users
+// @Tags Audit
+// @Produce json
+// @Param id path int true "User ID"
+// @Param limit query int false "Page size (default 50, max 500)"
+// @Param offset query int false "Number of events to skip"
+// @Success 200 {object} models.AuditPage
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id}/history [get]
+func GetUserHistory(service *services.UserService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := userIDParam(c)
+		if err != nil {
+			return err
+		}
+		limit, err := intQueryParam(c, "limit")
+		if err != nil || limit < 0 {
+			return invalidParam("limit")
+		}
+		offset, err := intQueryParam(c, "offset")
+		if err != nil || offset < 0 {
+			return invalidParam("offset")
+		}
+
+		page, err := service.UserHistory(c.Request().Context(), userID, limit, offset)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, page)
+	}
+}
+
+// @Summary List audit events
+// @Description List audit events of all users, newest first. Use field=status&status=T to find terminations.
+// @Tags Audit
+// @Produce json
+// @Param limit query int false "Page size (default 50, max 500)"
+// @Param offset query int false "Number of events to skip"
+// @Param user_id query int false "Filter by user ID"
+// @Param actor query string false "Filter by actor"
+// @Param operation query string false "Filter by operation" Enums(create, update, delete, restore, purge)
+// @Param request_id query string false "Filter by request ID"
+// @Param field query string false "Only events that changed this field" Enums(user_name, email, first_name, last_name, status, department, deleted_at)
+// @Param status query string false "Only events that left the user with this status" Enums(A, I, T)
+// @Param since query string false "Only events at or after this RFC 3339 time"
+// @Param until query string false "Only events before this RFC 3339 time"
+// @Success 200 {object} models.AuditPage
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /audit [get]
+func GetAuditEvents(service *services.UserService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		params := models.AuditListParams{
+			Actor:     c.QueryParam("actor"),
+			Operation: c.QueryParam("operation"),
+			RequestID: c.QueryParam("request_id"),
+			Field:     c.QueryParam("field"),
+			Status:    c.QueryParam("status"),
+		}
+		var err error
+		if params.Limit, err = intQueryParam(c, "limit"); err != nil || params.Limit < 0 {
+			return invalidParam("limit")
+		}
+		if params.Offset, err = intQueryParam(c, "offset"); err != nil || params.Offset < 0 {
+			return invalidParam("offset")
+		}
+		if params.UserID, err = intQueryParam(c, "user_id"); err != nil {
+			return invalidParam("user_id")
+		}
+		if params.Since, err = timeQueryParam(c, "since"); err != nil {
+			return invalidParam("since")
+		}
+		if params.Until, err = timeQueryParam(c, "until"); err != nil {
+			return invalidParam("until")
+		}
+
+		page, err := service.ListAuditEvents(c.Request().Context(), params)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, page)
+	}
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"net/http"
+
+	"user-service/models"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// @Summary List departments
+// @Description List all departments ordered by code
+// @Tags Departments
+// @Produce json
+// @Success 200 {array} models.Department
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /departments [get]
+func GetDepartments(service *services.DepartmentService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		departments, err := service.ListDepartments(c.Request().Context())
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, departments)
+	}
+}
+
+// @Summary Get a department
+// @Description Get a single department by code
+// @Tags Departments
+// @Produce json
+// @Param code path string true "Department code"
+// @Success 200 {object} models.Department
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /departments/{code} [get]
+func GetDepartment(service *services.DepartmentService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		department, err := service.GetDepartment(c.Request().Context(), c.Param("code"))
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, department)
+	}
+}
+
+// @Summary Create a department
+// @Description Create a department, optionally below a parent department
+// @Tags Departments
+// @Accept json
+// @Produce json
+// @Param department body models.Department true "Department data"
+// @Success 201 {object} models.Department
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /departments [post]
+func CreateDepartment(service *services.DepartmentService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var department models.Department
+		if err := c.Bind(&department); err != nil {
+			return invalidInput(err)
+		}
+
+		if err := service.CreateDepartment(c.Request().Context(), &department); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusCreated, department)
+	}
+}
+
+// @Summary Update a department
+// @Description Change the name, parent or cost center of a department. The code cannot be changed.
+// @Tags Departments
+// @Accept json
+// @Produce json
+// @Param code path string true "Department code"
+// @Param department body models.Department true "Department data"
+// @Success 200 {object} models.Department
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /departments/{code} [put]
+func UpdateDepartment(service *services.DepartmentService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var department models.Department
+		if err := c.Bind(&department); err != nil {
+			return invalidInput(err)
+		}
+		department.Code = c.Param("code")
+
+		if err := service.UpdateDepartment(c.Request().Context(), &department); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, department)
+	}
+}
+
+// @Summary Delete a department
+// @Description Delete a department that no user or sub-department refers to
+// @Tags Departments
+// @Produce json
+// @Param code path string true "Department code"
+// @Success 204
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /departments/{code} [delete]
+func DeleteDepartment(service *services.DepartmentService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := service.DeleteDepartment(c.Request().Context(), c.Param("code")); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"user-service/apperrors"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MIMEProblemJSON is the media type of RFC 7807 problem details.
+const MIMEProblemJSON = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object. Errors lists the fields
+// that failed validation.
+type Problem struct {
+	Type     string                 `json:"type"`
+	Title    string                 `json:"title"`
+	Status   int                    `json:"status"`
+	Detail   string                 `json:"detail,omitempty"`
+	Instance string                 `json:"instance,omitempty"`
+	Errors   []apperrors.FieldError `json:"errors,omitempty"`
+}
+
+// statusByKind maps each error kind to its HTTP status.
+var statusByKind = map[apperrors.Kind]int{
+	apperrors.Internal:             http.StatusInternalServerError,
+	apperrors.Invalid:              http.StatusBadRequest,
+	apperrors.Unauthenticated:      http.StatusUnauthorized,
+	apperrors.Forbidden:            http.StatusForbidden,
+	apperrors.NotFound:             http.StatusNotFound,
+	apperrors.Conflict:             http.StatusConflict,
+	apperrors.PreconditionFailed:   http.StatusPreconditionFailed,
+	apperrors.PreconditionRequired: http.StatusPreconditionRequired,
+	apperrors.UnsupportedMediaType: http.StatusUnsupportedMediaType,
+}
+
+// ErrorHandler is the Echo HTTPErrorHandler. It writes err as
+// application/problem+json: *apperrors.Error by its kind, *echo.HTTPError by
+// its code, and anything else as an internal error whose details are logged
+// but not returned. Errors of responses that have already been sent, such as
+// a stream failing halfway, can only be logged.
+func ErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		slog.Error("request failed after its response was sent", "method", c.Request().Method, "path", c.Request().URL.Path, "error", err)
+		return
+	}
+
+	err = requestError(c, err)
+	problem := newProblem(err)
+	problem.Instance = c.Request().URL.Path
+	if problem.Status == http.StatusUnauthorized {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+	}
+	logProblem(c, problem, err)
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(problem.Status)
+	} else {
+		err = writeProblem(c, problem)
+	}
+	if err != nil {
+		slog.Error("failed to write error response", "error", err)
+	}
+}
+
+// logProblem logs err, which problem describes, if it is a server error.
+func logProblem(c echo.Context, problem Problem, err error) {
+	if problem.Status >= http.StatusInternalServerError && !errors.Is(err, context.Canceled) {
+		slog.Error("request failed", "method", c.Request().Method, "path", c.Request().URL.Path, "error", err)
+	}
+}
+
+func writeProblem(c echo.Context, problem Problem) error {
+	data, err := json.Marshal(problem)
+	if err != nil {
+		return err
+	}
+	return c.Blob(problem.Status, MIMEProblemJSON, data)
+}
+
+// requestError returns err, wrapped in the error of the request's context if
+// that is done: a deadline or a client going away explains a failure better
+// than the errors of the statements it interrupted.
+func requestError(c echo.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctxErr := c.Request().Context().Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+		return fmt.Errorf("%w: %w", ctxErr, err)
+	}
+	return err
+}
+
+func newProblem(err error) Problem {
+	if errors.Is(err, context.DeadlineExceeded) {
+		problem := problemFor(http.StatusGatewayTimeout)
+		problem.Detail = "the request timed out"
+		return problem
+	}
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		problem := problemFor(httpErr.Code)
+		if message, ok := httpErr.Message.(string); ok && message != http.StatusText(httpErr.Code) {
+			problem.Detail = message
+		}
+		return problem
+	}
+
+	kind := apperrors.KindOf(err)
+	problem := problemFor(statusByKind[kind])
+	if kind == apperrors.Internal {
+		return problem
+	}
+
+	var appErr *apperrors.Error
+	errors.As(err, &appErr)
+	problem.Detail = apperrors.PublicMessage(err)
+	problem.Errors = appErr.Fields
+	return problem
+}
+
+func problemFor(status int) Problem {
+	return Problem{Type: "about:blank", Title: http.StatusText(status), Status: status}
+}
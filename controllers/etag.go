@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"strconv"
+	"strings"
+
+	"user-service/apperrors"
+	"user-service/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	headerETag    = "ETag"
+	headerIfMatch = "If-Match"
+)
+
+// setETag exposes the user's version as a strong entity tag.
+func setETag(c echo.Context, user *models.User) {
+	c.Response().Header().Set(headerETag, strconv.Quote(strconv.Itoa(user.Version)))
+}
+
+var (
+	errPreconditionRequired = apperrors.New(apperrors.PreconditionRequired, "If-Match header is required")
+	errPreconditionFailed   = apperrors.New(apperrors.PreconditionFailed, "If-Match does not match any version")
+)
+
+// ifMatchVersion returns the user version required by the If-Match header,
+// or 0 for "*", which matches any version.
+func ifMatchVersion(c echo.Context) (int, error) {
+	header := strings.TrimSpace(c.Request().Header.Get(headerIfMatch))
+	if header == "" {
+		return 0, errPreconditionRequired
+	}
+	if header == "*" {
+		return 0, nil
+	}
+	if unquoted, err := strconv.Unquote(header); err == nil {
+		if version, err := strconv.Atoi(unquoted); err == nil && version > 0 {
+			return version, nil
+		}
+	}
+	return 0, errPreconditionFailed
+}
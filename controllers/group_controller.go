@@ -0,0 +1,309 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"user-service/models"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// groupIDParam parses the id path parameter of group routes.
+func groupIDParam(c echo.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, invalidParam("group ID")
+	}
+	return id, nil
+}
+
+// pageParams parses the limit and offset query parameters.
+func pageParams(c echo.Context) (limit, offset int, err error) {
+	if limit, err = intQueryParam(c, "limit"); err != nil || limit < 0 {
+		return 0, 0, invalidParam("limit")
+	}
+	if offset, err = intQueryParam(c, "offset"); err != nil || offset < 0 {
+		return 0, 0, invalidParam("offset")
+	}
+	return limit, offset, nil
+}
+
+// @Summary List groups
+// @Description List groups ordered by name
+// @Tags Groups
+// @Produce json
+// @Param limit query int false "Page size (default 50, max 500)"
+// @Param offset query int false "Number of groups to skip"
+// @Success 200 {object} models.GroupPage
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /groups [get]
+func GetGroups(service *services.GroupService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		limit, offset, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		page, err := service.ListGroups(c.Request().Context(), limit, offset)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, page)
+	}
+}
+
+// @Summary Get a group
+// @Description Get a single group by ID
+// @Tags Groups
+// @Produce json
+// @Param id path int true "Group ID"
+// @Success 200 {object} models.Group
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /groups/{id} [get]
+func GetGroup(service *services.GroupService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := groupIDParam(c)
+		if err != nil {
+			return err
+		}
+
+		group, err := service.GetGroup(c.Request().Context(), id)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, group)
+	}
+}
+
+// @Summary Create a group
+// @Description Create a new group
+// @Tags Groups
+// @Accept json
+// @Produce json
+// @Param group body models.Group true "Group data"
+// @Success 201 {object} models.Group
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /groups [post]
+func CreateGroup(service *services.GroupService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var group models.Group
+		if err := c.Bind(&group); err != nil {
+			return invalidInput(err)
+		}
+
+		if err := service.CreateGroup(c.Request().Context(), &group); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusCreated, group)
+	}
+}
+
+// @Summary Update a group
+// @Description Rename a group or change its description
+// @Tags Groups
+// @Accept json
+// @Produce json
+// @Param id path int true "Group ID"
+// @Param group body models.Group true "Group data"
+// @Success 200 {object} models.Group
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /groups/{id} [put]
+func UpdateGroup(service *services.GroupService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := groupIDParam(c)
+		if err != nil {
+			return err
+		}
+
+		var group models.Group
+		if err := c.Bind(&group); err != nil {
+			return invalidInput(err)
+		}
+		group.ID = id
+
+		if err := service.UpdateGroup(c.Request().Context(), &group); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, group)
+	}
+}
+
+// @Summary Delete a group
+// @Description Delete a group and all of its memberships
+// @Tags Groups
+// @Produce json
+// @Param id path int true "Group ID"
+// @Success 204
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /groups/{id} [delete]
+func DeleteGroup(service *services.GroupService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := groupIDParam(c)
+		if err != nil {
+			return err
+		}
+
+		if err := service.DeleteGroup(c.Request().Context(), id); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// @Summary List group members
+// @Description List the members of a group ordered by user ID
+// @Tags Groups
+// @Produce json
+// @Param id path int true "Group ID"
+// @Param limit query int false "Page size (default 50, max 500)"
+// @Param offset query int false "Number of members to skip"
+// @Success 200 {object} models.UserPage
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /groups/{id}/members [get]
+func GetGroupMembers(service *services.GroupService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := groupIDParam(c)
+		if err != nil {
+			return err
+		}
+		limit, offset, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		page, err := service.ListMembers(c.Request().Context(), id, limit, offset)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, page)
+	}
+}
+
+// memberParams parses the id and user_id path parameters of membership
+// routes.
+func memberParams(c echo.Context) (groupID, userID int, err error) {
+	if groupID, err = groupIDParam(c); err != nil {
+		return 0, 0, err
+	}
+	if userID, err = strconv.Atoi(c.Param("user_id")); err != nil {
+		return 0, 0, invalidParam("user ID")
+	}
+	return groupID, userID, nil
+}
+
+// @Summary Add a group member
+// @Description Add a user to a group. Adding an existing member succeeds without change; deleted and terminated users cannot be added.
+// @Tags Groups
+// @Produce json
+// @Param id path int true "Group ID"
+// @Param user_id path int true "User ID"
+// @Success 204
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /groups/{id}/members/{user_id} [put]
+func AddGroupMember(service *services.GroupService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		groupID, userID, err := memberParams(c)
+		if err != nil {
+			return err
+		}
+
+		if err := service.AddMember(c.Request().Context(), groupID, userID); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// @Summary Remove a group member
+// @Description Remove a user from a group
+// @Tags Groups
+// @Produce json
+// @Param id path int true "Group ID"
+// @Param user_id path int true "User ID"
+// @Success 204
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /groups/{id}/members/{user_id} [delete]
+func RemoveGroupMember(service *services.GroupService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		groupID, userID, err := memberParams(c)
+		if err != nil {
+			return err
+		}
+
+		if err := service.RemoveMember(c.Request().Context(), groupID, userID); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// @Summary List a user's groups
+// @Description List the groups a user belongs to, ordered by name
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} models.Group
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id}/groups [get]
+func GetUserGroups(service *services.GroupService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := userIDParam(c)
+		if err != nil {
+			return err
+		}
+
+		groups, err := service.ListUserGroups(c.Request().Context(), userID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, groups)
+	}
+}
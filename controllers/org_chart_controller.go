@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"bytes"
+	"net/http"
+
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// dotMediaType is the media type of Graphviz DOT documents.
+const dotMediaType = "text/vnd.graphviz"
+
+// @Summary Get a user's reporting chain
+// @Description List the managers of a user, from the direct manager upwards. A deleted manager ends the chain.
+// @Tags Org chart
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} models.User
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id}/chain [get]
+func GetReportingChain(service *services.UserService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := userIDParam(c)
+		if err != nil {
+			return err
+		}
+		chain, err := service.ReportingChain(c.Request().Context(), id)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, chain)
+	}
+}
+
+// @Summary List a user's direct reports
+// @Description List the users reporting directly to a user, ordered by ID
+// @Tags Org chart
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} models.User
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id}/reports [get]
+func GetDirectReports(service *services.UserService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := userIDParam(c)
+		if err != nil {
+			return err
+		}
+		reports, err := service.DirectReports(c.Request().Context(), id)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, reports)
+	}
+}
+
+// @Summary Get a user's subtree
+// @Description Get a user together with their direct and indirect reports, nested
+// @Tags Org chart
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.OrgNode
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id}/subtree [get]
+func GetSubtree(service *services.UserService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := userIDParam(c)
+		if err != nil {
+			return err
+		}
+		node, err := service.Subtree(c.Request().Context(), id)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, node)
+	}
+}
+
+// @Summary Export the org chart
+// @Description Export the whole org chart as nested JSON or as a Graphviz DOT digraph. Users without a manager, or whose manager has been deleted, are at the top.
+// @Tags Org chart
+// @Produce json
+// @Produce text/vnd.graphviz
+// @Param format query string false "Export format (default json)" Enums(json, dot)
+// @Success 200 {array} models.OrgNode
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /org-chart [get]
+func GetOrgChart(service *services.UserService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		format := c.QueryParam("format")
+		if format != "" && format != "json" && format != "dot" {
+			return invalidParam("format")
+		}
+		roots, err := service.OrgChart(c.Request().Context())
+		if err != nil {
+			return err
+		}
+		if format != "dot" {
+			return c.JSON(http.StatusOK, roots)
+		}
+
+		var buf bytes.Buffer
+		if err := services.WriteOrgChartDOT(&buf, roots); err != nil {
+			return err
+		}
+		return c.Blob(http.StatusOK, dotMediaType, buf.Bytes())
+	}
+}
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"user-service/models"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// @Summary List role assignments
+// @Description List the roles assigned to a subject, or to every subject
+// @Tags Admin
+// @Produce json
+// @Param subject query string false "Only list the assignments of this subject"
+// @Success 200 {array} models.RoleAssignment
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/roles [get]
+func ListRoleAssignments(service *services.RoleService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		assignments, err := service.ListRoleAssignments(c.Request().Context(), c.QueryParam("subject"))
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, assignments)
+	}
+}
+
+// @Summary Assign a role
+// @Description Assign a role to a subject. Editor assignments must name the department whose users the subject may modify.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param assignment body models.RoleAssignment true "Subject, role and department"
+// @Success 201 {object} models.RoleAssignment
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/roles [post]
+func AssignRole(service *services.RoleService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var assignment models.RoleAssignment
+		if err := c.Bind(&assignment); err != nil {
+			return invalidInput(err)
+		}
+		assignment.ID = 0
+
+		if err := service.AssignRole(c.Request().Context(), &assignment); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusCreated, assignment)
+	}
+}
+
+// @Summary Remove a role assignment
+// @Description Remove a role assignment by ID
+// @Tags Admin
+// @Produce json
+// @Param id path int true "Role assignment ID"
+// @Success 204
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/roles/{id} [delete]
+func UnassignRole(service *services.RoleService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return invalidParam("role assignment ID")
+		}
+
+		if err := service.UnassignRole(c.Request().Context(), id); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
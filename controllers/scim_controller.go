@@ -0,0 +1,545 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"user-service/apperrors"
+	"user-service/auth"
+	"user-service/repositories"
+	"user-service/scim"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SCIMErrors is middleware for the SCIM routes that writes the errors of the
+// handlers after it, authentication included, as SCIM errors rather than
+// problem details.
+func SCIMErrors(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		if err == nil || c.Response().Committed {
+			return err
+		}
+
+		err = requestError(c, err)
+		problem := newProblem(err)
+		if problem.Status == http.StatusUnauthorized {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+		}
+		if problem.Status >= http.StatusInternalServerError && !errors.Is(err, context.Canceled) {
+			slog.Error("request failed", "method", c.Request().Method, "path", c.Request().URL.Path, "error", err)
+		}
+		detail := problem.Detail
+		for _, field := range problem.Errors {
+			detail += "; " + field.Field + " " + field.Message
+		}
+		return writeSCIM(c, problem.Status, scim.Error{
+			Schemas:  []string{scim.SchemaError},
+			Status:   strconv.Itoa(problem.Status),
+			ScimType: scimType(err),
+			Detail:   detail,
+		})
+	}
+}
+
+// scimType returns the SCIM error type of err, if it has one.
+func scimType(err error) string {
+	if errors.Is(err, repositories.ErrDuplicateUsername) || errors.Is(err, repositories.ErrDuplicateGroupName) {
+		return "uniqueness"
+	}
+	if t := scim.TypeOf(err); t != "" {
+		return t
+	}
+	if apperrors.KindOf(err) == apperrors.Invalid {
+		return "invalidValue"
+	}
+	return ""
+}
+
+func writeSCIM(c echo.Context, status int, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Blob(status, scim.MediaType, data)
+}
+
+// writeSCIMUser writes a user together with its ETag.
+func writeSCIMUser(c echo.Context, status int, user *scim.User) error {
+	c.Response().Header().Set(headerETag, user.Meta.Version)
+	if status == http.StatusCreated {
+		c.Response().Header().Set(echo.HeaderLocation, user.Meta.Location)
+	}
+	return writeSCIM(c, status, user)
+}
+
+// bindSCIM decodes a request body, which clients send as application/scim+json
+// or application/json.
+func bindSCIM(c echo.Context, v interface{}) error {
+	if err := json.NewDecoder(c.Request().Body).Decode(v); err != nil {
+		return apperrors.Wrap(scim.ErrInvalidSyntax, apperrors.Invalid, "invalid JSON: "+err.Error())
+	}
+	return nil
+}
+
+// scimIfMatch returns the user version required by the optional If-Match
+// header, which carries an ETag as returned by the SCIM routes, or 0 if
+// any version will do.
+func scimIfMatch(c echo.Context) (int, error) {
+	header := strings.TrimSpace(c.Request().Header.Get(headerIfMatch))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+	if unquoted, err := strconv.Unquote(strings.TrimPrefix(header, "W/")); err == nil {
+		if version, err := strconv.Atoi(unquoted); err == nil && version > 0 {
+			return version, nil
+		}
+	}
+	return 0, errPreconditionFailed
+}
+
+// listParams parses the filter, startIndex and count query parameters.
+func listParams(c echo.Context) (filter string, startIndex, count int, err error) {
+	if startIndex, err = intQueryParam(c, "startIndex"); err != nil {
+		return "", 0, 0, apperrors.Wrap(scim.ErrInvalidValue, apperrors.Invalid, "invalid startIndex")
+	}
+	count = scim.MaxResults
+	if c.QueryParam("count") != "" {
+		if count, err = intQueryParam(c, "count"); err != nil {
+			return "", 0, 0, apperrors.Wrap(scim.ErrInvalidValue, apperrors.Invalid, "invalid count")
+		}
+	}
+	return c.QueryParam("filter"), startIndex, count, nil
+}
+
+// scimCaller returns service acting for the caller of c; see asCaller.
+func scimCaller(c echo.Context, service *services.SCIMService, perm auth.Permission) *services.SCIMService {
+	return service.WithUsers(asCaller(c, service.Users, perm))
+}
+
+// @Summary Get the SCIM service provider configuration
+// @Tags SCIM
+// @Produce json
+// @Success 200 {object} scim.ServiceProviderConfig
+// @Failure 401 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/ServiceProviderConfig [get]
+func GetSCIMServiceProviderConfig(c echo.Context) error {
+	return writeSCIM(c, http.StatusOK, scim.Config())
+}
+
+// @Summary List SCIM schemas
+// @Tags SCIM
+// @Produce json
+// @Success 200 {object} scim.ListResponse
+// @Failure 401 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/Schemas [get]
+func GetSCIMSchemas(c echo.Context) error {
+	var resources []interface{}
+	for _, schema := range scim.Schemas() {
+		resources = append(resources, schema)
+	}
+	page, err := scim.List(resources, "", 1, len(resources))
+	if err != nil {
+		return err
+	}
+	return writeSCIM(c, http.StatusOK, page)
+}
+
+// @Summary Get a SCIM schema
+// @Tags SCIM
+// @Produce json
+// @Param id path string true "Schema URN"
+// @Success 200 {object} object
+// @Failure 401 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/Schemas/{id} [get]
+func GetSCIMSchema(c echo.Context) error {
+	schema, ok := scim.Schema(c.Param("id"))
+	if !ok {
+		return apperrors.New(apperrors.NotFound, "schema not found")
+	}
+	return writeSCIM(c, http.StatusOK, schema)
+}
+
+// @Summary List SCIM resource types
+// @Tags SCIM
+// @Produce json
+// @Success 200 {object} scim.ListResponse
+// @Failure 401 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/ResourceTypes [get]
+func GetSCIMResourceTypes(c echo.Context) error {
+	var resources []interface{}
+	for _, resourceType := range scim.ResourceTypes() {
+		resources = append(resources, resourceType)
+	}
+	page, err := scim.List(resources, "", 1, len(resources))
+	if err != nil {
+		return err
+	}
+	return writeSCIM(c, http.StatusOK, page)
+}
+
+// @Summary Get a SCIM resource type
+// @Tags SCIM
+// @Produce json
+// @Param id path string true "Resource type (User or Group)"
+// @Success 200 {object} scim.ResourceType
+// @Failure 401 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/ResourceTypes/{id} [get]
+func GetSCIMResourceType(c echo.Context) error {
+	for _, resourceType := range scim.ResourceTypes() {
+		if strings.EqualFold(resourceType.ID, c.Param("id")) {
+			return writeSCIM(c, http.StatusOK, resourceType)
+		}
+	}
+	return apperrors.New(apperrors.NotFound, "resource type not found")
+}
+
+// @Summary List SCIM users
+// @Description List users that have not been deleted, optionally filtered, e.g. userName eq "bjensen"
+// @Tags SCIM
+// @Produce json
+// @Param filter query string false "SCIM filter"
+// @Param startIndex query int false "1-based index of the first result"
+// @Param count query int false "Page size (max 200)"
+// @Success 200 {object} scim.ListResponse
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/Users [get]
+func GetSCIMUsers(service *services.SCIMService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filter, startIndex, count, err := listParams(c)
+		if err != nil {
+			return err
+		}
+		page, err := service.ListUsers(c.Request().Context(), filter, startIndex, count)
+		if err != nil {
+			return err
+		}
+		return writeSCIM(c, http.StatusOK, page)
+	}
+}
+
+// @Summary Get a SCIM user
+// @Tags SCIM
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} scim.User
+// @Failure 401 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/Users/{id} [get]
+func GetSCIMUser(service *services.SCIMService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := service.GetUser(c.Request().Context(), c.Param("id"))
+		if err != nil {
+			return err
+		}
+		return writeSCIMUser(c, http.StatusOK, user)
+	}
+}
+
+// @Summary Create a SCIM user
+// @Description Create a user. The enterprise extension must give the department.
+// @Tags SCIM
+// @Accept json
+// @Produce json
+// @Param user body scim.User true "User"
+// @Success 201 {object} scim.User
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/Users [post]
+func CreateSCIMUser(service *services.SCIMService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var resource scim.User
+		if err := bindSCIM(c, &resource); err != nil {
+			return err
+		}
+		user, err := scimCaller(c, service, auth.PermWriteUsers).CreateUser(c.Request().Context(), &resource)
+		if err != nil {
+			return err
+		}
+		return writeSCIMUser(c, http.StatusCreated, user)
+	}
+}
+
+// @Summary Replace a SCIM user
+// @Description Replace a user. If-Match, when given, must carry the user's current ETag.
+// @Tags SCIM
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param If-Match header string false "ETag of the user"
+// @Param user body scim.User true "User"
+// @Success 200 {object} scim.User
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Failure 412 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/Users/{id} [put]
+func ReplaceSCIMUser(service *services.SCIMService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		version, err := scimIfMatch(c)
+		if err != nil {
+			return err
+		}
+		var resource scim.User
+		if err := bindSCIM(c, &resource); err != nil {
+			return err
+		}
+		user, err := scimCaller(c, service, auth.PermWriteUsers).ReplaceUser(c.Request().Context(), c.Param("id"), version, &resource)
+		if err != nil {
+			return err
+		}
+		return writeSCIMUser(c, http.StatusOK, user)
+	}
+}
+
+// @Summary Patch a SCIM user
+// @Description Apply add, replace and remove operations to a user. If-Match, when given, must carry the user's current ETag.
+// @Tags SCIM
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param If-Match header string false "ETag of the user"
+// @Param patch body scim.PatchRequest true "Operations"
+// @Success 200 {object} scim.User
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Failure 412 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/Users/{id} [patch]
+func PatchSCIMUser(service *services.SCIMService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		version, err := scimIfMatch(c)
+		if err != nil {
+			return err
+		}
+		var req scim.PatchRequest
+		if err := bindSCIM(c, &req); err != nil {
+			return err
+		}
+		user, err := scimCaller(c, service, auth.PermWriteUsers).PatchUser(c.Request().Context(), c.Param("id"), version, req)
+		if err != nil {
+			return err
+		}
+		return writeSCIMUser(c, http.StatusOK, user)
+	}
+}
+
+// @Summary Delete a SCIM user
+// @Description Soft delete a user. If-Match, when given, must carry the user's current ETag.
+// @Tags SCIM
+// @Param id path string true "User ID"
+// @Param If-Match header string false "ETag of the user"
+// @Success 204
+// @Failure 401 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Failure 412 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/Users/{id} [delete]
+func DeleteSCIMUser(service *services.SCIMService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		version, err := scimIfMatch(c)
+		if err != nil {
+			return err
+		}
+		if err := scimCaller(c, service, auth.PermWriteUsers).DeleteUser(c.Request().Context(), c.Param("id"), version); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// @Summary List SCIM groups
+// @Description List groups with their members, optionally filtered, e.g. displayName eq "admins"
+// @Tags SCIM
+// @Produce json
+// @Param filter query string false "SCIM filter"
+// @Param startIndex query int false "1-based index of the first result"
+// @Param count query int false "Page size (max 200)"
+// @Success 200 {object} scim.ListResponse
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/Groups [get]
+func GetSCIMGroups(service *services.SCIMService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filter, startIndex, count, err := listParams(c)
+		if err != nil {
+			return err
+		}
+		page, err := service.ListGroups(c.Request().Context(), filter, startIndex, count)
+		if err != nil {
+			return err
+		}
+		return writeSCIM(c, http.StatusOK, page)
+	}
+}
+
+// @Summary Get a SCIM group
+// @Tags SCIM
+// @Produce json
+// @Param id path string true "Group ID"
+// @Success 200 {object} scim.Group
+// @Failure 401 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/Groups/{id} [get]
+func GetSCIMGroup(service *services.SCIMService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		group, err := service.GetGroup(c.Request().Context(), c.Param("id"))
+		if err != nil {
+			return err
+		}
+		return writeSCIM(c, http.StatusOK, group)
+	}
+}
+
+// @Summary Create a SCIM group
+// @Tags SCIM
+// @Accept json
+// @Produce json
+// @Param group body scim.Group true "Group"
+// @Success 201 {object} scim.Group
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/Groups [post]
+func CreateSCIMGroup(service *services.SCIMService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var resource scim.Group
+		if err := bindSCIM(c, &resource); err != nil {
+			return err
+		}
+		group, err := service.CreateGroup(c.Request().Context(), &resource)
+		if err != nil {
+			return err
+		}
+		c.Response().Header().Set(echo.HeaderLocation, group.Meta.Location)
+		return writeSCIM(c, http.StatusCreated, group)
+	}
+}
+
+// @Summary Replace a SCIM group
+// @Description Replace a group's name and members
+// @Tags SCIM
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param group body scim.Group true "Group"
+// @Success 200 {object} scim.Group
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/Groups/{id} [put]
+func ReplaceSCIMGroup(service *services.SCIMService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var resource scim.Group
+		if err := bindSCIM(c, &resource); err != nil {
+			return err
+		}
+		group, err := service.ReplaceGroup(c.Request().Context(), c.Param("id"), &resource)
+		if err != nil {
+			return err
+		}
+		return writeSCIM(c, http.StatusOK, group)
+	}
+}
+
+// @Summary Patch a SCIM group
+// @Description Apply add, replace and remove operations to a group, e.g. to add or remove members
+// @Tags SCIM
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param patch body scim.PatchRequest true "Operations"
+// @Success 200 {object} scim.Group
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/Groups/{id} [patch]
+func PatchSCIMGroup(service *services.SCIMService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req scim.PatchRequest
+		if err := bindSCIM(c, &req); err != nil {
+			return err
+		}
+		group, err := service.PatchGroup(c.Request().Context(), c.Param("id"), req)
+		if err != nil {
+			return err
+		}
+		return writeSCIM(c, http.StatusOK, group)
+	}
+}
+
+// @Summary Delete a SCIM group
+// @Tags SCIM
+// @Param id path string true "Group ID"
+// @Success 204
+// @Failure 401 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /scim/v2/Groups/{id} [delete]
+func DeleteSCIMGroup(service *services.SCIMService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := service.DeleteGroup(c.Request().Context(), c.Param("id")); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
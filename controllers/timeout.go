@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Timeout is middleware that gives each request d to do its work. The
+// services bind their database statements to the request's context, so once
+// d has passed they are canceled and the request is answered with 504 Gateway
+// Timeout. A zero d leaves requests unbounded.
+func Timeout(d time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if d <= 0 {
+			return next
+		}
+		return func(c echo.Context) error {
+			req := c.Request()
+			ctx, cancel := context.WithTimeout(req.Context(), d)
+			defer cancel()
+			c.SetRequest(req.WithContext(ctx))
+			// The deadline is checked while it still applies, as the
+			// context is canceled once the request is done
+			err := requestError(c, next(c))
+			c.SetRequest(req)
+			return err
+		}
+	}
+}
+
+// liftWriteDeadline lets a response that streams for as long as it needs to,
+// such as the change feed or an export, outlive the server's write timeout.
+// Such routes are registered without the request timeout; the response may
+// then be written until the request's own deadline, such as the one Timeout
+// gives exports, or, without one, until the client goes away.
+func liftWriteDeadline(c echo.Context) error {
+	deadline, _ := c.Request().Context().Deadline()
+	rc := http.NewResponseController(c.Response())
+	if err := rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
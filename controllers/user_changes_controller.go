@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"user-service/models"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+// resumeParam returns the sequence number of the last change a client has
+// seen, from the Last-Event-ID header that EventSource sends when it
+// reconnects or the last_event_id query parameter, or -1 if it gave none.
+func resumeParam(c echo.Context) (int, error) {
+	value := c.Request().Header.Get("Last-Event-ID")
+	if value == "" {
+		value = c.QueryParam("last_event_id")
+	}
+	if value == "" {
+		return -1, nil
+	}
+	seq, err := strconv.Atoi(value)
+	if err != nil || seq < 0 {
+		return 0, invalidParam("Last-Event-ID")
+	}
+	return seq, nil
+}
+
+// @Summary Stream user changes
+// @Description Stream the changes to users as Server-Sent Events, or over a WebSocket when the request asks to upgrade to one. Each change is an event whose id is its sequence number, whose type is user.created, user.updated or user.deleted and whose data is the change with the user as it is now. A client that reconnects with the Last-Event-ID header, or the last_event_id query parameter, first receives the changes it missed, in order; otherwise the stream starts with the next change. A user changed several times while a client was away is sent once, at its latest change.
+// @Tags Users
+// @Produce text/event-stream
+// @Param Last-Event-ID header int false "Sequence number of the last change received"
+// @Param last_event_id query int false "Sequence number of the last change received, for clients that cannot set headers"
+// @Success 200 {object} models.UserChange
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/changes [get]
+func StreamUserChanges(broker *services.ChangeBroker) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		after, err := resumeParam(c)
+		if err != nil {
+			return err
+		}
+
+		if strings.EqualFold(c.Request().Header.Get(echo.HeaderUpgrade), "websocket") {
+			websocket.Server{Handler: func(ws *websocket.Conn) {
+				streamChangesWebSocket(c.Request().Context(), broker, after, ws)
+			}}.ServeHTTP(c.Response(), c.Request())
+			return nil
+		}
+
+		if err := liftWriteDeadline(c); err != nil {
+			return err
+		}
+		err = broker.Stream(c.Request().Context(), after, &eventStream{c: c})
+		if c.Request().Context().Err() != nil {
+			// The client went away
+			return nil
+		}
+		return err
+	}
+}
+
+// eventStream writes changes as Server-Sent Events. The headers are sent once
+// the feed can be read, so that failing to subscribe to it is still answered
+// with a problem.
+type eventStream struct {
+	c echo.Context
+}
+
+func (s *eventStream) Open() error {
+	res := s.c.Response()
+	if res.Committed {
+		// Catching up again after falling behind
+		return nil
+	}
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	// Stop proxies such as nginx from buffering the stream
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	// EventSource waits this long before reconnecting
+	return s.write("retry: 3000\n\n")
+}
+
+func (s *eventStream) Send(change models.UserChange) error {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	return s.write(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", change.Seq, change.Type, data))
+}
+
+func (s *eventStream) KeepAlive() error {
+	return s.write(": keep-alive\n\n")
+}
+
+func (s *eventStream) write(event string) error {
+	res := s.c.Response()
+	if _, err := res.Write([]byte(event)); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
+
+// webSocketStream writes changes as JSON text messages, and keep-alives as
+// pings.
+type webSocketStream struct {
+	ws *websocket.Conn
+}
+
+func (s *webSocketStream) Open() error {
+	return nil
+}
+
+func (s *webSocketStream) Send(change models.UserChange) error {
+	return websocket.JSON.Send(s.ws, change)
+}
+
+func (s *webSocketStream) KeepAlive() error {
+	s.ws.PayloadType = websocket.PingFrame
+	defer func() { s.ws.PayloadType = websocket.TextFrame }()
+	_, err := s.ws.Write(nil)
+	return err
+}
+
+// streamChangesWebSocket streams the changes over ws until the client closes
+// it. Messages from the client are ignored. The connection has been hijacked
+// from the server, so errors can only be logged.
+func streamChangesWebSocket(ctx context.Context, broker *services.ChangeBroker, after int, ws *websocket.Conn) {
+	// A hijacked connection keeps the deadlines of the server, and is no
+	// longer watched by it, so reading tells when the client goes away.
+	ws.SetDeadline(time.Time{})
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		defer cancel()
+		var discard []byte
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+	}()
+	err := broker.Stream(ctx, after, &webSocketStream{ws: ws})
+	if err != nil && ctx.Err() == nil {
+		slog.Error("change stream failed", "error", err)
+	}
+}
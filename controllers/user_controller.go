@@ -1,35 +1,154 @@
 package controllers
 
 import (
-	"errors"
+	"io"
+	"mime"
 	"net/http"
 	"strconv"
-	"strings"
 
+	"user-service/apperrors"
+	"user-service/auth"
 	"user-service/models"
 	"user-service/services"
 
 	"github.com/labstack/echo/v4"
 )
 
-var (
-	ErrDuplicateUsername = errors.New("duplicate username")
-)
-
-// @Summary Get all users
-// @Description Get a list of all users
+// @Summary List users
+// @Description List users with optional filtering, sorting and offset or cursor pagination
 // @Tags Users
 // @Accept json
 // @Produce json
-// @Success 200 {array} models.User
+// @Param limit query int false "Page size (default 50, max 500)"
+// @Param offset query int false "Number of users to skip; ignored when cursor is set"
+// @Param cursor query string false "Opaque cursor from a previous page's next_cursor"
+// @Param status query string false "Filter by status" Enums(A, I, T)
+// @Param department query string false "Filter by department"
+// @Param user_name query string false "Filter by user name prefix"
+// @Param email_domain query string false "Filter by email domain"
+// @Param sort query string false "Sort field, prefixed with - for descending" Enums(id, -id, user_name, -user_name, email, -email, first_name, -first_name, last_name, -last_name, status, -status, department, -department)
+// @Param include_deleted query bool false "Include soft-deleted users"
+// @Success 200 {object} models.UserPage
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /users [get]
 func GetUsers(service *services.UserService) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		users, err := service.GetAllUsers()
+		params, err := userFilter(c)
+		if err != nil {
+			return err
+		}
+		params.Cursor = c.QueryParam("cursor")
+		if params.Limit, params.Offset, err = pageParams(c); err != nil {
+			return err
+		}
+
+		page, err := service.ListUsers(c.Request().Context(), params)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, page)
+	}
+}
+
+// userFilter parses the filter and sort parameters of a user listing.
+func userFilter(c echo.Context) (models.UserListParams, error) {
+	params := models.UserListParams{
+		Status:         c.QueryParam("status"),
+		Department:     c.QueryParam("department"),
+		UserNamePrefix: c.QueryParam("user_name"),
+		EmailDomain:    c.QueryParam("email_domain"),
+		Sort:           c.QueryParam("sort"),
+	}
+	var err error
+	if params.IncludeDeleted, err = boolQueryParam(c, "include_deleted"); err != nil {
+		return params, invalidParam("include_deleted")
+	}
+	return params, nil
+}
+
+// invalidParam reports a malformed query or path parameter.
+func invalidParam(name string) error {
+	return apperrors.New(apperrors.Invalid, "invalid "+name)
+}
+
+// invalidInput reports a request body that could not be read or decoded.
+func invalidInput(err error) error {
+	return apperrors.Wrap(err, apperrors.Invalid, "invalid input")
+}
+
+// asCaller returns service acting for the caller of c: its writes are
+// attributed to the caller in the audit log and limited to the users whose
+// department perm is granted in.
+func asCaller(c echo.Context, service *services.UserService, perm auth.Permission) *services.UserService {
+	return service.WithAudit(auditContext(c)).WithGuard(auth.UserGuard(c, perm))
+}
+
+// userIDParam parses the id path parameter.
+func userIDParam(c echo.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, invalidParam("user ID")
+	}
+	return id, nil
+}
+
+// intQueryParam parses an optional integer query parameter, returning 0 when
+// it is absent.
+func intQueryParam(c echo.Context, name string) (int, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// boolQueryParam parses an optional boolean query parameter, returning false
+// when it is absent.
+func boolQueryParam(c echo.Context, name string) (bool, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// @Summary Get a user
+// @Description Get a single user by ID
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param include_deleted query bool false "Return the user even if it has been soft deleted"
+// @Success 200 {object} models.User
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id} [get]
+func GetUser(service *services.UserService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := userIDParam(c)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch users"})
+			return err
 		}
-		return c.JSON(http.StatusOK, users)
+
+		includeDeleted, err := boolQueryParam(c, "include_deleted")
+		if err != nil {
+			return invalidParam("include_deleted")
+		}
+
+		user, err := service.GetUser(c.Request().Context(), userID, includeDeleted)
+		if err != nil {
+			return err
+		}
+		setETag(c, user)
+		return c.JSON(http.StatusOK, user)
 	}
 }
 
@@ -40,90 +159,219 @@ func GetUsers(service *services.UserService) echo.HandlerFunc {
 // @Produce json
 // @Param user body models.User true "User data"
 // @Success 201 {object} models.User
-// @Failure 400 {object} map[string]string
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /users [post]
 func CreateUser(service *services.UserService) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var user models.User
 		if err := c.Bind(&user); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+			return invalidInput(err)
 		}
 
-		if err := service.CreateUser(&user); err != nil {
-			if err.Error() == "duplicate username" {
-				return echo.NewHTTPError(http.StatusConflict, "username already exists")
-			}
-			// Check if the error is a validation failure
-			if strings.HasPrefix(err.Error(), "validation failed:") {
-				return echo.NewHTTPError(http.StatusBadRequest, "Invalid input")
-			}
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to create user")
+		if err := asCaller(c, service, auth.PermWriteUsers).CreateUser(c.Request().Context(), &user); err != nil {
+			return err
 		}
+		setETag(c, &user)
 		return c.JSON(http.StatusCreated, user)
 	}
 }
 
 // @Summary Delete a user
-// @Description Delete a user by ID
+// @Description Soft delete a user by ID. The user can be restored until it is purged. If-Match must carry the user's current ETag, or "*".
 // @Tags Users
 // @Accept json
 // @Produce json
 // @Param id path int true "User ID"
+// @Param If-Match header string true "ETag of the user"
 // @Success 204
-// @Failure 400 {object} map[string]string
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 412 {object} controllers.Problem
+// @Failure 428 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /users/{id} [delete]
 func DeleteUser(service *services.UserService) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		// Get user ID from request
-		id := c.Param("id")
-
-		// Convert id to integer
-		userID, err := strconv.Atoi(id)
+		userID, err := userIDParam(c)
+		if err != nil {
+			return err
+		}
+		version, err := ifMatchVersion(c)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+			return err
 		}
 
-		// Prepare delete query
-		if err := service.DeleteUser(userID); err != nil {
-			if err.Error() == "user not found" {
-				return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
-			}
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete user"})
+		if err := asCaller(c, service, auth.PermWriteUsers).DeleteUser(c.Request().Context(), userID, version); err != nil {
+			return err
 		}
 
-		return c.JSON(http.StatusNoContent, nil)
+		return c.NoContent(http.StatusNoContent)
 	}
 }
 
 // @Summary Update a user
-// @Description Update a user by ID
+// @Description Update a user by ID. If-Match must carry the user's current ETag, or "*".
 // @Tags Users
 // @Accept json
 // @Produce json
 // @Param id path int true "User ID"
+// @Param If-Match header string true "ETag of the user"
 // @Param user body models.User true "User data"
 // @Success 200 {object} models.User
-// @Failure 400 {object} map[string]string
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 412 {object} controllers.Problem
+// @Failure 428 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /users/{id} [put]
 func UpdateUser(service *services.UserService) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		userID, err := userIDParam(c)
+		if err != nil {
+			return err
+		}
+		version, err := ifMatchVersion(c)
+		if err != nil {
+			return err
+		}
+
 		var user models.User
 		if err := c.Bind(&user); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+			return invalidInput(err)
+		}
+		user.ID = userID
+		user.Version = version
+
+		if err := asCaller(c, service, auth.PermWriteUsers).UpdateUser(c.Request().Context(), &user); err != nil {
+			return err
+		}
+		setETag(c, &user)
+		return c.JSON(http.StatusOK, user) // Return updated user
+	}
+
+}
+
+// @Summary Patch a user
+// @Description Partially update a user with a JSON Merge Patch (RFC 7396) or JSON Patch (RFC 6902) document. Only the changed fields are written. If-Match must carry the user's current ETag, or "*".
+// @Tags Users
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param If-Match header string true "ETag of the user"
+// @Param patch body object true "Merge patch object or array of JSON Patch operations"
+// @Success 200 {object} models.User
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 412 {object} controllers.Problem
+// @Failure 415 {object} controllers.Problem
+// @Failure 428 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id} [patch]
+func PatchUser(service *services.UserService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := userIDParam(c)
+		if err != nil {
+			return err
 		}
-		// Validate input
-		if user.UserName == "" || user.Email == "" {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+
+		version, err := ifMatchVersion(c)
+		if err != nil {
+			return err
 		}
 
-		if err := service.UpdateUser(&user); err != nil {
-			if err.Error() == "user not found" {
-				return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
-			}
+		mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+		patch, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return invalidInput(err)
+		}
 
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create user"})
+		user, err := asCaller(c, service, auth.PermWriteUsers).PatchUser(c.Request().Context(), userID, version, mediaType, patch)
+		if err != nil {
+			return err
 		}
-		return c.JSON(http.StatusOK, user) // Return updated user
+		setETag(c, user)
+		return c.JSON(http.StatusOK, user)
 	}
+}
 
+// @Summary Restore a deleted user
+// @Description Undo the soft delete of a user. If-Match must carry the deleted user's current ETag, or "*".
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Param If-Match header string true "ETag of the deleted user"
+// @Success 200 {object} models.User
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 412 {object} controllers.Problem
+// @Failure 428 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id}/restore [post]
+func RestoreUser(service *services.UserService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := userIDParam(c)
+		if err != nil {
+			return err
+		}
+		version, err := ifMatchVersion(c)
+		if err != nil {
+			return err
+		}
+
+		user, err := asCaller(c, service, auth.PermWriteUsers).RestoreUser(c.Request().Context(), userID, version)
+		if err != nil {
+			return err
+		}
+		setETag(c, user)
+		return c.JSON(http.StatusOK, user)
+	}
+}
+
+// @Summary Purge a deleted user
+// @Description Permanently remove a soft-deleted user. This cannot be undone.
+// @Tags Admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 204
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/{id} [delete]
+func PurgeUser(service *services.UserService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := userIDParam(c)
+		if err != nil {
+			return err
+		}
+
+		if err := asCaller(c, service, auth.PermPurgeUsers).PurgeUser(c.Request().Context(), userID); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
 }
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"user-service/models"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// exportResponse streams an export to the response. The headers are sent
+// with the first bytes, so that an export failing before it writes anything
+// is still answered with a problem.
+type exportResponse struct {
+	c      echo.Context
+	format string
+}
+
+func (w *exportResponse) Write(p []byte) (int, error) {
+	res := w.c.Response()
+	if !res.Committed {
+		res.Header().Set(echo.HeaderContentType, services.ExportTypes[w.format])
+		res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="users.`+w.format+`"`)
+		res.WriteHeader(http.StatusOK)
+	}
+	n, err := res.Write(p)
+	if err == nil {
+		res.Flush()
+	}
+	return n, err
+}
+
+// @Summary Export users
+// @Description Download the users matching the filters of the list endpoint as CSV, NDJSON or an Excel workbook, streamed as they are read from the database. CSV files and workbooks start with a header row.
+// @Tags Users
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Param format query string false "Export format (default csv)" Enums(csv, ndjson, xlsx)
+// @Param columns query string false "Comma separated fields to export, in order (default all), e.g. user_name,email,department"
+// @Param status query string false "Filter by status" Enums(A, I, T)
+// @Param department query string false "Filter by department"
+// @Param user_name query string false "Filter by user name prefix"
+// @Param email_domain query string false "Filter by email domain"
+// @Param sort query string false "Sort field, prefixed with - for descending" Enums(id, -id, user_name, -user_name, email, -email, first_name, -first_name, last_name, -last_name, status, -status, department, -department)
+// @Param include_deleted query bool false "Include soft-deleted users"
+// @Success 200 {file} file
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/export [get]
+func ExportUsers(service *services.UserService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filter, err := userFilter(c)
+		if err != nil {
+			return err
+		}
+		opts := models.ExportOptions{Format: c.QueryParam("format"), Filter: filter}
+		if opts.Format == "" {
+			opts.Format = models.ExportCSV
+		}
+		if columns := c.QueryParam("columns"); columns != "" {
+			for _, column := range strings.Split(columns, ",") {
+				opts.Columns = append(opts.Columns, strings.TrimSpace(column))
+			}
+		}
+		if err := liftWriteDeadline(c); err != nil {
+			return err
+		}
+		return service.ExportUsers(c.Request().Context(), &exportResponse{c: c, format: opts.Format}, opts)
+	}
+}
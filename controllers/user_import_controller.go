@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"user-service/auth"
+	"user-service/models"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// mappingParam parses the mapping query parameter, a comma separated list of
+// column:field pairs.
+func mappingParam(c echo.Context) (map[string]string, error) {
+	value := c.QueryParam("mapping")
+	if value == "" {
+		return nil, nil
+	}
+	mapping := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		column, field, ok := strings.Cut(pair, ":")
+		if !ok || strings.TrimSpace(column) == "" {
+			return nil, invalidParam("mapping")
+		}
+		mapping[strings.TrimSpace(column)] = strings.TrimSpace(field)
+	}
+	return mapping, nil
+}
+
+// @Summary Import users
+// @Description Create, update or delete users in bulk from a CSV file with a header row or from NDJSON. mode is create (skip existing users), upsert (update existing users, matched by user_name) or replace (upsert and delete the users missing from the import). Every row is validated before anything is written; the rows that fail are reported and left out. With dry_run the import is only validated and planned. An import that fails after committing some batches answers with the status of the failure and the report, in which the rows left undone are not_applied.
+// @Tags Users
+// @Accept text/csv
+// @Accept application/x-ndjson
+// @Produce json
+// @Param mode query string false "create (default), upsert or replace"
+// @Param dry_run query bool false "Validate and report without writing"
+// @Param batch_size query int false "Writes per transaction; 0 applies the import in one"
+// @Param mapping query string false "Columns to map to user fields, e.g. Login:user_name,Mail:email"
+// @Param import body string true "CSV or NDJSON rows"
+// @Success 200 {object} models.ImportReport
+// @Failure 500 {object} models.ImportReport "The import failed after committing some batches"
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 415 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/import [post]
+func ImportUsers(service *services.UserService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		opts := models.ImportOptions{Mode: c.QueryParam("mode")}
+		var err error
+		if opts.DryRun, err = boolQueryParam(c, "dry_run"); err != nil {
+			return invalidParam("dry_run")
+		}
+		if opts.BatchSize, err = intQueryParam(c, "batch_size"); err != nil || opts.BatchSize < 0 {
+			return invalidParam("batch_size")
+		}
+		if opts.Mapping, err = mappingParam(c); err != nil {
+			return err
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+		if mediaType == "application/ndjson" {
+			mediaType = services.NDJSONType
+		}
+		report, err := asCaller(c, service, auth.PermWriteUsers).ImportUsers(c.Request().Context(), c.Request().Body, mediaType, opts)
+		if err != nil && (report == nil || report.Created+report.Updated+report.Deleted == 0) {
+			return err
+		}
+		if err != nil {
+			// Batches were committed before the import failed: the report
+			// tells which rows they wrote, with the status of the failure.
+			err = requestError(c, err)
+			problem := newProblem(err)
+			logProblem(c, problem, err)
+			report.Error = problem.Title
+			if problem.Detail != "" {
+				report.Error = problem.Detail
+			}
+			return c.JSON(problem.Status, report)
+		}
+		return c.JSON(http.StatusOK, report)
+	}
+}
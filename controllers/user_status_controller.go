@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"user-service/auth"
+	"user-service/models"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// changeStatus returns a handler that changes the status of the user in the
+// path to status, now or at the effective_at of the request body.
+func changeStatus(service *services.UserService, status string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := userIDParam(c)
+		if err != nil {
+			return err
+		}
+		version, err := ifMatchVersion(c)
+		if err != nil {
+			return err
+		}
+		var change models.StatusChange
+		if err := c.Bind(&change); err != nil {
+			return invalidInput(err)
+		}
+		change.Status = status
+		if change.Override {
+			if err := auth.Check(c, auth.PermOverrideStatus); err != nil {
+				return err
+			}
+		}
+
+		user, transition, err := asCaller(c, service, auth.PermWriteUsers).ChangeStatus(c.Request().Context(), userID, version, change)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return c.JSON(http.StatusAccepted, transition)
+		}
+		setETag(c, user)
+		return c.JSON(http.StatusOK, user)
+	}
+}
+
+// @Summary Activate a user
+// @Description Change a user's status to A, now or at effective_at. Reactivating a terminated user needs override, which requires the users:override-status permission, and a reason. If-Match must carry the user's current ETag, or "*".
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param If-Match header string true "ETag of the user"
+// @Param change body models.StatusChange false "When and why"
+// @Success 200 {object} models.User "Applied"
+// @Success 202 {object} models.StatusTransition "Scheduled"
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 412 {object} controllers.Problem
+// @Failure 428 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id}/activate [post]
+func ActivateUser(service *services.UserService) echo.HandlerFunc {
+	return changeStatus(service, models.StatusActive)
+}
+
+// @Summary Deactivate a user
+// @Description Change an active user's status to I, now or at effective_at. If-Match must carry the user's current ETag, or "*".
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param If-Match header string true "ETag of the user"
+// @Param change body models.StatusChange false "When and why"
+// @Success 200 {object} models.User "Applied"
+// @Success 202 {object} models.StatusTransition "Scheduled"
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 412 {object} controllers.Problem
+// @Failure 428 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id}/deactivate [post]
+func DeactivateUser(service *services.UserService) echo.HandlerFunc {
+	return changeStatus(service, models.StatusInactive)
+}
+
+// @Summary Terminate a user
+// @Description Change an active or inactive user's status to T, now or at effective_at. If-Match must carry the user's current ETag, or "*".
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param If-Match header string true "ETag of the user"
+// @Param change body models.StatusChange false "When and why"
+// @Success 200 {object} models.User "Applied"
+// @Success 202 {object} models.StatusTransition "Scheduled"
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Failure 412 {object} controllers.Problem
+// @Failure 428 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id}/terminate [post]
+func TerminateUser(service *services.UserService) echo.HandlerFunc {
+	return changeStatus(service, models.StatusTerminated)
+}
+
+// @Summary List a user's status transitions
+// @Description List the applied, scheduled, failed and canceled status transitions of a user, newest first
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} models.StatusTransition
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id}/transitions [get]
+func GetUserTransitions(service *services.UserService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := userIDParam(c)
+		if err != nil {
+			return err
+		}
+		transitions, err := service.ListTransitions(c.Request().Context(), userID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, transitions)
+	}
+}
+
+// @Summary Cancel a scheduled status transition
+// @Description Cancel a pending status transition of a user
+// @Tags Users
+// @Produce json
+// @Param id path int true "User ID"
+// @Param transition_id path int true "Transition ID"
+// @Success 204
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id}/transitions/{transition_id} [delete]
+func CancelUserTransition(service *services.UserService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := userIDParam(c)
+		if err != nil {
+			return err
+		}
+		transitionID, err := strconv.Atoi(c.Param("transition_id"))
+		if err != nil {
+			return invalidParam("transition ID")
+		}
+
+		if err := asCaller(c, service, auth.PermWriteUsers).CancelTransition(c.Request().Context(), userID, transitionID); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"user-service/models"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// webhookRequest is the body of CreateWebhook and UpdateWebhook.
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret signs the deliveries; if it is empty, CreateWebhook generates
+	// one and UpdateWebhook keeps the current one.
+	Secret string `json:"secret"`
+}
+
+func (r webhookRequest) webhook() models.Webhook {
+	return models.Webhook{URL: r.URL, Events: r.Events, Secret: r.Secret}
+}
+
+// webhookIDParam parses the id path parameter of webhook routes.
+func webhookIDParam(c echo.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, invalidParam("webhook ID")
+	}
+	return id, nil
+}
+
+// @Summary List webhooks
+// @Description List all webhook subscriptions. Secrets are never returned.
+// @Tags Webhooks
+// @Produce json
+// @Success 200 {array} models.Webhook
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks [get]
+func ListWebhooks(service *services.WebhookService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		webhooks, err := service.ListWebhooks(c.Request().Context())
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, webhooks)
+	}
+}
+
+// @Summary Create a webhook
+// @Description Subscribe a URL to user events of the types user.created, user.updated and user.deleted. The secret that signs deliveries is generated unless one is given, and only returned in this response.
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Param webhook body controllers.webhookRequest true "URL, event types and optional secret"
+// @Success 201 {object} models.NewWebhook
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks [post]
+func CreateWebhook(service *services.WebhookService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req webhookRequest
+		if err := c.Bind(&req); err != nil {
+			return invalidInput(err)
+		}
+
+		webhook := req.webhook()
+		created, err := service.CreateWebhook(c.Request().Context(), &webhook)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusCreated, created)
+	}
+}
+
+// @Summary Get a webhook
+// @Description Get a single webhook subscription by ID
+// @Tags Webhooks
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} models.Webhook
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks/{id} [get]
+func GetWebhook(service *services.WebhookService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := webhookIDParam(c)
+		if err != nil {
+			return err
+		}
+
+		webhook, err := service.GetWebhook(c.Request().Context(), id)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, webhook)
+	}
+}
+
+// @Summary Update a webhook
+// @Description Change the URL and event types of a webhook, and its secret if one is given
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Param webhook body controllers.webhookRequest true "URL, event types and optional secret"
+// @Success 200 {object} models.Webhook
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks/{id} [put]
+func UpdateWebhook(service *services.WebhookService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := webhookIDParam(c)
+		if err != nil {
+			return err
+		}
+
+		var req webhookRequest
+		if err := c.Bind(&req); err != nil {
+			return invalidInput(err)
+		}
+		webhook := req.webhook()
+		webhook.ID = id
+
+		if err := service.UpdateWebhook(c.Request().Context(), &webhook); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, webhook)
+	}
+}
+
+// @Summary Delete a webhook
+// @Description Delete a webhook subscription and its delivery log
+// @Tags Webhooks
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Success 204
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks/{id} [delete]
+func DeleteWebhook(service *services.WebhookService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := webhookIDParam(c)
+		if err != nil {
+			return err
+		}
+
+		if err := service.DeleteWebhook(c.Request().Context(), id); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// @Summary List webhook deliveries
+// @Description List the deliveries of a webhook, newest first, with the outcome of their last attempt
+// @Tags Webhooks
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Param state query string false "Only deliveries in this state" Enums(pending, succeeded, dead)
+// @Param limit query int false "Page size (default 50, max 500)"
+// @Param offset query int false "Number of deliveries to skip"
+// @Success 200 {object} models.WebhookDeliveryPage
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks/{id}/deliveries [get]
+func GetWebhookDeliveries(service *services.WebhookService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := webhookIDParam(c)
+		if err != nil {
+			return err
+		}
+		limit, offset, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+		state := c.QueryParam("state")
+		switch state {
+		case "", models.DeliveryPending, models.DeliverySucceeded, models.DeliveryDead:
+		default:
+			return invalidParam("state")
+		}
+
+		page, err := service.ListDeliveries(c.Request().Context(), models.WebhookDeliveryListParams{
+			WebhookID: id,
+			State:     state,
+			Limit:     limit,
+			Offset:    offset,
+		})
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, page)
+	}
+}
+
+// @Summary Redeliver a webhook delivery
+// @Description Queue a delivery to be made again, whatever its state, at the next dispatch of webhook deliveries. The delivery is retried like a new one. Answers 409 if the delivery changes meanwhile, as when an attempt ends.
+// @Tags Webhooks
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Param delivery_id path int true "Delivery ID"
+// @Success 202 {object} models.WebhookDelivery
+// @Failure 400 {object} controllers.Problem
+// @Failure 401 {object} controllers.Problem
+// @Failure 403 {object} controllers.Problem
+// @Failure 404 {object} controllers.Problem
+// @Failure 409 {object} controllers.Problem
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks/{id}/deliveries/{delivery_id}/redeliver [post]
+func RedeliverWebhook(service *services.WebhookService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := webhookIDParam(c)
+		if err != nil {
+			return err
+		}
+		deliveryID, err := strconv.Atoi(c.Param("delivery_id"))
+		if err != nil {
+			return invalidParam("delivery ID")
+		}
+
+		delivery, err := service.Redeliver(c.Request().Context(), id, deliveryID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusAccepted, delivery)
+	}
+}
@@ -3,21 +3,62 @@ package db
 import (
 	"database/sql"
 	"log"
+	"net/url"
+	"strings"
 
+	"user-service/config"
+
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func ConnectDB() *sql.DB {
-	db, err := sql.Open("sqlite3", "..\\user_db\\users.db")
+// driverNames maps configured drivers to their database/sql driver names.
+var driverNames = map[string]string{
+	"sqlite":   "sqlite3",
+	"postgres": "postgres",
+}
+
+// Connect opens and pings the database described by cfg and applies its
+// connection pool settings.
+func Connect(cfg config.DBConfig) *sql.DB {
+	driver, ok := driverNames[cfg.Driver]
+	if !ok {
+		log.Fatalf("Unsupported database driver %q", cfg.Driver)
+	}
+
+	dsn := cfg.DSN
+	if cfg.Driver == "sqlite" {
+		dsn = SQLiteDSN(dsn)
+	}
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 
 	// Verify the connection
 	if err := db.Ping(); err != nil {
 		log.Fatalf("Failed to ping database: %v", err)
 	}
 
-	log.Println("Connected to SQLite database")
+	log.Printf("Connected to %s database", cfg.Driver)
 	return db
 }
+
+// SQLiteDSN returns dsn with foreign keys enforced, which SQLite leaves off
+// unless asked, so that users cannot refer to departments that do not exist.
+// A DSN that sets _foreign_keys (or _fk) itself is left alone.
+func SQLiteDSN(dsn string) string {
+	name, query, _ := strings.Cut(dsn, "?")
+	params, err := url.ParseQuery(query)
+	if err == nil && (params.Has("_foreign_keys") || params.Has("_fk")) {
+		return dsn
+	}
+	if query == "" {
+		return name + "?_foreign_keys=on"
+	}
+	return dsn + "&_foreign_keys=on"
+}
@@ -0,0 +1,337 @@
+// Package migrations applies the embedded, versioned SQL schema migrations and
+// records the applied versions in the schema_migrations table.
+//
+// Migrations live in one directory per database driver and are named
+// NNNN_description.up.sql and NNNN_description.down.sql. Each migration runs
+// in its own transaction together with its schema_migrations bookkeeping.
+// SQLite migrations run with foreign keys off, as SQLite needs to rebuild a
+// table to change its constraints.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+)
+
+//go:embed sqlite/*.sql postgres/*.sql
+var files embed.FS
+
+var ErrUnknownVersion = errors.New("unknown migration version")
+
+// Migration is a single schema change and its inverse.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes a migration and whether it has been applied.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	DB           *sql.DB
+	QueryBuilder squirrel.StatementBuilderType
+	Migrations   []Migration
+
+	driver string
+}
+
+// New returns a Migrator for the embedded migrations of the given driver
+// ("sqlite" or "postgres").
+func New(db *sql.DB, driver string) (*Migrator, error) {
+	var placeholder squirrel.PlaceholderFormat = squirrel.Question
+	switch driver {
+	case "sqlite":
+	case "postgres":
+		placeholder = squirrel.Dollar
+	default:
+		return nil, fmt.Errorf("migrations are not supported for driver %q", driver)
+	}
+
+	migrations, err := load(driver)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(placeholder),
+		Migrations:   migrations,
+		driver:       driver,
+	}, nil
+}
+
+func load(dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file %s", name)
+		}
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		prefix, description, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s has no version prefix", name)
+		}
+		body, err := fs.ReadFile(files, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: description}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s is missing its up or down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Latest returns the highest known migration version.
+func (m *Migrator) Latest() int {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+// Version returns the highest applied migration version, or 0 if none.
+func (m *Migrator) Version() (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// Status lists every known migration in version order.
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(m.Migrations))
+	for i, migration := range m.Migrations {
+		statuses[i].Migration = migration
+		if at, ok := applied[migration.Version]; ok {
+			at := at
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+// Up applies all pending migrations and returns how many were applied.
+func (m *Migrator) Up() (int, error) {
+	return m.migrateTo(m.Latest())
+}
+
+// Down reverts the given number of most recently applied migrations.
+func (m *Migrator) Down(steps int) (int, error) {
+	current, err := m.Version()
+	if err != nil {
+		return 0, err
+	}
+	target := 0
+	for i := len(m.Migrations) - 1; i >= 0; i-- {
+		if m.Migrations[i].Version <= current {
+			if steps == 0 {
+				target = m.Migrations[i].Version
+				break
+			}
+			steps--
+		}
+	}
+	return m.migrateTo(target)
+}
+
+// Goto migrates up or down until version is the latest applied migration.
+// Version 0 reverts every migration.
+func (m *Migrator) Goto(version int) (int, error) {
+	if version != 0 && m.find(version) < 0 {
+		return 0, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	return m.migrateTo(version)
+}
+
+func (m *Migrator) migrateTo(target int) (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, migration := range m.Migrations {
+		if _, ok := applied[migration.Version]; ok || migration.Version > target {
+			continue
+		}
+		if err := m.run(migration, true); err != nil {
+			return count, err
+		}
+		count++
+	}
+	for i := len(m.Migrations) - 1; i >= 0; i-- {
+		migration := m.Migrations[i]
+		if _, ok := applied[migration.Version]; !ok || migration.Version <= target {
+			continue
+		}
+		if err := m.run(migration, false); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (m *Migrator) run(migration Migration, up bool) error {
+	script := migration.Down
+	var record squirrel.Sqlizer = m.QueryBuilder.
+		Delete("schema_migrations").
+		Where(squirrel.Eq{"version": migration.Version})
+	if up {
+		script = migration.Up
+		record = m.QueryBuilder.
+			Insert("schema_migrations").
+			Columns("version", "name", "applied_at").
+			Values(migration.Version, migration.Name, time.Now().UTC())
+	}
+	query, args, err := record.ToSql()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if m.driver == "sqlite" {
+		restore, err := disableForeignKeys(ctx, conn)
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(script); err != nil {
+		return fmt.Errorf("migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+	}
+	if _, err := tx.Exec(query, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// disableForeignKeys switches off the foreign keys of a SQLite connection,
+// which only works outside a transaction, and returns a function that
+// switches them back on if they were. Dropping a table while they are on
+// would delete, or fail on, the rows of other tables referencing it, even
+// when it is about to be replaced.
+func disableForeignKeys(ctx context.Context, conn *sql.Conn) (func(), error) {
+	var enabled bool
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&enabled); err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return func() {}, nil
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return nil, err
+	}
+	return func() { conn.ExecContext(ctx, "PRAGMA foreign_keys = ON") }, nil
+}
+
+// applied creates the schema_migrations table if needed and returns the
+// applied versions with the time they were applied.
+func (m *Migrator) applied() (map[int]time.Time, error) {
+	if _, err := m.DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`); err != nil {
+		return nil, err
+	}
+
+	query, args, err := m.QueryBuilder.Select("version", "applied_at").From("schema_migrations").ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+func (m *Migrator) find(version int) int {
+	for i, migration := range m.Migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
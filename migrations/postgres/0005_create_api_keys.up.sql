@@ -0,0 +1,9 @@
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    subject varchar(255) NOT NULL,
+    prefix varchar(20) NOT NULL,
+    key_hash varchar(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NULL
+);
@@ -0,0 +1,8 @@
+CREATE TABLE role_assignments (
+    id SERIAL PRIMARY KEY,
+    subject varchar(255) NOT NULL,
+    role varchar(20) NOT NULL,
+    department varchar(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (subject, role, department)
+);
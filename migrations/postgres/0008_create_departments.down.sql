@@ -0,0 +1,2 @@
+ALTER TABLE users DROP CONSTRAINT users_department_fkey;
+DROP TABLE departments;
@@ -0,0 +1,13 @@
+CREATE TABLE departments (
+    code varchar(50) PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    parent_code varchar(50) NULL REFERENCES departments (code),
+    cost_center varchar(50) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- Existing users may still carry free-text departments, so the constraint
+-- only applies to new writes until "department map-users" validates it.
+ALTER TABLE users ADD CONSTRAINT users_department_fkey
+    FOREIGN KEY (department) REFERENCES departments (code) NOT VALID;
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN manager_id INTEGER NULL REFERENCES users (id);
+
+CREATE INDEX users_manager_id ON users (manager_id);
@@ -0,0 +1,18 @@
+CREATE TABLE status_transitions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    status varchar(1) NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    override BOOLEAN NOT NULL DEFAULT FALSE,
+    effective_at TIMESTAMP NOT NULL,
+    requested_by varchar(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    state varchar(10) NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    finished_at TIMESTAMP NULL
+);
+
+CREATE INDEX status_transitions_user_id ON status_transitions (user_id);
+CREATE INDEX status_transitions_due ON status_transitions (effective_at) WHERE state = 'pending';
+-- A user has at most one pending transition at a time.
+CREATE UNIQUE INDEX status_transitions_pending ON status_transitions (user_id) WHERE state = 'pending';
@@ -0,0 +1,12 @@
+CREATE TABLE outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event_type varchar(50) NOT NULL,
+    user_id INTEGER NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    delivered_at TIMESTAMP NULL
+);
+
+CREATE INDEX outbox_pending ON outbox (id) WHERE delivered_at IS NULL;
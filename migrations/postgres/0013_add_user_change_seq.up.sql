@@ -0,0 +1,19 @@
+-- change_seq orders the changes of users for the change feed; each write of
+-- a user sets it to the next value of user_change_sequence.
+ALTER TABLE users ADD COLUMN change_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN change_type varchar(50) NOT NULL DEFAULT '';
+
+-- Existing users count as changed in the order they were created.
+UPDATE users SET change_seq = id, change_type = CASE
+    WHEN deleted_at IS NOT NULL THEN 'user.deleted'
+    WHEN version = 1 THEN 'user.created'
+    ELSE 'user.updated'
+END;
+
+CREATE INDEX users_change_seq ON users (change_seq);
+
+CREATE TABLE user_change_sequence (
+    value BIGINT NOT NULL
+);
+
+INSERT INTO user_change_sequence (value) SELECT COALESCE(MAX(id), 0) FROM users;
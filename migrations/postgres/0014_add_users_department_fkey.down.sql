@@ -0,0 +1,2 @@
+-- Nothing to revert on PostgreSQL; see the up migration.
+SELECT 1;
@@ -0,0 +1,4 @@
+-- users has referenced departments since 0008 on PostgreSQL, which can add
+-- the foreign key to an existing table; this version rebuilds the SQLite
+-- users table with it.
+SELECT 1;
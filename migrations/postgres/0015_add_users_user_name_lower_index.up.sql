@@ -0,0 +1,2 @@
+-- SCIM clients look users up by userName, which compares case-insensitively.
+CREATE INDEX users_user_name_lower ON users (LOWER(user_name));
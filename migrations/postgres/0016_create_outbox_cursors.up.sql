@@ -0,0 +1,20 @@
+-- Each consumer of the outbox, the webhooks and the event sink, delivers
+-- events from a cursor of its own, so that one that fails holds up no other.
+-- Both start after the events delivered so far, to every consumer at once.
+CREATE TABLE outbox_cursors (
+    consumer varchar(50) PRIMARY KEY,
+    event_id BIGINT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO outbox_cursors (consumer, event_id, updated_at)
+SELECT 'webhooks', COALESCE(MAX(id), 0), CURRENT_TIMESTAMP FROM outbox WHERE delivered_at IS NOT NULL;
+INSERT INTO outbox_cursors (consumer, event_id, updated_at)
+SELECT 'events', COALESCE(MAX(id), 0), CURRENT_TIMESTAMP FROM outbox WHERE delivered_at IS NOT NULL;
+
+DROP INDEX outbox_pending;
+ALTER TABLE outbox DROP COLUMN attempts;
+ALTER TABLE outbox DROP COLUMN last_error;
+ALTER TABLE outbox DROP COLUMN delivered_at;
@@ -0,0 +1,3 @@
+-- The version of a delivery counts its changes, so that an attempt that ends
+-- after the delivery was redelivered does not overwrite it.
+ALTER TABLE webhook_deliveries ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,13 @@
+CREATE TABLE audit_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    operation varchar(20) NOT NULL,
+    actor varchar(255) NOT NULL,
+    request_id varchar(255) NOT NULL,
+    user_status varchar(1) NOT NULL,
+    changed_fields varchar(255) NOT NULL,
+    changes TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX audit_events_user_id ON audit_events (user_id);
+CREATE INDEX audit_events_created_at ON audit_events (created_at);
@@ -0,0 +1,8 @@
+CREATE TABLE departments (
+    code varchar(50) PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    parent_code varchar(50) NULL REFERENCES departments (code),
+    cost_center varchar(50) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
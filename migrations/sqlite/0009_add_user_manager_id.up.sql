@@ -0,0 +1,5 @@
+-- SQLite cannot drop a column that takes part in a foreign key, so the
+-- reference to the manager is enforced by the repository instead.
+ALTER TABLE users ADD COLUMN manager_id INTEGER NULL;
+
+CREATE INDEX users_manager_id ON users (manager_id);
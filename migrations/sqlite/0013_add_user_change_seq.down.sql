@@ -0,0 +1,4 @@
+DROP TABLE user_change_sequence;
+DROP INDEX users_change_seq;
+ALTER TABLE users DROP COLUMN change_type;
+ALTER TABLE users DROP COLUMN change_seq;
@@ -0,0 +1,29 @@
+-- Rebuilds users without the foreign key to departments.
+CREATE TABLE users_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_name varchar(50) NOT NULL UNIQUE,
+    first_name varchar(255) NOT NULL,
+    last_name varchar(255) NOT NULL,
+    email varchar(255) NOT NULL,
+    user_status varchar(1) NOT NULL,
+    department varchar(255) NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    deleted_at TIMESTAMP NULL,
+    manager_id INTEGER NULL,
+    change_seq INTEGER NOT NULL DEFAULT 0,
+    change_type varchar(50) NOT NULL DEFAULT ''
+);
+
+INSERT INTO users_new (id, user_name, first_name, last_name, email, user_status, department, version, deleted_at, manager_id, change_seq, change_type)
+SELECT id, user_name, first_name, last_name, email, user_status, department, version, deleted_at, manager_id, change_seq, change_type FROM users;
+
+-- Keep the AUTOINCREMENT counter, so that the IDs of purged users are not
+-- handed out again.
+DELETE FROM sqlite_sequence WHERE name = 'users_new';
+INSERT INTO sqlite_sequence (name, seq) SELECT 'users_new', seq FROM sqlite_sequence WHERE name = 'users';
+
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+
+CREATE INDEX users_manager_id ON users (manager_id);
+CREATE INDEX users_change_seq ON users (change_seq);
@@ -0,0 +1,9 @@
+ALTER TABLE outbox ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE outbox ADD COLUMN last_error TEXT NOT NULL DEFAULT '';
+ALTER TABLE outbox ADD COLUMN delivered_at TIMESTAMP NULL;
+-- Events delivered to every consumer count as delivered.
+UPDATE outbox SET delivered_at = CURRENT_TIMESTAMP
+WHERE id <= (SELECT COALESCE(MIN(event_id), 0) FROM outbox_cursors);
+CREATE INDEX outbox_pending ON outbox (id) WHERE delivered_at IS NULL;
+
+DROP TABLE outbox_cursors;
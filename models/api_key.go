@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// APIKey is a credential for machine clients that authenticates them as
+// Subject. Only the SHA-256 hash of the key is stored; Prefix, the start of
+// the key, identifies it in listings.
+type APIKey struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name" validate:"required"`
+	Subject   string     `json:"subject" validate:"required"`
+	Prefix    string     `json:"prefix"`
+	Hash      string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// NewAPIKey is a freshly minted API key together with its secret, which is
+// shown only once.
+type NewAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
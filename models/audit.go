@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// Audit operations recorded for user mutations.
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+// AuditContext identifies who made a change and in which request.
+type AuditContext struct {
+	Actor     string
+	RequestID string
+}
+
+// AuditEvent records a single mutation of a user. Changes holds the before and
+// after value of every user field that changed, keyed by its JSON name.
+type AuditEvent struct {
+	ID        int                    `json:"id"`
+	UserID    int                    `json:"user_id"`
+	Operation string                 `json:"operation"`
+	Actor     string                 `json:"actor"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Changes   map[string]FieldChange `json:"changes"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// FieldChange is the value of a field before and after a mutation. Before is
+// null for created users and After is null for purged users.
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditListParams filters and paginates audit events. Zero values mean "no
+// filter". Field selects events that changed the named field, and Status
+// events that left the user with that status, so Field "status" with Status
+// "T" selects terminations.
+type AuditListParams struct {
+	Limit     int
+	Offset    int
+	UserID    int
+	Actor     string
+	Operation string
+	RequestID string
+	Field     string
+	Status    string
+	Since     time.Time
+	Until     time.Time
+}
+
+// AuditPage is a single page of audit events, newest first.
+type AuditPage struct {
+	Events []AuditEvent `json:"events"`
+	Total  int          `json:"total"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+}
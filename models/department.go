@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Department is an organisational unit. Users refer to their department by
+// Code.
+type Department struct {
+	Code string `json:"code" validate:"required,max=50"`
+	Name string `json:"name" validate:"required"`
+	// ParentCode is the code of the department this one belongs to, if any.
+	ParentCode string    `json:"parent_code,omitempty"`
+	CostCenter string    `json:"cost_center,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
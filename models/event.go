@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Types of the domain events published for user mutations.
+const (
+	EventUserCreated = "user.created"
+	EventUserUpdated = "user.updated"
+	EventUserDeleted = "user.deleted"
+)
+
+// Event is a domain event telling downstream systems about a change to a
+// user. User is the user after the change, or before it for purges, and
+// Changes holds the fields that changed, as in the audit log. Events are
+// delivered at least once; consumers should ignore IDs they have seen.
+type Event struct {
+	ID         int                    `json:"id"`
+	Type       string                 `json:"type"`
+	OccurredAt time.Time              `json:"occurred_at"`
+	Actor      string                 `json:"actor"`
+	RequestID  string                 `json:"request_id,omitempty"`
+	User       User                   `json:"user"`
+	Changes    map[string]FieldChange `json:"changes"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Group is a named set of users that downstream applications use to drive
+// access decisions.
+type Group struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name" validate:"required"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// GroupPage is a single page of a group listing.
+type GroupPage struct {
+	Groups []Group `json:"groups"`
+	Total  int     `json:"total"`
+	Limit  int     `json:"limit"`
+	Offset int     `json:"offset"`
+}
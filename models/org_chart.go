@@ -0,0 +1,8 @@
+package models
+
+// OrgNode is a user in the org chart together with the users reporting to
+// them.
+type OrgNode struct {
+	User
+	Reports []*OrgNode `json:"reports"`
+}
@@ -0,0 +1,27 @@
+package models
+
+// UserListParams controls filtering, ordering and pagination of a user listing.
+// Zero values mean "no filter"; Cursor, when set, takes precedence over Offset.
+// UserName and UserNamePrefix match user names case-insensitively.
+type UserListParams struct {
+	Limit          int
+	Offset         int
+	Cursor         string
+	ID             int
+	UserName       string
+	Status         string
+	Department     string
+	UserNamePrefix string
+	EmailDomain    string
+	Sort           string
+	IncludeDeleted bool
+}
+
+// UserPage is a single page of a user listing.
+type UserPage struct {
+	Users      []User `json:"users"`
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Roles that can be assigned to subjects.
+const (
+	RoleViewer  = "viewer"
+	RoleEditor  = "editor"
+	RoleHRAdmin = "hr-admin"
+	RoleAuditor = "auditor"
+	RoleAdmin   = "admin"
+)
+
+// RoleAssignment grants Role to the authenticated Subject. A non-empty
+// Department limits the users the role may modify to that department; editor
+// assignments always carry one.
+type RoleAssignment struct {
+	ID         int       `json:"id"`
+	Subject    string    `json:"subject" validate:"required"`
+	Role       string    `json:"role" validate:"required,oneof=viewer editor hr-admin auditor admin"`
+	Department string    `json:"department,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
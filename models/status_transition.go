@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Status transition states.
+const (
+	TransitionPending  = "pending"
+	TransitionApplied  = "applied"
+	TransitionFailed   = "failed"
+	TransitionCanceled = "canceled"
+)
+
+// StatusChange asks for a user's status to change, immediately or, when
+// EffectiveAt lies in the future, once it falls due. Terminated users can
+// only be reactivated with Override and a Reason.
+type StatusChange struct {
+	Status      string     `json:"-"`
+	EffectiveAt *time.Time `json:"effective_at,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	Override    bool       `json:"override,omitempty"`
+}
+
+// StatusTransition records a status change of a user, either applied when it
+// was requested or scheduled for EffectiveAt. Scheduled transitions stay
+// pending until they are applied, fail, or are canceled; Error explains
+// failures.
+type StatusTransition struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Status      string     `json:"status" validate:"required,oneof=A I T"`
+	Reason      string     `json:"reason,omitempty"`
+	Override    bool       `json:"override,omitempty"`
+	EffectiveAt time.Time  `json:"effective_at"`
+	RequestedBy string     `json:"requested_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	State       string     `json:"state" validate:"required,oneof=pending applied failed canceled"`
+	Error       string     `json:"error,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
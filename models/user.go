@@ -1,5 +1,14 @@
 package models
 
+import "time"
+
+// User statuses.
+const (
+	StatusActive     = "A"
+	StatusInactive   = "I"
+	StatusTerminated = "T"
+)
+
 type User struct {
 	ID        int    `json:"id"`
 	FirstName string `json:"first_name" validate:"required"`
@@ -9,4 +18,14 @@ type User struct {
 	Email      string `json:"email" validate:"required,email"`
 	Status     string `json:"status" validate:"required,oneof=A I T"`
 	Department string `json:"department" validate:"required"`
+
+	// ManagerID is the ID of the user this user reports to, if any.
+	ManagerID *int `json:"manager_id,omitempty"`
+
+	// Version is incremented on every write and used for optimistic
+	// concurrency control; it is exposed to clients as the ETag.
+	Version int `json:"version"`
+
+	// DeletedAt is set when the user has been soft deleted.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
@@ -0,0 +1,11 @@
+package models
+
+// UserChange is an entry of the change feed: the last change to a user, with
+// the type of the event it published and the user as it is now. Seq, the
+// sequence number of the change, increases with every change to any user, so
+// a client that has seen a change only needs the changes after it.
+type UserChange struct {
+	Seq  int    `json:"seq"`
+	Type string `json:"type"`
+	User User   `json:"user"`
+}
@@ -0,0 +1,20 @@
+package models
+
+// Export formats.
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+	ExportXLSX   = "xlsx"
+)
+
+// ExportOptions controls an export of users.
+type ExportOptions struct {
+	// Format is ExportCSV (the default), ExportNDJSON or ExportXLSX.
+	Format string
+	// Columns are the user fields to export, by JSON name and in order; all
+	// of them by default.
+	Columns []string
+	// Filter selects and orders the users to export. Its Limit, Offset and
+	// Cursor are ignored.
+	Filter UserListParams
+}
@@ -0,0 +1,67 @@
+package models
+
+import "user-service/apperrors"
+
+// Import modes.
+const (
+	// ImportCreate creates the users that do not exist yet and skips the
+	// others.
+	ImportCreate = "create"
+	// ImportUpsert also updates existing users, matched by user name, with
+	// the fields the import gives.
+	ImportUpsert = "upsert"
+	// ImportReplace upserts and also deletes the users missing from the
+	// import.
+	ImportReplace = "replace"
+)
+
+// Import row results.
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportSkipped = "skipped"
+	ImportDeleted = "deleted"
+	ImportError   = "error"
+	// ImportNotApplied marks the writes left undone when an import stops
+	// after committing some of its batches.
+	ImportNotApplied = "not_applied"
+)
+
+// ImportOptions controls a bulk import of users.
+type ImportOptions struct {
+	Mode string
+	// DryRun validates and plans the import without writing anything.
+	DryRun bool
+	// BatchSize is the number of writes applied per transaction; 0 applies
+	// the whole import in one.
+	BatchSize int
+	// Mapping maps column names of the import to user fields by JSON name.
+	// Columns that are not mapped must be named after a field.
+	Mapping map[string]string
+}
+
+// ImportRow reports what an import did, or would do, with one row, or with a
+// user it deleted. Line is the row's line in the import.
+type ImportRow struct {
+	Line     int                    `json:"line,omitempty"`
+	UserName string                 `json:"user_name,omitempty"`
+	UserID   int                    `json:"user_id,omitempty"`
+	Result   string                 `json:"result"`
+	Error    string                 `json:"error,omitempty"`
+	Errors   []apperrors.FieldError `json:"errors,omitempty"`
+}
+
+// ImportReport is the outcome of a bulk import. Error describes the failure
+// that stopped an import after it committed some of its batches.
+type ImportReport struct {
+	Mode       string      `json:"mode"`
+	DryRun     bool        `json:"dry_run"`
+	Created    int         `json:"created"`
+	Updated    int         `json:"updated"`
+	Skipped    int         `json:"skipped"`
+	Deleted    int         `json:"deleted"`
+	Errored    int         `json:"errored"`
+	NotApplied int         `json:"not_applied"`
+	Error      string      `json:"error,omitempty"`
+	Rows       []ImportRow `json:"rows"`
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryDead      = "dead"
+)
+
+// Webhook subscribes URL to the user events of the types in Events. Each
+// event is POSTed to it signed with Secret, which is never returned once the
+// webhook has been created.
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url" validate:"required,url"`
+	Events    []string  `json:"events" validate:"required,min=1,dive,oneof=user.created user.updated user.deleted"`
+	Secret    string    `json:"-" validate:"required"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Subscribes reports whether the webhook receives events of the given type.
+func (w *Webhook) Subscribes(eventType string) bool {
+	for _, t := range w.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// NewWebhook is a freshly created webhook together with its secret, which is
+// shown only once.
+type NewWebhook struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+// WebhookDelivery is the delivery of an event to a webhook. It stays pending,
+// with NextAttemptAt set, until an attempt succeeds or the attempts run out
+// and it is dead-lettered. LastStatus is the HTTP status of the last attempt,
+// 0 if there was no response, and LastError explains its failure.
+type WebhookDelivery struct {
+	ID            int             `json:"id"`
+	WebhookID     int             `json:"webhook_id"`
+	EventID       int             `json:"event_id"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload" swaggertype:"object"`
+	State         string          `json:"state"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt *time.Time      `json:"next_attempt_at,omitempty"`
+	LastStatus    int             `json:"last_status,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	FinishedAt    *time.Time      `json:"finished_at,omitempty"`
+	// Version counts the changes to the delivery, which are only made to the
+	// version they were based on.
+	Version int `json:"-"`
+}
+
+// WebhookDeliveryListParams selects a page of the deliveries of a webhook,
+// newest first; an empty State matches every state.
+type WebhookDeliveryListParams struct {
+	WebhookID int
+	State     string
+	Limit     int
+	Offset    int
+}
+
+// WebhookDeliveryPage is a single page of a webhook's delivery log.
+type WebhookDeliveryPage struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	Total      int               `json:"total"`
+	Limit      int               `json:"limit"`
+	Offset     int               `json:"offset"`
+}
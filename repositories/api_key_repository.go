@@ -0,0 +1,233 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/models"
+
+	"github.com/Masterminds/squirrel"
+)
+
+var ErrAPIKeyNotFound = apperrors.New(apperrors.NotFound, "API key not found")
+
+// APIKeyStore is the persistence contract for API keys. Keys are looked up by
+// the hash of their secret; revoked keys are kept for listing but are never
+// returned by GetAPIKeyByHash.
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key *models.APIKey) error
+	ListAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	// GetAPIKeyByHash returns the active key with the given hash, or
+	// ErrAPIKeyNotFound.
+	GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error)
+	// RevokeAPIKey revokes an active key; it returns ErrAPIKeyNotFound if
+	// there is none with the given id.
+	RevokeAPIKey(ctx context.Context, id int) error
+}
+
+// APIKeyRepository is the SQL implementation of APIKeyStore.
+type APIKeyRepository struct {
+	DB           *sql.DB
+	QueryBuilder squirrel.StatementBuilderType
+
+	dialect dialect
+}
+
+var _ APIKeyStore = (*APIKeyRepository)(nil)
+
+var apiKeyColumns = []string{"id", "name", "subject", "prefix", "key_hash", "created_at", "revoked_at"}
+
+func NewAPIKeyRepository(db *sql.DB) *APIKeyRepository {
+	return &APIKeyRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Question),
+		dialect:      dialectSQLite,
+	}
+}
+
+// NewPostgresAPIKeyRepository returns an APIKeyRepository for a PostgreSQL
+// database, using $n placeholders.
+func NewPostgresAPIKeyRepository(db *sql.DB) *APIKeyRepository {
+	return &APIKeyRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+		dialect:      dialectPostgres,
+	}
+}
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (models.APIKey, error) {
+	var key models.APIKey
+	var revokedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.Name, &key.Subject, &key.Prefix, &key.Hash, &key.CreatedAt, &revokedAt)
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, err
+}
+
+func (r *APIKeyRepository) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	if err := validate.Struct(key); err != nil {
+		return apperrors.Validation(err)
+	}
+	key.CreatedAt = time.Now().UTC()
+
+	insert := r.QueryBuilder.
+		Insert("api_keys").
+		Columns("name", "subject", "prefix", "key_hash", "created_at").
+		Values(key.Name, key.Subject, key.Prefix, key.Hash, key.CreatedAt)
+	if r.dialect == dialectPostgres {
+		query, args, err := insert.Suffix("RETURNING id").ToSql()
+		if err != nil {
+			return err
+		}
+		return r.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID)
+	}
+
+	query, args, err := insert.ToSql()
+	if err != nil {
+		return err
+	}
+	result, err := r.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	key.ID = int(id)
+	return err
+}
+
+func (r *APIKeyRepository) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	query, args, err := r.QueryBuilder.
+		Select(apiKeyColumns...).
+		From("api_keys").
+		OrderBy("id").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (r *APIKeyRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	query, args, err := r.QueryBuilder.
+		Select(apiKeyColumns...).
+		From("api_keys").
+		Where(squirrel.Eq{"key_hash": hash, "revoked_at": nil}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	key, err := scanAPIKey(r.DB.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *APIKeyRepository) RevokeAPIKey(ctx context.Context, id int) error {
+	query, args, err := r.QueryBuilder.
+		Update("api_keys").
+		Set("revoked_at", time.Now().UTC()).
+		Where(squirrel.Eq{"id": id, "revoked_at": nil}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	res, err := r.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// MemoryAPIKeyRepository is an in-memory APIKeyStore for tests and local
+// development.
+type MemoryAPIKeyRepository struct {
+	mu     sync.RWMutex
+	keys   map[int]models.APIKey
+	nextID int
+}
+
+var _ APIKeyStore = (*MemoryAPIKeyRepository)(nil)
+
+func NewMemoryAPIKeyRepository() *MemoryAPIKeyRepository {
+	return &MemoryAPIKeyRepository{keys: make(map[int]models.APIKey), nextID: 1}
+}
+
+func (r *MemoryAPIKeyRepository) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	if err := validate.Struct(key); err != nil {
+		return apperrors.Validation(err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key.ID = r.nextID
+	key.CreatedAt = time.Now().UTC()
+	r.nextID++
+	r.keys[key.ID] = *key
+	return nil
+}
+
+func (r *MemoryAPIKeyRepository) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]models.APIKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (r *MemoryAPIKeyRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range r.keys {
+		if key.Hash == hash && key.RevokedAt == nil {
+			return &key, nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+func (r *MemoryAPIKeyRepository) RevokeAPIKey(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[id]
+	if !ok || key.RevokedAt != nil {
+		return ErrAPIKeyNotFound
+	}
+	now := time.Now().UTC()
+	key.RevokedAt = &now
+	r.keys[id] = key
+	return nil
+}
@@ -0,0 +1,279 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/models"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// SystemActor is recorded as the actor of changes made outside of a request,
+// such as the background purger.
+const SystemActor = "system"
+
+var ErrInvalidAuditField = apperrors.New(apperrors.Invalid, "unknown audit field")
+
+// auditColumns are the columns read into a models.AuditEvent by
+// ListAuditEvents, in order.
+var auditColumns = []string{"id", "user_id", "operation", "actor", "request_id", "changes", "created_at"}
+
+// auditedFields are the user fields, by JSON name, whose changes are recorded.
+var auditedFields = []string{"user_name", "email", "first_name", "last_name", "status", "department", "manager_id", "deleted_at"}
+
+// auditRecord is an audit event together with the columns it is filtered by.
+type auditRecord struct {
+	event  models.AuditEvent
+	fields []string
+	status string
+}
+
+// newAuditRecord describes the mutation of a user from before to after; before
+// is nil for creations and after is nil for purges.
+func newAuditRecord(operation string, ac models.AuditContext, before, after *models.User) (auditRecord, error) {
+	beforeFields, err := userFields(before)
+	if err != nil {
+		return auditRecord{}, err
+	}
+	afterFields, err := userFields(after)
+	if err != nil {
+		return auditRecord{}, err
+	}
+
+	record := auditRecord{event: models.AuditEvent{
+		Operation: operation,
+		Actor:     ac.Actor,
+		RequestID: ac.RequestID,
+		Changes:   map[string]models.FieldChange{},
+		CreatedAt: time.Now().UTC(),
+	}}
+	if record.event.Actor == "" {
+		record.event.Actor = SystemActor
+	}
+	for _, field := range auditedFields {
+		if !reflect.DeepEqual(beforeFields[field], afterFields[field]) {
+			record.event.Changes[field] = models.FieldChange{Before: beforeFields[field], After: afterFields[field]}
+			record.fields = append(record.fields, field)
+		}
+	}
+	current := after
+	if current == nil {
+		current = before
+	}
+	record.event.UserID = current.ID
+	record.status = current.Status
+	return record, nil
+}
+
+// userFields returns the JSON representation of user as a map, or nil.
+func userFields(user *models.User) (map[string]interface{}, error) {
+	if user == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// matches reports whether the record passes the filters in params.
+func (a auditRecord) matches(params models.AuditListParams) bool {
+	e := a.event
+	switch {
+	case params.UserID != 0 && e.UserID != params.UserID,
+		params.Actor != "" && e.Actor != params.Actor,
+		params.Operation != "" && e.Operation != params.Operation,
+		params.RequestID != "" && e.RequestID != params.RequestID,
+		params.Status != "" && a.status != params.Status,
+		!params.Since.IsZero() && e.CreatedAt.Before(params.Since),
+		!params.Until.IsZero() && !e.CreatedAt.Before(params.Until):
+		return false
+	}
+	if params.Field == "" {
+		return true
+	}
+	for _, field := range a.fields {
+		if field == params.Field {
+			return true
+		}
+	}
+	return false
+}
+
+func validateAuditParams(params models.AuditListParams) error {
+	if params.Field == "" {
+		return nil
+	}
+	for _, field := range auditedFields {
+		if field == params.Field {
+			return nil
+		}
+	}
+	return ErrInvalidAuditField
+}
+
+// recordAudit writes the audit event for a mutation, and the domain event it
+// publishes to the outbox, within its transaction.
+func (r *UserRepository) recordAudit(ctx context.Context, tx *sql.Tx, operation string, before, after *models.User) error {
+	record, err := newAuditRecord(operation, r.audit, before, after)
+	if err != nil {
+		return err
+	}
+	changes, err := json.Marshal(record.event.Changes)
+	if err != nil {
+		return err
+	}
+
+	query, args, err := r.QueryBuilder.
+		Insert("audit_events").
+		Columns("user_id", "operation", "actor", "request_id", "user_status", "changed_fields", "changes", "created_at").
+		Values(record.event.UserID, record.event.Operation, record.event.Actor, record.event.RequestID,
+			record.status, ","+strings.Join(record.fields, ",")+",", string(changes), record.event.CreatedAt).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+	event := newEvent(record, before, after)
+	if err := r.enqueueEvent(ctx, tx, event); err != nil {
+		return err
+	}
+	return r.recordChange(ctx, tx, event.Type, after)
+}
+
+// audited runs a mutation of the user with the given id in a transaction and
+// records its audit event. Users that the mutation deletes or terminates are
+// removed from their groups. id is 0 for creations, in which case mutate returns
+// the new user's id. Within WriteUsers or a unit of work the mutation runs in a
+// savepoint of their transaction instead.
+func (r *UserRepository) audited(ctx context.Context, operation string, id int, mutate func(tx *sql.Tx) (int, error)) error {
+	return transact(ctx, r.DB, r.tx, func(tx *sql.Tx) error {
+		return r.auditedIn(ctx, tx, operation, id, mutate)
+	})
+}
+
+// auditedIn is audited within the transaction tx.
+func (r *UserRepository) auditedIn(ctx context.Context, tx *sql.Tx, operation string, id int, mutate func(tx *sql.Tx) (int, error)) error {
+	var err error
+	var before *models.User
+	if id != 0 {
+		before, err = r.getUser(ctx, tx, id, true)
+		if err != nil && !errors.Is(err, ErrUserNotFound) {
+			return err
+		}
+	}
+	if id, err = mutate(tx); err != nil {
+		return err
+	}
+	after, err := r.getUser(ctx, tx, id, true)
+	if errors.Is(err, ErrUserNotFound) {
+		after = nil
+	} else if err != nil {
+		return err
+	}
+
+	if leftGroups(before, after) {
+		if err := r.removeMemberships(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	return r.recordAudit(ctx, tx, operation, before, after)
+}
+
+// ListAuditEvents returns one page of audit events matching params, newest
+// first.
+func (r *UserRepository) ListAuditEvents(ctx context.Context, params models.AuditListParams) (*models.AuditPage, error) {
+	if err := validateAuditParams(params); err != nil {
+		return nil, err
+	}
+	limit := normalizeLimit(params.Limit)
+	filters := auditFilters(params)
+
+	countQuery := r.QueryBuilder.Select("COUNT(*)").From("audit_events")
+	for _, cond := range filters {
+		countQuery = countQuery.Where(cond)
+	}
+	query, args, err := countQuery.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	page := &models.AuditPage{Events: []models.AuditEvent{}, Limit: limit, Offset: params.Offset}
+	if err := r.conn().QueryRowContext(ctx, query, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	selectQuery := r.QueryBuilder.
+		Select(auditColumns...).
+		From("audit_events").
+		OrderBy("id DESC").
+		Limit(uint64(limit)).
+		Offset(uint64(params.Offset))
+	for _, cond := range filters {
+		selectQuery = selectQuery.Where(cond)
+	}
+	query, args, err = selectQuery.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event models.AuditEvent
+		var changes []byte
+		if err := rows.Scan(&event.ID, &event.UserID, &event.Operation, &event.Actor,
+			&event.RequestID, &changes, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(changes, &event.Changes); err != nil {
+			return nil, err
+		}
+		page.Events = append(page.Events, event)
+	}
+	return page, rows.Err()
+}
+
+func auditFilters(params models.AuditListParams) []squirrel.Sqlizer {
+	var conds []squirrel.Sqlizer
+	if params.UserID != 0 {
+		conds = append(conds, squirrel.Eq{"user_id": params.UserID})
+	}
+	if params.Actor != "" {
+		conds = append(conds, squirrel.Eq{"actor": params.Actor})
+	}
+	if params.Operation != "" {
+		conds = append(conds, squirrel.Eq{"operation": params.Operation})
+	}
+	if params.RequestID != "" {
+		conds = append(conds, squirrel.Eq{"request_id": params.RequestID})
+	}
+	if params.Field != "" {
+		conds = append(conds, squirrel.Expr(`changed_fields LIKE ? ESCAPE '\'`, "%,"+escapeLike(params.Field)+",%"))
+	}
+	if params.Status != "" {
+		conds = append(conds, squirrel.Eq{"user_status": params.Status})
+	}
+	if !params.Since.IsZero() {
+		conds = append(conds, squirrel.GtOrEq{"created_at": params.Since.UTC()})
+	}
+	if !params.Until.IsZero() {
+		conds = append(conds, squirrel.Lt{"created_at": params.Until.UTC()})
+	}
+	return conds
+}
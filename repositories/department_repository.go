@@ -0,0 +1,414 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/models"
+
+	"github.com/Masterminds/squirrel"
+)
+
+var (
+	ErrDepartmentNotFound      = apperrors.New(apperrors.NotFound, "department not found")
+	ErrDuplicateDepartment     = apperrors.New(apperrors.Conflict, "duplicate department code")
+	ErrDepartmentInUse         = apperrors.New(apperrors.Conflict, "department has users or sub-departments")
+	ErrUnknownParentDepartment = apperrors.New(apperrors.Invalid, "parent department not found")
+	ErrDepartmentCycle         = apperrors.New(apperrors.Invalid, "department cannot be its own ancestor")
+)
+
+// DepartmentStore is the persistence contract for departments. A department
+// cannot be deleted while users (including soft-deleted ones) or other
+// departments refer to it.
+type DepartmentStore interface {
+	// ListDepartments returns every department, ordered by code.
+	ListDepartments(ctx context.Context) ([]models.Department, error)
+	GetDepartment(ctx context.Context, code string) (*models.Department, error)
+	CreateDepartment(ctx context.Context, department *models.Department) error
+	// UpdateDepartment changes everything but the code of a department.
+	UpdateDepartment(ctx context.Context, department *models.Department) error
+	DeleteDepartment(ctx context.Context, code string) error
+	// UserDepartmentCounts returns how many users, including soft-deleted
+	// ones, have each distinct department value.
+	UserDepartmentCounts(ctx context.Context) (map[string]int, error)
+	// MapUserDepartments rewrites the department of every user whose value is
+	// a key of mapping to the mapped code, recording an audit event for each,
+	// and returns the number of users changed.
+	MapUserDepartments(ctx context.Context, mapping map[string]string) (int, error)
+}
+
+// DepartmentRepository is the SQL implementation of DepartmentStore.
+type DepartmentRepository struct {
+	DB           *sql.DB
+	QueryBuilder squirrel.StatementBuilderType
+
+	dialect dialect
+	tx      *sql.Tx
+	users   *UserRepository
+}
+
+var _ DepartmentStore = (*DepartmentRepository)(nil)
+
+var departmentColumns = []string{"code", "name", "parent_code", "cost_center", "created_at", "updated_at"}
+
+func NewDepartmentRepository(db *sql.DB) *DepartmentRepository {
+	return &DepartmentRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Question),
+		dialect:      dialectSQLite,
+		users:        NewUserRepository(db),
+	}
+}
+
+// NewPostgresDepartmentRepository returns a DepartmentRepository for a
+// PostgreSQL database, using $n placeholders.
+func NewPostgresDepartmentRepository(db *sql.DB) *DepartmentRepository {
+	return &DepartmentRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+		dialect:      dialectPostgres,
+		users:        NewPostgresUserRepository(db),
+	}
+}
+
+// bind returns a copy of the repository whose statements run in tx.
+func (r *DepartmentRepository) bind(tx *sql.Tx) *DepartmentRepository {
+	scoped := *r
+	scoped.tx = tx
+	scoped.users = r.users.bind(tx)
+	return &scoped
+}
+
+func (r *DepartmentRepository) conn() queryer {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.DB
+}
+
+func scanDepartment(row interface{ Scan(...interface{}) error }) (models.Department, error) {
+	var department models.Department
+	var parentCode sql.NullString
+	err := row.Scan(&department.Code, &department.Name, &parentCode, &department.CostCenter,
+		&department.CreatedAt, &department.UpdatedAt)
+	department.ParentCode = parentCode.String
+	return department, err
+}
+
+// nullable stores empty strings as NULL.
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func (r *DepartmentRepository) ListDepartments(ctx context.Context) ([]models.Department, error) {
+	query, args, err := r.QueryBuilder.
+		Select(departmentColumns...).
+		From("departments").
+		OrderBy("code").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	departments := []models.Department{}
+	for rows.Next() {
+		department, err := scanDepartment(rows)
+		if err != nil {
+			return nil, err
+		}
+		departments = append(departments, department)
+	}
+	return departments, rows.Err()
+}
+
+func (r *DepartmentRepository) GetDepartment(ctx context.Context, code string) (*models.Department, error) {
+	return r.getDepartment(ctx, r.conn(), code)
+}
+
+func (r *DepartmentRepository) getDepartment(ctx context.Context, q queryer, code string) (*models.Department, error) {
+	query, args, err := r.QueryBuilder.
+		Select(departmentColumns...).
+		From("departments").
+		Where(squirrel.Eq{"code": code}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	department, err := scanDepartment(q.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDepartmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &department, nil
+}
+
+// checkParent verifies that the parent of department exists and that
+// department is not among the parent's ancestors.
+func (r *DepartmentRepository) checkParent(ctx context.Context, q queryer, department *models.Department) error {
+	for code := department.ParentCode; code != ""; {
+		if code == department.Code {
+			return ErrDepartmentCycle
+		}
+		parent, err := r.getDepartment(ctx, q, code)
+		if errors.Is(err, ErrDepartmentNotFound) {
+			return ErrUnknownParentDepartment
+		}
+		if err != nil {
+			return err
+		}
+		code = parent.ParentCode
+	}
+	return nil
+}
+
+func (r *DepartmentRepository) CreateDepartment(ctx context.Context, department *models.Department) error {
+	if err := validate.Struct(department); err != nil {
+		return apperrors.Validation(err)
+	}
+	if err := r.checkParent(ctx, r.conn(), department); err != nil {
+		return err
+	}
+	department.CreatedAt = time.Now().UTC()
+	department.UpdatedAt = department.CreatedAt
+
+	query, args, err := r.QueryBuilder.
+		Insert("departments").
+		Columns(departmentColumns...).
+		Values(department.Code, department.Name, nullable(department.ParentCode), department.CostCenter,
+			department.CreatedAt, department.UpdatedAt).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = r.conn().ExecContext(ctx, query, args...)
+	if isUniqueConstraintViolation(err) {
+		return ErrDuplicateDepartment
+	}
+	return err
+}
+
+func (r *DepartmentRepository) UpdateDepartment(ctx context.Context, department *models.Department) error {
+	if err := validate.Struct(department); err != nil {
+		return apperrors.Validation(err)
+	}
+	return transact(ctx, r.DB, r.tx, func(tx *sql.Tx) error {
+		stored, err := r.getDepartment(ctx, tx, department.Code)
+		if err != nil {
+			return err
+		}
+		if err := r.checkParent(ctx, tx, department); err != nil {
+			return err
+		}
+		department.CreatedAt = stored.CreatedAt
+		department.UpdatedAt = time.Now().UTC()
+
+		query, args, err := r.QueryBuilder.
+			Update("departments").
+			Set("name", department.Name).
+			Set("parent_code", nullable(department.ParentCode)).
+			Set("cost_center", department.CostCenter).
+			Set("updated_at", department.UpdatedAt).
+			Where(squirrel.Eq{"code": department.Code}).
+			ToSql()
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, query, args...)
+		return err
+	})
+}
+
+func (r *DepartmentRepository) DeleteDepartment(ctx context.Context, code string) error {
+	return transact(ctx, r.DB, r.tx, func(tx *sql.Tx) error {
+		if _, err := r.getDepartment(ctx, tx, code); err != nil {
+			return err
+		}
+		for _, ref := range []squirrel.SelectBuilder{
+			r.QueryBuilder.Select("COUNT(*)").From("users").Where(squirrel.Eq{"department": code}),
+			r.QueryBuilder.Select("COUNT(*)").From("departments").Where(squirrel.Eq{"parent_code": code}),
+		} {
+			query, args, err := ref.ToSql()
+			if err != nil {
+				return err
+			}
+			var count int
+			if err := tx.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrDepartmentInUse
+			}
+		}
+
+		query, args, err := r.QueryBuilder.
+			Delete("departments").
+			Where(squirrel.Eq{"code": code}).
+			ToSql()
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, query, args...)
+		return err
+	})
+}
+
+func (r *DepartmentRepository) UserDepartmentCounts(ctx context.Context) (map[string]int, error) {
+	query, args, err := r.QueryBuilder.
+		Select("department", "COUNT(*)").
+		From("users").
+		GroupBy("department").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var department string
+		var count int
+		if err := rows.Scan(&department, &count); err != nil {
+			return nil, err
+		}
+		counts[department] = count
+	}
+	return counts, rows.Err()
+}
+
+// MapUserDepartments runs in a single transaction. It then validates the
+// users' foreign key to departments, which only applies to new writes until
+// then so that unmapped values do not block the schema migration: PostgreSQL
+// validates the constraint, created NOT VALID, and on SQLite, which cannot,
+// no user may be left that the constraint would reject.
+func (r *DepartmentRepository) MapUserDepartments(ctx context.Context, mapping map[string]string) (int, error) {
+	mapped := 0
+	err := transact(ctx, r.DB, r.tx, func(tx *sql.Tx) error {
+		mapped = 0
+		for _, value := range sortedKeys(mapping) {
+			ids, err := r.userIDsIn(ctx, tx, value)
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				before, err := r.users.getUser(ctx, tx, id, true)
+				if err != nil {
+					return err
+				}
+				query, args, err := r.QueryBuilder.
+					Update("users").
+					Set("department", mapping[value]).
+					Set("version", squirrel.Expr("version + 1")).
+					Where(squirrel.Eq{"id": id}).
+					ToSql()
+				if err != nil {
+					return err
+				}
+				if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+					return err
+				}
+				after, err := r.users.getUser(ctx, tx, id, true)
+				if err != nil {
+					return err
+				}
+				if err := r.users.recordAudit(ctx, tx, models.AuditUpdate, before, after); err != nil {
+					return err
+				}
+				mapped++
+			}
+		}
+
+		if r.dialect == dialectPostgres {
+			_, err := tx.ExecContext(ctx, "ALTER TABLE users VALIDATE CONSTRAINT users_department_fkey")
+			return err
+		}
+		return r.checkUserDepartments(ctx, tx)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return mapped, nil
+}
+
+// checkUserDepartments fails if a user of a SQLite database has a department
+// that is not the code of a department.
+func (r *DepartmentRepository) checkUserDepartments(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, "PRAGMA foreign_key_check(users)")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var table, parent string
+		var id, key int
+		if err := rows.Scan(&table, &id, &parent, &key); err != nil {
+			return err
+		}
+		ids = append(ids, strconv.Itoa(id))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		return fmt.Errorf("users %s have a department that does not exist", strings.Join(ids, ", "))
+	}
+	return nil
+}
+
+func (r *DepartmentRepository) userIDsIn(ctx context.Context, tx *sql.Tx, department string) ([]int, error) {
+	query, args, err := r.QueryBuilder.
+		Select("id").
+		From("users").
+		Where(squirrel.Eq{"department": department}).
+		OrderBy("id").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
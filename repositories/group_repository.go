@@ -0,0 +1,401 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/models"
+
+	"github.com/Masterminds/squirrel"
+)
+
+var (
+	ErrGroupNotFound      = apperrors.New(apperrors.NotFound, "group not found")
+	ErrDuplicateGroupName = apperrors.New(apperrors.Conflict, "duplicate group name")
+	ErrNotGroupMember     = apperrors.New(apperrors.NotFound, "user is not a member of the group")
+	ErrUserCannotJoin     = apperrors.New(apperrors.Conflict, "deleted or terminated users cannot join groups")
+)
+
+// GroupStore is the persistence contract for groups and their members. Only
+// users that are neither deleted nor terminated can be members; UserStore
+// implementations remove the memberships of users that become either.
+type GroupStore interface {
+	ListGroups(ctx context.Context, limit, offset int) (*models.GroupPage, error)
+	GetGroup(ctx context.Context, id int) (*models.Group, error)
+	CreateGroup(ctx context.Context, group *models.Group) error
+	UpdateGroup(ctx context.Context, group *models.Group) error
+	// DeleteGroup deletes the group together with its memberships.
+	DeleteGroup(ctx context.Context, id int) error
+	// AddMember adds the user to the group; adding a member again is not an
+	// error. It returns ErrUserCannotJoin for deleted or terminated users.
+	AddMember(ctx context.Context, groupID, userID int) error
+	// RemoveMember returns ErrNotGroupMember if the user is not a member.
+	RemoveMember(ctx context.Context, groupID, userID int) error
+	// ListMembers returns one page of the group's members, ordered by ID.
+	ListMembers(ctx context.Context, groupID int, limit, offset int) (*models.UserPage, error)
+	// ListUserGroups returns the groups of a user, ordered by name.
+	ListUserGroups(ctx context.Context, userID int) ([]models.Group, error)
+}
+
+// canBelongToGroups reports whether user may be a group member.
+func canBelongToGroups(user *models.User) bool {
+	return user != nil && user.DeletedAt == nil && user.Status != models.StatusTerminated
+}
+
+// leftGroups reports whether a write that changed a user from before to after
+// ends the user's group memberships.
+func leftGroups(before, after *models.User) bool {
+	return canBelongToGroups(before) && !canBelongToGroups(after)
+}
+
+// GroupRepository is the SQL implementation of GroupStore.
+type GroupRepository struct {
+	DB           *sql.DB
+	QueryBuilder squirrel.StatementBuilderType
+
+	dialect dialect
+	tx      *sql.Tx
+	users   *UserRepository
+}
+
+var _ GroupStore = (*GroupRepository)(nil)
+
+var groupColumns = []string{"id", "name", "description", "created_at", "updated_at"}
+
+func NewGroupRepository(db *sql.DB) *GroupRepository {
+	return &GroupRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Question),
+		dialect:      dialectSQLite,
+		users:        NewUserRepository(db),
+	}
+}
+
+// NewPostgresGroupRepository returns a GroupRepository for a PostgreSQL
+// database, using $n placeholders.
+func NewPostgresGroupRepository(db *sql.DB) *GroupRepository {
+	return &GroupRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+		dialect:      dialectPostgres,
+		users:        NewPostgresUserRepository(db),
+	}
+}
+
+// bind returns a copy of the repository whose statements run in tx.
+func (r *GroupRepository) bind(tx *sql.Tx) *GroupRepository {
+	scoped := *r
+	scoped.tx = tx
+	scoped.users = r.users.bind(tx)
+	return &scoped
+}
+
+func (r *GroupRepository) conn() queryer {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.DB
+}
+
+func scanGroup(row interface{ Scan(...interface{}) error }) (models.Group, error) {
+	var group models.Group
+	err := row.Scan(&group.ID, &group.Name, &group.Description, &group.CreatedAt, &group.UpdatedAt)
+	return group, err
+}
+
+func (r *GroupRepository) queryGroups(ctx context.Context, selectQuery squirrel.SelectBuilder) ([]models.Group, error) {
+	query, args, err := selectQuery.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []models.Group{}
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+func (r *GroupRepository) ListGroups(ctx context.Context, limit, offset int) (*models.GroupPage, error) {
+	limit = normalizeLimit(limit)
+	page := &models.GroupPage{Limit: limit, Offset: offset}
+
+	query, args, err := r.QueryBuilder.Select("COUNT(*)").From("groups").ToSql()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.conn().QueryRowContext(ctx, query, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	page.Groups, err = r.queryGroups(ctx, r.QueryBuilder.
+		Select(groupColumns...).
+		From("groups").
+		OrderBy("name", "id").
+		Limit(uint64(limit)).
+		Offset(uint64(offset)))
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (r *GroupRepository) GetGroup(ctx context.Context, id int) (*models.Group, error) {
+	return r.getGroup(ctx, r.conn(), id)
+}
+
+func (r *GroupRepository) getGroup(ctx context.Context, q queryer, id int) (*models.Group, error) {
+	query, args, err := r.QueryBuilder.
+		Select(groupColumns...).
+		From("groups").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	group, err := scanGroup(q.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (r *GroupRepository) CreateGroup(ctx context.Context, group *models.Group) error {
+	if err := validate.Struct(group); err != nil {
+		return apperrors.Validation(err)
+	}
+	group.CreatedAt = time.Now().UTC()
+	group.UpdatedAt = group.CreatedAt
+
+	insert := r.QueryBuilder.
+		Insert("groups").
+		Columns("name", "description", "created_at", "updated_at").
+		Values(group.Name, group.Description, group.CreatedAt, group.UpdatedAt)
+	if r.dialect == dialectPostgres {
+		query, args, err := insert.Suffix("RETURNING id").ToSql()
+		if err != nil {
+			return err
+		}
+		err = r.conn().QueryRowContext(ctx, query, args...).Scan(&group.ID)
+		if isUniqueConstraintViolation(err) {
+			return ErrDuplicateGroupName
+		}
+		return err
+	}
+
+	query, args, err := insert.ToSql()
+	if err != nil {
+		return err
+	}
+	result, err := r.conn().ExecContext(ctx, query, args...)
+	if isUniqueConstraintViolation(err) {
+		return ErrDuplicateGroupName
+	}
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	group.ID = int(id)
+	return err
+}
+
+func (r *GroupRepository) UpdateGroup(ctx context.Context, group *models.Group) error {
+	if err := validate.Struct(group); err != nil {
+		return apperrors.Validation(err)
+	}
+	updatedAt := time.Now().UTC()
+
+	query, args, err := r.QueryBuilder.
+		Update("groups").
+		Set("name", group.Name).
+		Set("description", group.Description).
+		Set("updated_at", updatedAt).
+		Where(squirrel.Eq{"id": group.ID}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	res, err := r.conn().ExecContext(ctx, query, args...)
+	if isUniqueConstraintViolation(err) {
+		return ErrDuplicateGroupName
+	}
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return ErrGroupNotFound
+	}
+
+	stored, err := r.GetGroup(ctx, group.ID)
+	if err != nil {
+		return err
+	}
+	*group = *stored
+	return nil
+}
+
+// DeleteGroup removes the memberships explicitly, as SQLite does not enforce
+// foreign keys unless asked to.
+func (r *GroupRepository) DeleteGroup(ctx context.Context, id int) error {
+	return transact(ctx, r.DB, r.tx, func(tx *sql.Tx) error {
+		if _, err := r.exec(ctx, tx, r.QueryBuilder.Delete("user_groups").Where(squirrel.Eq{"group_id": id})); err != nil {
+			return err
+		}
+		res, err := r.exec(ctx, tx, r.QueryBuilder.Delete("groups").Where(squirrel.Eq{"id": id}))
+		if err != nil {
+			return err
+		}
+		if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+			return ErrGroupNotFound
+		}
+		return nil
+	})
+}
+
+func (r *GroupRepository) exec(ctx context.Context, q queryer, builder squirrel.Sqlizer) (sql.Result, error) {
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	return q.ExecContext(ctx, query, args...)
+}
+
+func (r *GroupRepository) AddMember(ctx context.Context, groupID, userID int) error {
+	return transact(ctx, r.DB, r.tx, func(tx *sql.Tx) error {
+		if _, err := r.getGroup(ctx, tx, groupID); err != nil {
+			return err
+		}
+		user, err := r.users.getUser(ctx, tx, userID, true)
+		if err != nil {
+			return err
+		}
+		if !canBelongToGroups(user) {
+			return ErrUserCannotJoin
+		}
+
+		insert := r.QueryBuilder.
+			Insert("user_groups").
+			Columns("group_id", "user_id", "added_at").
+			Values(groupID, userID, time.Now().UTC())
+		if r.dialect == dialectPostgres {
+			insert = insert.Suffix("ON CONFLICT DO NOTHING")
+		} else {
+			insert = insert.Options("OR IGNORE")
+		}
+		_, err = r.exec(ctx, tx, insert)
+		return err
+	})
+}
+
+func (r *GroupRepository) RemoveMember(ctx context.Context, groupID, userID int) error {
+	res, err := r.exec(ctx, r.conn(), r.QueryBuilder.
+		Delete("user_groups").
+		Where(squirrel.Eq{"group_id": groupID, "user_id": userID}))
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		if _, err := r.GetGroup(ctx, groupID); err != nil {
+			return err
+		}
+		return ErrNotGroupMember
+	}
+	return nil
+}
+
+func (r *GroupRepository) ListMembers(ctx context.Context, groupID int, limit, offset int) (*models.UserPage, error) {
+	if _, err := r.GetGroup(ctx, groupID); err != nil {
+		return nil, err
+	}
+	limit = normalizeLimit(limit)
+	page := &models.UserPage{Users: []models.User{}, Limit: limit, Offset: offset}
+
+	member := squirrel.Eq{"user_groups.group_id": groupID}
+	query, args, err := r.QueryBuilder.
+		Select("COUNT(*)").
+		From("user_groups").
+		Where(member).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.conn().QueryRowContext(ctx, query, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, len(userColumns))
+	for i, column := range userColumns {
+		columns[i] = "users." + column
+	}
+	query, args, err = r.QueryBuilder.
+		Select(columns...).
+		From("users").
+		Join("user_groups ON user_groups.user_id = users.id").
+		Where(member).
+		OrderBy("users.id").
+		Limit(uint64(limit)).
+		Offset(uint64(offset)).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Users = append(page.Users, user)
+	}
+	return page, rows.Err()
+}
+
+func (r *GroupRepository) ListUserGroups(ctx context.Context, userID int) ([]models.Group, error) {
+	if _, err := r.users.GetUserByID(ctx, userID, false); err != nil {
+		return nil, err
+	}
+	columns := make([]string, len(groupColumns))
+	for i, column := range groupColumns {
+		columns[i] = "groups." + column
+	}
+	return r.queryGroups(ctx, r.QueryBuilder.
+		Select(columns...).
+		From("groups").
+		Join("user_groups ON user_groups.group_id = groups.id").
+		Where(squirrel.Eq{"user_groups.user_id": userID}).
+		OrderBy("groups.name", "groups.id"))
+}
+
+// removeMemberships deletes every membership of a user within a write to the
+// user.
+func (r *UserRepository) removeMemberships(ctx context.Context, tx *sql.Tx, userID int) error {
+	query, args, err := r.QueryBuilder.
+		Delete("user_groups").
+		Where(squirrel.Eq{"user_id": userID}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
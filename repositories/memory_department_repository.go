@@ -0,0 +1,160 @@
+package repositories
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/models"
+)
+
+// MemoryDepartmentRepository is an in-memory DepartmentStore. It shares its
+// data with the MemoryUserRepository it was created from.
+type MemoryDepartmentRepository struct {
+	users *MemoryUserRepository
+}
+
+var _ DepartmentStore = (*MemoryDepartmentRepository)(nil)
+
+func NewMemoryDepartmentRepository(users *MemoryUserRepository) *MemoryDepartmentRepository {
+	return &MemoryDepartmentRepository{users: &MemoryUserRepository{memoryData: users.memoryData, inUnit: users.inUnit}}
+}
+
+func (r *MemoryDepartmentRepository) ListDepartments(ctx context.Context) ([]models.Department, error) {
+	r.users.mu.RLock()
+	defer r.users.mu.RUnlock()
+
+	departments := make([]models.Department, 0, len(r.users.departments))
+	for _, department := range r.users.departments {
+		departments = append(departments, department)
+	}
+	sort.Slice(departments, func(i, j int) bool { return departments[i].Code < departments[j].Code })
+	return departments, nil
+}
+
+func (r *MemoryDepartmentRepository) GetDepartment(ctx context.Context, code string) (*models.Department, error) {
+	r.users.mu.RLock()
+	defer r.users.mu.RUnlock()
+
+	department, ok := r.users.departments[code]
+	if !ok {
+		return nil, ErrDepartmentNotFound
+	}
+	return &department, nil
+}
+
+// checkParent verifies that the parent of department exists and that
+// department is not among the parent's ancestors. Callers must hold the lock.
+func (r *MemoryDepartmentRepository) checkParent(department *models.Department) error {
+	for code := department.ParentCode; code != ""; {
+		if code == department.Code {
+			return ErrDepartmentCycle
+		}
+		parent, ok := r.users.departments[code]
+		if !ok {
+			return ErrUnknownParentDepartment
+		}
+		code = parent.ParentCode
+	}
+	return nil
+}
+
+func (r *MemoryDepartmentRepository) CreateDepartment(ctx context.Context, department *models.Department) error {
+	if err := validate.Struct(department); err != nil {
+		return apperrors.Validation(err)
+	}
+
+	defer lockWrites(&r.users.writes, r.users.inUnit)()
+	r.users.mu.Lock()
+	defer r.users.mu.Unlock()
+
+	if _, ok := r.users.departments[department.Code]; ok {
+		return ErrDuplicateDepartment
+	}
+	if err := r.checkParent(department); err != nil {
+		return err
+	}
+	department.CreatedAt = time.Now().UTC()
+	department.UpdatedAt = department.CreatedAt
+	r.users.departments[department.Code] = *department
+	return nil
+}
+
+func (r *MemoryDepartmentRepository) UpdateDepartment(ctx context.Context, department *models.Department) error {
+	if err := validate.Struct(department); err != nil {
+		return apperrors.Validation(err)
+	}
+
+	defer lockWrites(&r.users.writes, r.users.inUnit)()
+	r.users.mu.Lock()
+	defer r.users.mu.Unlock()
+
+	stored, ok := r.users.departments[department.Code]
+	if !ok {
+		return ErrDepartmentNotFound
+	}
+	if err := r.checkParent(department); err != nil {
+		return err
+	}
+	department.CreatedAt = stored.CreatedAt
+	department.UpdatedAt = time.Now().UTC()
+	r.users.departments[department.Code] = *department
+	return nil
+}
+
+func (r *MemoryDepartmentRepository) DeleteDepartment(ctx context.Context, code string) error {
+	defer lockWrites(&r.users.writes, r.users.inUnit)()
+	r.users.mu.Lock()
+	defer r.users.mu.Unlock()
+
+	if _, ok := r.users.departments[code]; !ok {
+		return ErrDepartmentNotFound
+	}
+	for _, user := range r.users.users {
+		if user.Department == code {
+			return ErrDepartmentInUse
+		}
+	}
+	for _, department := range r.users.departments {
+		if department.ParentCode == code {
+			return ErrDepartmentInUse
+		}
+	}
+	delete(r.users.departments, code)
+	return nil
+}
+
+func (r *MemoryDepartmentRepository) UserDepartmentCounts(ctx context.Context) (map[string]int, error) {
+	r.users.mu.RLock()
+	defer r.users.mu.RUnlock()
+
+	counts := map[string]int{}
+	for _, user := range r.users.users {
+		counts[user.Department]++
+	}
+	return counts, nil
+}
+
+func (r *MemoryDepartmentRepository) MapUserDepartments(ctx context.Context, mapping map[string]string) (int, error) {
+	defer lockWrites(&r.users.writes, r.users.inUnit)()
+	r.users.mu.Lock()
+	defer r.users.mu.Unlock()
+
+	mapped := 0
+	for _, user := range r.users.sorted(sortSpec{Key: "id", Column: "id"}) {
+		code, ok := mapping[user.Department]
+		if !ok {
+			continue
+		}
+		before := user
+		user.Department = code
+		user.Version++
+		r.users.users[user.ID] = user
+		if err := r.users.written(models.AuditUpdate, &before, &user); err != nil {
+			return mapped, err
+		}
+		mapped++
+	}
+	return mapped, nil
+}
@@ -0,0 +1,216 @@
+package repositories
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/models"
+)
+
+// MemoryGroupRepository is an in-memory GroupStore. It shares its data with
+// the MemoryUserRepository it was created from, so that the memberships of
+// users deleted or terminated there are removed.
+type MemoryGroupRepository struct {
+	*memoryData
+	inUnit bool
+}
+
+var _ GroupStore = (*MemoryGroupRepository)(nil)
+
+func NewMemoryGroupRepository(users *MemoryUserRepository) *MemoryGroupRepository {
+	return &MemoryGroupRepository{memoryData: users.memoryData, inUnit: users.inUnit}
+}
+
+func (r *MemoryGroupRepository) ListGroups(ctx context.Context, limit, offset int) (*models.GroupPage, error) {
+	limit = normalizeLimit(limit)
+
+	r.mu.RLock()
+	groups := make([]models.Group, 0, len(r.groups))
+	for _, group := range r.groups {
+		groups = append(groups, group)
+	}
+	r.mu.RUnlock()
+	sortGroups(groups)
+
+	page := &models.GroupPage{Groups: []models.Group{}, Total: len(groups), Limit: limit, Offset: offset}
+	if offset < len(groups) {
+		groups = groups[offset:]
+		if len(groups) > limit {
+			groups = groups[:limit]
+		}
+		page.Groups = append(page.Groups, groups...)
+	}
+	return page, nil
+}
+
+func sortGroups(groups []models.Group) {
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Name != groups[j].Name {
+			return groups[i].Name < groups[j].Name
+		}
+		return groups[i].ID < groups[j].ID
+	})
+}
+
+func (r *MemoryGroupRepository) GetGroup(ctx context.Context, id int) (*models.Group, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	group, ok := r.groups[id]
+	if !ok {
+		return nil, ErrGroupNotFound
+	}
+	return &group, nil
+}
+
+func (r *MemoryGroupRepository) CreateGroup(ctx context.Context, group *models.Group) error {
+	if err := validate.Struct(group); err != nil {
+		return apperrors.Validation(err)
+	}
+
+	defer lockWrites(&r.writes, r.inUnit)()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.groupNameTaken(group.Name, 0) {
+		return ErrDuplicateGroupName
+	}
+	group.ID = r.nextGroupID
+	group.CreatedAt = time.Now().UTC()
+	group.UpdatedAt = group.CreatedAt
+	r.nextGroupID++
+	r.groups[group.ID] = *group
+	r.members[group.ID] = map[int]time.Time{}
+	return nil
+}
+
+func (r *MemoryGroupRepository) UpdateGroup(ctx context.Context, group *models.Group) error {
+	if err := validate.Struct(group); err != nil {
+		return apperrors.Validation(err)
+	}
+
+	defer lockWrites(&r.writes, r.inUnit)()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.groups[group.ID]
+	if !ok {
+		return ErrGroupNotFound
+	}
+	if r.groupNameTaken(group.Name, group.ID) {
+		return ErrDuplicateGroupName
+	}
+	stored.Name = group.Name
+	stored.Description = group.Description
+	stored.UpdatedAt = time.Now().UTC()
+	r.groups[group.ID] = stored
+	*group = stored
+	return nil
+}
+
+func (r *MemoryGroupRepository) DeleteGroup(ctx context.Context, id int) error {
+	defer lockWrites(&r.writes, r.inUnit)()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.groups[id]; !ok {
+		return ErrGroupNotFound
+	}
+	delete(r.groups, id)
+	delete(r.members, id)
+	return nil
+}
+
+// groupNameTaken reports whether a group other than exceptID has name.
+// Callers must hold r.mu.
+func (r *MemoryGroupRepository) groupNameTaken(name string, exceptID int) bool {
+	for id, group := range r.groups {
+		if id != exceptID && group.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MemoryGroupRepository) AddMember(ctx context.Context, groupID, userID int) error {
+	defer lockWrites(&r.writes, r.inUnit)()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.groups[groupID]; !ok {
+		return ErrGroupNotFound
+	}
+	user, ok := r.users[userID]
+	if !ok {
+		return &UserNotFoundError{ID: userID}
+	}
+	if !canBelongToGroups(&user) {
+		return ErrUserCannotJoin
+	}
+	if _, ok := r.members[groupID][userID]; !ok {
+		r.members[groupID][userID] = time.Now().UTC()
+	}
+	return nil
+}
+
+func (r *MemoryGroupRepository) RemoveMember(ctx context.Context, groupID, userID int) error {
+	defer lockWrites(&r.writes, r.inUnit)()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	members, ok := r.members[groupID]
+	if !ok {
+		return ErrGroupNotFound
+	}
+	if _, ok := members[userID]; !ok {
+		return ErrNotGroupMember
+	}
+	delete(members, userID)
+	return nil
+}
+
+func (r *MemoryGroupRepository) ListMembers(ctx context.Context, groupID int, limit, offset int) (*models.UserPage, error) {
+	limit = normalizeLimit(limit)
+
+	r.mu.RLock()
+	members, ok := r.members[groupID]
+	if !ok {
+		r.mu.RUnlock()
+		return nil, ErrGroupNotFound
+	}
+	users := make([]models.User, 0, len(members))
+	for id := range members {
+		users = append(users, r.users[id])
+	}
+	r.mu.RUnlock()
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	page := &models.UserPage{Users: []models.User{}, Total: len(users), Limit: limit, Offset: offset}
+	if offset < len(users) {
+		users = users[offset:]
+		if len(users) > limit {
+			users = users[:limit]
+		}
+		page.Users = append(page.Users, users...)
+	}
+	return page, nil
+}
+
+func (r *MemoryGroupRepository) ListUserGroups(ctx context.Context, userID int) ([]models.Group, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if user, ok := r.users[userID]; !ok || user.DeletedAt != nil {
+		return nil, &UserNotFoundError{ID: userID}
+	}
+	groups := []models.Group{}
+	for id, members := range r.members {
+		if _, ok := members[userID]; ok {
+			groups = append(groups, r.groups[id])
+		}
+	}
+	sortGroups(groups)
+	return groups, nil
+}
@@ -0,0 +1,131 @@
+package repositories
+
+import (
+	"context"
+	"sort"
+
+	"user-service/models"
+)
+
+func (r *MemoryUserRepository) ReportingChain(ctx context.Context, id int) ([]models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt != nil {
+		return nil, &UserNotFoundError{ID: id}
+	}
+	chain := []models.User{}
+	// Visited users end the chain should the data ever contain a cycle.
+	visited := map[int]bool{id: true}
+	for user.ManagerID != nil && !visited[*user.ManagerID] {
+		manager, ok := r.users[*user.ManagerID]
+		if !ok || manager.DeletedAt != nil {
+			break
+		}
+		chain = append(chain, manager)
+		visited[manager.ID] = true
+		user = manager
+	}
+	return chain, nil
+}
+
+func (r *MemoryUserRepository) DirectReports(ctx context.Context, id int) ([]models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if user, ok := r.users[id]; !ok || user.DeletedAt != nil {
+		return nil, &UserNotFoundError{ID: id}
+	}
+	return r.reports(id), nil
+}
+
+func (r *MemoryUserRepository) Subtree(ctx context.Context, id int) ([]models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if user, ok := r.users[id]; !ok || user.DeletedAt != nil {
+		return nil, &UserNotFoundError{ID: id}
+	}
+	subtree := []models.User{}
+	// Visited users are left out should the data ever contain a cycle.
+	visited := map[int]bool{id: true}
+	for level := r.reports(id); len(level) > 0; {
+		subtree = append(subtree, level...)
+		for _, user := range level {
+			visited[user.ID] = true
+		}
+		var next []models.User
+		for _, user := range level {
+			for _, report := range r.reports(user.ID) {
+				if !visited[report.ID] {
+					next = append(next, report)
+				}
+			}
+		}
+		sort.Slice(next, func(i, j int) bool { return next[i].ID < next[j].ID })
+		level = next
+	}
+	return subtree, nil
+}
+
+// reports returns the users reporting to the user with the given id that have
+// not been deleted, ordered by ID. Callers must hold r.mu.
+func (r *MemoryUserRepository) reports(id int) []models.User {
+	reports := []models.User{}
+	for _, user := range r.users {
+		if user.ManagerID != nil && *user.ManagerID == id && user.DeletedAt == nil {
+			reports = append(reports, user)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ID < reports[j].ID })
+	return reports
+}
+
+// checkManager verifies that the manager of user exists, has not been deleted
+// and does not report to the user, directly or indirectly. A manager the user
+// already has is not checked again. Callers must hold r.mu.
+func (r *MemoryUserRepository) checkManager(user *models.User) error {
+	if user.ManagerID == nil {
+		return nil
+	}
+	if stored, ok := r.users[user.ID]; ok && stored.ManagerID != nil && *stored.ManagerID == *user.ManagerID {
+		return nil
+	}
+	if *user.ManagerID == user.ID {
+		return ErrManagerCycle
+	}
+	manager, ok := r.users[*user.ManagerID]
+	if !ok || manager.DeletedAt != nil {
+		return ErrUnknownManager
+	}
+	// Deleted users count here: restoring them brings their managers back.
+	for steps := 0; manager.ManagerID != nil && steps < len(r.users); steps++ {
+		if *manager.ManagerID == user.ID {
+			return ErrManagerCycle
+		}
+		if manager, ok = r.users[*manager.ManagerID]; !ok {
+			break
+		}
+	}
+	return nil
+}
+
+// detachReports clears the manager of everyone reporting to the user with the
+// given id, deleted or not, recording an audit event for each. Callers must
+// hold r.mu.
+func (r *MemoryUserRepository) detachReports(id int) error {
+	for _, report := range r.sorted(sortSpec{Key: "id", Column: "id"}) {
+		if report.ManagerID == nil || *report.ManagerID != id {
+			continue
+		}
+		before := report
+		report.ManagerID = nil
+		report.Version++
+		r.users[report.ID] = report
+		if err := r.recordAudit(models.AuditUpdate, &before, &report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
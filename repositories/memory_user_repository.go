@@ -0,0 +1,501 @@
+package repositories
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/models"
+)
+
+// MemoryUserRepository is an in-memory UserStore. It is safe for concurrent
+// use and is intended for tests and local development.
+type MemoryUserRepository struct {
+	*memoryData
+	audit  models.AuditContext
+	inUnit bool
+}
+
+// memoryData is shared by a MemoryUserRepository, its WithAudit copies and
+// the group and department repositories built on it.
+type memoryData struct {
+	mu sync.RWMutex
+	// writes is held by a unit of work for as long as it runs, and by every
+	// write made outside of one (see MemoryTransactor).
+	writes sync.Mutex
+
+	users  map[int]models.User
+	nextID int
+	events []auditRecord
+
+	groups      map[int]models.Group
+	nextGroupID int
+	// members maps group IDs to the IDs of their members and when they were
+	// added.
+	members map[int]map[int]time.Time
+
+	departments map[string]models.Department
+
+	outbox      []models.Event
+	nextEventID int
+	// outboxCursors maps the consumers of the outbox to their cursors.
+	outboxCursors map[string]*outboxCursor
+
+	// changes holds the last change of each user for the change feed, and
+	// changeSeq the sequence number of the latest change.
+	changes   map[int]userChange
+	changeSeq int
+}
+
+var _ UserStore = (*MemoryUserRepository)(nil)
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{memoryData: &memoryData{
+		users:       make(map[int]models.User),
+		nextID:      1,
+		groups:      make(map[int]models.Group),
+		nextGroupID: 1,
+		members:     make(map[int]map[int]time.Time),
+		departments: make(map[string]models.Department),
+		nextEventID: 1,
+		changes:     make(map[int]userChange),
+
+		outboxCursors: make(map[string]*outboxCursor),
+	}}
+}
+
+func (r *MemoryUserRepository) WithAudit(ac models.AuditContext) UserStore {
+	return &MemoryUserRepository{memoryData: r.memoryData, audit: ac, inUnit: r.inUnit}
+}
+
+func (r *MemoryUserRepository) ListAuditEvents(ctx context.Context, params models.AuditListParams) (*models.AuditPage, error) {
+	if err := validateAuditParams(params); err != nil {
+		return nil, err
+	}
+	limit := normalizeLimit(params.Limit)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	page := &models.AuditPage{Events: []models.AuditEvent{}, Limit: limit, Offset: params.Offset}
+	for i := len(r.events) - 1; i >= 0; i-- {
+		if !r.events[i].matches(params) {
+			continue
+		}
+		if page.Total >= params.Offset && len(page.Events) < limit {
+			page.Events = append(page.Events, r.events[i].event)
+		}
+		page.Total++
+	}
+	return page, nil
+}
+
+// written removes users that a mutation deleted or terminated from their
+// groups and records the mutation's audit event. Callers must hold r.mu.
+func (r *MemoryUserRepository) written(operation string, before, after *models.User) error {
+	if leftGroups(before, after) {
+		for _, members := range r.members {
+			delete(members, before.ID)
+		}
+	}
+	return r.recordAudit(operation, before, after)
+}
+
+// recordAudit appends the audit event for a mutation, and the domain event it
+// publishes to the outbox, and records it as the user's last change. Callers
+// must hold r.mu.
+func (r *MemoryUserRepository) recordAudit(operation string, before, after *models.User) error {
+	record, err := newAuditRecord(operation, r.audit, before, after)
+	if err != nil {
+		return err
+	}
+	record.event.ID = len(r.events) + 1
+	r.events = append(r.events, record)
+
+	event := newEvent(record, before, after)
+	event.ID = r.nextEventID
+	r.nextEventID++
+	r.outbox = append(r.outbox, event)
+	r.recordChange(event.Type, before, after)
+	return nil
+}
+
+func (r *MemoryUserRepository) GetAllUsers(ctx context.Context) ([]models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := []models.User{}
+	for _, user := range r.sorted(sortSpec{Key: "id", Column: "id"}) {
+		if user.DeletedAt == nil {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (r *MemoryUserRepository) ListUsers(ctx context.Context, params models.UserListParams) (*models.UserPage, error) {
+	spec, err := parseSort(params.Sort)
+	if err != nil {
+		return nil, err
+	}
+	limit := normalizeLimit(params.Limit)
+
+	var cursor *pageCursor
+	if params.Cursor != "" {
+		c, err := decodeCursor(params.Cursor, spec)
+		if err != nil {
+			return nil, err
+		}
+		cursor = &c
+	}
+
+	r.mu.RLock()
+	all := r.sorted(spec)
+	r.mu.RUnlock()
+
+	matched := make([]models.User, 0, len(all))
+	for _, user := range all {
+		if matchesFilters(user, params) {
+			matched = append(matched, user)
+		}
+	}
+
+	page := &models.UserPage{Users: []models.User{}, Total: len(matched), Limit: limit}
+	rest := matched
+	if cursor != nil {
+		rest = rest[:0:0]
+		for _, user := range matched {
+			if compareUsers(user, models.User{ID: cursor.ID}, cursor.Value, spec) > 0 {
+				rest = append(rest, user)
+			}
+		}
+	} else if params.Offset > 0 {
+		page.Offset = params.Offset
+		if params.Offset >= len(rest) {
+			rest = nil
+		} else {
+			rest = rest[params.Offset:]
+		}
+	}
+
+	if len(rest) > limit {
+		page.Users = append(page.Users, rest[:limit]...)
+		page.NextCursor = cursorFor(page.Users[limit-1], spec)
+	} else {
+		page.Users = append(page.Users, rest...)
+	}
+	return page, nil
+}
+
+// StreamUsers calls fn with each user matching params. The users are copied
+// up front so that fn may write to the repository.
+func (r *MemoryUserRepository) StreamUsers(ctx context.Context, params models.UserListParams, fn func(models.User) error) error {
+	spec, err := parseSort(params.Sort)
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	all := r.sorted(spec)
+	r.mu.RUnlock()
+
+	for _, user := range all {
+		if !matchesFilters(user, params) {
+			continue
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *MemoryUserRepository) GetUserByID(ctx context.Context, id int, includeDeleted bool) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok || (user.DeletedAt != nil && !includeDeleted) {
+		return nil, &UserNotFoundError{ID: id}
+	}
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) CreateUser(ctx context.Context, user *models.User) error {
+	if err := validate.Struct(user); err != nil {
+		return apperrors.Validation(err)
+	}
+
+	defer lockWrites(&r.writes, r.inUnit)()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.userNameTaken(user.UserName, 0) {
+		return ErrDuplicateUsername
+	}
+	if err := r.checkManager(user); err != nil {
+		return err
+	}
+	user.ID = r.nextID
+	user.Version = 1
+	r.nextID++
+	r.users[user.ID] = *user
+	return r.written(models.AuditCreate, nil, user)
+}
+
+func (r *MemoryUserRepository) UpdateUser(ctx context.Context, user *models.User) error {
+	if err := validate.Struct(user); err != nil {
+		return apperrors.Validation(err)
+	}
+
+	defer lockWrites(&r.writes, r.inUnit)()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.writable(user.ID, user.Version, false)
+	if err != nil {
+		return err
+	}
+	if r.userNameTaken(user.UserName, user.ID) {
+		return ErrDuplicateUsername
+	}
+	if err := r.checkManager(user); err != nil {
+		return err
+	}
+	user.Version = stored.Version + 1
+	user.DeletedAt = nil
+	r.users[user.ID] = *user
+	return r.written(models.AuditUpdate, &stored, user)
+}
+
+func (r *MemoryUserRepository) PatchUser(ctx context.Context, user *models.User, fields []string) error {
+	if err := validate.Struct(user); err != nil {
+		return apperrors.Validation(err)
+	}
+	if _, err := patchColumns(user, fields); err != nil {
+		return err
+	}
+
+	defer lockWrites(&r.writes, r.inUnit)()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.writable(user.ID, user.Version, false)
+	if err != nil {
+		return err
+	}
+	if r.userNameTaken(user.UserName, user.ID) {
+		return ErrDuplicateUsername
+	}
+	if err := r.checkManager(user); err != nil {
+		return err
+	}
+	before := stored
+	for _, field := range fields {
+		switch field {
+		case "user_name":
+			stored.UserName = user.UserName
+		case "email":
+			stored.Email = user.Email
+		case "first_name":
+			stored.FirstName = user.FirstName
+		case "last_name":
+			stored.LastName = user.LastName
+		case "status":
+			stored.Status = user.Status
+		case "department":
+			stored.Department = user.Department
+		case "manager_id":
+			stored.ManagerID = user.ManagerID
+		}
+	}
+	stored.Version++
+	user.Version = stored.Version
+	r.users[user.ID] = stored
+	return r.written(models.AuditUpdate, &before, &stored)
+}
+
+func (r *MemoryUserRepository) DeleteUser(ctx context.Context, id int, version int) error {
+	defer lockWrites(&r.writes, r.inUnit)()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.writable(id, version, false)
+	if err != nil {
+		return err
+	}
+	before := stored
+	now := time.Now().UTC()
+	stored.DeletedAt = &now
+	stored.Version++
+	r.users[id] = stored
+	return r.written(models.AuditDelete, &before, &stored)
+}
+
+func (r *MemoryUserRepository) RestoreUser(ctx context.Context, id int, version int) error {
+	defer lockWrites(&r.writes, r.inUnit)()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.writable(id, version, true)
+	if err != nil {
+		return err
+	}
+	before := stored
+	stored.DeletedAt = nil
+	stored.Version++
+	r.users[id] = stored
+	return r.written(models.AuditRestore, &before, &stored)
+}
+
+func (r *MemoryUserRepository) PurgeUser(ctx context.Context, id int, version int) error {
+	defer lockWrites(&r.writes, r.inUnit)()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.writable(id, version, true)
+	if err != nil {
+		return err
+	}
+	return r.purge(stored)
+}
+
+func (r *MemoryUserRepository) PurgeDeletedUsers(ctx context.Context, before time.Time) (int, error) {
+	defer lockWrites(&r.writes, r.inUnit)()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	purged := 0
+	for _, user := range r.sorted(sortSpec{Key: "id", Column: "id"}) {
+		if user.DeletedAt != nil && user.DeletedAt.Before(before) {
+			// Purging an earlier user may have detached this one from its
+			// manager, so the stored copy is the current one.
+			if err := r.purge(r.users[user.ID]); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// WriteUsers applies a batch of writes one by one. Writes to the memory store
+// cannot fail halfway, so each is atomic by itself.
+func (r *MemoryUserRepository) WriteUsers(ctx context.Context, writes []UserWrite) ([]error, error) {
+	errs := make([]error, len(writes))
+	for i, write := range writes {
+		switch {
+		case write.Delete:
+			errs[i] = r.DeleteUser(ctx, write.User.ID, write.User.Version)
+		case write.User.ID == 0:
+			errs[i] = r.CreateUser(ctx, write.User)
+		default:
+			errs[i] = r.UpdateUser(ctx, write.User)
+		}
+	}
+	return errs, nil
+}
+
+// purge permanently removes user, detaching its reports first. Callers must
+// hold r.mu.
+func (r *MemoryUserRepository) purge(user models.User) error {
+	if err := r.detachReports(user.ID); err != nil {
+		return err
+	}
+	delete(r.users, user.ID)
+	return r.written(models.AuditPurge, &user, nil)
+}
+
+// writable returns the stored user for a write that expects the user to be
+// (deleted) or not, checking version when it is non-zero. Callers must hold
+// r.mu.
+func (r *MemoryUserRepository) writable(id int, version int, deleted bool) (models.User, error) {
+	stored, ok := r.users[id]
+	if !ok {
+		return stored, ErrUserNotFound
+	}
+	if (stored.DeletedAt != nil) != deleted {
+		if deleted {
+			return stored, ErrUserNotDeleted
+		}
+		return stored, ErrUserNotFound
+	}
+	if version != 0 && version != stored.Version {
+		return stored, ErrVersionConflict
+	}
+	return stored, nil
+}
+
+// userNameTaken reports whether a user other than exceptID has userName.
+// Callers must hold r.mu.
+func (r *MemoryUserRepository) userNameTaken(userName string, exceptID int) bool {
+	for id, existing := range r.users {
+		if id != exceptID && existing.UserName == userName {
+			return true
+		}
+	}
+	return false
+}
+
+// sorted returns a copy of all users ordered by spec. Callers must hold r.mu.
+func (r *MemoryUserRepository) sorted(spec sortSpec) []models.User {
+	users := make([]models.User, 0, len(r.users))
+	for _, user := range r.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return compareUsers(users[i], users[j], sortValue(users[j], spec.Key), spec) < 0
+	})
+	return users
+}
+
+// compareUsers orders a before or after b (whose sort key is bValue) in the
+// same way as the SQL ORDER BY produced by spec.orderBy.
+func compareUsers(a, b models.User, bValue string, spec sortSpec) int {
+	cmp := 0
+	if spec.Column != "id" {
+		cmp = strings.Compare(sortValue(a, spec.Key), bValue)
+	}
+	if cmp == 0 {
+		switch {
+		case a.ID < b.ID:
+			cmp = -1
+		case a.ID > b.ID:
+			cmp = 1
+		}
+	}
+	if spec.Desc {
+		return -cmp
+	}
+	return cmp
+}
+
+func matchesFilters(user models.User, params models.UserListParams) bool {
+	if user.DeletedAt != nil && !params.IncludeDeleted {
+		return false
+	}
+	if params.ID != 0 && user.ID != params.ID {
+		return false
+	}
+	if params.UserName != "" && strings.ToLower(user.UserName) != strings.ToLower(params.UserName) {
+		return false
+	}
+	if params.Status != "" && user.Status != params.Status {
+		return false
+	}
+	if params.Department != "" && user.Department != params.Department {
+		return false
+	}
+	if params.UserNamePrefix != "" && !strings.HasPrefix(strings.ToLower(user.UserName), strings.ToLower(params.UserNamePrefix)) {
+		return false
+	}
+	if params.EmailDomain != "" {
+		domain := strings.ToLower(strings.TrimPrefix(params.EmailDomain, "@"))
+		if !strings.HasSuffix(strings.ToLower(user.Email), "@"+domain) {
+			return false
+		}
+	}
+	return true
+}
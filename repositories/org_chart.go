@@ -0,0 +1,245 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"user-service/apperrors"
+	"user-service/models"
+
+	"github.com/Masterminds/squirrel"
+)
+
+var (
+	ErrUnknownManager = apperrors.New(apperrors.Invalid, "manager not found")
+	ErrManagerCycle   = apperrors.New(apperrors.Invalid, "user cannot report to themselves, directly or indirectly")
+)
+
+// managerChain selects, as chain(id, manager_id), the user with the given id
+// and everyone above them, whether deleted or not. UNION rather than UNION
+// ALL stops the recursion should the data ever contain a cycle.
+const managerChain = `WITH RECURSIVE chain (id, manager_id) AS (
+	SELECT id, manager_id FROM users WHERE id = ?
+	UNION
+	SELECT users.id, users.manager_id FROM users JOIN chain ON users.id = chain.manager_id
+)`
+
+// reportingChain selects, as chain(id, depth), the managers of the user with
+// the given id, stopping at the first deleted one. path lists the IDs visited
+// so far, starting with the user's, and stops the recursion should the data
+// ever contain a cycle.
+const reportingChain = `WITH RECURSIVE chain (id, depth, path) AS (
+	SELECT manager_id, 1, ',' || CAST(id AS TEXT) || ',' || CAST(manager_id AS TEXT) || ','
+	FROM users WHERE id = ? AND manager_id IS NOT NULL
+	UNION ALL
+	SELECT users.manager_id, chain.depth + 1, chain.path || CAST(users.manager_id AS TEXT) || ','
+	FROM users JOIN chain ON users.id = chain.id
+	WHERE users.deleted_at IS NULL AND users.manager_id IS NOT NULL
+	AND chain.path NOT LIKE ('%,' || CAST(users.manager_id AS TEXT) || ',%')
+)`
+
+// reportSubtree selects, as subtree(id, depth), the direct and indirect
+// reports of the user with the given id that have not been deleted. path
+// guards against cycles like that of reportingChain.
+const reportSubtree = `WITH RECURSIVE subtree (id, depth, path) AS (
+	SELECT id, 1, ',' || CAST(manager_id AS TEXT) || ',' || CAST(id AS TEXT) || ','
+	FROM users WHERE manager_id = ? AND deleted_at IS NULL
+	UNION ALL
+	SELECT users.id, subtree.depth + 1, subtree.path || CAST(users.id AS TEXT) || ','
+	FROM users JOIN subtree ON users.manager_id = subtree.id
+	WHERE users.deleted_at IS NULL
+	AND subtree.path NOT LIKE ('%,' || CAST(users.id AS TEXT) || ',%')
+)`
+
+// qualifiedUserColumns are userColumns prefixed with the users table, for
+// queries that join it.
+var qualifiedUserColumns = func() []string {
+	columns := make([]string, len(userColumns))
+	for i, column := range userColumns {
+		columns[i] = "users." + column
+	}
+	return columns
+}()
+
+// ReportingChain returns the managers of the user with the given id, from the
+// direct manager upwards. A deleted manager ends the chain.
+func (r *UserRepository) ReportingChain(ctx context.Context, id int) ([]models.User, error) {
+	if _, err := r.getUser(ctx, r.conn(), id, false); err != nil {
+		return nil, err
+	}
+	return r.queryUsers(ctx, r.conn(), r.QueryBuilder.
+		Select(qualifiedUserColumns...).
+		Prefix(reportingChain, id).
+		From("chain").
+		Join("users ON users.id = chain.id").
+		Where(notDeleted).
+		OrderBy("chain.depth"))
+}
+
+// DirectReports returns the users reporting to the user with the given id,
+// ordered by ID.
+func (r *UserRepository) DirectReports(ctx context.Context, id int) ([]models.User, error) {
+	if _, err := r.getUser(ctx, r.conn(), id, false); err != nil {
+		return nil, err
+	}
+	return r.queryUsers(ctx, r.conn(), r.QueryBuilder.
+		Select(userColumns...).
+		From("users").
+		Where(squirrel.Eq{"manager_id": id}).
+		Where(notDeleted).
+		OrderBy("id"))
+}
+
+// Subtree returns the direct and indirect reports of the user with the given
+// id, breadth first and ordered by ID within each level. Deleted users are
+// left out together with their reports.
+func (r *UserRepository) Subtree(ctx context.Context, id int) ([]models.User, error) {
+	if _, err := r.getUser(ctx, r.conn(), id, false); err != nil {
+		return nil, err
+	}
+	return r.queryUsers(ctx, r.conn(), r.QueryBuilder.
+		Select(qualifiedUserColumns...).
+		Prefix(reportSubtree, id).
+		From("subtree").
+		Join("users ON users.id = subtree.id").
+		OrderBy("subtree.depth", "users.id"))
+}
+
+// queryUsers runs a query selecting userColumns.
+func (r *UserRepository) queryUsers(ctx context.Context, q queryer, selectQuery squirrel.SelectBuilder) ([]models.User, error) {
+	query, args, err := selectQuery.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// checkManager verifies, within a write of user, that the user's manager
+// exists, has not been deleted and does not report to the user, directly or
+// indirectly. A manager the user already has is not checked again.
+func (r *UserRepository) checkManager(ctx context.Context, q queryer, user *models.User) error {
+	if user.ManagerID == nil {
+		return nil
+	}
+	managerID := *user.ManagerID
+	if user.ID != 0 {
+		stored, err := r.getUser(ctx, q, user.ID, true)
+		if err != nil && !errors.Is(err, ErrUserNotFound) {
+			return err
+		}
+		if stored != nil && stored.ManagerID != nil && *stored.ManagerID == managerID {
+			return nil
+		}
+	}
+	if managerID == user.ID {
+		return ErrManagerCycle
+	}
+	if _, err := r.getUser(ctx, q, managerID, false); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return ErrUnknownManager
+		}
+		return err
+	}
+	if user.ID == 0 {
+		return nil
+	}
+	if err := r.lockManagerChain(ctx, q, user.ID, managerID); err != nil {
+		return err
+	}
+
+	query, args, err := r.QueryBuilder.
+		Select("COUNT(*)").
+		Prefix(managerChain, managerID).
+		From("chain").
+		Where(squirrel.Eq{"id": user.ID}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	var found int
+	if err := q.QueryRowContext(ctx, query, args...).Scan(&found); err != nil {
+		return err
+	}
+	if found > 0 {
+		return ErrManagerCycle
+	}
+	return nil
+}
+
+// lockManagerChain locks, on PostgreSQL, the row of the user with the given
+// id together with those of the manager with managerID and everyone above
+// them, so that concurrent writes cannot each pass checkManager and together
+// store a cycle. The check must read the chain again once the locks are held,
+// as a write that held them first may have changed it. SQLite runs one write
+// transaction at a time and needs no locks.
+func (r *UserRepository) lockManagerChain(ctx context.Context, q queryer, id, managerID int) error {
+	if r.dialect != dialectPostgres {
+		return nil
+	}
+	query, args, err := r.QueryBuilder.
+		Select("id").
+		Prefix(managerChain, managerID).
+		From("users").
+		Where(squirrel.Or{
+			squirrel.Eq{"id": id},
+			squirrel.Expr("id IN (SELECT id FROM chain)"),
+		}).
+		OrderBy("id").
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, query, args...)
+	return err
+}
+
+// detachReports clears the manager of everyone reporting to the user with the
+// given id, deleted or not, within a write that removes the user. Each report
+// gets an audit event of its own.
+func (r *UserRepository) detachReports(ctx context.Context, tx *sql.Tx, id int) error {
+	reports, err := r.queryUsers(ctx, tx, r.QueryBuilder.
+		Select(userColumns...).
+		From("users").
+		Where(squirrel.Eq{"manager_id": id}))
+	if err != nil || len(reports) == 0 {
+		return err
+	}
+
+	query, args, err := r.QueryBuilder.
+		Update("users").
+		Set("manager_id", nil).
+		Set("version", squirrel.Expr("version + 1")).
+		Where(squirrel.Eq{"manager_id": id}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+	for _, before := range reports {
+		after := before
+		after.ManagerID = nil
+		after.Version++
+		if err := r.recordAudit(ctx, tx, models.AuditUpdate, &before, &after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"user-service/models"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// OutboxStore holds the domain events that user mutations write to the
+// outbox, in the same transaction as the mutation. Each consumer of the
+// outbox, such as a sink, reads the events from a cursor of its own, so that
+// one that fails holds up no other.
+type OutboxStore interface {
+	// PendingEvents returns up to limit events that have not been delivered
+	// to the named consumer, oldest first.
+	PendingEvents(ctx context.Context, consumer string, limit int) ([]models.Event, error)
+	// MarkDelivered records that the events up to and including the one with
+	// the given id were delivered to the named consumer.
+	MarkDelivered(ctx context.Context, consumer string, id int) error
+	// MarkFailed records a failed attempt to deliver the event with the given
+	// id to the named consumer and its error.
+	MarkFailed(ctx context.Context, consumer string, id int, errMsg string) error
+	// PruneDelivered removes the events written before the given time that
+	// were delivered to all the named consumers and returns how many it
+	// removed.
+	PruneDelivered(ctx context.Context, consumers []string, before time.Time) (int, error)
+}
+
+// eventTypes maps the audit operations to the type of event they publish.
+// Purges publish another user.deleted, for consumers that missed the soft
+// delete.
+var eventTypes = map[string]string{
+	models.AuditCreate:  models.EventUserCreated,
+	models.AuditUpdate:  models.EventUserUpdated,
+	models.AuditRestore: models.EventUserUpdated,
+	models.AuditDelete:  models.EventUserDeleted,
+	models.AuditPurge:   models.EventUserDeleted,
+}
+
+// newEvent returns the event published for the mutation that record
+// describes; see newAuditRecord.
+func newEvent(record auditRecord, before, after *models.User) models.Event {
+	user := after
+	if user == nil {
+		user = before
+	}
+	return models.Event{
+		Type:       eventTypes[record.event.Operation],
+		OccurredAt: record.event.CreatedAt,
+		Actor:      record.event.Actor,
+		RequestID:  record.event.RequestID,
+		User:       *user,
+		Changes:    record.event.Changes,
+	}
+}
+
+// enqueueEvent writes an event to the outbox within the transaction of its
+// mutation.
+func (r *UserRepository) enqueueEvent(ctx context.Context, tx *sql.Tx, event models.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	query, args, err := r.QueryBuilder.
+		Insert("outbox").
+		Columns("event_type", "user_id", "payload", "created_at").
+		Values(event.Type, event.User.ID, string(payload), event.OccurredAt).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// OutboxRepository is the SQL implementation of OutboxStore.
+type OutboxRepository struct {
+	DB           *sql.DB
+	QueryBuilder squirrel.StatementBuilderType
+}
+
+var _ OutboxStore = (*OutboxRepository)(nil)
+
+func NewOutboxRepository(db *sql.DB) *OutboxRepository {
+	return &OutboxRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Question),
+	}
+}
+
+// NewPostgresOutboxRepository returns an OutboxRepository for a PostgreSQL
+// database, using $n placeholders.
+func NewPostgresOutboxRepository(db *sql.DB) *OutboxRepository {
+	return &OutboxRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+	}
+}
+
+func (r *OutboxRepository) PendingEvents(ctx context.Context, consumer string, limit int) ([]models.Event, error) {
+	query, args, err := r.QueryBuilder.
+		Select("id", "payload").
+		From("outbox").
+		Where(squirrel.Expr("id > COALESCE((SELECT event_id FROM outbox_cursors WHERE consumer = ?), 0)", consumer)).
+		OrderBy("id").
+		Limit(uint64(limit)).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.Event{}
+	for rows.Next() {
+		var id int
+		var payload []byte
+		if err := rows.Scan(&id, &payload); err != nil {
+			return nil, err
+		}
+		var event models.Event
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, err
+		}
+		event.ID = id
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (r *OutboxRepository) MarkDelivered(ctx context.Context, consumer string, id int) error {
+	return r.exec(ctx, r.QueryBuilder.
+		Insert("outbox_cursors").
+		Columns("consumer", "event_id", "attempts", "last_error", "updated_at").
+		Values(consumer, id, 0, "", time.Now().UTC()).
+		Suffix("ON CONFLICT (consumer) DO UPDATE SET event_id = excluded.event_id, "+
+			"attempts = 0, last_error = '', updated_at = excluded.updated_at"))
+}
+
+// MarkFailed leaves the cursor where it is; a consumer that has none yet
+// starts at the beginning of the outbox, as if it had one at 0.
+func (r *OutboxRepository) MarkFailed(ctx context.Context, consumer string, id int, errMsg string) error {
+	return r.exec(ctx, r.QueryBuilder.
+		Insert("outbox_cursors").
+		Columns("consumer", "event_id", "attempts", "last_error", "updated_at").
+		Values(consumer, 0, 1, errMsg, time.Now().UTC()).
+		Suffix("ON CONFLICT (consumer) DO UPDATE SET attempts = outbox_cursors.attempts + 1, "+
+			"last_error = excluded.last_error, updated_at = excluded.updated_at"))
+}
+
+func (r *OutboxRepository) exec(ctx context.Context, stmt squirrel.Sqlizer) error {
+	query, args, err := stmt.ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = r.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (r *OutboxRepository) PruneDelivered(ctx context.Context, consumers []string, before time.Time) (int, error) {
+	if len(consumers) == 0 {
+		return 0, nil
+	}
+	query, args, err := r.QueryBuilder.
+		Select("COUNT(*)", "COALESCE(MIN(event_id), 0)").
+		From("outbox_cursors").
+		Where(squirrel.Eq{"consumer": consumers}).
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+	var cursors, delivered int
+	if err := r.DB.QueryRowContext(ctx, query, args...).Scan(&cursors, &delivered); err != nil {
+		return 0, err
+	}
+	// A consumer without a cursor has been delivered nothing yet
+	if cursors < len(consumers) || delivered == 0 {
+		return 0, nil
+	}
+
+	query, args, err = r.QueryBuilder.
+		Delete("outbox").
+		Where(squirrel.LtOrEq{"id": delivered}).
+		Where(squirrel.Lt{"created_at": before.UTC()}).
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := r.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	pruned, err := res.RowsAffected()
+	return int(pruned), err
+}
+
+// outboxCursor is the cursor of a consumer of the outbox of the memory
+// stores.
+type outboxCursor struct {
+	eventID   int
+	attempts  int
+	lastError string
+}
+
+// MemoryOutboxRepository is an in-memory OutboxStore holding the events of
+// the MemoryUserRepository it was created from.
+type MemoryOutboxRepository struct {
+	*memoryData
+}
+
+var _ OutboxStore = (*MemoryOutboxRepository)(nil)
+
+func NewMemoryOutboxRepository(users *MemoryUserRepository) *MemoryOutboxRepository {
+	return &MemoryOutboxRepository{memoryData: users.memoryData}
+}
+
+func (r *MemoryOutboxRepository) PendingEvents(ctx context.Context, consumer string, limit int) ([]models.Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	delivered := 0
+	if cursor := r.outboxCursors[consumer]; cursor != nil {
+		delivered = cursor.eventID
+	}
+	events := []models.Event{}
+	for _, event := range r.outbox {
+		if len(events) == limit {
+			break
+		}
+		if event.ID > delivered {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (r *MemoryOutboxRepository) MarkDelivered(ctx context.Context, consumer string, id int) error {
+	r.update(consumer, func(cursor *outboxCursor) {
+		*cursor = outboxCursor{eventID: id}
+	})
+	return nil
+}
+
+func (r *MemoryOutboxRepository) MarkFailed(ctx context.Context, consumer string, id int, errMsg string) error {
+	r.update(consumer, func(cursor *outboxCursor) {
+		cursor.attempts++
+		cursor.lastError = errMsg
+	})
+	return nil
+}
+
+func (r *MemoryOutboxRepository) update(consumer string, fn func(cursor *outboxCursor)) {
+	defer lockWrites(&r.writes, false)()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cursor := r.outboxCursors[consumer]
+	if cursor == nil {
+		cursor = &outboxCursor{}
+		r.outboxCursors[consumer] = cursor
+	}
+	fn(cursor)
+}
+
+func (r *MemoryOutboxRepository) PruneDelivered(ctx context.Context, consumers []string, before time.Time) (int, error) {
+	defer lockWrites(&r.writes, false)()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(consumers) == 0 {
+		return 0, nil
+	}
+	delivered := -1
+	for _, consumer := range consumers {
+		cursor := r.outboxCursors[consumer]
+		if cursor == nil {
+			return 0, nil
+		}
+		if delivered < 0 || cursor.eventID < delivered {
+			delivered = cursor.eventID
+		}
+	}
+	kept := r.outbox[:0]
+	for _, event := range r.outbox {
+		if event.ID > delivered || !event.OccurredAt.Before(before) {
+			kept = append(kept, event)
+		}
+	}
+	pruned := len(r.outbox) - len(kept)
+	r.outbox = kept
+	return pruned, nil
+}
@@ -0,0 +1,189 @@
+package repositories
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"user-service/apperrors"
+	"user-service/models"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+var (
+	ErrInvalidSort   = apperrors.New(apperrors.Invalid, "invalid sort field")
+	ErrInvalidCursor = apperrors.New(apperrors.Invalid, "invalid cursor")
+)
+
+// sortableColumns whitelists the sort keys accepted from clients and maps them
+// to their column names.
+var sortableColumns = map[string]string{
+	"id":         "id",
+	"user_name":  "user_name",
+	"email":      "email",
+	"first_name": "first_name",
+	"last_name":  "last_name",
+	"status":     "user_status",
+	"department": "department",
+}
+
+// sortSpec is a parsed sort parameter such as "-user_name".
+type sortSpec struct {
+	Key    string
+	Column string
+	Desc   bool
+}
+
+func parseSort(sort string) (sortSpec, error) {
+	if sort == "" {
+		sort = "id"
+	}
+	spec := sortSpec{Key: sort}
+	if strings.HasPrefix(sort, "-") {
+		spec.Desc = true
+		spec.Key = sort[1:]
+	}
+	column, ok := sortableColumns[spec.Key]
+	if !ok {
+		return sortSpec{}, ErrInvalidSort
+	}
+	spec.Column = column
+	return spec, nil
+}
+
+func (s sortSpec) String() string {
+	if s.Desc {
+		return "-" + s.Key
+	}
+	return s.Key
+}
+
+// orderBy returns the ORDER BY terms, using id as a tie-breaker so that the
+// ordering is total and keyset pagination is stable.
+func (s sortSpec) orderBy() []string {
+	dir := " ASC"
+	if s.Desc {
+		dir = " DESC"
+	}
+	if s.Column == "id" {
+		return []string{"id" + dir}
+	}
+	return []string{s.Column + dir, "id" + dir}
+}
+
+// pageCursor is the decoded form of the opaque cursor handed to clients. It
+// records the sort it was issued for and the sort key of the last row seen.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    int    `json:"id"`
+}
+
+func encodeCursor(c pageCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(raw string, spec sortSpec) (pageCursor, error) {
+	var c pageCursor
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	if c.Sort != spec.String() {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// cursorFor builds the cursor pointing just after the given user.
+func cursorFor(user models.User, spec sortSpec) string {
+	return encodeCursor(pageCursor{Sort: spec.String(), Value: sortValue(user, spec.Key), ID: user.ID})
+}
+
+func sortValue(user models.User, key string) string {
+	switch key {
+	case "user_name":
+		return user.UserName
+	case "email":
+		return user.Email
+	case "first_name":
+		return user.FirstName
+	case "last_name":
+		return user.LastName
+	case "status":
+		return user.Status
+	case "department":
+		return user.Department
+	}
+	return strconv.Itoa(user.ID)
+}
+
+// after returns the keyset condition selecting rows that sort after the cursor.
+func (c pageCursor) after(spec sortSpec) squirrel.Sqlizer {
+	cmp := ">"
+	if spec.Desc {
+		cmp = "<"
+	}
+	if spec.Column == "id" {
+		return squirrel.Expr("id "+cmp+" ?", c.ID)
+	}
+	return squirrel.Or{
+		squirrel.Expr(spec.Column+" "+cmp+" ?", c.Value),
+		squirrel.And{
+			squirrel.Eq{spec.Column: c.Value},
+			squirrel.Expr("id "+cmp+" ?", c.ID),
+		},
+	}
+}
+
+// userFilters translates the filter fields of params into WHERE conditions.
+func userFilters(params models.UserListParams) []squirrel.Sqlizer {
+	var conds []squirrel.Sqlizer
+	if !params.IncludeDeleted {
+		conds = append(conds, notDeleted)
+	}
+	if params.ID != 0 {
+		conds = append(conds, squirrel.Eq{"id": params.ID})
+	}
+	if params.UserName != "" {
+		conds = append(conds, squirrel.Eq{"LOWER(user_name)": strings.ToLower(params.UserName)})
+	}
+	if params.Status != "" {
+		conds = append(conds, squirrel.Eq{"user_status": params.Status})
+	}
+	if params.Department != "" {
+		conds = append(conds, squirrel.Eq{"department": params.Department})
+	}
+	if params.UserNamePrefix != "" {
+		conds = append(conds, squirrel.Expr(`LOWER(user_name) LIKE ? ESCAPE '\'`, escapeLike(strings.ToLower(params.UserNamePrefix))+"%"))
+	}
+	if params.EmailDomain != "" {
+		domain := strings.ToLower(strings.TrimPrefix(params.EmailDomain, "@"))
+		conds = append(conds, squirrel.Expr(`LOWER(email) LIKE ? ESCAPE '\'`, "%@"+escapeLike(domain)))
+	}
+	return conds
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func normalizeLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return limit
+}
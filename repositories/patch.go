@@ -0,0 +1,35 @@
+package repositories
+
+import (
+	"fmt"
+
+	"user-service/models"
+)
+
+// patchableFields maps the JSON name of each writable user field to its
+// column and value.
+var patchableFields = map[string]struct {
+	column string
+	value  func(*models.User) interface{}
+}{
+	"user_name":  {"user_name", func(u *models.User) interface{} { return u.UserName }},
+	"email":      {"email", func(u *models.User) interface{} { return u.Email }},
+	"first_name": {"first_name", func(u *models.User) interface{} { return u.FirstName }},
+	"last_name":  {"last_name", func(u *models.User) interface{} { return u.LastName }},
+	"status":     {"user_status", func(u *models.User) interface{} { return u.Status }},
+	"department": {"department", func(u *models.User) interface{} { return u.Department }},
+	"manager_id": {"manager_id", func(u *models.User) interface{} { return u.ManagerID }},
+}
+
+// patchColumns returns the column values to SET for the given fields.
+func patchColumns(user *models.User, fields []string) (map[string]interface{}, error) {
+	set := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		f, ok := patchableFields[field]
+		if !ok {
+			return nil, fmt.Errorf("field %q cannot be patched", field)
+		}
+		set[f.column] = f.value(user)
+	}
+	return set, nil
+}
@@ -0,0 +1,33 @@
+package repositories
+
+import (
+	"database/sql"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// dialect identifies the SQL database a repository talks to, for the few
+// statements that cannot be written portably.
+type dialect int
+
+const (
+	dialectSQLite dialect = iota
+	dialectPostgres
+)
+
+// PostgreSQL SQLSTATEs the repositories look for.
+const (
+	pqUniqueViolation      = "23505"
+	pqSerializationFailure = "40001"
+	pqDeadlockDetected     = "40P01"
+)
+
+// NewPostgresUserRepository returns a UserRepository for a PostgreSQL
+// database, using $n placeholders.
+func NewPostgresUserRepository(db *sql.DB) *UserRepository {
+	return &UserRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+		dialect:      dialectPostgres,
+	}
+}
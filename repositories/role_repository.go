@@ -0,0 +1,204 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/models"
+
+	"github.com/Masterminds/squirrel"
+)
+
+var (
+	ErrRoleAssignmentNotFound  = apperrors.New(apperrors.NotFound, "role assignment not found")
+	ErrDuplicateRoleAssignment = apperrors.New(apperrors.Conflict, "role is already assigned")
+)
+
+// RoleStore is the persistence contract for role assignments.
+type RoleStore interface {
+	// ListRoleAssignments returns the assignments of subject, or of every
+	// subject if it is empty.
+	ListRoleAssignments(ctx context.Context, subject string) ([]models.RoleAssignment, error)
+	// AssignRole returns ErrDuplicateRoleAssignment if the subject already
+	// holds the role in the same department.
+	AssignRole(ctx context.Context, assignment *models.RoleAssignment) error
+	// UnassignRole returns ErrRoleAssignmentNotFound if there is no
+	// assignment with the given id.
+	UnassignRole(ctx context.Context, id int) error
+}
+
+// RoleRepository is the SQL implementation of RoleStore.
+type RoleRepository struct {
+	DB           *sql.DB
+	QueryBuilder squirrel.StatementBuilderType
+
+	dialect dialect
+}
+
+var _ RoleStore = (*RoleRepository)(nil)
+
+func NewRoleRepository(db *sql.DB) *RoleRepository {
+	return &RoleRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Question),
+		dialect:      dialectSQLite,
+	}
+}
+
+// NewPostgresRoleRepository returns a RoleRepository for a PostgreSQL
+// database, using $n placeholders.
+func NewPostgresRoleRepository(db *sql.DB) *RoleRepository {
+	return &RoleRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+		dialect:      dialectPostgres,
+	}
+}
+
+func (r *RoleRepository) ListRoleAssignments(ctx context.Context, subject string) ([]models.RoleAssignment, error) {
+	selectQuery := r.QueryBuilder.
+		Select("id", "subject", "role", "department", "created_at").
+		From("role_assignments").
+		OrderBy("id")
+	if subject != "" {
+		selectQuery = selectQuery.Where(squirrel.Eq{"subject": subject})
+	}
+	query, args, err := selectQuery.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []models.RoleAssignment{}
+	for rows.Next() {
+		var a models.RoleAssignment
+		if err := rows.Scan(&a.ID, &a.Subject, &a.Role, &a.Department, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, a)
+	}
+	return assignments, rows.Err()
+}
+
+func (r *RoleRepository) AssignRole(ctx context.Context, assignment *models.RoleAssignment) error {
+	if err := validate.Struct(assignment); err != nil {
+		return apperrors.Validation(err)
+	}
+	assignment.CreatedAt = time.Now().UTC()
+
+	insert := r.QueryBuilder.
+		Insert("role_assignments").
+		Columns("subject", "role", "department", "created_at").
+		Values(assignment.Subject, assignment.Role, assignment.Department, assignment.CreatedAt)
+	if r.dialect == dialectPostgres {
+		query, args, err := insert.Suffix("RETURNING id").ToSql()
+		if err != nil {
+			return err
+		}
+		err = r.DB.QueryRowContext(ctx, query, args...).Scan(&assignment.ID)
+		if isUniqueConstraintViolation(err) {
+			return ErrDuplicateRoleAssignment
+		}
+		return err
+	}
+
+	query, args, err := insert.ToSql()
+	if err != nil {
+		return err
+	}
+	result, err := r.DB.ExecContext(ctx, query, args...)
+	if isUniqueConstraintViolation(err) {
+		return ErrDuplicateRoleAssignment
+	}
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	assignment.ID = int(id)
+	return err
+}
+
+func (r *RoleRepository) UnassignRole(ctx context.Context, id int) error {
+	query, args, err := r.QueryBuilder.
+		Delete("role_assignments").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	res, err := r.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return ErrRoleAssignmentNotFound
+	}
+	return nil
+}
+
+// MemoryRoleRepository is an in-memory RoleStore for tests and local
+// development.
+type MemoryRoleRepository struct {
+	mu          sync.RWMutex
+	assignments map[int]models.RoleAssignment
+	nextID      int
+}
+
+var _ RoleStore = (*MemoryRoleRepository)(nil)
+
+func NewMemoryRoleRepository() *MemoryRoleRepository {
+	return &MemoryRoleRepository{assignments: make(map[int]models.RoleAssignment), nextID: 1}
+}
+
+func (r *MemoryRoleRepository) ListRoleAssignments(ctx context.Context, subject string) ([]models.RoleAssignment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	assignments := []models.RoleAssignment{}
+	for _, a := range r.assignments {
+		if subject == "" || a.Subject == subject {
+			assignments = append(assignments, a)
+		}
+	}
+	sort.Slice(assignments, func(i, j int) bool { return assignments[i].ID < assignments[j].ID })
+	return assignments, nil
+}
+
+func (r *MemoryRoleRepository) AssignRole(ctx context.Context, assignment *models.RoleAssignment) error {
+	if err := validate.Struct(assignment); err != nil {
+		return apperrors.Validation(err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, a := range r.assignments {
+		if a.Subject == assignment.Subject && a.Role == assignment.Role && a.Department == assignment.Department {
+			return ErrDuplicateRoleAssignment
+		}
+	}
+	assignment.ID = r.nextID
+	assignment.CreatedAt = time.Now().UTC()
+	r.nextID++
+	r.assignments[assignment.ID] = *assignment
+	return nil
+}
+
+func (r *MemoryRoleRepository) UnassignRole(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.assignments[id]; !ok {
+		return ErrRoleAssignmentNotFound
+	}
+	delete(r.assignments, id)
+	return nil
+}
@@ -0,0 +1,326 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/models"
+
+	"github.com/Masterminds/squirrel"
+)
+
+var (
+	ErrTransitionNotFound   = apperrors.New(apperrors.NotFound, "status transition not found")
+	ErrTransitionPending    = apperrors.New(apperrors.Conflict, "user already has a pending status transition")
+	ErrTransitionNotPending = apperrors.New(apperrors.Conflict, "status transition is not pending")
+)
+
+// TransitionStore is the persistence contract for status transitions. A user
+// has at most one pending transition; creating another returns
+// ErrTransitionPending.
+type TransitionStore interface {
+	CreateTransition(ctx context.Context, transition *models.StatusTransition) error
+	GetTransition(ctx context.Context, id int) (*models.StatusTransition, error)
+	// ListTransitions returns the transitions of a user, newest first.
+	ListTransitions(ctx context.Context, userID int) ([]models.StatusTransition, error)
+	// DueTransitions returns the pending transitions effective at or before
+	// now, oldest first.
+	DueTransitions(ctx context.Context, now time.Time) ([]models.StatusTransition, error)
+	// FinishTransition moves a pending transition to state, which is one of
+	// applied, failed or canceled, together with an explanation for
+	// failures. It returns ErrTransitionNotPending if the transition is not
+	// pending.
+	FinishTransition(ctx context.Context, id int, state, errMsg string) error
+}
+
+// TransitionRepository is the SQL implementation of TransitionStore.
+type TransitionRepository struct {
+	DB           *sql.DB
+	QueryBuilder squirrel.StatementBuilderType
+
+	dialect dialect
+	tx      *sql.Tx
+}
+
+var _ TransitionStore = (*TransitionRepository)(nil)
+
+var transitionColumns = []string{"id", "user_id", "status", "reason", "override", "effective_at",
+	"requested_by", "created_at", "state", "error", "finished_at"}
+
+func NewTransitionRepository(db *sql.DB) *TransitionRepository {
+	return &TransitionRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Question),
+		dialect:      dialectSQLite,
+	}
+}
+
+// NewPostgresTransitionRepository returns a TransitionRepository for a
+// PostgreSQL database, using $n placeholders.
+func NewPostgresTransitionRepository(db *sql.DB) *TransitionRepository {
+	return &TransitionRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+		dialect:      dialectPostgres,
+	}
+}
+
+// bind returns a copy of the repository whose statements run in tx.
+func (r *TransitionRepository) bind(tx *sql.Tx) *TransitionRepository {
+	scoped := *r
+	scoped.tx = tx
+	return &scoped
+}
+
+func (r *TransitionRepository) conn() queryer {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.DB
+}
+
+func scanTransition(row interface{ Scan(...interface{}) error }) (models.StatusTransition, error) {
+	var t models.StatusTransition
+	var finishedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.UserID, &t.Status, &t.Reason, &t.Override, &t.EffectiveAt,
+		&t.RequestedBy, &t.CreatedAt, &t.State, &t.Error, &finishedAt)
+	if finishedAt.Valid {
+		t.FinishedAt = &finishedAt.Time
+	}
+	return t, err
+}
+
+func (r *TransitionRepository) CreateTransition(ctx context.Context, t *models.StatusTransition) error {
+	if err := validate.Struct(t); err != nil {
+		return apperrors.Validation(err)
+	}
+	t.CreatedAt = time.Now().UTC()
+	t.EffectiveAt = t.EffectiveAt.UTC()
+
+	insert := r.QueryBuilder.
+		Insert("status_transitions").
+		Columns(transitionColumns[1:]...).
+		Values(t.UserID, t.Status, t.Reason, t.Override, t.EffectiveAt,
+			t.RequestedBy, t.CreatedAt, t.State, t.Error, t.FinishedAt)
+	if r.dialect == dialectPostgres {
+		query, args, err := insert.Suffix("RETURNING id").ToSql()
+		if err != nil {
+			return err
+		}
+		err = r.conn().QueryRowContext(ctx, query, args...).Scan(&t.ID)
+		if isUniqueConstraintViolation(err) {
+			return ErrTransitionPending
+		}
+		return err
+	}
+
+	query, args, err := insert.ToSql()
+	if err != nil {
+		return err
+	}
+	result, err := r.conn().ExecContext(ctx, query, args...)
+	if isUniqueConstraintViolation(err) {
+		return ErrTransitionPending
+	}
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	t.ID = int(id)
+	return err
+}
+
+func (r *TransitionRepository) GetTransition(ctx context.Context, id int) (*models.StatusTransition, error) {
+	query, args, err := r.QueryBuilder.
+		Select(transitionColumns...).
+		From("status_transitions").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	t, err := scanTransition(r.conn().QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTransitionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *TransitionRepository) ListTransitions(ctx context.Context, userID int) ([]models.StatusTransition, error) {
+	return r.list(ctx, r.QueryBuilder.
+		Select(transitionColumns...).
+		From("status_transitions").
+		Where(squirrel.Eq{"user_id": userID}).
+		OrderBy("id DESC"))
+}
+
+func (r *TransitionRepository) DueTransitions(ctx context.Context, now time.Time) ([]models.StatusTransition, error) {
+	return r.list(ctx, r.QueryBuilder.
+		Select(transitionColumns...).
+		From("status_transitions").
+		Where(squirrel.Eq{"state": models.TransitionPending}).
+		Where(squirrel.LtOrEq{"effective_at": now.UTC()}).
+		OrderBy("effective_at", "id"))
+}
+
+func (r *TransitionRepository) list(ctx context.Context, selectQuery squirrel.SelectBuilder) ([]models.StatusTransition, error) {
+	query, args, err := selectQuery.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transitions := []models.StatusTransition{}
+	for rows.Next() {
+		t, err := scanTransition(rows)
+		if err != nil {
+			return nil, err
+		}
+		transitions = append(transitions, t)
+	}
+	return transitions, rows.Err()
+}
+
+func (r *TransitionRepository) FinishTransition(ctx context.Context, id int, state, errMsg string) error {
+	query, args, err := r.QueryBuilder.
+		Update("status_transitions").
+		Set("state", state).
+		Set("error", errMsg).
+		Set("finished_at", time.Now().UTC()).
+		Where(squirrel.Eq{"id": id, "state": models.TransitionPending}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	res, err := r.conn().ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		if _, err := r.GetTransition(ctx, id); err != nil {
+			return err
+		}
+		return ErrTransitionNotPending
+	}
+	return nil
+}
+
+// MemoryTransitionRepository is an in-memory TransitionStore for tests and
+// local development.
+type MemoryTransitionRepository struct {
+	*transitionData
+	inUnit bool
+}
+
+type transitionData struct {
+	mu sync.RWMutex
+	// writes is held like that of memoryData (see MemoryTransactor).
+	writes      sync.Mutex
+	transitions map[int]models.StatusTransition
+	nextID      int
+}
+
+var _ TransitionStore = (*MemoryTransitionRepository)(nil)
+
+func NewMemoryTransitionRepository() *MemoryTransitionRepository {
+	return &MemoryTransitionRepository{transitionData: &transitionData{transitions: make(map[int]models.StatusTransition), nextID: 1}}
+}
+
+func (r *MemoryTransitionRepository) CreateTransition(ctx context.Context, t *models.StatusTransition) error {
+	if err := validate.Struct(t); err != nil {
+		return apperrors.Validation(err)
+	}
+
+	defer lockWrites(&r.writes, r.inUnit)()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t.State == models.TransitionPending {
+		for _, existing := range r.transitions {
+			if existing.UserID == t.UserID && existing.State == models.TransitionPending {
+				return ErrTransitionPending
+			}
+		}
+	}
+	t.ID = r.nextID
+	t.CreatedAt = time.Now().UTC()
+	t.EffectiveAt = t.EffectiveAt.UTC()
+	r.nextID++
+	r.transitions[t.ID] = *t
+	return nil
+}
+
+func (r *MemoryTransitionRepository) GetTransition(ctx context.Context, id int) (*models.StatusTransition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.transitions[id]
+	if !ok {
+		return nil, ErrTransitionNotFound
+	}
+	return &t, nil
+}
+
+func (r *MemoryTransitionRepository) ListTransitions(ctx context.Context, userID int) ([]models.StatusTransition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	transitions := []models.StatusTransition{}
+	for _, t := range r.transitions {
+		if t.UserID == userID {
+			transitions = append(transitions, t)
+		}
+	}
+	sort.Slice(transitions, func(i, j int) bool { return transitions[i].ID > transitions[j].ID })
+	return transitions, nil
+}
+
+func (r *MemoryTransitionRepository) DueTransitions(ctx context.Context, now time.Time) ([]models.StatusTransition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	transitions := []models.StatusTransition{}
+	for _, t := range r.transitions {
+		if t.State == models.TransitionPending && !t.EffectiveAt.After(now) {
+			transitions = append(transitions, t)
+		}
+	}
+	sort.Slice(transitions, func(i, j int) bool {
+		a, b := transitions[i], transitions[j]
+		if !a.EffectiveAt.Equal(b.EffectiveAt) {
+			return a.EffectiveAt.Before(b.EffectiveAt)
+		}
+		return a.ID < b.ID
+	})
+	return transitions, nil
+}
+
+func (r *MemoryTransitionRepository) FinishTransition(ctx context.Context, id int, state, errMsg string) error {
+	defer lockWrites(&r.writes, r.inUnit)()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.transitions[id]
+	if !ok {
+		return ErrTransitionNotFound
+	}
+	if t.State != models.TransitionPending {
+		return ErrTransitionNotPending
+	}
+	now := time.Now().UTC()
+	t.State, t.Error, t.FinishedAt = state, errMsg, &now
+	r.transitions[id] = t
+	return nil
+}
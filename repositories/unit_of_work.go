@@ -0,0 +1,281 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"maps"
+	"math/rand"
+	"sync"
+	"time"
+
+	"user-service/models"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Repos are the stores a unit of work hands to its function, all bound to the
+// same transaction.
+type Repos struct {
+	Users       UserStore
+	Groups      GroupStore
+	Departments DepartmentStore
+	Transitions TransitionStore
+}
+
+// UnitOfWork runs several repository operations atomically.
+type UnitOfWork interface {
+	// WithTx calls fn with stores bound to a new transaction, which is
+	// committed if fn returns nil and rolled back if it returns an error or
+	// panics. The transaction is retried, calling fn again, when it fails
+	// because of contention with other transactions, so fn must not have
+	// effects outside of the stores it is given.
+	WithTx(ctx context.Context, fn func(tx Repos) error) error
+}
+
+const (
+	// maxTxAttempts is how many times a transaction failing with a transient
+	// error (see retryable) is tried in all.
+	maxTxAttempts = 5
+	// txRetryDelay is the delay before the first retry of a transaction.
+	// Each further retry waits twice as long, plus up to as much again at
+	// random so that competing transactions spread out.
+	txRetryDelay = 10 * time.Millisecond
+)
+
+// Transactor is the SQL implementation of UnitOfWork.
+type Transactor struct {
+	DB *sql.DB
+
+	users       *UserRepository
+	groups      *GroupRepository
+	departments *DepartmentRepository
+	transitions *TransitionRepository
+}
+
+var _ UnitOfWork = (*Transactor)(nil)
+
+func NewTransactor(db *sql.DB) *Transactor {
+	return &Transactor{
+		DB:          db,
+		users:       NewUserRepository(db),
+		groups:      NewGroupRepository(db),
+		departments: NewDepartmentRepository(db),
+		transitions: NewTransitionRepository(db),
+	}
+}
+
+// NewPostgresTransactor returns a Transactor for a PostgreSQL database.
+func NewPostgresTransactor(db *sql.DB) *Transactor {
+	return &Transactor{
+		DB:          db,
+		users:       NewPostgresUserRepository(db),
+		groups:      NewPostgresGroupRepository(db),
+		departments: NewPostgresDepartmentRepository(db),
+		transitions: NewPostgresTransitionRepository(db),
+	}
+}
+
+func (t *Transactor) WithTx(ctx context.Context, fn func(tx Repos) error) error {
+	return inTx(ctx, t.DB, func(tx *sql.Tx) error {
+		return fn(Repos{
+			Users:       t.users.bind(tx),
+			Groups:      t.groups.bind(tx),
+			Departments: t.departments.bind(tx),
+			Transitions: t.transitions.bind(tx),
+		})
+	})
+}
+
+// transact runs fn in a transaction of db, or, if tx is set because the
+// repository is bound to the transaction of a unit of work, in a savepoint of
+// tx. Either way the changes of fn are undone if it fails.
+func transact(ctx context.Context, db *sql.DB, tx *sql.Tx, fn func(tx *sql.Tx) error) error {
+	if tx != nil {
+		return savepoint(ctx, tx, func() error { return fn(tx) })
+	}
+	return inTx(ctx, db, fn)
+}
+
+// inTx runs fn in a new transaction of db, which is committed if fn succeeds
+// and rolled back otherwise, including when fn panics. Transactions failing
+// with a transient error are retried.
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	delay := txRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := func() error {
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+			if err := fn(tx); err != nil {
+				return err
+			}
+			return tx.Commit()
+		}()
+		if err == nil || attempt == maxTxAttempts || !retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(delay + time.Duration(rand.Int63n(int64(delay))))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// retryable reports whether err is a failure that a transaction may not hit
+// when tried again: SQLite finding the database busy or locked by another
+// connection, or PostgreSQL aborting the transaction on a serialization
+// failure or deadlock.
+func retryable(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == pqSerializationFailure || pqErr.Code == pqDeadlockDetected
+	}
+	return false
+}
+
+// savepoint runs fn within a savepoint of tx, rolling back to it if fn fails.
+// Both SQLite and PostgreSQL support savepoints, and PostgreSQL needs one to
+// carry on with a transaction after a failed statement.
+func savepoint(ctx context.Context, tx *sql.Tx, fn func() error) error {
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT nested_write"); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT nested_write"); rollbackErr != nil {
+			return rollbackErr
+		}
+		if _, releaseErr := tx.ExecContext(ctx, "RELEASE SAVEPOINT nested_write"); releaseErr != nil {
+			return releaseErr
+		}
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT nested_write")
+	return err
+}
+
+// MemoryTransactor is the UnitOfWork of the memory stores. A unit of work
+// holds the write locks of the data it covers for as long as it runs, so that
+// units of work run one at a time and writes made outside of one wait for it
+// to finish. A unit of work that fails can therefore put back the data as it
+// was when it began without undoing anyone else's writes. Reads are not
+// isolated: they see the writes of a unit of work as it makes them.
+type MemoryTransactor struct {
+	users       *MemoryUserRepository
+	transitions *MemoryTransitionRepository
+}
+
+var _ UnitOfWork = (*MemoryTransactor)(nil)
+
+// NewMemoryTransactor returns a MemoryTransactor over the data of users, the
+// group, department and outbox repositories built on it, and transitions.
+func NewMemoryTransactor(users *MemoryUserRepository, transitions *MemoryTransitionRepository) *MemoryTransactor {
+	return &MemoryTransactor{users: users, transitions: transitions}
+}
+
+func (t *MemoryTransactor) WithTx(ctx context.Context, fn func(tx Repos) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t.users.writes.Lock()
+	defer t.users.writes.Unlock()
+	t.transitions.writes.Lock()
+	defer t.transitions.writes.Unlock()
+
+	data := t.users.snapshot()
+	transitions := t.transitions.snapshot()
+	committed := false
+	defer func() {
+		if !committed {
+			t.users.restore(data)
+			t.transitions.restore(transitions)
+		}
+	}()
+
+	// The stores of the unit of work write without waiting for the locks
+	// it holds
+	users := &MemoryUserRepository{memoryData: t.users.memoryData, audit: t.users.audit, inUnit: true}
+	err := fn(Repos{
+		Users:       users,
+		Groups:      NewMemoryGroupRepository(users),
+		Departments: NewMemoryDepartmentRepository(users),
+		Transitions: &MemoryTransitionRepository{transitionData: t.transitions.transitionData, inUnit: true},
+	})
+	committed = err == nil
+	return err
+}
+
+// lockWrites locks writes, the write lock of memory data, for a write made
+// outside of a unit of work, and returns the function that unlocks it. The
+// writes of a unit of work (inUnit), which holds the lock already, go ahead.
+func lockWrites(writes *sync.Mutex, inUnit bool) func() {
+	if inUnit {
+		return func() {}
+	}
+	writes.Lock()
+	return writes.Unlock
+}
+
+// snapshot returns a copy of the data for restore.
+func (d *memoryData) snapshot() *memoryData {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	members := make(map[int]map[int]time.Time, len(d.members))
+	for groupID, users := range d.members {
+		members[groupID] = maps.Clone(users)
+	}
+	return &memoryData{
+		users:       maps.Clone(d.users),
+		nextID:      d.nextID,
+		events:      append([]auditRecord(nil), d.events...),
+		groups:      maps.Clone(d.groups),
+		nextGroupID: d.nextGroupID,
+		members:     members,
+		departments: maps.Clone(d.departments),
+		outbox:      append([]models.Event(nil), d.outbox...),
+		nextEventID: d.nextEventID,
+		changes:     maps.Clone(d.changes),
+	}
+}
+
+// restore puts back the data of a snapshot.
+func (d *memoryData) restore(s *memoryData) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.users, d.nextID, d.events = s.users, s.nextID, s.events
+	d.groups, d.nextGroupID, d.members = s.groups, s.nextGroupID, s.members
+	d.departments = s.departments
+	d.outbox, d.nextEventID = s.outbox, s.nextEventID
+	// The change sequence is not rolled back: readers may have seen the
+	// numbers taken, which must not be given to other changes.
+	d.changes = s.changes
+	// Nor are the outbox cursors, which units of work do not move.
+}
+
+// snapshot returns a copy of the transitions for restore.
+func (d *transitionData) snapshot() *transitionData {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return &transitionData{transitions: maps.Clone(d.transitions), nextID: d.nextID}
+}
+
+// restore puts back the transitions of a snapshot.
+func (d *transitionData) restore(s *transitionData) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.transitions, d.nextID = s.transitions, s.nextID
+}
@@ -0,0 +1,56 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+
+	"user-service/apperrors"
+	"user-service/models"
+)
+
+// UserWrite is one write of a WriteUsers batch: a create if User.ID is 0 and
+// an update of every field otherwise, or, with Delete, a soft delete of
+// User.ID. Updates and deletes check User.Version as UpdateUser and
+// DeleteUser do.
+type UserWrite struct {
+	User   *models.User
+	Delete bool
+}
+
+// WriteUsers applies a batch of writes in one transaction. Each write runs in
+// a savepoint, so that one that fails, for example with a validation error or
+// ErrDuplicateUsername, is rolled back alone and reported at its index of the
+// returned errors. Internal errors abort and roll back the whole batch. Bound
+// to a unit of work, the batch runs in a savepoint of its transaction.
+func (r *UserRepository) WriteUsers(ctx context.Context, writes []UserWrite) ([]error, error) {
+	var errs []error
+	err := transact(ctx, r.DB, r.tx, func(tx *sql.Tx) error {
+		batch := *r
+		batch.tx = tx
+		errs = make([]error, len(writes))
+		for i, write := range writes {
+			err := batch.write(ctx, write)
+			if err != nil && apperrors.KindOf(err) == apperrors.Internal {
+				return err
+			}
+			errs[i] = err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// write applies a single write of a batch.
+func (r *UserRepository) write(ctx context.Context, write UserWrite) error {
+	switch {
+	case write.Delete:
+		return r.DeleteUser(ctx, write.User.ID, write.User.Version)
+	case write.User.ID == 0:
+		return r.CreateUser(ctx, write.User)
+	default:
+		return r.UpdateUser(ctx, write.User)
+	}
+}
@@ -0,0 +1,182 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"user-service/models"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// ChangeStore reads the change feed: the last change to each user, ordered by
+// a sequence number that every write of a user takes the next value of. A
+// user written several times appears once, at its latest change, so a client
+// that has seen the changes up to some sequence number only needs those after
+// it to be up to date. Purged users leave the feed.
+type ChangeStore interface {
+	// ListChanges returns up to limit changes with a sequence number greater
+	// than after, in order.
+	ListChanges(ctx context.Context, after, limit int) ([]models.UserChange, error)
+	// LatestChange returns the sequence number of the latest change, or 0 if
+	// there has been none.
+	LatestChange(ctx context.Context) (int, error)
+}
+
+// recordChange makes the write of a user its last change, of the given event
+// type, with the next sequence number. The sequence is a single row, which
+// the transaction holds locked until it commits, so changes are committed in
+// the order of their sequence numbers and a reader never skips over one that
+// commits later. Purges leave nothing to record.
+func (r *UserRepository) recordChange(ctx context.Context, tx *sql.Tx, eventType string, after *models.User) error {
+	if after == nil {
+		return nil
+	}
+	var seq int
+	err := tx.QueryRowContext(ctx, "UPDATE user_change_sequence SET value = value + 1 RETURNING value").Scan(&seq)
+	if err != nil {
+		return err
+	}
+	query, args, err := r.QueryBuilder.
+		Update("users").
+		Set("change_seq", seq).
+		Set("change_type", eventType).
+		Where(squirrel.Eq{"id": after.ID}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ChangeRepository is the SQL implementation of ChangeStore.
+type ChangeRepository struct {
+	DB           *sql.DB
+	QueryBuilder squirrel.StatementBuilderType
+}
+
+var _ ChangeStore = (*ChangeRepository)(nil)
+
+func NewChangeRepository(db *sql.DB) *ChangeRepository {
+	return &ChangeRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Question),
+	}
+}
+
+// NewPostgresChangeRepository returns a ChangeRepository for a PostgreSQL
+// database, using $n placeholders.
+func NewPostgresChangeRepository(db *sql.DB) *ChangeRepository {
+	return &ChangeRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+	}
+}
+
+func (r *ChangeRepository) ListChanges(ctx context.Context, after, limit int) ([]models.UserChange, error) {
+	query, args, err := r.QueryBuilder.
+		Select(append([]string{"change_seq", "change_type"}, userColumns...)...).
+		From("users").
+		Where(squirrel.Gt{"change_seq": after}).
+		OrderBy("change_seq").
+		Limit(uint64(limit)).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []models.UserChange{}
+	for rows.Next() {
+		var change models.UserChange
+		user, err := scanUser(changeScanner{rows, &change})
+		if err != nil {
+			return nil, err
+		}
+		change.User = user
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// changeScanner reads the sequence number and type of a change ahead of the
+// userColumns that scanUser reads.
+type changeScanner struct {
+	rows   *sql.Rows
+	change *models.UserChange
+}
+
+func (s changeScanner) Scan(dest ...interface{}) error {
+	return s.rows.Scan(append([]interface{}{&s.change.Seq, &s.change.Type}, dest...)...)
+}
+
+func (r *ChangeRepository) LatestChange(ctx context.Context) (int, error) {
+	query, args, err := r.QueryBuilder.
+		Select("value").
+		From("user_change_sequence").
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+	var seq int
+	err = r.DB.QueryRowContext(ctx, query, args...).Scan(&seq)
+	return seq, err
+}
+
+// userChange is the last change to a user in the memory stores.
+type userChange struct {
+	seq       int
+	eventType string
+}
+
+// recordChange makes the write of a user its last change; see
+// UserRepository.recordChange. Callers must hold r.mu.
+func (r *MemoryUserRepository) recordChange(eventType string, before, after *models.User) {
+	if after == nil {
+		delete(r.changes, before.ID)
+		return
+	}
+	r.changeSeq++
+	r.changes[after.ID] = userChange{seq: r.changeSeq, eventType: eventType}
+}
+
+// MemoryChangeRepository is an in-memory ChangeStore reading the changes of
+// the MemoryUserRepository it was created from.
+type MemoryChangeRepository struct {
+	*memoryData
+}
+
+var _ ChangeStore = (*MemoryChangeRepository)(nil)
+
+func NewMemoryChangeRepository(users *MemoryUserRepository) *MemoryChangeRepository {
+	return &MemoryChangeRepository{memoryData: users.memoryData}
+}
+
+func (r *MemoryChangeRepository) ListChanges(ctx context.Context, after, limit int) ([]models.UserChange, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	changes := []models.UserChange{}
+	for id, change := range r.changes {
+		if change.seq > after {
+			changes = append(changes, models.UserChange{Seq: change.seq, Type: change.eventType, User: r.users[id]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Seq < changes[j].Seq })
+	if len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes, nil
+}
+
+func (r *MemoryChangeRepository) LatestChange(ctx context.Context) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.changeSeq, nil
+}
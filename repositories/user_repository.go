@@ -1,47 +1,158 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator"
+	"user-service/apperrors"
 	"user-service/models"
 
 	"github.com/Masterminds/squirrel"
 
+	"github.com/lib/pq"
 	"github.com/mattn/go-sqlite3"
 )
 
+// UserRepository is the SQL implementation of UserStore. The same
+// implementation serves SQLite and PostgreSQL; see NewPostgresUserRepository.
 type UserRepository struct {
 	DB           *sql.DB
 	QueryBuilder squirrel.StatementBuilderType
+
+	dialect dialect
+	audit   models.AuditContext
+	// tx, when set, is the transaction that statements run in: that of a
+	// unit of work (see Transactor) or of a WriteUsers batch.
+	tx *sql.Tx
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
+var _ UserStore = (*UserRepository)(nil)
+
 var (
-	ErrDuplicateUsername = errors.New("duplicate username")
-	ErrUserNotFound      = errors.New("user not found")
+	ErrDuplicateUsername = apperrors.New(apperrors.Conflict, "duplicate username")
+	ErrUserNotFound      = apperrors.New(apperrors.NotFound, "user not found")
+	ErrVersionConflict   = apperrors.New(apperrors.PreconditionFailed, "user has been modified")
+	ErrUserNotDeleted    = apperrors.New(apperrors.Conflict, "user is not deleted")
 )
 
-var validate = validator.New()
+// userColumns are the columns read into a models.User by scanUser, in order.
+var userColumns = []string{"id", "user_name", "email", "first_name", "last_name", "user_status", "department", "manager_id", "version", "deleted_at"}
+
+// notDeleted restricts a query to users that have not been soft deleted.
+var notDeleted = squirrel.Eq{"deleted_at": nil}
+
+// scanUser reads a row selected with userColumns.
+func scanUser(row interface{ Scan(...interface{}) error }) (models.User, error) {
+	var user models.User
+	var managerID sql.NullInt64
+	var deletedAt sql.NullTime
+	err := row.Scan(&user.ID, &user.UserName, &user.Email, &user.FirstName,
+		&user.LastName, &user.Status, &user.Department, &managerID, &user.Version, &deletedAt)
+	if managerID.Valid {
+		id := int(managerID.Int64)
+		user.ManagerID = &id
+	}
+	if deletedAt.Valid {
+		user.DeletedAt = &deletedAt.Time
+	}
+	return user, err
+}
+
+// UserNotFoundError is returned when no user exists with the requested ID.
+// It wraps ErrUserNotFound.
+type UserNotFoundError struct {
+	ID int
+}
+
+func (e *UserNotFoundError) Error() string {
+	return fmt.Sprintf("user with id %d not found", e.ID)
+}
+
+func (e *UserNotFoundError) Unwrap() error {
+	return ErrUserNotFound
+}
+
+var validate = newValidator()
+
+// ValidateUser checks user against its validate tags, as every write does,
+// returning an Invalid error with the failed fields.
+func ValidateUser(user *models.User) error {
+	if err := validate.Struct(user); err != nil {
+		return apperrors.Validation(err)
+	}
+	return nil
+}
+
+// newValidator returns a validator that reports fields by their JSON names.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
 
 func NewUserRepository(db *sql.DB) *UserRepository {
 	return &UserRepository{
 		DB:           db,
 		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Question),
+		dialect:      dialectSQLite,
 	}
 }
 
-func (r *UserRepository) GetAllUsers() ([]models.User, error) {
+// WithAudit returns a copy of the repository that attributes the changes it
+// makes to ac in the audit log.
+func (r *UserRepository) WithAudit(ac models.AuditContext) UserStore {
+	scoped := *r
+	scoped.audit = ac
+	return &scoped
+}
+
+// bind returns a copy of the repository whose statements run in tx.
+func (r *UserRepository) bind(tx *sql.Tx) *UserRepository {
+	scoped := *r
+	scoped.tx = tx
+	return &scoped
+}
+
+// conn returns the transaction the repository is bound to, if any, and its
+// database otherwise.
+func (r *UserRepository) conn() queryer {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.DB
+}
+
+// GetAllUsers returns every user that has not been deleted.
+func (r *UserRepository) GetAllUsers(ctx context.Context) ([]models.User, error) {
 	query, args, err := r.QueryBuilder.
-		Select("id", "user_name", "email", "first_name", "last_name", "user_status", "department").
+		Select(userColumns...).
 		From("users").
+		Where(notDeleted).
 		ToSql()
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := r.DB.Query(query, args...)
+	rows, err := r.conn().QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -49,9 +160,8 @@ func (r *UserRepository) GetAllUsers() ([]models.User, error) {
 
 	var users []models.User
 	for rows.Next() {
-		var user models.User
-		if err := rows.Scan(&user.ID, &user.UserName, &user.Email, &user.FirstName,
-			&user.LastName, &user.Status, &user.Department); err != nil {
+		user, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
 		users = append(users, user)
@@ -60,107 +170,435 @@ func (r *UserRepository) GetAllUsers() ([]models.User, error) {
 	return users, nil
 }
 
-func (r *UserRepository) CreateUser(user *models.User) error {
+// ListUsers returns one page of users matching params, together with the total
+// number of matching users and a cursor for the following page.
+func (r *UserRepository) ListUsers(ctx context.Context, params models.UserListParams) (*models.UserPage, error) {
+	spec, err := parseSort(params.Sort)
+	if err != nil {
+		return nil, err
+	}
+	limit := normalizeLimit(params.Limit)
+	filters := userFilters(params)
+
+	countQuery := r.QueryBuilder.Select("COUNT(*)").From("users")
+	for _, cond := range filters {
+		countQuery = countQuery.Where(cond)
+	}
+	query, args, err := countQuery.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	page := &models.UserPage{Users: []models.User{}, Limit: limit}
+	if err := r.conn().QueryRowContext(ctx, query, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	selectQuery := r.QueryBuilder.
+		Select(userColumns...).
+		From("users").
+		OrderBy(spec.orderBy()...).
+		Limit(uint64(limit + 1))
+	for _, cond := range filters {
+		selectQuery = selectQuery.Where(cond)
+	}
+	if params.Cursor != "" {
+		cursor, err := decodeCursor(params.Cursor, spec)
+		if err != nil {
+			return nil, err
+		}
+		selectQuery = selectQuery.Where(cursor.after(spec))
+	} else if params.Offset > 0 {
+		page.Offset = params.Offset
+		selectQuery = selectQuery.Offset(uint64(params.Offset))
+	}
+	query, args, err = selectQuery.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Users = append(page.Users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// One extra row was requested to find out whether another page follows.
+	if len(page.Users) > limit {
+		page.Users = page.Users[:limit]
+		page.NextCursor = cursorFor(page.Users[limit-1], spec)
+	}
+	return page, nil
+}
+
+// streamPageSize is the number of users StreamUsers reads per query.
+const streamPageSize = MaxPageSize
+
+// StreamUsers calls fn with each user matching params, reading them from the
+// database in keyset pages of streamPageSize users. Each page is read by a
+// query of its own, done before fn sees its users, so that a slow consumer,
+// such as an export to a slow client, neither holds a statement open nor, on
+// SQLite, keeps writers out. Like the pages of ListUsers, a user whose sort
+// key changes in between may be missed or seen twice.
+func (r *UserRepository) StreamUsers(ctx context.Context, params models.UserListParams, fn func(models.User) error) error {
+	spec, err := parseSort(params.Sort)
+	if err != nil {
+		return err
+	}
+	selectQuery := r.QueryBuilder.
+		Select(userColumns...).
+		From("users").
+		OrderBy(spec.orderBy()...).
+		Limit(streamPageSize)
+	for _, cond := range userFilters(params) {
+		selectQuery = selectQuery.Where(cond)
+	}
+
+	page := selectQuery
+	for {
+		users, err := r.queryUsers(ctx, r.conn(), page)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+		if len(users) < streamPageSize {
+			return nil
+		}
+		last := users[len(users)-1]
+		page = selectQuery.Where(pageCursor{Value: sortValue(last, spec.Key), ID: last.ID}.after(spec))
+	}
+}
+
+func (r *UserRepository) CreateUser(ctx context.Context, user *models.User) error {
 	// Validate the user input
 	if err := validate.Struct(user); err != nil {
-		// If validation fails, return the first error message
-		return fmt.Errorf("validation failed: %w", err)
+		return apperrors.Validation(err)
 	}
 
-	query, args, err := r.QueryBuilder.
+	insert := r.QueryBuilder.
 		Insert("users").
-		Columns("user_name", "email", "first_name", "last_name", "user_status", "department").
-		Values(user.UserName, user.Email, user.FirstName, user.LastName, user.Status, user.Department).
-		ToSql()
-	if err != nil {
-		return fmt.Errorf("failed to build query: %w", err) // Wrap the error
-	}
+		Columns("user_name", "email", "first_name", "last_name", "user_status", "department", "manager_id").
+		Values(user.UserName, user.Email, user.FirstName, user.LastName, user.Status, user.Department, user.ManagerID)
+
+	err := r.audited(ctx, models.AuditCreate, 0, func(tx *sql.Tx) (int, error) {
+		if err := r.checkManager(ctx, tx, user); err != nil {
+			return 0, err
+		}
+		// PostgreSQL drivers do not support LastInsertId, so the new ID is read
+		// back with RETURNING instead.
+		if r.dialect == dialectPostgres {
+			query, args, err := insert.Suffix("RETURNING id").ToSql()
+			if err != nil {
+				return 0, fmt.Errorf("failed to build query: %w", err)
+			}
+			if err := tx.QueryRowContext(ctx, query, args...).Scan(&user.ID); err != nil {
+				if isUniqueConstraintViolation(err) {
+					return 0, ErrDuplicateUsername
+				}
+				return 0, fmt.Errorf("failed to execute query: %w", err)
+			}
+			return user.ID, nil
+		}
+
+		query, args, err := insert.ToSql()
+		if err != nil {
+			return 0, fmt.Errorf("failed to build query: %w", err) // Wrap the error
+		}
 
-	_, execErr := r.DB.Exec(query, args...)
-	if execErr != nil {
-		// Check if the error is a duplicate key error
-		if execErr.Error() == "duplicate username" || isUniqueConstraintViolation(execErr) {
-			// Wrap the error with ErrDuplicateUsername so it can be detected by errors.Is
-			return fmt.Errorf("%w", ErrDuplicateUsername)
+		result, execErr := tx.ExecContext(ctx, query, args...)
+		if execErr != nil {
+			// Check if the error is a duplicate key error
+			if isUniqueConstraintViolation(execErr) {
+				return 0, ErrDuplicateUsername
+			}
+			// Wrap the error and add context
+			return 0, fmt.Errorf("failed to execute query: %w", execErr)
 		}
-		// Wrap the error and add context
-		return fmt.Errorf("failed to execute query: %w", execErr)
+		if id, err := result.LastInsertId(); err == nil {
+			user.ID = int(id)
+		}
+		return user.ID, nil
+	})
+	if err != nil {
+		return err
 	}
+	user.Version = 1
 	return nil
 }
 
-func (ur *UserRepository) UpdateUser(user *models.User) error {
+// UpdateUser overwrites every field of the user. If user.Version is non-zero
+// the update only succeeds while the stored version still matches, and
+// ErrVersionConflict is returned otherwise. On success user.Version is set to
+// the new version.
+func (ur *UserRepository) UpdateUser(ctx context.Context, user *models.User) error {
 	// Validate the user input
 	if err := validate.Struct(user); err != nil {
-		// If validation fails, return the first error message
-		return fmt.Errorf("validation failed: %w", err)
+		return apperrors.Validation(err)
 	}
 
 	// Prepare the update query using squirrel
-	query, args, err := squirrel.Update("users").
+	return ur.updateVersioned(ctx, user, ur.QueryBuilder.Update("users").
 		Set("user_name", user.UserName).
 		Set("email", user.Email).
 		Set("first_name", user.FirstName).
 		Set("last_name", user.LastName).
 		Set("user_status", user.Status).
 		Set("department", user.Department).
-		Where(squirrel.Eq{"id": user.ID}).
-		ToSql()
-	if err != nil {
-		return err
+		Set("manager_id", user.ManagerID))
+}
+
+// PatchUser validates user and writes only the given fields, named by their
+// JSON names, leaving the other columns untouched.
+func (r *UserRepository) PatchUser(ctx context.Context, user *models.User, fields []string) error {
+	if err := validate.Struct(user); err != nil {
+		return apperrors.Validation(err)
 	}
 
-	// Execute the update query
-	result, err := ur.DB.Exec(query, args...)
+	set, err := patchColumns(user, fields)
 	if err != nil {
 		return err
 	}
+	if len(set) == 0 {
+		return nil
+	}
+
+	return r.updateVersioned(ctx, user, r.QueryBuilder.Update("users").SetMap(set))
+}
 
-	// Check if any rows were affected
-	rowsAffected, err := result.RowsAffected()
+// updateVersioned runs update against user.ID, bumping the version and, when
+// user.Version is non-zero, requiring the stored version to match it.
+func (r *UserRepository) updateVersioned(ctx context.Context, user *models.User, update squirrel.UpdateBuilder) error {
+	var version int
+	err := r.audited(ctx, models.AuditUpdate, user.ID, func(tx *sql.Tx) (int, error) {
+		if err := r.checkManager(ctx, tx, user); err != nil {
+			return user.ID, err
+		}
+		var err error
+		version, err = r.execVersioned(ctx, tx, user.ID, user.Version, update.Where(notDeleted), notDeleted)
+		return user.ID, err
+	})
 	if err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		return errors.New("user not found")
+	user.Version = version
+	return nil
+}
+
+// execVersioned runs update against the user with the given id, bumping its
+// version and, when version is non-zero, requiring the stored version to
+// match. It returns the new version. If nothing matched, the user is looked up
+// again with the visible condition to tell ErrUserNotFound from
+// ErrVersionConflict.
+func (r *UserRepository) execVersioned(ctx context.Context, q queryer, id int, version int, update squirrel.UpdateBuilder, visible squirrel.Sqlizer) (int, error) {
+	update = update.
+		Set("version", squirrel.Expr("version + 1")).
+		Where(squirrel.Eq{"id": id})
+	if version != 0 {
+		update = update.Where(squirrel.Eq{"version": version})
+	}
+	// Both PostgreSQL and SQLite (3.35+) support RETURNING
+	query, args, err := update.Suffix("RETURNING version").ToSql()
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	// Execute the update query
+	var newVersion int
+	err = q.QueryRowContext(ctx, query, args...).Scan(&newVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Nothing matched: either the user is gone or its version moved on
+		return 0, r.missOrConflict(ctx, q, id, visible)
+	}
+	if err != nil {
+		if isUniqueConstraintViolation(err) {
+			return 0, ErrDuplicateUsername
+		}
+		return 0, err
+	}
+	return newVersion, nil
 }
 
-func (r *UserRepository) DeleteUser(id int) error {
+// missOrConflict explains why a conditional write on id affected no rows.
+func (r *UserRepository) missOrConflict(ctx context.Context, q queryer, id int, visible squirrel.Sqlizer) error {
 	query, args, err := r.QueryBuilder.
-		Delete("users").
+		Select("version").
+		From("users").
 		Where(squirrel.Eq{"id": id}).
+		Where(visible).
 		ToSql()
 	if err != nil {
 		return err
 	}
+	var version int
+	err = q.QueryRowContext(ctx, query, args...).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return ErrVersionConflict
+}
+
+// DeleteUser soft deletes the user by setting deleted_at. If version is
+// non-zero the user is only deleted while its stored version matches, and
+// ErrVersionConflict is returned otherwise.
+func (r *UserRepository) DeleteUser(ctx context.Context, id int, version int) error {
+	update := r.QueryBuilder.Update("users").
+		Set("deleted_at", time.Now().UTC()).
+		Where(notDeleted)
+	return r.audited(ctx, models.AuditDelete, id, func(tx *sql.Tx) (int, error) {
+		_, err := r.execVersioned(ctx, tx, id, version, update, notDeleted)
+		return id, err
+	})
+}
 
-	res, execErr := r.DB.Exec(query, args...)
-	if execErr != nil {
-		return execErr
+// RestoreUser clears deleted_at on a soft-deleted user.
+func (r *UserRepository) RestoreUser(ctx context.Context, id int, version int) error {
+	deleted := squirrel.NotEq{"deleted_at": nil}
+	update := r.QueryBuilder.Update("users").
+		Set("deleted_at", nil).
+		Where(deleted)
+	return r.audited(ctx, models.AuditRestore, id, func(tx *sql.Tx) (int, error) {
+		_, err := r.execVersioned(ctx, tx, id, version, update, deleted)
+		if errors.Is(err, ErrUserNotFound) {
+			return id, r.notDeletedOrMissing(ctx, tx, id)
+		}
+		return id, err
+	})
+}
+
+// PurgeUser permanently removes a soft-deleted user. Users reporting to it are
+// left without a manager.
+func (r *UserRepository) PurgeUser(ctx context.Context, id int, version int) error {
+	deleted := squirrel.NotEq{"deleted_at": nil}
+	purge := r.QueryBuilder.
+		Delete("users").
+		Where(squirrel.Eq{"id": id}).
+		Where(deleted)
+	if version != 0 {
+		purge = purge.Where(squirrel.Eq{"version": version})
+	}
+	query, args, err := purge.ToSql()
+	if err != nil {
+		return err
 	}
 
-	rowsAffected, _ := res.RowsAffected()
-	if rowsAffected == 0 {
-		return errors.New("no rows affected")
+	return r.audited(ctx, models.AuditPurge, id, func(tx *sql.Tx) (int, error) {
+		if err := r.detachReports(ctx, tx, id); err != nil {
+			return id, err
+		}
+		res, execErr := tx.ExecContext(ctx, query, args...)
+		if execErr != nil {
+			return id, execErr
+		}
+
+		rowsAffected, _ := res.RowsAffected()
+		if rowsAffected == 0 {
+			err := r.missOrConflict(ctx, tx, id, deleted)
+			if errors.Is(err, ErrUserNotFound) {
+				err = r.notDeletedOrMissing(ctx, tx, id)
+			}
+			return id, err
+		}
+		return id, nil
+	})
+}
+
+// PurgeDeletedUsers permanently removes users soft deleted before the given
+// time.
+func (r *UserRepository) PurgeDeletedUsers(ctx context.Context, before time.Time) (int, error) {
+	ids, err := r.deletedBefore(ctx, before)
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	// Each user is purged in its own transaction so that it gets its own
+	// audit event; users restored in the meantime are skipped.
+	purged := 0
+	for _, id := range ids {
+		err := r.PurgeUser(ctx, id, 0)
+		if errors.Is(err, ErrUserNotDeleted) || errors.Is(err, ErrUserNotFound) {
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
 }
 
-func (r *UserRepository) GetUserByID(id int) (*models.User, error) {
+// deletedBefore returns the IDs of users soft deleted before the given time.
+func (r *UserRepository) deletedBefore(ctx context.Context, before time.Time) ([]int, error) {
 	query, args, err := r.QueryBuilder.
-		Select("id", "user_name", "email", "first_name", "last_name", "user_status", "department").
+		Select("id").
 		From("users").
-		Where(squirrel.Eq{"id": id}).
+		Where(squirrel.Lt{"deleted_at": before.UTC()}).
 		ToSql()
 	if err != nil {
 		return nil, err
 	}
+	rows, err := r.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// notDeletedOrMissing explains why an operation on a soft-deleted user with
+// the given id matched no rows.
+func (r *UserRepository) notDeletedOrMissing(ctx context.Context, q queryer, id int) error {
+	if _, err := r.getUser(ctx, q, id, false); err != nil {
+		return err
+	}
+	return ErrUserNotDeleted
+}
+
+// GetUserByID returns the user with the given ID. Soft-deleted users are only
+// returned if includeDeleted is set.
+func (r *UserRepository) GetUserByID(ctx context.Context, id int, includeDeleted bool) (*models.User, error) {
+	return r.getUser(ctx, r.conn(), id, includeDeleted)
+}
+
+func (r *UserRepository) getUser(ctx context.Context, q queryer, id int, includeDeleted bool) (*models.User, error) {
+	selectQuery := r.QueryBuilder.
+		Select(userColumns...).
+		From("users").
+		Where(squirrel.Eq{"id": id})
+	if !includeDeleted {
+		selectQuery = selectQuery.Where(notDeleted)
+	}
+	query, args, err := selectQuery.ToSql()
+	if err != nil {
+		return nil, err
+	}
 
-	rows, err := r.DB.Query(query, args...)
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -168,9 +606,8 @@ func (r *UserRepository) GetUserByID(id int) (*models.User, error) {
 
 	// Check if we have any rows and scan them into a User struct
 	if rows.Next() {
-		var user models.User
-		if err := rows.Scan(&user.ID, &user.UserName, &user.Email, &user.FirstName,
-			&user.LastName, &user.Status, &user.Department); err != nil {
+		user, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
 		// Return the user if found
@@ -181,18 +618,23 @@ func (r *UserRepository) GetUserByID(id int) (*models.User, error) {
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	return nil, fmt.Errorf("user with id %d not found", id)
+	return nil, &UserNotFoundError{ID: id}
 }
 
-// isUniqueConstraintViolation checks if the error is a unique constraint violation error
+// isUniqueConstraintViolation checks if the error is a unique or primary key
+// constraint violation error
 func isUniqueConstraintViolation(err error) bool {
 	// Check for specific error related to unique constraint violation
 	// For SQLite, check for a constraint violation
 	if sqliteErr, ok := err.(sqlite3.Error); ok {
-		if sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		if sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
 			return true
 		}
 	}
-	// Add checks for other DB types if needed (e.g., PostgreSQL, MySQL)
+	// For PostgreSQL, check for unique_violation
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == pqUniqueViolation
+	}
 	return false
 }
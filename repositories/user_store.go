@@ -0,0 +1,77 @@
+// Package repositories stores users and the data attached to them, in SQLite,
+// in PostgreSQL or in memory.
+//
+// Store methods take the context of the work they serve as their first
+// argument, and the SQL stores run their statements with it, so that the work
+// stops once the context is done. The memory stores never wait on anything
+// that could be canceled and ignore it.
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"user-service/models"
+)
+
+// UserStore is the persistence contract for users. Implementations must
+// validate users before writing them, assign user.ID on create, and return
+// ErrUserNotFound and ErrDuplicateUsername for the corresponding conditions.
+//
+// Every write increments the user's version. Writes given a non-zero version
+// only apply while the stored version matches and return ErrVersionConflict
+// otherwise; a zero version makes the write unconditional.
+//
+// Deleting a user only marks it deleted. Deleted users are invisible to reads
+// (unless asked for) and writes until restored, and are removed for good by
+// the purge methods.
+//
+// Every write is recorded in the audit log together with the changed fields,
+// atomically with the write itself.
+//
+// A user's manager must be another user that has not been deleted and that
+// does not report to the user, directly or indirectly; writes return
+// ErrUnknownManager and ErrManagerCycle otherwise. Purging a user leaves its
+// reports without a manager.
+type UserStore interface {
+	GetAllUsers(ctx context.Context) ([]models.User, error)
+	ListUsers(ctx context.Context, params models.UserListParams) (*models.UserPage, error)
+	// StreamUsers calls fn with every user matching the filters of params, in
+	// the order of params.Sort, and stops at the first error fn returns.
+	// Limit, Offset and Cursor are ignored.
+	StreamUsers(ctx context.Context, params models.UserListParams, fn func(models.User) error) error
+	GetUserByID(ctx context.Context, id int, includeDeleted bool) (*models.User, error)
+	CreateUser(ctx context.Context, user *models.User) error
+	UpdateUser(ctx context.Context, user *models.User) error
+	// PatchUser validates user and writes only the named fields (by JSON name).
+	PatchUser(ctx context.Context, user *models.User, fields []string) error
+	// DeleteUser soft deletes the user.
+	DeleteUser(ctx context.Context, id int, version int) error
+	// RestoreUser undoes a soft delete; it returns ErrUserNotDeleted if the
+	// user is not deleted.
+	RestoreUser(ctx context.Context, id int, version int) error
+	// PurgeUser permanently removes a soft-deleted user, provided its version
+	// matches; it returns ErrUserNotDeleted if the user is not deleted.
+	PurgeUser(ctx context.Context, id int, version int) error
+	// PurgeDeletedUsers permanently removes users deleted before the given
+	// time and returns how many were removed.
+	PurgeDeletedUsers(ctx context.Context, before time.Time) (int, error)
+	// WriteUsers applies a batch of creates, updates and soft deletes and
+	// returns the error of each, if any. A non-nil second result means the
+	// batch was not applied.
+	WriteUsers(ctx context.Context, writes []UserWrite) ([]error, error)
+	// ReportingChain returns the managers of the user, from the direct
+	// manager upwards. A deleted manager ends the chain.
+	ReportingChain(ctx context.Context, id int) ([]models.User, error)
+	// DirectReports returns the users reporting to the user, ordered by ID.
+	DirectReports(ctx context.Context, id int) ([]models.User, error)
+	// Subtree returns the direct and indirect reports of the user, breadth
+	// first and ordered by ID within each level. Deleted users are left out
+	// together with their reports.
+	Subtree(ctx context.Context, id int) ([]models.User, error)
+	// WithAudit returns a UserStore sharing the same data whose writes are
+	// attributed to ac in the audit log.
+	WithAudit(ac models.AuditContext) UserStore
+	// ListAuditEvents returns one page of the audit log, newest first.
+	ListAuditEvents(ctx context.Context, params models.AuditListParams) (*models.AuditPage, error)
+}
@@ -0,0 +1,654 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/models"
+
+	"github.com/Masterminds/squirrel"
+)
+
+var (
+	ErrWebhookNotFound  = apperrors.New(apperrors.NotFound, "webhook not found")
+	ErrDeliveryNotFound = apperrors.New(apperrors.NotFound, "webhook delivery not found")
+	ErrDeliveryChanged  = apperrors.New(apperrors.Conflict, "webhook delivery has changed")
+)
+
+// WebhookStore is the persistence contract for webhook subscriptions and the
+// log of their deliveries.
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, webhook *models.Webhook) error
+	GetWebhook(ctx context.Context, id int) (*models.Webhook, error)
+	// ListWebhooks returns every webhook, ordered by ID.
+	ListWebhooks(ctx context.Context) ([]models.Webhook, error)
+	// UpdateWebhook stores the URL, events and secret of a webhook.
+	UpdateWebhook(ctx context.Context, webhook *models.Webhook) error
+	// DeleteWebhook deletes the webhook together with its deliveries.
+	DeleteWebhook(ctx context.Context, id int) error
+	// EnqueueDeliveries adds a pending delivery of event, due at once, for
+	// every webhook subscribed to its type. Enqueuing an event again adds no
+	// delivery for the webhooks it was enqueued for before.
+	EnqueueDeliveries(ctx context.Context, event models.Event) error
+	GetDelivery(ctx context.Context, id int) (*models.WebhookDelivery, error)
+	// ListDeliveries returns one page of the deliveries of a webhook, newest
+	// first.
+	ListDeliveries(ctx context.Context, params models.WebhookDeliveryListParams) (*models.WebhookDeliveryPage, error)
+	// DueDeliveries returns up to limit pending deliveries whose next attempt
+	// is due at now, oldest first.
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error)
+	// UpdateDelivery stores the outcome of an attempt: the state, attempts,
+	// next attempt, last status and error, and finish time of a delivery.
+	// UpdateDelivery and RequeueDelivery store nothing and return
+	// ErrDeliveryChanged unless the delivery still has the version it was
+	// read with, and move delivery to the next version otherwise.
+	UpdateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+	// RequeueDelivery makes a delivery pending again, with no attempts and
+	// due at now, and updates delivery to match.
+	RequeueDelivery(ctx context.Context, delivery *models.WebhookDelivery, now time.Time) error
+	// PruneDeliveries removes the deliveries that finished, successfully or
+	// not, before the given time and returns how many it removed.
+	PruneDeliveries(ctx context.Context, before time.Time) (int, error)
+}
+
+// WebhookRepository is the SQL implementation of WebhookStore.
+type WebhookRepository struct {
+	DB           *sql.DB
+	QueryBuilder squirrel.StatementBuilderType
+
+	dialect dialect
+}
+
+var _ WebhookStore = (*WebhookRepository)(nil)
+
+var (
+	webhookColumns  = []string{"id", "url", "event_types", "secret", "created_at", "updated_at"}
+	deliveryColumns = []string{"id", "webhook_id", "event_id", "event_type", "payload", "state", "attempts",
+		"next_attempt_at", "last_status", "last_error", "created_at", "finished_at", "version"}
+)
+
+func NewWebhookRepository(db *sql.DB) *WebhookRepository {
+	return &WebhookRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Question),
+		dialect:      dialectSQLite,
+	}
+}
+
+// NewPostgresWebhookRepository returns a WebhookRepository for a PostgreSQL
+// database, using $n placeholders.
+func NewPostgresWebhookRepository(db *sql.DB) *WebhookRepository {
+	return &WebhookRepository{
+		DB:           db,
+		QueryBuilder: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+		dialect:      dialectPostgres,
+	}
+}
+
+// The event types of a webhook are stored comma-separated.
+func scanWebhook(row interface{ Scan(...interface{}) error }) (models.Webhook, error) {
+	var webhook models.Webhook
+	var eventTypes string
+	err := row.Scan(&webhook.ID, &webhook.URL, &eventTypes, &webhook.Secret, &webhook.CreatedAt, &webhook.UpdatedAt)
+	webhook.Events = strings.Split(eventTypes, ",")
+	return webhook, err
+}
+
+func scanDelivery(row interface{ Scan(...interface{}) error }) (models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	var payload string
+	var nextAttemptAt, finishedAt sql.NullTime
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &payload, &d.State, &d.Attempts,
+		&nextAttemptAt, &d.LastStatus, &d.LastError, &d.CreatedAt, &finishedAt, &d.Version)
+	d.Payload = json.RawMessage(payload)
+	if nextAttemptAt.Valid {
+		d.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if finishedAt.Valid {
+		d.FinishedAt = &finishedAt.Time
+	}
+	return d, err
+}
+
+func (r *WebhookRepository) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	if err := validate.Struct(webhook); err != nil {
+		return apperrors.Validation(err)
+	}
+	webhook.CreatedAt = time.Now().UTC()
+	webhook.UpdatedAt = webhook.CreatedAt
+
+	insert := r.QueryBuilder.
+		Insert("webhooks").
+		Columns(webhookColumns[1:]...).
+		Values(webhook.URL, strings.Join(webhook.Events, ","), webhook.Secret, webhook.CreatedAt, webhook.UpdatedAt)
+	if r.dialect == dialectPostgres {
+		query, args, err := insert.Suffix("RETURNING id").ToSql()
+		if err != nil {
+			return err
+		}
+		return r.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID)
+	}
+
+	query, args, err := insert.ToSql()
+	if err != nil {
+		return err
+	}
+	result, err := r.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	webhook.ID = int(id)
+	return err
+}
+
+func (r *WebhookRepository) GetWebhook(ctx context.Context, id int) (*models.Webhook, error) {
+	query, args, err := r.QueryBuilder.
+		Select(webhookColumns...).
+		From("webhooks").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	webhook, err := scanWebhook(r.DB.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (r *WebhookRepository) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	query, args, err := r.QueryBuilder.
+		Select(webhookColumns...).
+		From("webhooks").
+		OrderBy("id").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func (r *WebhookRepository) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	if err := validate.Struct(webhook); err != nil {
+		return apperrors.Validation(err)
+	}
+	webhook.UpdatedAt = time.Now().UTC()
+
+	query, args, err := r.QueryBuilder.
+		Update("webhooks").
+		Set("url", webhook.URL).
+		Set("event_types", strings.Join(webhook.Events, ",")).
+		Set("secret", webhook.Secret).
+		Set("updated_at", webhook.UpdatedAt).
+		Where(squirrel.Eq{"id": webhook.ID}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	res, err := r.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// DeleteWebhook removes the deliveries explicitly, as SQLite does not enforce
+// foreign keys unless asked to.
+func (r *WebhookRepository) DeleteWebhook(ctx context.Context, id int) error {
+	return inTx(ctx, r.DB, func(tx *sql.Tx) error {
+		if _, err := r.exec(ctx, tx, r.QueryBuilder.Delete("webhook_deliveries").Where(squirrel.Eq{"webhook_id": id})); err != nil {
+			return err
+		}
+		res, err := r.exec(ctx, tx, r.QueryBuilder.Delete("webhooks").Where(squirrel.Eq{"id": id}))
+		if err != nil {
+			return err
+		}
+		if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+			return ErrWebhookNotFound
+		}
+		return nil
+	})
+}
+
+func (r *WebhookRepository) exec(ctx context.Context, q queryer, builder squirrel.Sqlizer) (sql.Result, error) {
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	return q.ExecContext(ctx, query, args...)
+}
+
+// EnqueueDeliveries relies on the unique index on webhook_id and event_id to
+// skip the webhooks an event was enqueued for before.
+func (r *WebhookRepository) EnqueueDeliveries(ctx context.Context, event models.Event) error {
+	webhooks, err := r.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+
+	insert := r.QueryBuilder.
+		Insert("webhook_deliveries").
+		Columns("webhook_id", "event_id", "event_type", "payload", "state", "next_attempt_at", "created_at").
+		Suffix("ON CONFLICT (webhook_id, event_id) DO NOTHING")
+	subscribed := false
+	for _, webhook := range webhooks {
+		if webhook.Subscribes(event.Type) {
+			insert = insert.Values(webhook.ID, event.ID, event.Type, string(payload), models.DeliveryPending, now, now)
+			subscribed = true
+		}
+	}
+	if !subscribed {
+		return nil
+	}
+	_, err = r.exec(ctx, r.DB, insert)
+	return err
+}
+
+func (r *WebhookRepository) GetDelivery(ctx context.Context, id int) (*models.WebhookDelivery, error) {
+	query, args, err := r.QueryBuilder.
+		Select(deliveryColumns...).
+		From("webhook_deliveries").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	d, err := scanDelivery(r.DB.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, params models.WebhookDeliveryListParams) (*models.WebhookDeliveryPage, error) {
+	limit := normalizeLimit(params.Limit)
+	page := &models.WebhookDeliveryPage{Limit: limit, Offset: params.Offset}
+
+	where := squirrel.Eq{"webhook_id": params.WebhookID}
+	if params.State != "" {
+		where["state"] = params.State
+	}
+	query, args, err := r.QueryBuilder.Select("COUNT(*)").From("webhook_deliveries").Where(where).ToSql()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.DB.QueryRowContext(ctx, query, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	page.Deliveries, err = r.queryDeliveries(ctx, r.QueryBuilder.
+		Select(deliveryColumns...).
+		From("webhook_deliveries").
+		Where(where).
+		OrderBy("id DESC").
+		Limit(uint64(limit)).
+		Offset(uint64(params.Offset)))
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (r *WebhookRepository) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	return r.queryDeliveries(ctx, r.QueryBuilder.
+		Select(deliveryColumns...).
+		From("webhook_deliveries").
+		Where(squirrel.Eq{"state": models.DeliveryPending}).
+		Where(squirrel.LtOrEq{"next_attempt_at": now.UTC()}).
+		OrderBy("id").
+		Limit(uint64(limit)))
+}
+
+func (r *WebhookRepository) queryDeliveries(ctx context.Context, selectQuery squirrel.SelectBuilder) ([]models.WebhookDelivery, error) {
+	query, args, err := selectQuery.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (r *WebhookRepository) UpdateDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	res, err := r.exec(ctx, r.DB, r.QueryBuilder.
+		Update("webhook_deliveries").
+		Set("state", d.State).
+		Set("attempts", d.Attempts).
+		Set("next_attempt_at", d.NextAttemptAt).
+		Set("last_status", d.LastStatus).
+		Set("last_error", d.LastError).
+		Set("finished_at", d.FinishedAt).
+		Set("version", d.Version+1).
+		Where(squirrel.Eq{"id": d.ID, "version": d.Version}))
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return ErrDeliveryChanged
+	}
+	d.Version++
+	return nil
+}
+
+func (r *WebhookRepository) RequeueDelivery(ctx context.Context, d *models.WebhookDelivery, now time.Time) error {
+	now = now.UTC()
+	res, err := r.exec(ctx, r.DB, r.QueryBuilder.
+		Update("webhook_deliveries").
+		Set("state", models.DeliveryPending).
+		Set("attempts", 0).
+		Set("next_attempt_at", now).
+		Set("finished_at", nil).
+		Set("version", d.Version+1).
+		Where(squirrel.Eq{"id": d.ID, "version": d.Version}))
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return ErrDeliveryChanged
+	}
+	requeue(d, now)
+	return nil
+}
+
+// requeue makes d pending again, as RequeueDelivery stores it.
+func requeue(d *models.WebhookDelivery, now time.Time) {
+	d.State, d.Attempts, d.NextAttemptAt, d.FinishedAt = models.DeliveryPending, 0, &now, nil
+	d.Version++
+}
+
+func (r *WebhookRepository) PruneDeliveries(ctx context.Context, before time.Time) (int, error) {
+	res, err := r.exec(ctx, r.DB, r.QueryBuilder.
+		Delete("webhook_deliveries").
+		Where(squirrel.Lt{"finished_at": before.UTC()}))
+	if err != nil {
+		return 0, err
+	}
+	pruned, err := res.RowsAffected()
+	return int(pruned), err
+}
+
+// MemoryWebhookRepository is an in-memory WebhookStore for tests and local
+// development.
+type MemoryWebhookRepository struct {
+	mu             sync.RWMutex
+	webhooks       map[int]models.Webhook
+	nextID         int
+	deliveries     map[int]models.WebhookDelivery
+	nextDeliveryID int
+}
+
+var _ WebhookStore = (*MemoryWebhookRepository)(nil)
+
+func NewMemoryWebhookRepository() *MemoryWebhookRepository {
+	return &MemoryWebhookRepository{
+		webhooks:       make(map[int]models.Webhook),
+		nextID:         1,
+		deliveries:     make(map[int]models.WebhookDelivery),
+		nextDeliveryID: 1,
+	}
+}
+
+func (r *MemoryWebhookRepository) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	if err := validate.Struct(webhook); err != nil {
+		return apperrors.Validation(err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	webhook.ID = r.nextID
+	webhook.CreatedAt = time.Now().UTC()
+	webhook.UpdatedAt = webhook.CreatedAt
+	r.nextID++
+	r.webhooks[webhook.ID] = cloneWebhook(*webhook)
+	return nil
+}
+
+// cloneWebhook copies the events of a webhook, so that the store and its
+// callers do not share them.
+func cloneWebhook(webhook models.Webhook) models.Webhook {
+	webhook.Events = append([]string(nil), webhook.Events...)
+	return webhook
+}
+
+func (r *MemoryWebhookRepository) GetWebhook(ctx context.Context, id int) (*models.Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	webhook, ok := r.webhooks[id]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+	webhook = cloneWebhook(webhook)
+	return &webhook, nil
+}
+
+func (r *MemoryWebhookRepository) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	webhooks := make([]models.Webhook, 0, len(r.webhooks))
+	for _, webhook := range r.webhooks {
+		webhooks = append(webhooks, cloneWebhook(webhook))
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+	return webhooks, nil
+}
+
+func (r *MemoryWebhookRepository) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	if err := validate.Struct(webhook); err != nil {
+		return apperrors.Validation(err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.webhooks[webhook.ID]
+	if !ok {
+		return ErrWebhookNotFound
+	}
+	webhook.CreatedAt = existing.CreatedAt
+	webhook.UpdatedAt = time.Now().UTC()
+	r.webhooks[webhook.ID] = cloneWebhook(*webhook)
+	return nil
+}
+
+func (r *MemoryWebhookRepository) DeleteWebhook(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.webhooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(r.webhooks, id)
+	for deliveryID, d := range r.deliveries {
+		if d.WebhookID == id {
+			delete(r.deliveries, deliveryID)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryWebhookRepository) EnqueueDeliveries(ctx context.Context, event models.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	enqueued := make(map[int]bool)
+	for _, d := range r.deliveries {
+		if d.EventID == event.ID {
+			enqueued[d.WebhookID] = true
+		}
+	}
+	webhookIDs := make([]int, 0, len(r.webhooks))
+	for id, webhook := range r.webhooks {
+		if webhook.Subscribes(event.Type) && !enqueued[id] {
+			webhookIDs = append(webhookIDs, id)
+		}
+	}
+	sort.Ints(webhookIDs)
+
+	now := time.Now().UTC()
+	for _, webhookID := range webhookIDs {
+		r.deliveries[r.nextDeliveryID] = models.WebhookDelivery{
+			ID:            r.nextDeliveryID,
+			WebhookID:     webhookID,
+			EventID:       event.ID,
+			EventType:     event.Type,
+			Payload:       payload,
+			State:         models.DeliveryPending,
+			NextAttemptAt: &now,
+			CreatedAt:     now,
+			Version:       1,
+		}
+		r.nextDeliveryID++
+	}
+	return nil
+}
+
+func (r *MemoryWebhookRepository) GetDelivery(ctx context.Context, id int) (*models.WebhookDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d, ok := r.deliveries[id]
+	if !ok {
+		return nil, ErrDeliveryNotFound
+	}
+	return &d, nil
+}
+
+func (r *MemoryWebhookRepository) ListDeliveries(ctx context.Context, params models.WebhookDeliveryListParams) (*models.WebhookDeliveryPage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	limit := normalizeLimit(params.Limit)
+	matched := []models.WebhookDelivery{}
+	for _, d := range r.deliveries {
+		if d.WebhookID == params.WebhookID && (params.State == "" || d.State == params.State) {
+			matched = append(matched, d)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID > matched[j].ID })
+
+	page := &models.WebhookDeliveryPage{Deliveries: []models.WebhookDelivery{}, Total: len(matched), Limit: limit, Offset: params.Offset}
+	if params.Offset < len(matched) {
+		end := min(params.Offset+limit, len(matched))
+		page.Deliveries = matched[params.Offset:end]
+	}
+	return page, nil
+}
+
+func (r *MemoryWebhookRepository) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	deliveries := []models.WebhookDelivery{}
+	for _, d := range r.deliveries {
+		if d.State == models.DeliveryPending && d.NextAttemptAt != nil && !d.NextAttemptAt.After(now) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (r *MemoryWebhookRepository) UpdateDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.deliveries[d.ID]
+	if !ok || existing.Version != d.Version {
+		return ErrDeliveryChanged
+	}
+	existing.State, existing.Attempts, existing.NextAttemptAt = d.State, d.Attempts, d.NextAttemptAt
+	existing.LastStatus, existing.LastError, existing.FinishedAt = d.LastStatus, d.LastError, d.FinishedAt
+	existing.Version++
+	r.deliveries[d.ID] = existing
+	d.Version++
+	return nil
+}
+
+func (r *MemoryWebhookRepository) RequeueDelivery(ctx context.Context, d *models.WebhookDelivery, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.deliveries[d.ID]
+	if !ok || existing.Version != d.Version {
+		return ErrDeliveryChanged
+	}
+	now = now.UTC()
+	requeue(&existing, now)
+	r.deliveries[d.ID] = existing
+	requeue(d, now)
+	return nil
+}
+
+func (r *MemoryWebhookRepository) PruneDeliveries(ctx context.Context, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pruned := 0
+	for id, d := range r.deliveries {
+		if d.FinishedAt != nil && d.FinishedAt.Before(before) {
+			delete(r.deliveries, id)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
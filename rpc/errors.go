@@ -0,0 +1,76 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"user-service/apperrors"
+	"user-service/repositories"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// codeByKind maps each kind of error to its gRPC status code, as
+// controllers.ErrorHandler maps them to HTTP statuses.
+var codeByKind = map[apperrors.Kind]codes.Code{
+	apperrors.Internal:        codes.Internal,
+	apperrors.Invalid:         codes.InvalidArgument,
+	apperrors.Unauthenticated: codes.Unauthenticated,
+	apperrors.Forbidden:       codes.PermissionDenied,
+	apperrors.NotFound:        codes.NotFound,
+	apperrors.Conflict:        codes.FailedPrecondition,
+	// The user changed since the client read it: read it again and retry
+	apperrors.PreconditionFailed:   codes.Aborted,
+	apperrors.PreconditionRequired: codes.FailedPrecondition,
+	apperrors.UnsupportedMediaType: codes.InvalidArgument,
+}
+
+// statusError converts err into a gRPC status error: *apperrors.Error by its
+// kind, with its field errors as BadRequest details, and anything else as an
+// internal error whose details are logged but not returned. Errors of a call
+// whose context is done report the deadline or the cancellation instead.
+func statusError(ctx context.Context, method string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, "the call timed out")
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, "the call was canceled")
+	}
+
+	kind := apperrors.KindOf(err)
+	code := codeByKind[kind]
+	if errors.Is(err, repositories.ErrDuplicateUsername) {
+		code = codes.AlreadyExists
+	}
+	if kind == apperrors.Internal {
+		slog.Error("call failed", "method", method, "error", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+
+	var appErr *apperrors.Error
+	errors.As(err, &appErr)
+	st := status.New(code, apperrors.PublicMessage(err))
+	if len(appErr.Fields) == 0 {
+		return st.Err()
+	}
+	violations := make([]*errdetails.BadRequest_FieldViolation, len(appErr.Fields))
+	for i, field := range appErr.Fields {
+		violations[i] = &errdetails.BadRequest_FieldViolation{Field: field.Field, Description: field.Message}
+	}
+	if detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
@@ -0,0 +1,169 @@
+// Package rpc serves the user.v1.UserService gRPC API on top of the same
+// services as the REST API, with the same authentication, authorization,
+// validation and audit trail.
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/auth"
+	"user-service/models"
+	userv1 "user-service/proto/user/v1"
+	"user-service/services"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Metadata keys read and set by the server. They are the lowercase names of
+// the headers of the REST API.
+const (
+	metadataAPIKey        = "x-api-key"
+	metadataAuthorization = "authorization"
+	metadataActor         = "x-actor"
+	metadataRequestID     = "x-request-id"
+)
+
+// methodPermissions is the permission each method requires.
+var methodPermissions = map[string]auth.Permission{
+	userv1.UserService_Get_FullMethodName:    auth.PermReadUsers,
+	userv1.UserService_List_FullMethodName:   auth.PermReadUsers,
+	userv1.UserService_Create_FullMethodName: auth.PermWriteUsers,
+	userv1.UserService_Update_FullMethodName: auth.PermWriteUsers,
+	userv1.UserService_Patch_FullMethodName:  auth.PermWriteUsers,
+	userv1.UserService_Delete_FullMethodName: auth.PermWriteUsers,
+	userv1.UserService_Watch_FullMethodName:  auth.PermReadUsers,
+}
+
+var errUnknownMethod = apperrors.New(apperrors.Forbidden, "method is not permitted")
+
+// unbounded lists the methods that Options.Timeout does not apply to.
+var unbounded = map[string]bool{
+	userv1.UserService_Watch_FullMethodName: true,
+}
+
+// Options configure a server.
+type Options struct {
+	// Authenticator authenticates calls; nil leaves every call open, like
+	// the REST API with authentication disabled.
+	Authenticator *auth.Authenticator
+	// Timeout bounds each call but Watch, like db.request_timeout bounds
+	// requests; 0 leaves calls unbounded.
+	Timeout time.Duration
+}
+
+// NewServer returns a gRPC server serving user.v1.UserService with users and
+// the change feed of changes.
+func NewServer(users *services.UserService, changes *services.ChangeBroker, opts Options) *grpc.Server {
+	i := &interceptor{opts: opts}
+	server := grpc.NewServer(grpc.UnaryInterceptor(i.unary), grpc.StreamInterceptor(i.stream))
+	userv1.RegisterUserServiceServer(server, &UserServer{Users: users, Changes: changes})
+	return server
+}
+
+// interceptor prepares the context of each call: it assigns the request ID,
+// authenticates and authorizes the caller and applies the timeout. It also
+// converts the errors of calls into status errors.
+type interceptor struct {
+	opts Options
+}
+
+func (i *interceptor) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, cancel, err := i.prepare(ctx, info.FullMethod, grpc.SetHeader)
+	if err != nil {
+		return nil, statusError(ctx, info.FullMethod, err)
+	}
+	defer cancel()
+	res, err := handler(ctx, req)
+	return res, statusError(ctx, info.FullMethod, err)
+}
+
+func (i *interceptor) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	setHeader := func(_ context.Context, md metadata.MD) error { return ss.SetHeader(md) }
+	ctx, cancel, err := i.prepare(ss.Context(), info.FullMethod, setHeader)
+	if err != nil {
+		return statusError(ctx, info.FullMethod, err)
+	}
+	defer cancel()
+	err = handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	return statusError(ctx, info.FullMethod, err)
+}
+
+func (i *interceptor) prepare(ctx context.Context, method string, setHeader func(context.Context, metadata.MD) error) (context.Context, context.CancelFunc, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	requestID := first(md, metadataRequestID)
+	if requestID == "" {
+		var err error
+		if requestID, err = newRequestID(); err != nil {
+			return ctx, nil, err
+		}
+	}
+	setHeader(ctx, metadata.Pairs(metadataRequestID, requestID))
+	audit := models.AuditContext{Actor: first(md, metadataActor), RequestID: requestID}
+
+	if i.opts.Authenticator != nil {
+		principal, err := i.opts.Authenticator.AuthenticateCredentials(ctx, first(md, metadataAPIKey), first(md, metadataAuthorization))
+		if err != nil {
+			return ctx, nil, err
+		}
+		perm, ok := methodPermissions[method]
+		if !ok {
+			return ctx, nil, errUnknownMethod
+		}
+		if err := principal.Authorize(perm); err != nil {
+			return ctx, nil, err
+		}
+		ctx = auth.NewContext(ctx, principal)
+		audit.Actor = principal.Subject
+	}
+	ctx = context.WithValue(ctx, auditKey{}, audit)
+
+	if i.opts.Timeout > 0 && !unbounded[method] {
+		ctx, cancel := context.WithTimeout(ctx, i.opts.Timeout)
+		return ctx, cancel, nil
+	}
+	return ctx, func() {}, nil
+}
+
+// first returns the first value of a metadata key, or "".
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// newRequestID returns a random request ID, like those the REST API assigns.
+// Like the REST API, it fails the call rather than hand out an ID that other
+// calls may share when the system's random source fails.
+func newRequestID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("generating a request ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+type auditKey struct{}
+
+// auditContext returns the audit context prepared for the call of ctx.
+func auditContext(ctx context.Context) models.AuditContext {
+	audit, _ := ctx.Value(auditKey{}).(models.AuditContext)
+	return audit
+}
+
+// serverStream is a grpc.ServerStream whose context was prepared by the
+// interceptor.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
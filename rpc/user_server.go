@@ -0,0 +1,234 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"user-service/apperrors"
+	"user-service/auth"
+	"user-service/models"
+	userv1 "user-service/proto/user/v1"
+	"user-service/services"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	errVersionRequired = apperrors.New(apperrors.PreconditionRequired, "version is required")
+	errInvalidVersion  = apperrors.New(apperrors.Invalid, "invalid version")
+	errMaskRequired    = apperrors.New(apperrors.Invalid, "update_mask is required")
+)
+
+// patchableFields are the fields of a user, by their proto and JSON names,
+// that Patch can name in its update mask.
+var patchableFields = map[string]bool{
+	"user_name":  true,
+	"email":      true,
+	"first_name": true,
+	"last_name":  true,
+	"status":     true,
+	"department": true,
+	"manager_id": true,
+}
+
+// UserServer implements user.v1.UserService with the services behind the
+// REST API.
+type UserServer struct {
+	userv1.UnimplementedUserServiceServer
+
+	Users   *services.UserService
+	Changes *services.ChangeBroker
+}
+
+var _ userv1.UserServiceServer = (*UserServer)(nil)
+
+// asCaller returns the user service acting for the caller of ctx: its writes
+// are attributed to the caller in the audit log and limited to the users
+// whose department perm is granted in.
+func (s *UserServer) asCaller(ctx context.Context, perm auth.Permission) *services.UserService {
+	service := s.Users.WithAudit(auditContext(ctx))
+	if principal, ok := auth.FromContext(ctx); ok {
+		service = service.WithGuard(principal.Guard(perm))
+	}
+	return service
+}
+
+func (s *UserServer) Get(ctx context.Context, req *userv1.GetRequest) (*userv1.User, error) {
+	user, err := s.Users.GetUser(ctx, int(req.GetId()), req.GetIncludeDeleted())
+	if err != nil {
+		return nil, err
+	}
+	return toProto(user), nil
+}
+
+func (s *UserServer) List(req *userv1.ListRequest, stream userv1.UserService_ListServer) error {
+	params := models.UserListParams{
+		Status:         req.GetStatus(),
+		Department:     req.GetDepartment(),
+		UserNamePrefix: req.GetUserName(),
+		EmailDomain:    req.GetEmailDomain(),
+		Sort:           req.GetSort(),
+		IncludeDeleted: req.GetIncludeDeleted(),
+	}
+	return s.Users.StreamUsers(stream.Context(), params, func(user models.User) error {
+		return stream.Send(toProto(&user))
+	})
+}
+
+func (s *UserServer) Create(ctx context.Context, req *userv1.CreateRequest) (*userv1.User, error) {
+	user := fromProto(req.GetUser())
+	if err := s.asCaller(ctx, auth.PermWriteUsers).CreateUser(ctx, &user); err != nil {
+		return nil, err
+	}
+	return toProto(&user), nil
+}
+
+func (s *UserServer) Update(ctx context.Context, req *userv1.UpdateRequest) (*userv1.User, error) {
+	version, err := versionParam(req.Version)
+	if err != nil {
+		return nil, err
+	}
+	user := fromProto(req.GetUser())
+	user.Version = version
+	if err := s.asCaller(ctx, auth.PermWriteUsers).UpdateUser(ctx, &user); err != nil {
+		return nil, err
+	}
+	return toProto(&user), nil
+}
+
+// Patch turns the fields named by the update mask into a JSON Merge Patch, so
+// that patches are checked and written as those of PATCH /users/{id}.
+func (s *UserServer) Patch(ctx context.Context, req *userv1.PatchRequest) (*userv1.User, error) {
+	version, err := versionParam(req.Version)
+	if err != nil {
+		return nil, err
+	}
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		return nil, errMaskRequired
+	}
+	values, err := json.Marshal(fromProto(req.GetUser()))
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(values, &fields); err != nil {
+		return nil, err
+	}
+	patch := make(map[string]json.RawMessage, len(paths))
+	for _, path := range paths {
+		if !patchableFields[path] {
+			return nil, apperrors.New(apperrors.Invalid, fmt.Sprintf("update_mask cannot name %q", path))
+		}
+		patch[path] = fields[path]
+		if patch[path] == nil {
+			// Unset, which clears the field
+			patch[path] = json.RawMessage("null")
+		}
+	}
+	document, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.asCaller(ctx, auth.PermWriteUsers).PatchUser(ctx, int(req.GetId()), version, services.MergePatchType, document)
+	if err != nil {
+		return nil, err
+	}
+	return toProto(user), nil
+}
+
+func (s *UserServer) Delete(ctx context.Context, req *userv1.DeleteRequest) (*emptypb.Empty, error) {
+	version, err := versionParam(req.Version)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.asCaller(ctx, auth.PermWriteUsers).DeleteUser(ctx, int(req.GetId()), version); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *UserServer) Watch(req *userv1.WatchRequest, stream userv1.UserService_WatchServer) error {
+	after := -1
+	if req.After != nil {
+		if *req.After < 0 {
+			return apperrors.New(apperrors.Invalid, "invalid after")
+		}
+		after = int(*req.After)
+	}
+	return s.Changes.Stream(stream.Context(), after, changeStream{stream})
+}
+
+// changeStream sends changes as UserChange messages. gRPC has keep-alives of
+// its own.
+type changeStream struct {
+	stream userv1.UserService_WatchServer
+}
+
+func (s changeStream) Open() error {
+	return nil
+}
+
+func (s changeStream) Send(change models.UserChange) error {
+	return s.stream.Send(&userv1.UserChange{Seq: int64(change.Seq), Type: change.Type, User: toProto(&change.User)})
+}
+
+func (s changeStream) KeepAlive() error {
+	return nil
+}
+
+// versionParam returns the version a write requires, or 0 for any, like
+// the If-Match header of the REST API.
+func versionParam(version *int64) (int, error) {
+	if version == nil {
+		return 0, errVersionRequired
+	}
+	if *version < 0 {
+		return 0, errInvalidVersion
+	}
+	return int(*version), nil
+}
+
+func toProto(user *models.User) *userv1.User {
+	message := &userv1.User{
+		Id:         int64(user.ID),
+		UserName:   user.UserName,
+		Email:      user.Email,
+		FirstName:  user.FirstName,
+		LastName:   user.LastName,
+		Status:     user.Status,
+		Department: user.Department,
+		Version:    int64(user.Version),
+	}
+	if user.ManagerID != nil {
+		managerID := int64(*user.ManagerID)
+		message.ManagerId = &managerID
+	}
+	if user.DeletedAt != nil {
+		message.DeletedAt = timestamppb.New(*user.DeletedAt)
+	}
+	return message
+}
+
+// fromProto returns the user that message describes. Its deletion time is
+// left out: users are deleted with Delete.
+func fromProto(message *userv1.User) models.User {
+	user := models.User{
+		ID:         int(message.GetId()),
+		UserName:   message.GetUserName(),
+		Email:      message.GetEmail(),
+		FirstName:  message.GetFirstName(),
+		LastName:   message.GetLastName(),
+		Status:     message.GetStatus(),
+		Department: message.GetDepartment(),
+		Version:    int(message.GetVersion()),
+	}
+	if message.ManagerId != nil {
+		managerID := int(*message.ManagerId)
+		user.ManagerID = &managerID
+	}
+	return user
+}
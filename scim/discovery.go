@@ -0,0 +1,132 @@
+package scim
+
+import (
+	_ "embed"
+	"encoding/json"
+	"strings"
+)
+
+// Discovery schema URNs.
+const (
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+)
+
+//go:embed schemas.json
+var schemasJSON []byte
+
+// Schemas returns the definitions of the supported schemas.
+func Schemas() []json.RawMessage {
+	var schemas []json.RawMessage
+	if err := json.Unmarshal(schemasJSON, &schemas); err != nil {
+		panic("scim: invalid schemas.json: " + err.Error())
+	}
+	return schemas
+}
+
+// Schema returns the definition of the schema with the given URN.
+func Schema(id string) (json.RawMessage, bool) {
+	for _, schema := range Schemas() {
+		var s struct{ ID string }
+		if json.Unmarshal(schema, &s) == nil && strings.EqualFold(s.ID, id) {
+			return schema, true
+		}
+	}
+	return nil, false
+}
+
+// Supported describes whether an optional feature is supported.
+type Supported struct {
+	Supported bool `json:"supported"`
+}
+
+// FilterConfig describes filter support.
+type FilterConfig struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+// BulkConfig describes bulk operation support.
+type BulkConfig struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+// AuthenticationScheme describes a way to authenticate.
+type AuthenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary,omitempty"`
+}
+
+// ServiceProviderConfig describes the features of the service.
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	DocumentationURI      string                 `json:"documentationUri,omitempty"`
+	Patch                 Supported              `json:"patch"`
+	Bulk                  BulkConfig             `json:"bulk"`
+	Filter                FilterConfig           `json:"filter"`
+	ChangePassword        Supported              `json:"changePassword"`
+	Sort                  Supported              `json:"sort"`
+	ETag                  Supported              `json:"etag"`
+	AuthenticationSchemes []AuthenticationScheme `json:"authenticationSchemes"`
+	Meta                  Meta                   `json:"meta"`
+}
+
+// Config returns the service provider configuration.
+func Config() ServiceProviderConfig {
+	return ServiceProviderConfig{
+		Schemas:        []string{SchemaServiceProviderConfig},
+		Patch:          Supported{true},
+		Filter:         FilterConfig{Supported: true, MaxResults: MaxResults},
+		ChangePassword: Supported{false},
+		Sort:           Supported{false},
+		ETag:           Supported{true},
+		AuthenticationSchemes: []AuthenticationScheme{
+			{Type: "oauthbearertoken", Name: "OAuth Bearer Token", Description: "A JWT or an API key as a bearer token", Primary: true},
+		},
+		Meta: Meta{ResourceType: "ServiceProviderConfig", Location: "/scim/v2/ServiceProviderConfig"},
+	}
+}
+
+// SchemaExtension names an extension of a resource type.
+type SchemaExtension struct {
+	Schema   string `json:"schema"`
+	Required bool   `json:"required"`
+}
+
+// ResourceType describes a resource type.
+type ResourceType struct {
+	Schemas          []string          `json:"schemas"`
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	Endpoint         string            `json:"endpoint"`
+	Schema           string            `json:"schema"`
+	SchemaExtensions []SchemaExtension `json:"schemaExtensions,omitempty"`
+	Meta             Meta              `json:"meta"`
+}
+
+// ResourceTypes returns the supported resource types.
+func ResourceTypes() []ResourceType {
+	return []ResourceType{
+		{
+			Schemas:          []string{SchemaResourceType},
+			ID:               "User",
+			Name:             "User",
+			Endpoint:         "/Users",
+			Schema:           SchemaUser,
+			SchemaExtensions: []SchemaExtension{{Schema: SchemaEnterpriseUser, Required: true}},
+			Meta:             Meta{ResourceType: "ResourceType", Location: "/scim/v2/ResourceTypes/User"},
+		},
+		{
+			Schemas:  []string{SchemaResourceType},
+			ID:       "Group",
+			Name:     "Group",
+			Endpoint: "/Groups",
+			Schema:   SchemaGroup,
+			Meta:     Meta{ResourceType: "ResourceType", Location: "/scim/v2/ResourceTypes/Group"},
+		},
+	}
+}
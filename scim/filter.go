@@ -0,0 +1,523 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Filter is a parsed SCIM filter (RFC 7644, section 3.4.2.2). It is
+// evaluated against a resource in its JSON form, as decoded into a map.
+// Attribute names and string values compare case-insensitively.
+type Filter interface {
+	Match(resource map[string]interface{}) bool
+}
+
+// ParseFilter parses a filter such as `userName eq "bjensen"`. Every operator
+// of RFC 7644 is supported, as are logical expressions, parentheses and
+// value paths such as `emails[type eq "work" and value co "@example.com"]`.
+func ParseFilter(filter string) (Filter, error) {
+	tokens, err := tokenize(filter)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, invalid(ErrInvalidFilter, fmt.Sprintf("unexpected %q in filter", p.peek().text))
+	}
+	return f, nil
+}
+
+// EqualValue returns the string that attribute must equal for f to match a
+// resource: f compares attribute with eq, alone or as one of the operands of
+// an and. Listings use it to read only the resources that can match before
+// evaluating f itself.
+func EqualValue(f Filter, attribute string) (string, bool) {
+	path, err := splitAttribute(attribute)
+	if err != nil {
+		return "", false
+	}
+	switch f := f.(type) {
+	case compareFilter:
+		if value, ok := f.value.(string); ok && f.operator == "eq" && samePath(f.path, path) {
+			return value, true
+		}
+	case andFilter:
+		if value, ok := EqualValue(f.left, attribute); ok {
+			return value, true
+		}
+		return EqualValue(f.right, attribute)
+	}
+	return "", false
+}
+
+// SoleEqualValue is EqualValue for filters that do nothing but compare
+// attribute with eq, which a listing can leave to its store altogether.
+func SoleEqualValue(f Filter, attribute string) (string, bool) {
+	if _, ok := f.(compareFilter); !ok {
+		return "", false
+	}
+	return EqualValue(f, attribute)
+}
+
+// Refers reports whether f refers to attribute or any of its sub-attributes.
+func Refers(f Filter, attribute string) bool {
+	switch f := f.(type) {
+	case orFilter:
+		return Refers(f.left, attribute) || Refers(f.right, attribute)
+	case andFilter:
+		return Refers(f.left, attribute) || Refers(f.right, attribute)
+	case notFilter:
+		return Refers(f.inner, attribute)
+	case presentFilter:
+		return strings.EqualFold(f.path[0], attribute)
+	case valuePathFilter:
+		return strings.EqualFold(f.path[0], attribute)
+	case compareFilter:
+		return strings.EqualFold(f.path[0], attribute)
+	}
+	return true
+}
+
+func samePath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOpen
+	tokenClose
+	tokenOpenBracket
+	tokenCloseBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// tokenize splits a filter into words (attribute paths, operators and
+// literals), quoted strings and brackets.
+func tokenize(filter string) ([]token, error) {
+	var tokens []token
+	runes := []rune(filter)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokenOpen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokenClose, ")"})
+			i++
+		case r == '[':
+			tokens = append(tokens, token{tokenOpenBracket, "["})
+			i++
+		case r == ']':
+			tokens = append(tokens, token{tokenCloseBracket, "]"})
+			i++
+		case r == '"':
+			end := i + 1
+			for ; end < len(runes) && runes[end] != '"'; end++ {
+				if runes[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(runes) {
+				return nil, invalid(ErrInvalidFilter, "unterminated string in filter")
+			}
+			var s string
+			if err := json.Unmarshal([]byte(string(runes[i:end+1])), &s); err != nil {
+				return nil, invalid(ErrInvalidFilter, "invalid string in filter")
+			}
+			tokens = append(tokens, token{tokenString, s})
+			i = end + 1
+		default:
+			end := i
+			for ; end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune(`()[]"`, runes[end]); end++ {
+			}
+			tokens = append(tokens, token{tokenWord, string(runes[i:end])})
+			i = end
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, invalid(ErrInvalidFilter, "empty filter")
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *filterParser) peek() token {
+	if p.done() {
+		return token{kind: tokenWord}
+	}
+	return p.tokens[p.pos]
+}
+
+// keyword reports whether the next token is the given keyword and consumes it
+// if so.
+func (p *filterParser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokenWord && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(kind tokenKind, text string) error {
+	if p.done() || p.peek().kind != kind {
+		return invalid(ErrInvalidFilter, fmt.Sprintf("expected %q in filter", text))
+	}
+	p.pos++
+	return nil
+}
+
+func (p *filterParser) or() (Filter, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = orFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) and() (Filter, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = andFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) unary() (Filter, error) {
+	if p.keyword("not") {
+		if err := p.expect(tokenOpen, "("); err != nil {
+			return nil, err
+		}
+		f, err := p.group()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{f}, nil
+	}
+	if p.peek().kind == tokenOpen {
+		p.pos++
+		return p.group()
+	}
+	return p.attribute()
+}
+
+// group parses the rest of a parenthesised expression.
+func (p *filterParser) group() (Filter, error) {
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokenClose, ")"); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *filterParser) attribute() (Filter, error) {
+	t := p.peek()
+	if p.done() || t.kind != tokenWord {
+		return nil, invalid(ErrInvalidFilter, "expected an attribute in filter")
+	}
+	p.pos++
+	path, err := splitAttribute(t.text)
+	if err != nil {
+		return nil, invalid(ErrInvalidFilter, err.Error())
+	}
+
+	if p.peek().kind == tokenOpenBracket {
+		p.pos++
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenCloseBracket, "]"); err != nil {
+			return nil, err
+		}
+		return valuePathFilter{path, inner}, nil
+	}
+
+	op := p.peek()
+	if p.done() || op.kind != tokenWord {
+		return nil, invalid(ErrInvalidFilter, "expected an operator after "+t.text)
+	}
+	p.pos++
+	operator := strings.ToLower(op.text)
+	if operator == "pr" {
+		return presentFilter{path}, nil
+	}
+	if _, ok := comparisons[operator]; !ok {
+		return nil, invalid(ErrInvalidFilter, fmt.Sprintf("unknown operator %q", op.text))
+	}
+
+	v := p.peek()
+	if p.done() || (v.kind != tokenWord && v.kind != tokenString) {
+		return nil, invalid(ErrInvalidFilter, "expected a value after "+op.text)
+	}
+	p.pos++
+	value, err := literal(v)
+	if err != nil {
+		return nil, err
+	}
+	return compareFilter{path, operator, value}, nil
+}
+
+// literal converts a value token to a string, bool, float64 or nil.
+func literal(t token) (interface{}, error) {
+	if t.kind == tokenString {
+		return t.text, nil
+	}
+	switch strings.ToLower(t.text) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	var n float64
+	if err := json.Unmarshal([]byte(t.text), &n); err != nil {
+		return nil, invalid(ErrInvalidFilter, fmt.Sprintf("invalid value %q in filter", t.text))
+	}
+	return n, nil
+}
+
+type orFilter struct{ left, right Filter }
+
+func (f orFilter) Match(r map[string]interface{}) bool { return f.left.Match(r) || f.right.Match(r) }
+
+type andFilter struct{ left, right Filter }
+
+func (f andFilter) Match(r map[string]interface{}) bool { return f.left.Match(r) && f.right.Match(r) }
+
+type notFilter struct{ inner Filter }
+
+func (f notFilter) Match(r map[string]interface{}) bool { return !f.inner.Match(r) }
+
+type presentFilter struct{ path []string }
+
+func (f presentFilter) Match(r map[string]interface{}) bool {
+	for _, v := range lookup(r, f.path) {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			if v != "" {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// valuePathFilter matches resources with an element of a multi-valued
+// attribute that matches the inner filter.
+type valuePathFilter struct {
+	path  []string
+	inner Filter
+}
+
+func (f valuePathFilter) Match(r map[string]interface{}) bool {
+	for _, v := range lookup(r, f.path) {
+		if element, ok := v.(map[string]interface{}); ok && f.inner.Match(element) {
+			return true
+		}
+	}
+	return false
+}
+
+type compareFilter struct {
+	path     []string
+	operator string
+	value    interface{}
+}
+
+func (f compareFilter) Match(r map[string]interface{}) bool {
+	values := lookup(r, f.path)
+	if f.operator == "ne" {
+		return !(compareFilter{f.path, "eq", f.value}).Match(r)
+	}
+	compare := comparisons[f.operator]
+	for _, v := range values {
+		// A multi-valued attribute compares by its value sub-attribute.
+		if element, ok := v.(map[string]interface{}); ok {
+			v = get(element, "value")
+		}
+		if compare(v, f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// comparisons holds the comparison operators other than ne and pr.
+var comparisons = map[string]func(attr, value interface{}) bool{
+	"eq": func(a, v interface{}) bool { return order(a, v) == 0 },
+	"ne": nil,
+	"co": stringTest(strings.Contains),
+	"sw": stringTest(strings.HasPrefix),
+	"ew": stringTest(strings.HasSuffix),
+	"gt": func(a, v interface{}) bool { return order(a, v) == 1 },
+	"ge": func(a, v interface{}) bool { c := order(a, v); return c == 0 || c == 1 },
+	"lt": func(a, v interface{}) bool { return order(a, v) == -1 },
+	"le": func(a, v interface{}) bool { c := order(a, v); return c == 0 || c == -1 },
+}
+
+func stringTest(test func(s, substr string) bool) func(a, v interface{}) bool {
+	return func(a, v interface{}) bool {
+		as, ok1 := a.(string)
+		vs, ok2 := v.(string)
+		return ok1 && ok2 && test(strings.ToLower(as), strings.ToLower(vs))
+	}
+}
+
+// order compares two values of the same type, returning -1, 0 or 1, or 2 if
+// they cannot be compared.
+func order(a, v interface{}) int {
+	switch a := a.(type) {
+	case string:
+		if v, ok := v.(string); ok {
+			return strings.Compare(strings.ToLower(a), strings.ToLower(v))
+		}
+	case float64:
+		if v, ok := v.(float64); ok {
+			switch {
+			case a < v:
+				return -1
+			case a > v:
+				return 1
+			}
+			return 0
+		}
+	case bool:
+		if v, ok := v.(bool); ok && a == v {
+			return 0
+		}
+	case nil:
+		if v == nil {
+			return 0
+		}
+	}
+	return 2
+}
+
+// lookup returns the values at path in r, flattening multi-valued
+// attributes along the way.
+func lookup(r map[string]interface{}, path []string) []interface{} {
+	values := []interface{}{r}
+	for _, name := range path {
+		var next []interface{}
+		for _, v := range values {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch v := get(m, name).(type) {
+			case nil:
+			case []interface{}:
+				next = append(next, v...)
+			default:
+				next = append(next, v)
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// get returns the attribute of m with the given name, ignoring case.
+func get(m map[string]interface{}, name string) interface{} {
+	if key, ok := keyOf(m, name); ok {
+		return m[key]
+	}
+	return nil
+}
+
+// keyOf returns the key of m that matches name, ignoring case.
+func keyOf(m map[string]interface{}, name string) (string, bool) {
+	if _, ok := m[name]; ok {
+		return name, true
+	}
+	for key := range m {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return name, false
+}
+
+// extensionSchemas lists the schemas whose attributes are nested under the
+// schema URN in a resource.
+var extensionSchemas = []string{SchemaEnterpriseUser}
+
+// splitAttribute splits an attribute path such as "name.givenName" into its
+// names. Attributes qualified by a core schema URN lose the URN; those of an
+// extension are nested under it.
+func splitAttribute(attr string) ([]string, error) {
+	if strings.HasPrefix(strings.ToLower(attr), "urn:") {
+		for _, schema := range append([]string{SchemaUser, SchemaGroup}, extensionSchemas...) {
+			if len(attr) <= len(schema)+1 || !strings.EqualFold(attr[:len(schema)+1], schema+":") {
+				continue
+			}
+			names := strings.Split(attr[len(schema)+1:], ".")
+			if schema == SchemaUser || schema == SchemaGroup {
+				return names, nil
+			}
+			return append([]string{schema}, names...), nil
+		}
+		return nil, fmt.Errorf("unknown schema in attribute %q", attr)
+	}
+	names := strings.Split(attr, ".")
+	for _, name := range names {
+		if name == "" {
+			return nil, fmt.Errorf("invalid attribute %q", attr)
+		}
+	}
+	return names, nil
+}
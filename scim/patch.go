@@ -0,0 +1,318 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PatchUser applies the operations of req to a copy of user and returns it.
+func PatchUser(user *User, req PatchRequest) (*User, error) {
+	resource, err := toMap(user)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyPatch(resource, req); err != nil {
+		return nil, err
+	}
+	// Some clients, Azure AD among them, send active as a string.
+	if key, ok := keyOf(resource, "active"); ok {
+		if s, ok := resource[key].(string); ok {
+			active, err := strconv.ParseBool(s)
+			if err != nil {
+				return nil, invalid(ErrInvalidValue, fmt.Sprintf("active must be a boolean, not %q", s))
+			}
+			resource[key] = active
+		}
+	}
+	patched := &User{}
+	return patched, fromMap(resource, patched)
+}
+
+// PatchGroup applies the operations of req to a copy of group and returns
+// it.
+func PatchGroup(group *Group, req PatchRequest) (*Group, error) {
+	resource, err := toMap(group)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyPatch(resource, req); err != nil {
+		return nil, err
+	}
+	patched := &Group{}
+	return patched, fromMap(resource, patched)
+}
+
+func toMap(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	return m, json.Unmarshal(data, &m)
+}
+
+func fromMap(m map[string]interface{}, v interface{}) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return invalid(ErrInvalidValue, "patched resource is invalid: "+err.Error())
+	}
+	return nil
+}
+
+func applyPatch(resource map[string]interface{}, req PatchRequest) error {
+	if len(req.Schemas) > 0 && !containsFold(req.Schemas, SchemaPatchOp) {
+		return invalid(ErrInvalidSyntax, "schemas must contain "+SchemaPatchOp)
+	}
+	if len(req.Operations) == 0 {
+		return invalid(ErrInvalidSyntax, "no operations")
+	}
+	for _, op := range req.Operations {
+		if err := applyOperation(resource, op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// patchPath is a parsed PATCH path: an attribute, optionally narrowed to the
+// elements matching a filter and to a sub-attribute of those.
+type patchPath struct {
+	names  []string
+	filter Filter
+	sub    string
+}
+
+func parsePath(path string) (*patchPath, error) {
+	attr, rest := path, ""
+	if i := strings.IndexByte(path, '['); i >= 0 {
+		attr, rest = path[:i], path[i:]
+	}
+	names, err := splitAttribute(attr)
+	if err != nil {
+		return nil, invalid(ErrInvalidPath, err.Error())
+	}
+	p := &patchPath{names: names}
+	if rest == "" {
+		return p, nil
+	}
+
+	end := strings.LastIndexByte(rest, ']')
+	if end < 0 {
+		return nil, invalid(ErrInvalidPath, fmt.Sprintf("unterminated filter in path %q", path))
+	}
+	if p.filter, err = ParseFilter(rest[1:end]); err != nil {
+		return nil, invalid(ErrInvalidPath, err.Error())
+	}
+	switch sub := rest[end+1:]; {
+	case sub == "":
+	case strings.HasPrefix(sub, ".") && len(sub) > 1 && !strings.ContainsAny(sub[1:], ".[]"):
+		p.sub = sub[1:]
+	default:
+		return nil, invalid(ErrInvalidPath, fmt.Sprintf("invalid path %q", path))
+	}
+	return p, nil
+}
+
+func applyOperation(resource map[string]interface{}, op PatchOperation) error {
+	kind := strings.ToLower(op.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return invalid(ErrInvalidSyntax, fmt.Sprintf("unknown operation %q", op.Op))
+	}
+
+	if op.Path == "" {
+		if kind == "remove" {
+			return invalid(ErrNoTarget, "remove requires a path")
+		}
+		values, ok := op.Value.(map[string]interface{})
+		if !ok {
+			return invalid(ErrInvalidValue, "an operation without a path requires an object value")
+		}
+		for attr, value := range values {
+			// An extension's attributes may be given as an object under its
+			// schema URN.
+			if schema, ok := extensionSchema(attr); ok {
+				if ext, ok := value.(map[string]interface{}); ok {
+					for sub, value := range ext {
+						if err := apply(resource, kind, &patchPath{names: []string{schema, sub}}, value); err != nil {
+							return err
+						}
+					}
+					continue
+				}
+			}
+			path, err := parsePath(attr)
+			if err != nil {
+				return err
+			}
+			if err := apply(resource, kind, path, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path, err := parsePath(op.Path)
+	if err != nil {
+		return err
+	}
+	return apply(resource, kind, path, op.Value)
+}
+
+func extensionSchema(attr string) (string, bool) {
+	for _, schema := range extensionSchemas {
+		if strings.EqualFold(attr, schema) {
+			return schema, true
+		}
+	}
+	return "", false
+}
+
+// apply performs an add, replace or remove at path.
+func apply(resource map[string]interface{}, kind string, path *patchPath, value interface{}) error {
+	parent, err := parentOf(resource, path.names, kind != "remove")
+	if err != nil || parent == nil {
+		return err
+	}
+	key, _ := keyOf(parent, path.names[len(path.names)-1])
+
+	if path.filter != nil {
+		return applyFiltered(parent, key, kind, path, value)
+	}
+
+	switch kind {
+	case "remove":
+		elements, isArray := parent[key].([]interface{})
+		if !isArray || value == nil {
+			delete(parent, key)
+			return nil
+		}
+		// Removing given values from a multi-valued attribute, as clients do
+		// to remove group members
+		remove := map[string]bool{}
+		for _, v := range asSlice(value) {
+			if s, ok := get(asMap(v), "value").(string); ok {
+				remove[strings.ToLower(s)] = true
+			}
+		}
+		var kept []interface{}
+		for _, element := range elements {
+			if s, ok := get(asMap(element), "value").(string); !ok || !remove[strings.ToLower(s)] {
+				kept = append(kept, element)
+			}
+		}
+		parent[key] = kept
+	case "add":
+		if elements, isArray := parent[key].([]interface{}); isArray {
+			parent[key] = append(elements, asSlice(value)...)
+			return nil
+		}
+		parent[key] = value
+	case "replace":
+		parent[key] = value
+	}
+	return nil
+}
+
+// applyFiltered performs an add, replace or remove on the elements of the
+// multi-valued attribute parent[key] that match path's filter.
+func applyFiltered(parent map[string]interface{}, key, kind string, path *patchPath, value interface{}) error {
+	elements, _ := parent[key].([]interface{})
+	var kept []interface{}
+	matched := false
+	for _, element := range elements {
+		m, ok := element.(map[string]interface{})
+		if !ok || !path.filter.Match(m) {
+			kept = append(kept, element)
+			continue
+		}
+		matched = true
+		switch {
+		case kind == "remove" && path.sub == "":
+			continue
+		case kind == "remove":
+			subKey, _ := keyOf(m, path.sub)
+			delete(m, subKey)
+		case path.sub != "":
+			subKey, _ := keyOf(m, path.sub)
+			m[subKey] = value
+		default:
+			for k, v := range asMap(value) {
+				subKey, _ := keyOf(m, k)
+				m[subKey] = v
+			}
+		}
+		kept = append(kept, m)
+	}
+	if matched {
+		parent[key] = kept
+		return nil
+	}
+	if kind == "remove" {
+		return invalid(ErrNoTarget, "no value matches the path filter")
+	}
+
+	// A filter of the form `attr eq "value"` describes the element to add,
+	// so that for example `emails[type eq "work"].value` can set a work
+	// email that does not exist yet.
+	eq, ok := path.filter.(compareFilter)
+	if !ok || eq.operator != "eq" || len(eq.path) != 1 {
+		return invalid(ErrNoTarget, "no value matches the path filter")
+	}
+	element := map[string]interface{}{eq.path[0]: eq.value}
+	if path.sub != "" {
+		element[path.sub] = value
+	} else {
+		for k, v := range asMap(value) {
+			element[k] = v
+		}
+	}
+	parent[key] = append(elements, element)
+	return nil
+}
+
+// parentOf returns the object holding the last of names, creating the
+// objects along the way if create is set and returning nil if not.
+func parentOf(resource map[string]interface{}, names []string, create bool) (map[string]interface{}, error) {
+	parent := resource
+	for _, name := range names[:len(names)-1] {
+		key, ok := keyOf(parent, name)
+		if !ok || parent[key] == nil {
+			if !create {
+				return nil, nil
+			}
+			parent[key] = map[string]interface{}{}
+		}
+		child, ok := parent[key].(map[string]interface{})
+		if !ok {
+			return nil, invalid(ErrInvalidPath, fmt.Sprintf("%s is not a complex attribute", name))
+		}
+		parent = child
+	}
+	return parent, nil
+}
+
+func asSlice(v interface{}) []interface{} {
+	if s, ok := v.([]interface{}); ok {
+		return s
+	}
+	return []interface{}{v}
+}
+
+func asMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
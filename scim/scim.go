@@ -0,0 +1,209 @@
+// Package scim implements the protocol side of SCIM 2.0 (RFC 7643 and RFC
+// 7644): resource representations, filters, PATCH operations, errors and the
+// discovery documents. Mapping resources onto the service's own models is
+// left to the services package.
+package scim
+
+import (
+	"errors"
+	"time"
+
+	"user-service/apperrors"
+)
+
+// MediaType is the media type of SCIM requests and responses.
+const MediaType = "application/scim+json"
+
+// Schema URNs.
+const (
+	SchemaUser           = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaEnterpriseUser = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	SchemaGroup          = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse   = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp        = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError          = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// MaxResults is the largest page a listing returns.
+const MaxResults = 200
+
+// Errors with a SCIM error type (RFC 7644, section 3.12). They are reported
+// wrapped in *apperrors.Error values that describe the problem.
+var (
+	ErrInvalidFilter = apperrors.New(apperrors.Invalid, "invalid filter")
+	ErrInvalidPath   = apperrors.New(apperrors.Invalid, "invalid path")
+	ErrInvalidSyntax = apperrors.New(apperrors.Invalid, "invalid syntax")
+	ErrInvalidValue  = apperrors.New(apperrors.Invalid, "invalid value")
+	ErrNoTarget      = apperrors.New(apperrors.Invalid, "no target")
+	ErrMutability    = apperrors.New(apperrors.Invalid, "attribute is immutable")
+	ErrUniqueness    = apperrors.New(apperrors.Conflict, "value is not unique")
+)
+
+// scimTypes maps each error above to its SCIM error type.
+var scimTypes = []struct {
+	err      error
+	scimType string
+}{
+	{ErrInvalidFilter, "invalidFilter"},
+	{ErrInvalidPath, "invalidPath"},
+	{ErrInvalidSyntax, "invalidSyntax"},
+	{ErrInvalidValue, "invalidValue"},
+	{ErrNoTarget, "noTarget"},
+	{ErrMutability, "mutability"},
+	{ErrUniqueness, "uniqueness"},
+}
+
+// invalid returns an error of kind base described by detail.
+func invalid(base *apperrors.Error, detail string) error {
+	return apperrors.Wrap(base, base.Kind, detail)
+}
+
+// TypeOf returns the SCIM error type of err, or "" if it has none.
+func TypeOf(err error) string {
+	for _, t := range scimTypes {
+		if errors.Is(err, t.err) {
+			return t.scimType
+		}
+	}
+	return ""
+}
+
+// Error is a SCIM error response.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// Meta is the metadata of a resource. Locations are relative to the host.
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+	Version      string     `json:"version,omitempty"`
+}
+
+// Name is the name of a user.
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// MultiValue is an element of a multi-valued attribute such as emails.
+type MultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// Manager refers to the manager of a user in the enterprise extension.
+type Manager struct {
+	Value string `json:"value,omitempty"`
+	Ref   string `json:"$ref,omitempty"`
+}
+
+// EnterpriseUser is the enterprise extension of a user.
+type EnterpriseUser struct {
+	Department string   `json:"department,omitempty"`
+	Manager    *Manager `json:"manager,omitempty"`
+}
+
+// User is a SCIM user resource.
+type User struct {
+	Schemas    []string        `json:"schemas"`
+	ID         string          `json:"id,omitempty"`
+	ExternalID string          `json:"externalId,omitempty"`
+	UserName   string          `json:"userName"`
+	Name       *Name           `json:"name,omitempty"`
+	Emails     []MultiValue    `json:"emails,omitempty"`
+	Active     *bool           `json:"active,omitempty"`
+	Groups     []MultiValue    `json:"groups,omitempty"`
+	Enterprise *EnterpriseUser `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User,omitempty"`
+	Meta       *Meta           `json:"meta,omitempty"`
+}
+
+// Group is a SCIM group resource.
+type Group struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []MultiValue `json:"members,omitempty"`
+	Meta        *Meta        `json:"meta,omitempty"`
+}
+
+// ListResponse is one page of a listing. StartIndex is 1-based.
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// PatchRequest is the body of a PATCH request.
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is a single add, replace or remove operation.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// List filters resources and returns the page of count resources starting at
+// the 1-based startIndex. Counts above MaxResults are reduced to it.
+func List(resources []interface{}, filter string, startIndex, count int) (*ListResponse, error) {
+	if filter != "" {
+		f, err := ParseFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		var matched []interface{}
+		for _, resource := range resources {
+			m, err := toMap(resource)
+			if err != nil {
+				return nil, err
+			}
+			if f.Match(m) {
+				matched = append(matched, resource)
+			}
+		}
+		resources = matched
+	}
+
+	startIndex, count = Bounds(startIndex, count)
+	page := resources[min(startIndex-1, len(resources)):]
+	page = page[:min(count, len(page))]
+	return Page(page, len(resources), startIndex), nil
+}
+
+// Bounds returns startIndex and count as List applies them: startIndex is at
+// least 1, and count between 0 and MaxResults.
+func Bounds(startIndex, count int) (int, int) {
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	return startIndex, max(0, min(count, MaxResults))
+}
+
+// Page returns the response listing page, the resources from the 1-based
+// startIndex on of the total matching a filter, for listings that filter and
+// page resources themselves, within the Bounds of List.
+func Page(page []interface{}, total, startIndex int) *ListResponse {
+	return &ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(page),
+		Resources:    append([]interface{}{}, page...),
+	}
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"context"
+
+	"user-service/auth"
+	"user-service/models"
+	"user-service/repositories"
+)
+
+type APIKeyService struct {
+	Repo repositories.APIKeyStore
+}
+
+func NewAPIKeyService(repo repositories.APIKeyStore) *APIKeyService {
+	return &APIKeyService{Repo: repo}
+}
+
+// CreateAPIKey mints an API key that authenticates as subject. The secret is
+// only returned here; afterwards only its hash is known.
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, subject, name string) (*models.NewAPIKey, error) {
+	secret, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	key := models.APIKey{Name: name, Subject: subject, Prefix: prefix, Hash: hash}
+	if err := s.Repo.CreateAPIKey(ctx, &key); err != nil {
+		return nil, err
+	}
+	return &models.NewAPIKey{APIKey: key, Key: secret}, nil
+}
+
+func (s *APIKeyService) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	return s.Repo.ListAPIKeys(ctx)
+}
+
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, id int) error {
+	return s.Repo.RevokeAPIKey(ctx, id)
+}
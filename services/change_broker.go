@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"user-service/models"
+	"user-service/repositories"
+)
+
+// ChangeBroker fans the change feed out to the clients streaming it. It polls
+// the feed for the changes after the last one it has seen and hands each to
+// every subscriber, so that the store is read once however many clients there
+// are. Subscribers have a buffer of Buffer changes; one that falls further
+// behind is dropped rather than holding up the others, and resumes from the
+// last change it received.
+type ChangeBroker struct {
+	Changes repositories.ChangeStore
+	// BatchSize is the number of changes read at a time.
+	BatchSize int
+	// Buffer is the number of changes a subscriber can fall behind by.
+	Buffer int
+
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+	// last is the sequence number of the last change handed to the
+	// subscribers.
+	last int
+}
+
+func NewChangeBroker(changes repositories.ChangeStore) *ChangeBroker {
+	return &ChangeBroker{
+		Changes:     changes,
+		BatchSize:   100,
+		Buffer:      256,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives the changes polled by a ChangeBroker after it
+// subscribed.
+type Subscription struct {
+	c      chan models.UserChange
+	after  int
+	lagged bool
+}
+
+// After returns the sequence number of the latest change when the
+// subscription started; it receives the changes after it.
+func (s *Subscription) After() int {
+	return s.after
+}
+
+// C returns the channel of changes, which is closed once the subscription is
+// dropped.
+func (s *Subscription) C() <-chan models.UserChange {
+	return s.c
+}
+
+// Lagged reports whether the subscription was dropped because it fell behind.
+// It is only meaningful once C is closed.
+func (s *Subscription) Lagged() bool {
+	return s.lagged
+}
+
+// Subscribe returns a subscription to the changes after the latest one.
+// Changes made before are read from the store instead; see Stream.
+func (b *ChangeBroker) Subscribe(ctx context.Context) (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// The broker does not poll without subscribers, so the first one starts
+	// it from the latest change.
+	if len(b.subscribers) == 0 {
+		latest, err := b.Changes.LatestChange(ctx)
+		if err != nil {
+			return nil, err
+		}
+		b.last = latest
+	}
+	sub := &Subscription{c: make(chan models.UserChange, b.Buffer), after: b.last}
+	b.subscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+// Unsubscribe ends a subscription, closing its channel. Ending one that was
+// dropped does nothing.
+func (b *ChangeBroker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drop(sub)
+}
+
+// drop removes a subscriber. Callers must hold b.mu.
+func (b *ChangeBroker) drop(sub *Subscription) {
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.c)
+	}
+}
+
+// Subscribers returns the number of subscribers.
+func (b *ChangeBroker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// listChanges returns the next batch of changes after the given sequence
+// number from the store, for clients catching up.
+func (b *ChangeBroker) listChanges(ctx context.Context, after int) ([]models.UserChange, error) {
+	return b.Changes.ListChanges(ctx, after, b.BatchSize)
+}
+
+// Poll hands the changes made since the last poll to the subscribers and
+// returns how many there were. Subscribers whose buffer is full are dropped.
+func (b *ChangeBroker) Poll(ctx context.Context) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	polled := 0
+	for len(b.subscribers) > 0 {
+		changes, err := b.Changes.ListChanges(ctx, b.last, b.BatchSize)
+		if err != nil {
+			return polled, err
+		}
+		for _, change := range changes {
+			for sub := range b.subscribers {
+				select {
+				case sub.c <- change:
+				default:
+					sub.lagged = true
+					b.drop(sub)
+				}
+			}
+			b.last = change.Seq
+		}
+		polled += len(changes)
+		if len(changes) < b.BatchSize {
+			break
+		}
+	}
+	return polled, nil
+}
+
+// Run polls every interval until ctx is cancelled, then drops the
+// subscribers.
+func (b *ChangeBroker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			b.mu.Lock()
+			for sub := range b.subscribers {
+				b.drop(sub)
+			}
+			b.mu.Unlock()
+			return
+		case <-ticker.C:
+			if _, err := b.Poll(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to poll user changes: %v", err)
+			}
+		}
+	}
+}
+
+// ChangeKeepAlive is how often Stream sends a keep-alive on a stream with
+// nothing to send, so that proxies do not close it as idle.
+const ChangeKeepAlive = 15 * time.Second
+
+// ChangeStream is where Stream writes the change feed for a client.
+type ChangeStream interface {
+	// Open starts the stream, once the feed can be read.
+	Open() error
+	Send(change models.UserChange) error
+	KeepAlive() error
+}
+
+// Stream writes the changes after the given sequence number to stream until
+// ctx is done or the broker stops: first those in the store, then those the
+// broker polls. A stream that falls behind the broker catches up from the
+// store again. With after -1, it starts with the next change.
+func (b *ChangeBroker) Stream(ctx context.Context, after int, stream ChangeStream) error {
+	keepAlive := time.NewTicker(ChangeKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		// Subscribing first leaves no gap between the changes read from
+		// the store and those the broker sends; changes received twice are
+		// skipped by their sequence number.
+		sub, err := b.Subscribe(ctx)
+		if err != nil {
+			return err
+		}
+		if after < 0 {
+			after = sub.After()
+		}
+		err = stream.Open()
+		if err == nil {
+			err = b.catchUp(ctx, &after, stream)
+		}
+		if err == nil {
+			err = relayChanges(ctx, sub, &after, stream, keepAlive.C)
+		}
+		b.Unsubscribe(sub)
+		if err != nil || !sub.Lagged() {
+			return err
+		}
+	}
+}
+
+// catchUp writes the changes in the store after *after to stream, advancing
+// *after.
+func (b *ChangeBroker) catchUp(ctx context.Context, after *int, stream ChangeStream) error {
+	for {
+		changes, err := b.listChanges(ctx, *after)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			if err := stream.Send(change); err != nil {
+				return err
+			}
+			*after = change.Seq
+		}
+		if len(changes) < b.BatchSize {
+			return nil
+		}
+	}
+}
+
+// relayChanges writes the changes the broker sends to sub to stream,
+// advancing *after, until ctx is done or sub is dropped.
+func relayChanges(ctx context.Context, sub *Subscription, after *int, stream ChangeStream, keepAlive <-chan time.Time) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case change, ok := <-sub.C():
+			if !ok {
+				return nil
+			}
+			if change.Seq <= *after {
+				continue
+			}
+			if err := stream.Send(change); err != nil {
+				return err
+			}
+			*after = change.Seq
+		case <-keepAlive:
+			if err := stream.KeepAlive(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"user-service/models"
+	"user-service/repositories"
+)
+
+type DepartmentService struct {
+	Repo repositories.DepartmentStore
+}
+
+func NewDepartmentService(repo repositories.DepartmentStore) *DepartmentService {
+	return &DepartmentService{Repo: repo}
+}
+
+func (s *DepartmentService) ListDepartments(ctx context.Context) ([]models.Department, error) {
+	return s.Repo.ListDepartments(ctx)
+}
+
+func (s *DepartmentService) GetDepartment(ctx context.Context, code string) (*models.Department, error) {
+	return s.Repo.GetDepartment(ctx, code)
+}
+
+func (s *DepartmentService) CreateDepartment(ctx context.Context, department *models.Department) error {
+	return s.Repo.CreateDepartment(ctx, department)
+}
+
+func (s *DepartmentService) UpdateDepartment(ctx context.Context, department *models.Department) error {
+	return s.Repo.UpdateDepartment(ctx, department)
+}
+
+// DeleteDepartment deletes a department that no user or other department
+// refers to.
+func (s *DepartmentService) DeleteDepartment(ctx context.Context, code string) error {
+	return s.Repo.DeleteDepartment(ctx, code)
+}
+
+// DepartmentMapping maps a free-text department value of existing users onto
+// a department code.
+type DepartmentMapping struct {
+	Value string
+	Code  string
+	// Users is the number of users with Value.
+	Users int
+	// Create is set if no department has Code yet; it is created with Name.
+	Create bool
+	Name   string
+}
+
+// DepartmentCode derives a department code from a free-text department by
+// upper-casing it, dropping punctuation and joining words with hyphens, so
+// that "IT", "it" and "I.T." all become "IT".
+func DepartmentCode(value string) string {
+	var words []string
+	for _, word := range strings.FieldsFunc(value, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '-' || r == '_' || r == '/'
+	}) {
+		word = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToUpper(r)
+			}
+			return -1
+		}, word)
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, "-")
+}
+
+// PlanUserDepartmentMapping works out how the department values of existing
+// users that are not department codes map onto departments: by overrides if
+// they name the value, and otherwise by DepartmentCode. Departments that do
+// not exist yet are named after the most common value mapped onto them.
+func (s *DepartmentService) PlanUserDepartmentMapping(ctx context.Context, overrides map[string]string) ([]DepartmentMapping, error) {
+	counts, err := s.Repo.UserDepartmentCounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	departments, err := s.Repo.ListDepartments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing := map[string]bool{}
+	for _, department := range departments {
+		existing[department.Code] = true
+	}
+
+	var plan []DepartmentMapping
+	var unmappable []string
+	names := map[string]string{}
+	for _, value := range sortedValues(counts) {
+		if existing[value] {
+			continue
+		}
+		code, ok := overrides[value]
+		if !ok {
+			code = DepartmentCode(value)
+		}
+		if code == "" {
+			unmappable = append(unmappable, fmt.Sprintf("%q", value))
+			continue
+		}
+		plan = append(plan, DepartmentMapping{Value: value, Code: code, Users: counts[value], Create: !existing[code]})
+		if name, ok := names[code]; !ok || counts[value] > counts[name] {
+			names[code] = value
+		}
+	}
+	if len(unmappable) > 0 {
+		return nil, fmt.Errorf("no department code can be derived from %s; map them explicitly", strings.Join(unmappable, ", "))
+	}
+	for i := range plan {
+		if plan[i].Create {
+			plan[i].Name = names[plan[i].Code]
+		}
+	}
+	return plan, nil
+}
+
+// MapUserDepartments creates the departments that plan needs and rewrites the
+// users' departments accordingly. It returns the number of users changed.
+func (s *DepartmentService) MapUserDepartments(ctx context.Context, plan []DepartmentMapping) (int, error) {
+	mapping := map[string]string{}
+	created := map[string]bool{}
+	for _, m := range plan {
+		if m.Value != m.Code {
+			mapping[m.Value] = m.Code
+		}
+		if !m.Create || created[m.Code] {
+			continue
+		}
+		if err := s.Repo.CreateDepartment(ctx, &models.Department{Code: m.Code, Name: m.Name}); err != nil {
+			return 0, fmt.Errorf("failed to create department %s: %w", m.Code, err)
+		}
+		created[m.Code] = true
+	}
+	return s.Repo.MapUserDepartments(ctx, mapping)
+}
+
+// sortedValues returns the keys of counts in order.
+func sortedValues(counts map[string]int) []string {
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
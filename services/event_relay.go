@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"user-service/repositories"
+)
+
+// EventRelay delivers the domain events of the outbox to sinks, oldest
+// first. Each sink reads the outbox from a cursor of its own, named by its
+// key in Sinks, so that a sink that fails holds up no other. An event is
+// marked as delivered to a sink only once the sink has accepted it, so every
+// event is delivered at least once: one whose delivery fails, or that cannot
+// be marked after it was delivered, is delivered again later.
+type EventRelay struct {
+	Outbox repositories.OutboxStore
+	Sinks  map[string]EventSink
+	// BatchSize is the number of events read from the outbox at a time.
+	BatchSize int
+	// Retention is how long events delivered to every sink are kept in the
+	// outbox before Relay removes them; 0 keeps them.
+	Retention time.Duration
+}
+
+func NewEventRelay(outbox repositories.OutboxStore, sinks map[string]EventSink) *EventRelay {
+	return &EventRelay{Outbox: outbox, Sinks: sinks, BatchSize: 100}
+}
+
+// Relay delivers the pending events to each sink and returns how many
+// deliveries it made. A sink that fails does not keep the events from the
+// others; the errors of all the sinks that failed are returned together.
+func (r *EventRelay) Relay(ctx context.Context) (int, error) {
+	delivered := 0
+	var errs []error
+	for _, name := range r.names() {
+		n, err := r.relayTo(ctx, name)
+		delivered += n
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := r.prune(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return delivered, errors.Join(errs...)
+}
+
+// relayTo delivers the pending events to the named sink. It stops at the
+// first event the sink fails to accept, recording the failure with the
+// sink's cursor, so that events reach the sink in the order they were
+// written; that event is the first one tried on the next call.
+func (r *EventRelay) relayTo(ctx context.Context, name string) (int, error) {
+	sink := r.Sinks[name]
+	delivered := 0
+	for {
+		events, err := r.Outbox.PendingEvents(ctx, name, r.BatchSize)
+		if err != nil {
+			return delivered, err
+		}
+		for _, event := range events {
+			if err := sink.Publish(ctx, event); err != nil {
+				if markErr := r.Outbox.MarkFailed(ctx, name, event.ID, err.Error()); markErr != nil {
+					return delivered, markErr
+				}
+				return delivered, fmt.Errorf("failed to deliver event %d to %s: %w", event.ID, name, err)
+			}
+			if err := r.Outbox.MarkDelivered(ctx, name, event.ID); err != nil {
+				return delivered, err
+			}
+			delivered++
+		}
+		if len(events) == 0 || len(events) < r.BatchSize {
+			return delivered, nil
+		}
+	}
+}
+
+// prune removes the events delivered to every sink more than Retention ago.
+func (r *EventRelay) prune(ctx context.Context) error {
+	if r.Retention <= 0 {
+		return nil
+	}
+	_, err := r.Outbox.PruneDelivered(ctx, r.names(), time.Now().Add(-r.Retention))
+	return err
+}
+
+func (r *EventRelay) names() []string {
+	names := make([]string, 0, len(r.Sinks))
+	for name := range r.Sinks {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Run relays the events to each sink every interval, each sink on a
+// goroutine of its own so that a slow one delays no other, until ctx is
+// cancelled, which also cancels the deliveries in progress.
+func (r *EventRelay) Run(ctx context.Context, interval time.Duration) {
+	var wg sync.WaitGroup
+	for _, name := range r.names() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			every(ctx, interval, func() {
+				if _, err := r.relayTo(ctx, name); err != nil && ctx.Err() == nil {
+					log.Printf("Failed to relay events: %v", err)
+				}
+			})
+		}()
+	}
+	every(ctx, interval, func() {
+		if err := r.prune(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to prune the outbox: %v", err)
+		}
+	})
+	wg.Wait()
+}
+
+// every calls fn every interval until ctx is cancelled.
+func every(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn()
+		}
+	}
+}
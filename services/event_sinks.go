@@ -0,0 +1,100 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"user-service/models"
+)
+
+// EventSink delivers domain events to downstream systems. Publish returns
+// nil only once the event has been accepted.
+type EventSink interface {
+	Publish(ctx context.Context, event models.Event) error
+}
+
+// FileSink appends events to a file as NDJSON, one JSON object per line. The
+// file is synced after each event, so that an event reported as delivered is
+// not lost in a crash.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+var _ EventSink = (*FileSink)(nil)
+
+// NewFileSink opens, or creates, the file at path for appending.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file}, nil
+}
+
+func (s *FileSink) Publish(ctx context.Context, event models.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// HTTPSink POSTs each event as JSON to URL, with its ID and type in the
+// X-Event-Id and X-Event-Type headers. Any response other than 2xx is a
+// failure.
+type HTTPSink struct {
+	URL    string
+	Client *http.Client
+}
+
+var _ EventSink = (*HTTPSink)(nil)
+
+// NewHTTPSink returns an HTTPSink whose requests give up after timeout.
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{URL: url, Client: &http.Client{Timeout: timeout}}
+}
+
+func (s *HTTPSink) Publish(ctx context.Context, event models.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Id", strconv.Itoa(event.ID))
+	req.Header.Set("X-Event-Type", event.Type)
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// Drain the body so that the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s answered %s", s.URL, res.Status)
+	}
+	return nil
+}
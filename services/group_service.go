@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+
+	"user-service/models"
+	"user-service/repositories"
+)
+
+type GroupService struct {
+	Repo repositories.GroupStore
+}
+
+func NewGroupService(repo repositories.GroupStore) *GroupService {
+	return &GroupService{Repo: repo}
+}
+
+func (s *GroupService) ListGroups(ctx context.Context, limit, offset int) (*models.GroupPage, error) {
+	return s.Repo.ListGroups(ctx, limit, offset)
+}
+
+func (s *GroupService) GetGroup(ctx context.Context, id int) (*models.Group, error) {
+	return s.Repo.GetGroup(ctx, id)
+}
+
+func (s *GroupService) CreateGroup(ctx context.Context, group *models.Group) error {
+	return s.Repo.CreateGroup(ctx, group)
+}
+
+func (s *GroupService) UpdateGroup(ctx context.Context, group *models.Group) error {
+	return s.Repo.UpdateGroup(ctx, group)
+}
+
+// DeleteGroup deletes a group and all of its memberships.
+func (s *GroupService) DeleteGroup(ctx context.Context, id int) error {
+	return s.Repo.DeleteGroup(ctx, id)
+}
+
+// AddMember adds a user to a group. Deleted and terminated users cannot be
+// added.
+func (s *GroupService) AddMember(ctx context.Context, groupID, userID int) error {
+	return s.Repo.AddMember(ctx, groupID, userID)
+}
+
+func (s *GroupService) RemoveMember(ctx context.Context, groupID, userID int) error {
+	return s.Repo.RemoveMember(ctx, groupID, userID)
+}
+
+// ListMembers returns one page of a group's members, ordered by ID.
+func (s *GroupService) ListMembers(ctx context.Context, groupID int, limit, offset int) (*models.UserPage, error) {
+	return s.Repo.ListMembers(ctx, groupID, limit, offset)
+}
+
+// ListUserGroups returns the groups a user belongs to, ordered by name.
+func (s *GroupService) ListUserGroups(ctx context.Context, userID int) ([]models.Group, error) {
+	return s.Repo.ListUserGroups(ctx, userID)
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"user-service/models"
+)
+
+// ReportingChain returns the managers of the user with the given id, from the
+// direct manager upwards.
+func (s *UserService) ReportingChain(ctx context.Context, id int) ([]models.User, error) {
+	return s.Repo.ReportingChain(ctx, id)
+}
+
+// DirectReports returns the users reporting to the user with the given id.
+func (s *UserService) DirectReports(ctx context.Context, id int) ([]models.User, error) {
+	return s.Repo.DirectReports(ctx, id)
+}
+
+// Subtree returns the user with the given id together with their direct and
+// indirect reports.
+func (s *UserService) Subtree(ctx context.Context, id int) (*models.OrgNode, error) {
+	user, err := s.Repo.GetUserByID(ctx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	reports, err := s.Repo.Subtree(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// The user roots the tree even should the data contain a cycle through
+	// them.
+	user.ManagerID = nil
+	return orgTree(append([]models.User{*user}, reports...))[0], nil
+}
+
+// OrgChart returns the whole org chart: every user without a manager, or
+// whose manager has been deleted, together with their reports.
+func (s *UserService) OrgChart(ctx context.Context) ([]*models.OrgNode, error) {
+	users, err := s.Repo.GetAllUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return orgTree(users), nil
+}
+
+// orgTree arranges users under their managers. Users whose manager is not
+// among them become roots. Roots and reports are ordered by ID.
+func orgTree(users []models.User) []*models.OrgNode {
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	nodes := make(map[int]*models.OrgNode, len(users))
+	for _, user := range users {
+		nodes[user.ID] = &models.OrgNode{User: user, Reports: []*models.OrgNode{}}
+	}
+	roots := []*models.OrgNode{}
+	for _, user := range users {
+		node := nodes[user.ID]
+		if user.ManagerID != nil {
+			if manager, ok := nodes[*user.ManagerID]; ok {
+				manager.Reports = append(manager.Reports, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+	return roots
+}
+
+// WriteOrgChartDOT writes the org chart rooted at roots as a Graphviz digraph
+// with an edge from every manager to each of their reports.
+func WriteOrgChartDOT(w io.Writer, roots []*models.OrgNode) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "digraph org {")
+	fmt.Fprintln(out, "\tnode [shape=box];")
+	var write func(node *models.OrgNode)
+	write = func(node *models.OrgNode) {
+		label := dotEscape(node.FirstName+" "+node.LastName) + `\n` + dotEscape(node.UserName)
+		fmt.Fprintf(out, "\tu%d [label=\"%s\"];\n", node.ID, label)
+		for _, report := range node.Reports {
+			fmt.Fprintf(out, "\tu%d -> u%d;\n", node.ID, report.ID)
+			write(report)
+		}
+	}
+	for _, root := range roots {
+		write(root)
+	}
+	fmt.Fprintln(out, "}")
+	return out.Flush()
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// dotEscape escapes s for use inside a double-quoted DOT string.
+func dotEscape(s string) string {
+	return dotEscaper.Replace(s)
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"context"
+
+	"user-service/apperrors"
+	"user-service/models"
+	"user-service/repositories"
+)
+
+type RoleService struct {
+	Repo repositories.RoleStore
+}
+
+func NewRoleService(repo repositories.RoleStore) *RoleService {
+	return &RoleService{Repo: repo}
+}
+
+// ListRoleAssignments returns the role assignments of subject, or of every
+// subject if it is empty.
+func (s *RoleService) ListRoleAssignments(ctx context.Context, subject string) ([]models.RoleAssignment, error) {
+	return s.Repo.ListRoleAssignments(ctx, subject)
+}
+
+// AssignRole grants a role. Editors may only modify users of their own
+// department, so their assignments must name it.
+func (s *RoleService) AssignRole(ctx context.Context, assignment *models.RoleAssignment) error {
+	if assignment.Role == models.RoleEditor && assignment.Department == "" {
+		err := apperrors.New(apperrors.Invalid, "validation failed")
+		err.Fields = []apperrors.FieldError{{Field: "department", Rule: "required", Message: "is required for editors"}}
+		return err
+	}
+	return s.Repo.AssignRole(ctx, assignment)
+}
+
+func (s *RoleService) UnassignRole(ctx context.Context, id int) error {
+	return s.Repo.UnassignRole(ctx, id)
+}
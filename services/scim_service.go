@@ -0,0 +1,557 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"user-service/apperrors"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/scim"
+)
+
+// SCIMService exposes users and groups as SCIM 2.0 resources. Users map onto
+// models.User as follows:
+//
+//	userName                  UserName
+//	name.givenName            FirstName
+//	name.familyName           LastName
+//	emails (the primary one)  Email
+//	active                    Status: true is A; false is I, or T if terminated
+//	enterprise department     Department
+//	enterprise manager.value  ManagerID
+//
+// Status changes follow the transitions of ChangeStatus, without overrides.
+// Attributes without a counterpart, such as externalId, are not stored.
+type SCIMService struct {
+	Users  *UserService
+	Groups *GroupService
+}
+
+func NewSCIMService(users *UserService, groups *GroupService) *SCIMService {
+	return &SCIMService{Users: users, Groups: groups}
+}
+
+// WithUsers returns a SCIMService that reads and writes users through users,
+// typically a UserService scoped to the caller.
+func (s *SCIMService) WithUsers(users *UserService) *SCIMService {
+	scoped := *s
+	scoped.Users = users
+	return &scoped
+}
+
+// scimAttributes maps the JSON names of models.User fields to SCIM
+// attributes, for reporting validation failures.
+var scimAttributes = map[string]string{
+	"user_name":  "userName",
+	"first_name": "name.givenName",
+	"last_name":  "name.familyName",
+	"email":      "emails",
+	"status":     "active",
+	"department": scim.SchemaEnterpriseUser + ":department",
+	"manager_id": scim.SchemaEnterpriseUser + ":manager",
+}
+
+// scimError renames the fields of validation failures to SCIM attributes.
+func scimError(err error) error {
+	var appErr *apperrors.Error
+	if !errors.As(err, &appErr) || len(appErr.Fields) == 0 {
+		return err
+	}
+	renamed := &apperrors.Error{Kind: appErr.Kind, Message: appErr.Message, Err: err}
+	for _, field := range appErr.Fields {
+		if attr, ok := scimAttributes[field.Field]; ok {
+			field.Field = attr
+		}
+		renamed.Fields = append(renamed.Fields, field)
+	}
+	return renamed
+}
+
+// parseID parses a resource id, reporting ids that cannot exist as notFound.
+func parseID(id string, notFound error) (int, error) {
+	n, err := strconv.Atoi(id)
+	if err != nil || n <= 0 {
+		return 0, notFound
+	}
+	return n, nil
+}
+
+// SCIMVersion formats a user version as a SCIM (weak) ETag.
+func SCIMVersion(version int) string {
+	return fmt.Sprintf(`W/"%d"`, version)
+}
+
+func userLocation(id int) string {
+	return fmt.Sprintf("/scim/v2/Users/%d", id)
+}
+
+func groupLocation(id int) string {
+	return fmt.Sprintf("/scim/v2/Groups/%d", id)
+}
+
+// scimUser converts a user to its SCIM representation.
+func scimUser(user *models.User) *scim.User {
+	active := user.Status == models.StatusActive
+	resource := &scim.User{
+		Schemas:  []string{scim.SchemaUser, scim.SchemaEnterpriseUser},
+		ID:       strconv.Itoa(user.ID),
+		UserName: user.UserName,
+		Name: &scim.Name{
+			Formatted:  strings.TrimSpace(user.FirstName + " " + user.LastName),
+			GivenName:  user.FirstName,
+			FamilyName: user.LastName,
+		},
+		Active:     &active,
+		Enterprise: &scim.EnterpriseUser{Department: user.Department},
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Location:     userLocation(user.ID),
+			Version:      SCIMVersion(user.Version),
+		},
+	}
+	if user.Email != "" {
+		resource.Emails = []scim.MultiValue{{Value: user.Email, Type: "work", Primary: true}}
+	}
+	if user.ManagerID != nil {
+		resource.Enterprise.Manager = &scim.Manager{Value: strconv.Itoa(*user.ManagerID), Ref: userLocation(*user.ManagerID)}
+	}
+	return resource
+}
+
+// applySCIMUser sets the fields of user from a SCIM representation. user
+// holds the stored user, or a new one, and keeps the fields the
+// representation leaves out: the status if active is absent, and the
+// department and manager if the enterprise extension is.
+func applySCIMUser(user *models.User, resource *scim.User) error {
+	user.UserName = resource.UserName
+	user.FirstName, user.LastName = "", ""
+	if resource.Name != nil {
+		user.FirstName, user.LastName = resource.Name.GivenName, resource.Name.FamilyName
+	}
+	user.Email = primaryEmail(resource.Emails)
+
+	switch {
+	case resource.Active == nil:
+	case *resource.Active:
+		user.Status = models.StatusActive
+	case user.Status != models.StatusTerminated:
+		user.Status = models.StatusInactive
+	}
+
+	if ext := resource.Enterprise; ext != nil {
+		user.Department = ext.Department
+		user.ManagerID = nil
+		if ext.Manager != nil && ext.Manager.Value != "" {
+			managerID, err := strconv.Atoi(ext.Manager.Value)
+			if err != nil {
+				return repositories.ErrUnknownManager
+			}
+			user.ManagerID = &managerID
+		}
+	}
+	return nil
+}
+
+// primaryEmail returns the primary email, or else the first work email, or
+// else the first email.
+func primaryEmail(emails []scim.MultiValue) string {
+	for _, email := range emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	for _, email := range emails {
+		if strings.EqualFold(email.Type, "work") {
+			return email.Value
+		}
+	}
+	if len(emails) > 0 {
+		return emails[0].Value
+	}
+	return ""
+}
+
+// ListUsers returns the page of users matching filter (see scim.List).
+// Deleted users are left out. Filters on userName or id with eq, which
+// identity providers use to look a user up before every change, are passed
+// on to the store, so that only the users they can match are read. Without
+// a filter, or with nothing but such a comparison, the store pages and counts
+// the users itself.
+func (s *SCIMService) ListUsers(ctx context.Context, filter string, startIndex, count int) (*scim.ListResponse, error) {
+	params := models.UserListParams{Sort: "id"}
+	paged := true
+	if filter != "" {
+		f, err := scim.ParseFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		params.UserName, _ = scim.EqualValue(f, "userName")
+		if id, ok := scim.EqualValue(f, "id"); ok {
+			if params.ID, err = strconv.Atoi(id); err != nil || params.ID <= 0 {
+				return scim.List(nil, filter, startIndex, count)
+			}
+		}
+		_, byUserName := scim.SoleEqualValue(f, "userName")
+		_, byID := scim.SoleEqualValue(f, "id")
+		paged = byUserName || byID
+	}
+	if paged {
+		return s.listUserPage(ctx, params, startIndex, count)
+	}
+
+	var resources []interface{}
+	err := s.Users.StreamUsers(ctx, params, func(user models.User) error {
+		resources = append(resources, scimUser(&user))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return scim.List(resources, filter, startIndex, count)
+}
+
+// listUserPage returns the page of the users matching params that starts at
+// startIndex, as read and counted by the store.
+func (s *SCIMService) listUserPage(ctx context.Context, params models.UserListParams, startIndex, count int) (*scim.ListResponse, error) {
+	startIndex, count = scim.Bounds(startIndex, count)
+	params.Offset = startIndex - 1
+	// A zero limit asks the store for its default page size, so a count of
+	// zero reads one user, which is left out.
+	params.Limit = max(count, 1)
+	page, err := s.Users.ListUsers(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	resources := make([]interface{}, 0, count)
+	for _, user := range page.Users[:min(count, len(page.Users))] {
+		resources = append(resources, scimUser(&user))
+	}
+	return scim.Page(resources, page.Total, startIndex), nil
+}
+
+func (s *SCIMService) GetUser(ctx context.Context, id string) (*scim.User, error) {
+	userID, err := parseID(id, repositories.ErrUserNotFound)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.Users.GetUser(ctx, userID, false)
+	if err != nil {
+		return nil, err
+	}
+	return scimUser(user), nil
+}
+
+// CreateUser creates a user, active unless the resource says otherwise.
+func (s *SCIMService) CreateUser(ctx context.Context, resource *scim.User) (*scim.User, error) {
+	user := &models.User{Status: models.StatusActive}
+	if err := applySCIMUser(user, resource); err != nil {
+		return nil, err
+	}
+	if err := s.Users.CreateUser(ctx, user); err != nil {
+		return nil, scimError(err)
+	}
+	return scimUser(user), nil
+}
+
+// ReplaceUser overwrites the user with the given id, provided its version
+// matches (see repositories.UserStore).
+func (s *SCIMService) ReplaceUser(ctx context.Context, id string, version int, resource *scim.User) (*scim.User, error) {
+	userID, err := parseID(id, repositories.ErrUserNotFound)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.Users.GetUser(ctx, userID, false)
+	if err != nil {
+		return nil, err
+	}
+	return s.updateUser(ctx, user, version, resource)
+}
+
+// PatchUser applies a SCIM PATCH request to the user with the given id,
+// provided its version matches (see repositories.UserStore).
+func (s *SCIMService) PatchUser(ctx context.Context, id string, version int, req scim.PatchRequest) (*scim.User, error) {
+	userID, err := parseID(id, repositories.ErrUserNotFound)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.Users.GetUser(ctx, userID, false)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := scim.PatchUser(scimUser(user), req)
+	if err != nil {
+		return nil, err
+	}
+	return s.updateUser(ctx, user, version, patched)
+}
+
+func (s *SCIMService) updateUser(ctx context.Context, user *models.User, version int, resource *scim.User) (*scim.User, error) {
+	if version != 0 && version != user.Version {
+		return nil, repositories.ErrVersionConflict
+	}
+	if err := applySCIMUser(user, resource); err != nil {
+		return nil, err
+	}
+	user.Version = version
+	if err := s.Users.UpdateUser(ctx, user); err != nil {
+		return nil, scimError(err)
+	}
+	return scimUser(user), nil
+}
+
+// DeleteUser soft deletes the user with the given id, provided its version
+// matches (see repositories.UserStore).
+func (s *SCIMService) DeleteUser(ctx context.Context, id string, version int) error {
+	userID, err := parseID(id, repositories.ErrUserNotFound)
+	if err != nil {
+		return err
+	}
+	return s.Users.DeleteUser(ctx, userID, version)
+}
+
+// scimGroup converts a group and its members to the SCIM representation.
+func scimGroup(group *models.Group, members []models.User) *scim.Group {
+	created, modified := group.CreatedAt, group.UpdatedAt
+	resource := &scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          strconv.Itoa(group.ID),
+		DisplayName: group.Name,
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      &created,
+			LastModified: &modified,
+			Location:     groupLocation(group.ID),
+		},
+	}
+	setMembers(resource, members)
+	return resource
+}
+
+// setMembers sets the members of a SCIM group.
+func setMembers(resource *scim.Group, members []models.User) {
+	resource.Members = nil
+	for _, member := range members {
+		resource.Members = append(resource.Members, scim.MultiValue{
+			Value:   strconv.Itoa(member.ID),
+			Display: member.UserName,
+			Ref:     userLocation(member.ID),
+		})
+	}
+}
+
+// members returns every member of a group.
+func (s *SCIMService) members(ctx context.Context, groupID int) ([]models.User, error) {
+	var members []models.User
+	for {
+		page, err := s.Groups.ListMembers(ctx, groupID, repositories.MaxPageSize, len(members))
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, page.Users...)
+		if len(page.Users) == 0 || len(members) >= page.Total {
+			return members, nil
+		}
+	}
+}
+
+func (s *SCIMService) group(ctx context.Context, group *models.Group) (*scim.Group, error) {
+	members, err := s.members(ctx, group.ID)
+	if err != nil {
+		return nil, err
+	}
+	return scimGroup(group, members), nil
+}
+
+// ListGroups returns the page of groups matching filter (see scim.List).
+// Members are read only for the groups on the page, unless the filter
+// refers to them.
+func (s *SCIMService) ListGroups(ctx context.Context, filter string, startIndex, count int) (*scim.ListResponse, error) {
+	withMembers := false
+	if filter != "" {
+		f, err := scim.ParseFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		withMembers = scim.Refers(f, "members")
+	}
+
+	var resources []interface{}
+	for {
+		page, err := s.Groups.ListGroups(ctx, repositories.MaxPageSize, len(resources))
+		if err != nil {
+			return nil, err
+		}
+		for i := range page.Groups {
+			resource := scimGroup(&page.Groups[i], nil)
+			if withMembers {
+				if resource, err = s.group(ctx, &page.Groups[i]); err != nil {
+					return nil, err
+				}
+			}
+			resources = append(resources, resource)
+		}
+		if len(page.Groups) == 0 || len(resources) >= page.Total {
+			break
+		}
+	}
+
+	list, err := scim.List(resources, filter, startIndex, count)
+	if err != nil || withMembers {
+		return list, err
+	}
+	for _, resource := range list.Resources {
+		group := resource.(*scim.Group)
+		groupID, _ := strconv.Atoi(group.ID)
+		members, err := s.members(ctx, groupID)
+		if err != nil {
+			return nil, err
+		}
+		setMembers(group, members)
+	}
+	return list, nil
+}
+
+func (s *SCIMService) GetGroup(ctx context.Context, id string) (*scim.Group, error) {
+	groupID, err := parseID(id, repositories.ErrGroupNotFound)
+	if err != nil {
+		return nil, err
+	}
+	group, err := s.Groups.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	return s.group(ctx, group)
+}
+
+// CreateGroup creates a group with the resource's members.
+func (s *SCIMService) CreateGroup(ctx context.Context, resource *scim.Group) (*scim.Group, error) {
+	return s.writeGroup(ctx, func(s *SCIMService) (*scim.Group, error) {
+		group := &models.Group{Name: resource.DisplayName}
+		if err := s.Groups.CreateGroup(ctx, group); err != nil {
+			return nil, err
+		}
+		return s.updateGroup(ctx, group, resource)
+	})
+}
+
+// ReplaceGroup overwrites the name and members of the group with the given
+// id.
+func (s *SCIMService) ReplaceGroup(ctx context.Context, id string, resource *scim.Group) (*scim.Group, error) {
+	groupID, err := parseID(id, repositories.ErrGroupNotFound)
+	if err != nil {
+		return nil, err
+	}
+	return s.writeGroup(ctx, func(s *SCIMService) (*scim.Group, error) {
+		group, err := s.Groups.GetGroup(ctx, groupID)
+		if err != nil {
+			return nil, err
+		}
+		return s.updateGroup(ctx, group, resource)
+	})
+}
+
+// PatchGroup applies a SCIM PATCH request to the group with the given id.
+func (s *SCIMService) PatchGroup(ctx context.Context, id string, req scim.PatchRequest) (*scim.Group, error) {
+	return s.writeGroup(ctx, func(s *SCIMService) (*scim.Group, error) {
+		current, err := s.GetGroup(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		patched, err := scim.PatchGroup(current, req)
+		if err != nil {
+			return nil, err
+		}
+		groupID, _ := strconv.Atoi(current.ID)
+		group, err := s.Groups.GetGroup(ctx, groupID)
+		if err != nil {
+			return nil, err
+		}
+		return s.updateGroup(ctx, group, patched)
+	})
+}
+
+// writeGroup runs write, which returns the group it wrote, with the users and
+// groups bound to a single transaction of the users' UnitOfWork, so that a
+// group is renamed and its members changed together or not at all.
+func (s *SCIMService) writeGroup(ctx context.Context, write func(s *SCIMService) (*scim.Group, error)) (*scim.Group, error) {
+	if s.Users.UnitOfWork == nil {
+		return write(s)
+	}
+	var group *scim.Group
+	err := s.Users.UnitOfWork.WithTx(ctx, func(tx repositories.Repos) error {
+		groups := *s.Groups
+		groups.Repo = tx.Groups
+		var err error
+		group, err = write(&SCIMService{Users: s.Users.bound(tx), Groups: &groups})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// updateGroup renames group as the resource says and adds and removes
+// members to match it.
+func (s *SCIMService) updateGroup(ctx context.Context, group *models.Group, resource *scim.Group) (*scim.Group, error) {
+	if resource.DisplayName != group.Name {
+		group.Name = resource.DisplayName
+		if err := s.Groups.UpdateGroup(ctx, group); err != nil {
+			return nil, err
+		}
+	}
+
+	wanted := map[int]bool{}
+	for _, member := range resource.Members {
+		userID, err := strconv.Atoi(member.Value)
+		if err != nil {
+			return nil, apperrors.Wrap(scim.ErrInvalidValue, apperrors.Invalid, fmt.Sprintf("member %q is not a user id", member.Value))
+		}
+		wanted[userID] = true
+	}
+	members, err := s.members(ctx, group.ID)
+	if err != nil {
+		return nil, err
+	}
+	current := map[int]bool{}
+	for _, member := range members {
+		current[member.ID] = true
+		if !wanted[member.ID] {
+			if err := s.Groups.RemoveMember(ctx, group.ID, member.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, member := range resource.Members {
+		userID, _ := strconv.Atoi(member.Value)
+		if !current[userID] {
+			err := s.Groups.AddMember(ctx, group.ID, userID)
+			if errors.Is(err, repositories.ErrUserNotFound) {
+				return nil, apperrors.Wrap(scim.ErrInvalidValue, apperrors.Invalid, fmt.Sprintf("member %q is not a user", member.Value))
+			}
+			if err != nil {
+				return nil, err
+			}
+			current[userID] = true
+		}
+	}
+
+	updated, err := s.Groups.GetGroup(ctx, group.ID)
+	if err != nil {
+		return nil, err
+	}
+	return s.group(ctx, updated)
+}
+
+// DeleteGroup deletes the group with the given id.
+func (s *SCIMService) DeleteGroup(ctx context.Context, id string) error {
+	groupID, err := parseID(id, repositories.ErrGroupNotFound)
+	if err != nil {
+		return err
+	}
+	return s.Groups.DeleteGroup(ctx, groupID)
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+
+	"user-service/models"
+)
+
+// WithAudit returns a UserService whose writes are attributed to ac in the
+// audit log.
+func (s *UserService) WithAudit(ac models.AuditContext) *UserService {
+	scoped := *s
+	scoped.Repo = s.Repo.WithAudit(ac)
+	scoped.audit = ac
+	return &scoped
+}
+
+// ListAuditEvents returns one page of the audit log, newest first.
+func (s *UserService) ListAuditEvents(ctx context.Context, params models.AuditListParams) (*models.AuditPage, error) {
+	return s.Repo.ListAuditEvents(ctx, params)
+}
+
+// UserHistory returns one page of the audit events of a user, newest first.
+// It returns ErrUserNotFound for users that never existed.
+func (s *UserService) UserHistory(ctx context.Context, id int, limit, offset int) (*models.AuditPage, error) {
+	page, err := s.Repo.ListAuditEvents(ctx, models.AuditListParams{UserID: id, Limit: limit, Offset: offset})
+	if err != nil {
+		return nil, err
+	}
+	if page.Total == 0 {
+		if _, err := s.Repo.GetUserByID(ctx, id, true); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/models"
+)
+
+// XLSXType is the media type of the workbooks written by ExportUsers.
+const XLSXType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// ExportTypes maps each export format to the media type of its output.
+var ExportTypes = map[string]string{
+	models.ExportCSV:    CSVType,
+	models.ExportNDJSON: NDJSONType,
+	models.ExportXLSX:   XLSXType,
+}
+
+var ErrInvalidExport = apperrors.New(apperrors.Invalid, "invalid export")
+
+// exportColumns are the user fields, by JSON name, that an export can hold,
+// in their default order.
+var exportColumns = []string{"id", "user_name", "email", "first_name", "last_name", "status", "department", "manager_id", "version", "deleted_at"}
+
+// exportWriter writes the rows of an export in one format. Values are
+// strings, ints or nil for empty ones.
+type exportWriter interface {
+	writeRow(values []interface{}) error
+	close() error
+}
+
+// ExportUsers writes the users selected by opts.Filter to w in opts.Format,
+// one row per user. Users are written as the store reads them, so that the
+// memory used does not grow with their number. CSV exports and workbooks
+// start with a header row naming the columns.
+func (s *UserService) ExportUsers(ctx context.Context, w io.Writer, opts models.ExportOptions) error {
+	if opts.Format == "" {
+		opts.Format = models.ExportCSV
+	}
+	if _, ok := ExportTypes[opts.Format]; !ok {
+		return apperrors.Wrap(ErrInvalidExport, apperrors.Invalid, fmt.Sprintf("invalid format %q", opts.Format))
+	}
+	columns := opts.Columns
+	if len(columns) == 0 {
+		columns = exportColumns
+	}
+	for i, column := range columns {
+		if !contains(exportColumns, column) {
+			return apperrors.Wrap(ErrInvalidExport, apperrors.Invalid, fmt.Sprintf("unknown column %q", column))
+		}
+		if contains(columns[:i], column) {
+			return apperrors.Wrap(ErrInvalidExport, apperrors.Invalid, fmt.Sprintf("column %q is given more than once", column))
+		}
+	}
+
+	var out exportWriter
+	switch opts.Format {
+	case models.ExportCSV:
+		out = &csvExport{csv.NewWriter(w)}
+	case models.ExportNDJSON:
+		out = &ndjsonExport{out: bufio.NewWriter(w), columns: columns}
+	case models.ExportXLSX:
+		xlsx, err := newXLSXWriter(w)
+		if err != nil {
+			return err
+		}
+		out = xlsx
+	}
+	if opts.Format != models.ExportNDJSON {
+		header := make([]interface{}, len(columns))
+		for i, column := range columns {
+			header[i] = column
+		}
+		if err := out.writeRow(header); err != nil {
+			return err
+		}
+	}
+
+	values := make([]interface{}, len(columns))
+	err := s.Repo.StreamUsers(ctx, opts.Filter, func(user models.User) error {
+		// The store reads users a page at a time, so a page may still be
+		// written once ctx is done.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for i, column := range columns {
+			values[i] = exportValue(&user, column)
+		}
+		return out.writeRow(values)
+	})
+	if err != nil {
+		return err
+	}
+	return out.close()
+}
+
+// exportValue returns the value of a column of user.
+func exportValue(user *models.User, column string) interface{} {
+	switch column {
+	case "id":
+		return user.ID
+	case "user_name":
+		return user.UserName
+	case "email":
+		return user.Email
+	case "first_name":
+		return user.FirstName
+	case "last_name":
+		return user.LastName
+	case "status":
+		return user.Status
+	case "department":
+		return user.Department
+	case "manager_id":
+		if user.ManagerID != nil {
+			return *user.ManagerID
+		}
+	case "version":
+		return user.Version
+	case "deleted_at":
+		if user.DeletedAt != nil {
+			return user.DeletedAt.UTC().Format(time.RFC3339)
+		}
+	}
+	return nil
+}
+
+// spreadsheetText returns value as a spreadsheet shows it verbatim: text that
+// starts like a formula is prefixed with a quote, so that opening an export
+// does not evaluate what users put in their names.
+func spreadsheetText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+type csvExport struct {
+	out *csv.Writer
+}
+
+func (e *csvExport) writeRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		switch value := value.(type) {
+		case string:
+			record[i] = spreadsheetText(value)
+		case int:
+			record[i] = strconv.Itoa(value)
+		}
+	}
+	return e.out.Write(record)
+}
+
+func (e *csvExport) close() error {
+	e.out.Flush()
+	return e.out.Error()
+}
+
+// ndjsonExport writes each row as a JSON object with a member per column, in
+// the order of the columns.
+type ndjsonExport struct {
+	out     *bufio.Writer
+	columns []string
+}
+
+func (e *ndjsonExport) writeRow(values []interface{}) error {
+	e.out.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			e.out.WriteByte(',')
+		}
+		name, _ := json.Marshal(e.columns[i])
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		e.out.Write(name)
+		e.out.WriteByte(':')
+		e.out.Write(data)
+	}
+	_, err := e.out.WriteString("}\n")
+	return err
+}
+
+func (e *ndjsonExport) close() error {
+	return e.out.Flush()
+}
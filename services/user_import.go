@@ -0,0 +1,472 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"user-service/apperrors"
+	"user-service/models"
+	"user-service/repositories"
+)
+
+// Media types accepted by ImportUsers.
+const (
+	CSVType    = "text/csv"
+	NDJSONType = "application/x-ndjson"
+)
+
+var (
+	ErrUnsupportedImportType = apperrors.New(apperrors.UnsupportedMediaType, "Content-Type must be "+CSVType+" or "+NDJSONType)
+	ErrInvalidImport         = apperrors.New(apperrors.Invalid, "invalid import")
+)
+
+// importFields are the user fields, by JSON name, that an import can set.
+var importFields = []string{"user_name", "email", "first_name", "last_name", "status", "department", "manager_id"}
+
+// importRecord is a row of an import, with its values by user field.
+type importRecord struct {
+	line   int
+	values map[string]string
+	err    error
+}
+
+// importPlan is what an import does with one row or deleted user.
+type importPlan struct {
+	row   models.ImportRow
+	write *repositories.UserWrite
+	// changesStatus is set for the updates that change a user's status,
+	// which a pending transition of the user fails.
+	changesStatus bool
+}
+
+// ImportUsers creates, updates and deletes users as a CSV or NDJSON import
+// asks (see models.ImportOptions) and reports what it did with each row.
+// Every row is validated, and checked against the caller's permissions and
+// the allowed status transitions, before anything is written; rows that fail
+// are reported and left out, as are status changes of users with a pending
+// transition, also when found as they are written. The remaining writes are applied in batches of
+// opts.BatchSize per transaction, defaulting to ImportBatchSize. Should a
+// batch fail as a whole, the import stops and the error is returned with the
+// report of the batches committed before, in which the writes left undone
+// are models.ImportNotApplied.
+//
+// A CSV import starts with a header naming its columns, and each line of an
+// NDJSON import is an object with one member per column. Empty values clear
+// a field, except that users created without a status are active. Updates
+// only change the fields given and are skipped if they change nothing.
+func (s *UserService) ImportUsers(ctx context.Context, r io.Reader, mediaType string, opts models.ImportOptions) (*models.ImportReport, error) {
+	switch opts.Mode {
+	case "":
+		opts.Mode = models.ImportCreate
+	case models.ImportCreate, models.ImportUpsert, models.ImportReplace:
+	default:
+		return nil, apperrors.Wrap(ErrInvalidImport, apperrors.Invalid, fmt.Sprintf("invalid mode %q", opts.Mode))
+	}
+	for column, field := range opts.Mapping {
+		if !contains(importFields, field) {
+			return nil, apperrors.Wrap(ErrInvalidImport, apperrors.Invalid, fmt.Sprintf("column %q is mapped to unknown field %q", column, field))
+		}
+	}
+
+	var records []importRecord
+	var err error
+	switch mediaType {
+	case CSVType:
+		records, err = readCSV(r, opts.Mapping)
+	case NDJSONType:
+		records, err = readNDJSON(r, opts.Mapping)
+	default:
+		return nil, ErrUnsupportedImportType
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plans, err := s.planImport(ctx, records, opts.Mode)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.DryRun {
+		err = s.applyImport(ctx, plans, opts.BatchSize)
+	}
+
+	report := &models.ImportReport{Mode: opts.Mode, DryRun: opts.DryRun, Rows: make([]models.ImportRow, len(plans))}
+	for i, plan := range plans {
+		report.Rows[i] = plan.row
+		switch plan.row.Result {
+		case models.ImportCreated:
+			report.Created++
+		case models.ImportUpdated:
+			report.Updated++
+		case models.ImportSkipped:
+			report.Skipped++
+		case models.ImportDeleted:
+			report.Deleted++
+		case models.ImportError:
+			report.Errored++
+		case models.ImportNotApplied:
+			report.NotApplied++
+		}
+	}
+	return report, err
+}
+
+// importField returns the user field a column maps to.
+func importField(column string, mapping map[string]string) (string, error) {
+	field, ok := mapping[column]
+	if !ok {
+		field = column
+	}
+	if !contains(importFields, field) {
+		return "", fmt.Errorf("unknown column %q", column)
+	}
+	return field, nil
+}
+
+func readCSV(r io.Reader, mapping map[string]string) ([]importRecord, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, apperrors.Wrap(ErrInvalidImport, apperrors.Invalid, "the import is empty")
+	}
+	if err != nil {
+		return nil, apperrors.Wrap(ErrInvalidImport, apperrors.Invalid, err.Error())
+	}
+
+	fields := make([]string, len(header))
+	for i, column := range header {
+		// Spreadsheets often start CSV files with a byte order mark
+		column = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		field, err := importField(column, mapping)
+		if err != nil {
+			return nil, apperrors.Wrap(ErrInvalidImport, apperrors.Invalid, err.Error())
+		}
+		if contains(fields[:i], field) {
+			return nil, apperrors.Wrap(ErrInvalidImport, apperrors.Invalid, fmt.Sprintf("more than one column sets %s", field))
+		}
+		fields[i] = field
+	}
+	if !contains(fields, "user_name") {
+		return nil, apperrors.Wrap(ErrInvalidImport, apperrors.Invalid, "the import has no user_name column")
+	}
+
+	var records []importRecord
+	for {
+		values, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		line, _ := reader.FieldPos(0)
+		record := importRecord{line: line, values: map[string]string{}}
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr) && errors.Is(err, csv.ErrFieldCount):
+			record.err = fmt.Errorf("expected %d values, got %d", len(fields), len(values))
+		case err != nil:
+			return nil, apperrors.Wrap(ErrInvalidImport, apperrors.Invalid, err.Error())
+		default:
+			for i, value := range values {
+				record.values[fields[i]] = strings.TrimSpace(value)
+			}
+		}
+		records = append(records, record)
+	}
+}
+
+func readNDJSON(r io.Reader, mapping map[string]string) ([]importRecord, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var records []importRecord
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		record := importRecord{line: line, values: map[string]string{}}
+		record.err = parseNDJSONRow(text, mapping, record.values)
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, apperrors.Wrap(ErrInvalidImport, apperrors.Invalid, err.Error())
+	}
+	if len(records) == 0 {
+		return nil, apperrors.Wrap(ErrInvalidImport, apperrors.Invalid, "the import is empty")
+	}
+	return records, nil
+}
+
+// parseNDJSONRow reads one line of an NDJSON import into values.
+func parseNDJSONRow(text string, mapping map[string]string, values map[string]string) error {
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return errors.New("the line is not a JSON object")
+	}
+	for column, value := range object {
+		field, err := importField(column, mapping)
+		if err != nil {
+			return err
+		}
+		if _, ok := values[field]; ok {
+			return fmt.Errorf("more than one member sets %s", field)
+		}
+		switch value := value.(type) {
+		case nil:
+			values[field] = ""
+		case string:
+			values[field] = strings.TrimSpace(value)
+		case json.Number:
+			values[field] = value.String()
+		default:
+			return fmt.Errorf("%s must be a string or a number", column)
+		}
+	}
+	return nil
+}
+
+// planImport decides what to do with each record, and with the users missing
+// from the import in replace mode.
+func (s *UserService) planImport(ctx context.Context, records []importRecord, mode string) ([]importPlan, error) {
+	existing, err := s.Repo.GetAllUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].ID < existing[j].ID })
+	byName := make(map[string]*models.User, len(existing))
+	for i := range existing {
+		byName[existing[i].UserName] = &existing[i]
+	}
+
+	plans := make([]importPlan, 0, len(records))
+	lines := map[string]int{}
+	for _, record := range records {
+		userName := record.values["user_name"]
+		plan := importPlan{row: models.ImportRow{Line: record.line, UserName: userName}}
+		line, duplicate := lines[userName]
+		switch {
+		case record.err != nil:
+			importFailed(&plan.row, record.err)
+		case duplicate && userName != "":
+			importFailed(&plan.row, fmt.Errorf("user_name %s is also on line %d", userName, line))
+		default:
+			lines[userName] = record.line
+			if err := s.planRow(ctx, &plan, record.values, byName[userName], mode); err != nil {
+				if apperrors.KindOf(err) == apperrors.Internal {
+					return nil, err
+				}
+				importFailed(&plan.row, err)
+			}
+		}
+		plans = append(plans, plan)
+	}
+
+	if mode == models.ImportReplace {
+		for i := range existing {
+			user := existing[i]
+			if _, ok := lines[user.UserName]; ok || s.check(&user) != nil {
+				continue
+			}
+			plans = append(plans, importPlan{
+				row:   models.ImportRow{UserName: user.UserName, UserID: user.ID, Result: models.ImportDeleted},
+				write: &repositories.UserWrite{User: &user, Delete: true},
+			})
+		}
+	}
+	return plans, nil
+}
+
+// planRow plans the creation or update of a user from the values of a row.
+// stored is the existing user with the row's user name, if any.
+func (s *UserService) planRow(ctx context.Context, plan *importPlan, values map[string]string, stored *models.User, mode string) error {
+	user := models.User{Status: models.StatusActive}
+	if stored != nil {
+		if mode == models.ImportCreate {
+			plan.row.UserID, plan.row.Result = stored.ID, models.ImportSkipped
+			return nil
+		}
+		user = *stored
+	}
+	if err := setImportValues(&user, values, stored == nil); err != nil {
+		return err
+	}
+	if err := repositories.ValidateUser(&user); err != nil {
+		return err
+	}
+
+	if stored == nil {
+		if err := s.check(&user); err != nil {
+			return err
+		}
+		if err := s.checkDepartment(ctx, &user); err != nil {
+			return err
+		}
+		plan.row.Result = models.ImportCreated
+		plan.write = &repositories.UserWrite{User: &user}
+		return nil
+	}
+
+	plan.row.UserID = stored.ID
+	fields := changedFields(stored, &user)
+	if len(fields) == 0 {
+		plan.row.Result = models.ImportSkipped
+		return nil
+	}
+	if err := s.check(stored, &user); err != nil {
+		return err
+	}
+	if err := checkTransition(stored.Status, user.Status, false, ""); err != nil {
+		return err
+	}
+	if contains(fields, "status") {
+		// Checked again as the write is applied; checked here too for dry
+		// runs to report it.
+		if err := s.checkNoPendingTransition(ctx, stored.ID); err != nil {
+			return err
+		}
+	}
+	if contains(fields, "department") {
+		if err := s.checkDepartment(ctx, &user); err != nil {
+			return err
+		}
+	}
+	plan.row.Result = models.ImportUpdated
+	plan.write = &repositories.UserWrite{User: &user}
+	plan.changesStatus = contains(fields, "status")
+	return nil
+}
+
+// setImportValues sets the fields of user from the values of a row. An empty
+// status leaves that of a new user alone.
+func setImportValues(user *models.User, values map[string]string, created bool) error {
+	for field, value := range values {
+		switch field {
+		case "user_name":
+			user.UserName = value
+		case "email":
+			user.Email = value
+		case "first_name":
+			user.FirstName = value
+		case "last_name":
+			user.LastName = value
+		case "status":
+			if value != "" || !created {
+				user.Status = value
+			}
+		case "department":
+			user.Department = value
+		case "manager_id":
+			user.ManagerID = nil
+			if value == "" {
+				continue
+			}
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				e := apperrors.New(apperrors.Invalid, "validation failed")
+				e.Fields = []apperrors.FieldError{{Field: "manager_id", Rule: "numeric", Message: "must be a user ID"}}
+				return e
+			}
+			user.ManagerID = &id
+		}
+	}
+	return nil
+}
+
+// applyImport applies the planned writes in batches of batchSize, recording
+// the writes that fail in their rows. If a batch fails as a whole, the rows
+// of its writes and of those of later batches are marked as not applied.
+func (s *UserService) applyImport(ctx context.Context, plans []importPlan, batchSize int) error {
+	var pending []*importPlan
+	for i := range plans {
+		if plans[i].write != nil {
+			pending = append(pending, &plans[i])
+		}
+	}
+	if batchSize <= 0 {
+		batchSize = s.ImportBatchSize
+	}
+	if batchSize <= 0 {
+		batchSize = len(pending)
+	}
+
+	for len(pending) > 0 {
+		batch := pending[:min(batchSize, len(pending))]
+		pending = pending[len(batch):]
+
+		err := s.inTx(ctx, func(s *UserService) error {
+			return s.writeImportBatch(ctx, batch)
+		})
+		if err != nil {
+			notApplied(batch)
+			notApplied(pending)
+			return err
+		}
+	}
+	return nil
+}
+
+// notApplied marks the rows of plans that have not failed on their own as
+// not applied.
+func notApplied(plans []*importPlan) {
+	for _, plan := range plans {
+		if plan.row.Result != models.ImportError {
+			plan.row.Result = models.ImportNotApplied
+		}
+	}
+}
+
+// writeImportBatch writes a batch of planned writes, within the unit of work
+// of s if it has one. Status changes of users with a pending transition are
+// recorded as failed and left out.
+func (s *UserService) writeImportBatch(ctx context.Context, batch []*importPlan) error {
+	writes := make([]repositories.UserWrite, 0, len(batch))
+	written := make([]*importPlan, 0, len(batch))
+	for _, plan := range batch {
+		if plan.changesStatus {
+			err := s.checkNoPendingTransition(ctx, plan.write.User.ID)
+			if errors.Is(err, repositories.ErrTransitionPending) {
+				importFailed(&plan.row, err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		writes = append(writes, *plan.write)
+		written = append(written, plan)
+	}
+	errs, err := s.Repo.WriteUsers(ctx, writes)
+	if err != nil {
+		return err
+	}
+	for i, plan := range written {
+		if errs[i] != nil {
+			importFailed(&plan.row, errs[i])
+			continue
+		}
+		plan.row.UserID = plan.write.User.ID
+	}
+	return nil
+}
+
+// importFailed marks a row as failed with err, describing it as it would be
+// to clients.
+func importFailed(row *models.ImportRow, err error) {
+	row.Result = models.ImportError
+	row.Error = err.Error()
+	var appErr *apperrors.Error
+	if errors.As(err, &appErr) {
+		if err == error(appErr) {
+			row.Error = appErr.Message
+		}
+		row.Errors = appErr.Fields
+	}
+}
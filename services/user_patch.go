@@ -0,0 +1,147 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"user-service/apperrors"
+	"user-service/models"
+	"user-service/repositories"
+)
+
+// Media types accepted by PatchUser.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+var (
+	ErrUnsupportedPatchType = apperrors.New(apperrors.UnsupportedMediaType, "Content-Type must be "+MergePatchType+" or "+JSONPatchType)
+	ErrInvalidPatch         = apperrors.New(apperrors.Invalid, "invalid patch")
+	ErrPatchTestFailed      = apperrors.New(apperrors.Conflict, "patch test operation failed")
+)
+
+// PatchUser applies an RFC 7396 JSON Merge Patch or RFC 6902 JSON Patch
+// document to the stored user and writes back only the fields it changed.
+// A non-zero version must match the stored version. The write is conditional
+// on the version the patch was applied to, so concurrent changes are never
+// overwritten. Like UpdateUser, it does not change the status of a user with
+// a pending transition.
+func (s *UserService) PatchUser(ctx context.Context, id int, version int, mediaType string, patch []byte) (*models.User, error) {
+	current, err := s.Repo.GetUserByID(ctx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && version != current.Version {
+		return nil, repositories.ErrVersionConflict
+	}
+	if err := s.check(current); err != nil {
+		return nil, err
+	}
+
+	patched, err := applyPatch(current, mediaType, patch)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.check(patched); err != nil {
+		return nil, err
+	}
+	if err := checkTransition(current.Status, patched.Status, false, ""); err != nil {
+		return nil, err
+	}
+
+	fields := changedFields(current, patched)
+	if len(fields) == 0 {
+		return current, nil
+	}
+	if patched.Department != current.Department {
+		if err := s.checkDepartment(ctx, patched); err != nil {
+			return nil, err
+		}
+	}
+	err = s.inTx(ctx, func(s *UserService) error {
+		if contains(fields, "status") {
+			if err := s.checkNoPendingTransition(ctx, id); err != nil {
+				return err
+			}
+		}
+		return s.Repo.PatchUser(ctx, patched, fields)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return patched, nil
+}
+
+func applyPatch(user *models.User, mediaType string, patch []byte) (*models.User, error) {
+	original, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []byte
+	switch mediaType {
+	case MergePatchType:
+		result, err = jsonpatch.MergePatch(original, patch)
+	case JSONPatchType:
+		var ops jsonpatch.Patch
+		if ops, err = jsonpatch.DecodePatch(patch); err == nil {
+			result, err = ops.Apply(original)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedPatchType, mediaType)
+	}
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		return nil, fmt.Errorf("%w: %v", ErrPatchTestFailed, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	var patched models.User
+	decoder := json.NewDecoder(bytes.NewReader(result))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patched); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	if patched.ID != user.ID {
+		return nil, fmt.Errorf("%w: id cannot be changed", ErrInvalidPatch)
+	}
+	if patched.Version != user.Version {
+		return nil, fmt.Errorf("%w: version cannot be changed", ErrInvalidPatch)
+	}
+	return &patched, nil
+}
+
+// changedFields returns the JSON names of the fields that differ between a
+// and b.
+func changedFields(a, b *models.User) []string {
+	var fields []string
+	compare := func(name, x, y string) {
+		if x != y {
+			fields = append(fields, name)
+		}
+	}
+	compare("user_name", a.UserName, b.UserName)
+	compare("email", a.Email, b.Email)
+	compare("first_name", a.FirstName, b.FirstName)
+	compare("last_name", a.LastName, b.LastName)
+	compare("status", a.Status, b.Status)
+	compare("department", a.Department, b.Department)
+	if !sameManager(a.ManagerID, b.ManagerID) {
+		fields = append(fields, "manager_id")
+	}
+	return fields
+}
+
+// sameManager reports whether two manager IDs are both unset or equal.
+func sameManager(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// PurgeDeletedUsers permanently removes users that were soft deleted more
+// than retention ago.
+func (s *UserService) PurgeDeletedUsers(ctx context.Context, retention time.Duration) (int, error) {
+	return s.Repo.PurgeDeletedUsers(ctx, time.Now().Add(-retention))
+}
+
+// RunPurger calls PurgeDeletedUsers every interval until ctx is cancelled,
+// which also cancels a purge in progress.
+func (s *UserService) RunPurger(ctx context.Context, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := s.PurgeDeletedUsers(ctx, retention)
+			if err != nil {
+				log.Printf("Failed to purge deleted users: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Purged %d user(s) deleted more than %s ago", purged, retention)
+			}
+		}
+	}
+}
@@ -1,30 +1,220 @@
 package services
 
 import (
+	"context"
+	"errors"
+
+	"user-service/apperrors"
 	"user-service/models"
 	"user-service/repositories"
 )
 
 type UserService struct {
-	Repo *repositories.UserRepository
+	Repo repositories.UserStore
+	// Departments, when set, is used to check that users are written with
+	// the code of an existing department.
+	Departments repositories.DepartmentStore
+	// Transitions records status changes and holds the scheduled ones; see
+	// ChangeStatus.
+	Transitions repositories.TransitionStore
+	// ImportBatchSize is the number of writes ImportUsers applies per
+	// transaction when not told otherwise; 0 applies each import in one.
+	ImportBatchSize int
+	// UnitOfWork, when set, makes operations that write to several stores,
+	// such as ChangeStatus, atomic. It must cover the stores above.
+	UnitOfWork repositories.UnitOfWork
+
+	audit models.AuditContext
+	guard Guard
+}
+
+// Guard decides whether the caller may modify user, returning an error if
+// not. Writes consult it with the user as stored and, for creates and
+// updates, as it is about to be written.
+type Guard func(user *models.User) error
+
+// WithGuard returns a UserService whose writes are checked by guard; a nil
+// guard allows every write.
+func (s *UserService) WithGuard(guard Guard) *UserService {
+	scoped := *s
+	scoped.guard = guard
+	return &scoped
+}
+
+// inTx calls fn with a UserService whose stores are bound to a single
+// transaction of s.UnitOfWork, so that the writes fn makes through it are
+// committed together or not at all. Without a UnitOfWork, fn is called with s.
+func (s *UserService) inTx(ctx context.Context, fn func(s *UserService) error) error {
+	if s.UnitOfWork == nil {
+		return fn(s)
+	}
+	return s.UnitOfWork.WithTx(ctx, func(tx repositories.Repos) error {
+		return fn(s.bound(tx))
+	})
 }
 
-func NewUserService(repo *repositories.UserRepository) *UserService {
+// bound returns a copy of s using the stores of a unit of work, within which
+// further calls of inTx join the unit of work.
+func (s *UserService) bound(tx repositories.Repos) *UserService {
+	scoped := *s
+	scoped.UnitOfWork = nil
+	scoped.Repo = tx.Users.WithAudit(s.audit)
+	if s.Departments != nil {
+		scoped.Departments = tx.Departments
+	}
+	if s.Transitions != nil {
+		scoped.Transitions = tx.Transitions
+	}
+	return &scoped
+}
+
+// check applies the guard to the users given.
+func (s *UserService) check(users ...*models.User) error {
+	if s.guard == nil {
+		return nil
+	}
+	for _, user := range users {
+		if err := s.guard(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkStored applies the guard to the stored user with the given id.
+func (s *UserService) checkStored(ctx context.Context, id int, includeDeleted bool) error {
+	_, err := s.guardedVersion(ctx, id, includeDeleted, 0)
+	return err
+}
+
+// guardedVersion applies the guard to the stored user with the given id and
+// returns the version the write that follows must be made on: version, or for
+// 0 (any) that of the user checked. The user is read outside the write, so
+// this keeps a user changed in between, for example moved out of the
+// caller's departments, from being written without a check.
+func (s *UserService) guardedVersion(ctx context.Context, id int, includeDeleted bool, version int) (int, error) {
+	if s.guard == nil {
+		return version, nil
+	}
+	user, err := s.Repo.GetUserByID(ctx, id, includeDeleted)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.guard(user); err != nil {
+		return 0, err
+	}
+	if version == 0 {
+		return user.Version, nil
+	}
+	return version, nil
+}
+
+func NewUserService(repo repositories.UserStore) *UserService {
 	return &UserService{Repo: repo}
 }
 
-func (s *UserService) GetAllUsers() ([]models.User, error) {
-	return s.Repo.GetAllUsers()
+func (s *UserService) GetAllUsers(ctx context.Context) ([]models.User, error) {
+	return s.Repo.GetAllUsers(ctx)
+}
+
+func (s *UserService) ListUsers(ctx context.Context, params models.UserListParams) (*models.UserPage, error) {
+	return s.Repo.ListUsers(ctx, params)
+}
+
+// StreamUsers calls fn with every user matching the filters of params, in
+// the order of params.Sort, as the store reads them.
+func (s *UserService) StreamUsers(ctx context.Context, params models.UserListParams, fn func(models.User) error) error {
+	return s.Repo.StreamUsers(ctx, params, fn)
+}
+
+func (s *UserService) GetUser(ctx context.Context, id int, includeDeleted bool) (*models.User, error) {
+	return s.Repo.GetUserByID(ctx, id, includeDeleted)
+}
+
+func (s *UserService) CreateUser(ctx context.Context, user *models.User) error {
+	if err := s.check(user); err != nil {
+		return err
+	}
+	if err := s.checkDepartment(ctx, user); err != nil {
+		return err
+	}
+	return s.Repo.CreateUser(ctx, user)
+}
+
+// UpdateUser overwrites every field of the user. Status changes must follow
+// the allowed transitions (see ChangeStatus), without overrides, and fail
+// with repositories.ErrTransitionPending while the user has one pending. Like
+// PatchUser, a user with version 0 is written on the version it was checked
+// against, so that concurrent changes are not overwritten unchecked.
+func (s *UserService) UpdateUser(ctx context.Context, user *models.User) error {
+	stored, err := s.Repo.GetUserByID(ctx, user.ID, false)
+	if err != nil {
+		return err
+	}
+	if err := s.check(stored, user); err != nil {
+		return err
+	}
+	if err := checkTransition(stored.Status, user.Status, false, ""); err != nil {
+		return err
+	}
+	if err := s.checkDepartment(ctx, user); err != nil {
+		return err
+	}
+	if user.Version == 0 {
+		user.Version = stored.Version
+	}
+	return s.inTx(ctx, func(s *UserService) error {
+		if user.Status != stored.Status {
+			if err := s.checkNoPendingTransition(ctx, user.ID); err != nil {
+				return err
+			}
+		}
+		return s.Repo.UpdateUser(ctx, user)
+	})
+}
+
+// checkDepartment verifies that user's department exists, reporting it as a
+// validation failure of the department field if not.
+func (s *UserService) checkDepartment(ctx context.Context, user *models.User) error {
+	if s.Departments == nil || user.Department == "" {
+		return nil
+	}
+	_, err := s.Departments.GetDepartment(ctx, user.Department)
+	if errors.Is(err, repositories.ErrDepartmentNotFound) {
+		e := apperrors.New(apperrors.Invalid, "validation failed")
+		e.Fields = []apperrors.FieldError{{Field: "department", Rule: "exists", Message: "must be the code of an existing department"}}
+		return e
+	}
+	return err
 }
 
-func (s *UserService) CreateUser(user *models.User) error {
-	return s.Repo.CreateUser(user)
+// DeleteUser soft deletes the user, provided its version matches (see
+// repositories.UserStore).
+func (s *UserService) DeleteUser(ctx context.Context, id int, version int) error {
+	version, err := s.guardedVersion(ctx, id, false, version)
+	if err != nil {
+		return err
+	}
+	return s.Repo.DeleteUser(ctx, id, version)
 }
 
-func (s *UserService) UpdateUser(user *models.User) error {
-	return s.Repo.UpdateUser(user)
+// RestoreUser undoes a soft delete and returns the restored user.
+func (s *UserService) RestoreUser(ctx context.Context, id int, version int) (*models.User, error) {
+	version, err := s.guardedVersion(ctx, id, true, version)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Repo.RestoreUser(ctx, id, version); err != nil {
+		return nil, err
+	}
+	return s.Repo.GetUserByID(ctx, id, false)
 }
 
-func (s *UserService) DeleteUser(id int) error {
-	return s.Repo.DeleteUser(id)
+// PurgeUser permanently removes a soft-deleted user.
+func (s *UserService) PurgeUser(ctx context.Context, id int) error {
+	version, err := s.guardedVersion(ctx, id, true, 0)
+	if err != nil {
+		return err
+	}
+	return s.Repo.PurgeUser(ctx, id, version)
 }
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/models"
+	"user-service/repositories"
+)
+
+var (
+	ErrInvalidTransition = apperrors.New(apperrors.Conflict, "status transition not allowed")
+	errNoTransitionStore = errors.New("status transitions cannot be scheduled without a transition store")
+)
+
+// statusTransitions lists the statuses each status may change to.
+var statusTransitions = map[string][]string{
+	models.StatusActive:   {models.StatusInactive, models.StatusTerminated},
+	models.StatusInactive: {models.StatusActive, models.StatusTerminated},
+}
+
+// overridableTransitions lists the further changes that an override with a
+// reason allows.
+var overridableTransitions = map[string][]string{
+	models.StatusTerminated: {models.StatusActive},
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTransition verifies that a user's status may change from one status
+// to another. Unknown statuses are left to validation.
+func checkTransition(from, to string, override bool, reason string) error {
+	known := []string{models.StatusActive, models.StatusInactive, models.StatusTerminated}
+	if from == to || !contains(known, from) || !contains(known, to) || contains(statusTransitions[from], to) {
+		return nil
+	}
+	if override && contains(overridableTransitions[from], to) {
+		if strings.TrimSpace(reason) == "" {
+			e := apperrors.New(apperrors.Invalid, "validation failed")
+			e.Fields = []apperrors.FieldError{{Field: "reason", Rule: "required", Message: "is required for an override"}}
+			return e
+		}
+		return nil
+	}
+	return apperrors.Wrap(ErrInvalidTransition, apperrors.Conflict, fmt.Sprintf("cannot change status from %s to %s", from, to))
+}
+
+// ChangeStatus changes the status of the user with the given id, provided its
+// version matches (see repositories.UserStore), the transition is allowed and
+// the user has no other transition pending; otherwise it returns
+// repositories.ErrTransitionPending, so that a scheduled change never fires
+// against a status it was not checked against. Changes effective now or
+// earlier are applied at once and the changed user is returned; later ones
+// are scheduled, and the user is nil. Either way the transition is recorded,
+// in the same unit of work as the change when there is one.
+func (s *UserService) ChangeStatus(ctx context.Context, id int, version int, change models.StatusChange) (*models.User, *models.StatusTransition, error) {
+	user, err := s.Repo.GetUserByID(ctx, id, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.check(user); err != nil {
+		return nil, nil, err
+	}
+	if version != 0 && version != user.Version {
+		return nil, nil, repositories.ErrVersionConflict
+	}
+	if user.Status == change.Status {
+		return nil, nil, apperrors.Wrap(ErrInvalidTransition, apperrors.Conflict, "user already has status "+change.Status)
+	}
+	if err := checkTransition(user.Status, change.Status, change.Override, change.Reason); err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	transition := &models.StatusTransition{
+		UserID:      id,
+		Status:      change.Status,
+		Reason:      change.Reason,
+		Override:    change.Override,
+		EffectiveAt: now,
+		RequestedBy: s.audit.Actor,
+		State:       models.TransitionPending,
+	}
+	if transition.RequestedBy == "" {
+		transition.RequestedBy = repositories.SystemActor
+	}
+	if change.EffectiveAt != nil && change.EffectiveAt.After(now) {
+		if s.Transitions == nil {
+			return nil, nil, errNoTransitionStore
+		}
+		transition.EffectiveAt = *change.EffectiveAt
+		if err := s.Transitions.CreateTransition(ctx, transition); err != nil {
+			return nil, nil, err
+		}
+		return nil, transition, nil
+	}
+
+	transition.State = models.TransitionApplied
+	transition.FinishedAt = &now
+	var changed models.User
+	err = s.inTx(ctx, func(s *UserService) error {
+		if err := s.checkNoPendingTransition(ctx, id); err != nil {
+			return err
+		}
+		changed = *user
+		changed.Status = change.Status
+		if err := s.Repo.PatchUser(ctx, &changed, []string{"status"}); err != nil {
+			return err
+		}
+		if s.Transitions == nil {
+			return nil
+		}
+		return s.Transitions.CreateTransition(ctx, transition)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &changed, transition, nil
+}
+
+// checkNoPendingTransition returns repositories.ErrTransitionPending if the
+// user has a pending transition.
+func (s *UserService) checkNoPendingTransition(ctx context.Context, userID int) error {
+	if s.Transitions == nil {
+		return nil
+	}
+	transitions, err := s.Transitions.ListTransitions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, t := range transitions {
+		if t.State == models.TransitionPending {
+			return repositories.ErrTransitionPending
+		}
+	}
+	return nil
+}
+
+// ListTransitions returns the status transitions of a user, newest first.
+func (s *UserService) ListTransitions(ctx context.Context, userID int) ([]models.StatusTransition, error) {
+	if _, err := s.Repo.GetUserByID(ctx, userID, true); err != nil {
+		return nil, err
+	}
+	if s.Transitions == nil {
+		return []models.StatusTransition{}, nil
+	}
+	return s.Transitions.ListTransitions(ctx, userID)
+}
+
+// CancelTransition cancels a pending status transition of a user.
+func (s *UserService) CancelTransition(ctx context.Context, userID, id int) error {
+	if err := s.checkStored(ctx, userID, true); err != nil {
+		return err
+	}
+	if s.Transitions == nil {
+		return repositories.ErrTransitionNotFound
+	}
+	transition, err := s.Transitions.GetTransition(ctx, id)
+	if err != nil {
+		return err
+	}
+	if transition.UserID != userID {
+		return repositories.ErrTransitionNotFound
+	}
+	return s.Transitions.FinishTransition(ctx, id, models.TransitionCanceled, "")
+}
+
+// ApplyDueTransitions applies the pending status transitions effective at or
+// before now and returns how many it applied. Transitions that are no longer
+// allowed, for example because the user has been deleted or terminated in
+// the meantime, are marked as failed; ones that lose a race with another
+// write to the user are retried on the next call. With a UnitOfWork, each
+// transition is applied and marked as such in one transaction.
+func (s *UserService) ApplyDueTransitions(ctx context.Context, now time.Time) (int, error) {
+	due, err := s.Transitions.DueTransitions(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, transition := range due {
+		var state string
+		err := s.inTx(ctx, func(s *UserService) error {
+			state = ""
+			err := s.applyTransition(ctx, transition)
+			if errors.Is(err, repositories.ErrVersionConflict) {
+				return nil
+			}
+			if err != nil && apperrors.KindOf(err) == apperrors.Internal {
+				return err
+			}
+
+			message := ""
+			state = models.TransitionApplied
+			if err != nil {
+				state, message = models.TransitionFailed, err.Error()
+			}
+			return s.Transitions.FinishTransition(ctx, transition.ID, state, message)
+		})
+		if err != nil {
+			return applied, err
+		}
+		if state == models.TransitionApplied {
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// applyTransition changes the user's status as a scheduled transition asks,
+// checking the transition against the user's current status.
+func (s *UserService) applyTransition(ctx context.Context, transition models.StatusTransition) error {
+	user, err := s.Repo.GetUserByID(ctx, transition.UserID, false)
+	if err != nil {
+		return err
+	}
+	if user.Status == transition.Status {
+		return nil
+	}
+	if err := checkTransition(user.Status, transition.Status, transition.Override, transition.Reason); err != nil {
+		return err
+	}
+	user.Status = transition.Status
+	return s.Repo.PatchUser(ctx, user, []string{"status"})
+}
+
+// RunStatusScheduler calls ApplyDueTransitions every interval until ctx is
+// cancelled, which also cancels the transitions being applied.
+func (s *UserService) RunStatusScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			applied, err := s.ApplyDueTransitions(ctx, now)
+			if err != nil {
+				log.Printf("Failed to apply status transitions: %v", err)
+				continue
+			}
+			if applied > 0 {
+				log.Printf("Applied %d scheduled status transition(s)", applied)
+			}
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/models"
+	"user-service/repositories"
+)
+
+var ErrWebhookURL = apperrors.New(apperrors.Invalid, "webhook URL must be an absolute http or https URL")
+
+// WebhookSecretPrefix starts the secrets generated for webhooks.
+const WebhookSecretPrefix = "whsec_"
+
+// WebhookService manages webhook subscriptions and delivers the events queued
+// for them. A delivery that fails is retried with exponential backoff, after
+// RetryDelay, twice that, and so on up to MaxRetryDelay, until MaxAttempts
+// attempts have failed and it is dead-lettered.
+type WebhookService struct {
+	Repo   repositories.WebhookStore
+	Client *http.Client
+	// Workers is how many deliveries Dispatch attempts at once.
+	Workers int
+	// MaxAttempts is how many times a delivery is tried in all.
+	MaxAttempts   int
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// BatchSize is the number of due deliveries read at a time.
+	BatchSize int
+	// Retention is how long finished deliveries stay in the delivery log
+	// before Run removes them; 0 keeps them.
+	Retention time.Duration
+}
+
+func NewWebhookService(repo repositories.WebhookStore) *WebhookService {
+	return &WebhookService{
+		Repo:          repo,
+		Client:        &http.Client{Timeout: 10 * time.Second},
+		Workers:       8,
+		MaxAttempts:   8,
+		RetryDelay:    30 * time.Second,
+		MaxRetryDelay: time.Hour,
+		BatchSize:     100,
+	}
+}
+
+// CreateWebhook subscribes a URL to user events. Without a secret, one is
+// generated; either way it is only returned here.
+func (s *WebhookService) CreateWebhook(ctx context.Context, webhook *models.Webhook) (*models.NewWebhook, error) {
+	if err := checkWebhookURL(webhook.URL); err != nil {
+		return nil, err
+	}
+	if webhook.Secret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			return nil, err
+		}
+		webhook.Secret = secret
+	}
+	if err := s.Repo.CreateWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+	return &models.NewWebhook{Webhook: *webhook, Secret: webhook.Secret}, nil
+}
+
+func checkWebhookURL(rawURL string) error {
+	if rawURL == "" {
+		// Left to the validation of the webhook.
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrWebhookURL
+	}
+	return nil
+}
+
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return WebhookSecretPrefix + hex.EncodeToString(secret), nil
+}
+
+func (s *WebhookService) GetWebhook(ctx context.Context, id int) (*models.Webhook, error) {
+	return s.Repo.GetWebhook(ctx, id)
+}
+
+func (s *WebhookService) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	return s.Repo.ListWebhooks(ctx)
+}
+
+// UpdateWebhook changes the URL and events of a webhook and, if one is given,
+// its secret. Deliveries already queued are made to the new URL.
+func (s *WebhookService) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	if err := checkWebhookURL(webhook.URL); err != nil {
+		return err
+	}
+	existing, err := s.Repo.GetWebhook(ctx, webhook.ID)
+	if err != nil {
+		return err
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = existing.Secret
+	}
+	webhook.CreatedAt = existing.CreatedAt
+	return s.Repo.UpdateWebhook(ctx, webhook)
+}
+
+// DeleteWebhook deletes a webhook and its delivery log.
+func (s *WebhookService) DeleteWebhook(ctx context.Context, id int) error {
+	return s.Repo.DeleteWebhook(ctx, id)
+}
+
+// ListDeliveries returns one page of the delivery log of a webhook, newest
+// first.
+func (s *WebhookService) ListDeliveries(ctx context.Context, params models.WebhookDeliveryListParams) (*models.WebhookDeliveryPage, error) {
+	if _, err := s.Repo.GetWebhook(ctx, params.WebhookID); err != nil {
+		return nil, err
+	}
+	return s.Repo.ListDeliveries(ctx, params)
+}
+
+// Redeliver queues a delivery of the webhook to be made again, whatever its
+// state, and returns it. The delivery is made by Dispatch and retried like a
+// new one, dead letters included. Should the delivery change meanwhile, as
+// when an attempt ends, Redeliver fails with ErrDeliveryChanged.
+func (s *WebhookService) Redeliver(ctx context.Context, webhookID, deliveryID int) (*models.WebhookDelivery, error) {
+	if _, err := s.Repo.GetWebhook(ctx, webhookID); err != nil {
+		return nil, err
+	}
+	delivery, err := s.Repo.GetDelivery(ctx, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	if delivery.WebhookID != webhookID {
+		return nil, repositories.ErrDeliveryNotFound
+	}
+	if err := s.Repo.RequeueDelivery(ctx, delivery, time.Now()); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// Sink returns the EventSink that queues events for the webhooks subscribed
+// to them, for an EventRelay to publish to.
+func (s *WebhookService) Sink() EventSink {
+	return webhookSink{repo: s.Repo}
+}
+
+// webhookSink queues each event for delivery by Dispatch. Queuing an event
+// twice queues it once.
+type webhookSink struct {
+	repo repositories.WebhookStore
+}
+
+func (k webhookSink) Publish(ctx context.Context, event models.Event) error {
+	return k.repo.EnqueueDeliveries(ctx, event)
+}
+
+// Dispatch attempts the deliveries that are due, up to Workers at once, and
+// returns how many of them succeeded. Failed attempts are recorded with the
+// deliveries; only failing to read or record them is an error.
+func (s *WebhookService) Dispatch(ctx context.Context) (int, error) {
+	succeeded := 0
+	for {
+		deliveries, err := s.Repo.DueDeliveries(ctx, time.Now(), s.BatchSize)
+		if err != nil {
+			return succeeded, err
+		}
+		webhooks := make(map[int]*models.Webhook)
+		for _, delivery := range deliveries {
+			if _, ok := webhooks[delivery.WebhookID]; ok {
+				continue
+			}
+			webhook, err := s.Repo.GetWebhook(ctx, delivery.WebhookID)
+			if err != nil {
+				return succeeded, err
+			}
+			webhooks[delivery.WebhookID] = webhook
+		}
+		n, err := s.attemptAll(ctx, webhooks, deliveries)
+		succeeded += n
+		if err != nil {
+			return succeeded, err
+		}
+		// Failed deliveries are not due again at once, so the next batch
+		// holds new ones.
+		if len(deliveries) < s.BatchSize {
+			return succeeded, nil
+		}
+	}
+}
+
+// attemptAll attempts the deliveries on up to Workers goroutines, so that a
+// slow webhook holds up only the worker attempting it, and records their
+// outcomes. It returns how many succeeded and the errors recording them.
+func (s *WebhookService) attemptAll(ctx context.Context, webhooks map[int]*models.Webhook, deliveries []models.WebhookDelivery) (int, error) {
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+		errs      []error
+	)
+	queue := make(chan *models.WebhookDelivery)
+	for range min(max(s.Workers, 1), len(deliveries)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for delivery := range queue {
+				s.attempt(ctx, webhooks[delivery.WebhookID], delivery)
+				err := s.Repo.UpdateDelivery(ctx, delivery)
+				if errors.Is(err, repositories.ErrDeliveryChanged) {
+					// Redelivered meanwhile; the outcome of the attempt no
+					// longer matters.
+					continue
+				}
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				} else if delivery.State == models.DeliverySucceeded {
+					succeeded++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range deliveries {
+		queue <- &deliveries[i]
+	}
+	close(queue)
+	wg.Wait()
+	return succeeded, errors.Join(errs...)
+}
+
+// attempt POSTs the delivery to the webhook and records the outcome in
+// delivery, scheduling the next attempt or dead-lettering it on failure.
+func (s *WebhookService) attempt(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) {
+	status, err := s.post(ctx, webhook, delivery)
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.LastStatus = status
+	delivery.NextAttemptAt = nil
+	if err == nil {
+		delivery.State = models.DeliverySucceeded
+		delivery.LastError = ""
+		delivery.FinishedAt = &now
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= s.MaxAttempts {
+		delivery.State = models.DeliveryDead
+		delivery.FinishedAt = &now
+		return
+	}
+	next := now.Add(s.backoff(delivery.Attempts))
+	delivery.State = models.DeliveryPending
+	delivery.NextAttemptAt = &next
+	delivery.FinishedAt = nil
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (s *WebhookService) backoff(attempts int) time.Duration {
+	delay := s.RetryDelay
+	for i := 1; i < attempts && delay < s.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, s.MaxRetryDelay)
+}
+
+// post sends the payload of delivery to the webhook and returns the HTTP
+// status of the response, or 0 if there was none. Any status other than 2xx
+// is a failure.
+func (s *WebhookService) post(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "user-service-webhooks")
+	req.Header.Set("X-Webhook-Id", strconv.Itoa(webhook.ID))
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(delivery.ID))
+	req.Header.Set("X-Event-Id", strconv.Itoa(delivery.EventID))
+	req.Header.Set("X-Event-Type", delivery.EventType)
+	req.Header.Set("X-Webhook-Signature", SignWebhook(webhook.Secret, time.Now(), delivery.Payload))
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			// Leave out the method and URL, which may carry credentials.
+			err = urlErr.Err
+		}
+		return 0, err
+	}
+	defer res.Body.Close()
+	// Drain the body so that the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook answered %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// SignWebhook returns the X-Webhook-Signature header of a delivery of body
+// made at t: "t=<unix time>,v1=<signature>", where the signature is the
+// hex-encoded HMAC-SHA256, keyed with the webhook's secret, of the unix time,
+// a dot and the body. Receivers recompute it to check that a delivery is
+// authentic, and reject old timestamps to stop replays.
+func SignWebhook(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Run dispatches the due deliveries every interval, and prunes the delivery
+// log, until ctx is cancelled, which also cancels a delivery in progress.
+func (s *WebhookService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Dispatch(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to dispatch webhook deliveries: %v", err)
+			}
+			if s.Retention > 0 {
+				if _, err := s.Repo.PruneDeliveries(ctx, time.Now().Add(-s.Retention)); err != nil && ctx.Err() == nil {
+					log.Printf("Failed to prune webhook deliveries: %v", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+// xlsxParts are the parts of a workbook other than its worksheet, which are
+// the same for every export.
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Users" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+// xlsxWriter streams a workbook with a single worksheet. Strings are written
+// inline rather than to a shared string table, so that no row needs to be
+// kept once written.
+type xlsxWriter struct {
+	zip   *zip.Writer
+	sheet io.Writer
+	row   int
+	buf   bytes.Buffer
+}
+
+func newXLSXWriter(w io.Writer) (*xlsxWriter, error) {
+	x := &xlsxWriter{zip: zip.NewWriter(w)}
+	for _, part := range xlsxParts {
+		f, err := x.zip.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, xml.Header+part.content); err != nil {
+			return nil, err
+		}
+	}
+	// The worksheet is the last part, so that its rows can be written as
+	// they come.
+	sheet, err := x.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	x.sheet = sheet
+	_, err = io.WriteString(sheet, xml.Header+`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return x, err
+}
+
+func (x *xlsxWriter) writeRow(values []interface{}) error {
+	x.row++
+	row := strconv.Itoa(x.row)
+	x.buf.Reset()
+	x.buf.WriteString(`<row r="` + row + `">`)
+	for i, value := range values {
+		ref := xlsxColumn(i) + row
+		switch value := value.(type) {
+		case string:
+			x.buf.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+			xml.EscapeText(&x.buf, []byte(spreadsheetText(value)))
+			x.buf.WriteString(`</t></is></c>`)
+		case int:
+			x.buf.WriteString(`<c r="` + ref + `"><v>` + strconv.Itoa(value) + `</v></c>`)
+		}
+	}
+	x.buf.WriteString(`</row>`)
+	_, err := x.sheet.Write(x.buf.Bytes())
+	return err
+}
+
+func (x *xlsxWriter) close() error {
+	if _, err := io.WriteString(x.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return x.zip.Close()
+}
+
+// xlsxColumn returns the name of the i-th column, counting from 0: A to Z,
+// then AA and so on.
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
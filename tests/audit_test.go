@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"user-service/controllers"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Audit log", func() {
+
+	forEachStore(func(newStores func() testStores) {
+		var (
+			userService *services.UserService
+			user        *models.User
+		)
+
+		BeforeEach(func() {
+			userService = services.NewUserService(newStores().Users)
+			user = &models.User{UserName: "john_doe", Email: "john@example.com", FirstName: "John", LastName: "Doe", Status: "A", Department: "IT"}
+			Expect(userService.WithAudit(models.AuditContext{Actor: "alice", RequestID: "req-1"}).CreateUser(ctx, user)).To(Succeed())
+		})
+
+		It("should record every mutation with a diff of the changed fields", func() {
+			hr := userService.WithAudit(models.AuditContext{Actor: "bob", RequestID: "req-2"})
+			user.Status = "T"
+			Expect(hr.UpdateUser(ctx, user)).To(Succeed())
+			Expect(hr.DeleteUser(ctx, user.ID, user.Version)).To(Succeed())
+			Expect(userService.PurgeUser(ctx, user.ID)).To(Succeed())
+
+			page, err := userService.UserHistory(ctx, user.ID, 0, 0)
+			Expect(err).To(BeNil())
+			Expect(page.Total).To(Equal(4))
+			purge, del, update, create := page.Events[0], page.Events[1], page.Events[2], page.Events[3]
+
+			Expect(create.Operation).To(Equal(models.AuditCreate))
+			Expect(create.Actor).To(Equal("alice"))
+			Expect(create.RequestID).To(Equal("req-1"))
+			Expect(create.Changes["user_name"]).To(Equal(models.FieldChange{Before: nil, After: "john_doe"}))
+
+			Expect(update.Operation).To(Equal(models.AuditUpdate))
+			Expect(update.Actor).To(Equal("bob"))
+			Expect(update.Changes).To(Equal(map[string]models.FieldChange{"status": {Before: "A", After: "T"}}))
+
+			Expect(del.Operation).To(Equal(models.AuditDelete))
+			Expect(del.Changes).To(HaveKey("deleted_at"))
+			Expect(del.Changes["deleted_at"].Before).To(BeNil())
+
+			Expect(purge.Operation).To(Equal(models.AuditPurge))
+			Expect(purge.Actor).To(Equal(repositories.SystemActor))
+			Expect(purge.Changes["email"]).To(Equal(models.FieldChange{Before: "john@example.com", After: nil}))
+		})
+
+		It("should not record failed mutations", func() {
+			user.Version = 5
+			Expect(userService.UpdateUser(ctx, user)).To(MatchError(repositories.ErrVersionConflict))
+
+			page, err := userService.ListAuditEvents(ctx, models.AuditListParams{})
+			Expect(err).To(BeNil())
+			Expect(page.Total).To(Equal(1))
+		})
+
+		It("should filter terminations", func() {
+			other := &models.User{UserName: "jane_doe", Email: "jane@example.com", FirstName: "Jane", LastName: "Doe", Status: "T", Department: "IT"}
+			Expect(userService.CreateUser(ctx, other)).To(Succeed())
+			user.Department = "HR"
+			Expect(userService.UpdateUser(ctx, user)).To(Succeed())
+			user.Status = "T"
+			Expect(userService.UpdateUser(ctx, user)).To(Succeed())
+
+			page, err := userService.ListAuditEvents(ctx, models.AuditListParams{Field: "status", Status: "T"})
+			Expect(err).To(BeNil())
+			Expect(page.Total).To(Equal(2))
+			Expect(page.Events[0].UserID).To(Equal(user.ID))
+			Expect(page.Events[0].Operation).To(Equal(models.AuditUpdate))
+			Expect(page.Events[1].UserID).To(Equal(other.ID))
+			Expect(page.Events[1].Operation).To(Equal(models.AuditCreate))
+
+			page, err = userService.ListAuditEvents(ctx, models.AuditListParams{Actor: "alice", Limit: 1})
+			Expect(err).To(BeNil())
+			Expect(page.Total).To(Equal(1))
+
+			_, err = userService.ListAuditEvents(ctx, models.AuditListParams{Field: "salary"})
+			Expect(err).To(MatchError(repositories.ErrInvalidAuditField))
+		})
+	})
+
+	Describe("controllers", func() {
+		var (
+			e           *echo.Echo
+			userService *services.UserService
+		)
+
+		BeforeEach(func() {
+			e = echo.New()
+			e.HTTPErrorHandler = controllers.ErrorHandler
+			userService = services.NewUserService(repositories.NewMemoryUserRepository())
+			e.POST("/users", controllers.CreateUser(userService))
+			e.GET("/users/:id/history", controllers.GetUserHistory(userService))
+			e.GET("/audit", controllers.GetAuditEvents(userService))
+		})
+
+		serve := func(req *http.Request) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec
+		}
+
+		It("should attribute changes to the caller and request", func() {
+			req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(
+				`{"user_name": "john_doe", "email": "john@example.com", "first_name": "John", "last_name": "Doe", "status": "A", "department": "IT"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set("X-Actor", "alice")
+			req.Header.Set(echo.HeaderXRequestID, "req-1")
+			Expect(serve(req).Code).To(Equal(http.StatusCreated))
+
+			rec := serve(httptest.NewRequest(http.MethodGet, "/users/1/history", nil))
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			var page models.AuditPage
+			Expect(json.Unmarshal(rec.Body.Bytes(), &page)).To(Succeed())
+			Expect(page.Events).To(HaveLen(1))
+			Expect(page.Events[0].Actor).To(Equal("alice"))
+			Expect(page.Events[0].RequestID).To(Equal("req-1"))
+		})
+
+		It("should return 404 for the history of unknown users", func() {
+			Expect(serve(httptest.NewRequest(http.MethodGet, "/users/42/history", nil)).Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should reject invalid filters", func() {
+			Expect(serve(httptest.NewRequest(http.MethodGet, "/audit?since=yesterday", nil)).Code).To(Equal(http.StatusBadRequest))
+			Expect(serve(httptest.NewRequest(http.MethodGet, "/audit?field=salary", nil)).Code).To(Equal(http.StatusBadRequest))
+			Expect(serve(httptest.NewRequest(http.MethodGet, "/audit?field=status&status=T", nil)).Code).To(Equal(http.StatusOK))
+		})
+	})
+})
@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"user-service/auth"
+	"user-service/controllers"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/services"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Authentication", func() {
+	var (
+		e             *echo.Echo
+		apiKeyService *services.APIKeyService
+		userService   *services.UserService
+		rsaKey        *rsa.PrivateKey
+		hmacSecret    []byte
+	)
+
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	BeforeEach(func() {
+		var err error
+		rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).To(BeNil())
+		hmacSecret = []byte("0123456789abcdef0123456789abcdef")
+
+		jwks, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "oct", "kid": "hmac-1", "k": b64(hmacSecret)},
+		}})
+		Expect(err).To(BeNil())
+		path := filepath.Join(GinkgoT().TempDir(), "jwks.json")
+		Expect(os.WriteFile(path, jwks, 0o600)).To(Succeed())
+		keySet, err := auth.LoadKeySet(path)
+		Expect(err).To(BeNil())
+
+		apiKeyService = services.NewAPIKeyService(repositories.NewAPIKeyRepository(openMigratedSQLite()))
+		userService = services.NewUserService(repositories.NewMemoryUserRepository())
+
+		roles := repositories.NewMemoryRoleRepository()
+		Expect(roles.AssignRole(ctx, &models.RoleAssignment{Subject: "alice", Role: models.RoleEditor, Department: "IT"})).To(Succeed())
+		Expect(roles.AssignRole(ctx, &models.RoleAssignment{Subject: "root", Role: models.RoleAdmin})).To(Succeed())
+
+		e = echo.New()
+		e.HTTPErrorHandler = controllers.ErrorHandler
+		api := e.Group("", auth.Middleware(&auth.Authenticator{
+			APIKeys:  apiKeyService.Repo,
+			Roles:    roles,
+			KeySet:   keySet,
+			Issuer:   "https://issuer.example.com",
+			Audience: "user-service",
+		}))
+		api.GET("/whoami", func(c echo.Context) error {
+			principal, _ := auth.PrincipalFrom(c)
+			return c.JSON(http.StatusOK, principal)
+		})
+		api.POST("/users", controllers.CreateUser(userService))
+		api.GET("/admin/api-keys", controllers.ListAPIKeys(apiKeyService))
+		api.POST("/admin/api-keys", controllers.CreateAPIKey(apiKeyService))
+		api.DELETE("/admin/api-keys/:id", controllers.RevokeAPIKey(apiKeyService))
+	})
+
+	request := func(method, target, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		for name, values := range header {
+			req.Header.Set(name, values[0])
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	bearer := func(token string) http.Header {
+		return http.Header{echo.HeaderAuthorization: {"Bearer " + token}}
+	}
+
+	principalOf := func(rec *httptest.ResponseRecorder) auth.Principal {
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		var principal auth.Principal
+		Expect(json.Unmarshal(rec.Body.Bytes(), &principal)).To(Succeed())
+		return principal
+	}
+
+	claims := func(expiresIn time.Duration) jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			Subject:   "alice",
+			Issuer:    "https://issuer.example.com",
+			Audience:  jwt.ClaimStrings{"user-service"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+		}
+	}
+
+	sign := func(method jwt.SigningMethod, kid string, claims jwt.Claims, key interface{}) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		Expect(err).To(BeNil())
+		return signed
+	}
+
+	It("should reject requests without credentials", func() {
+		rec := request(http.MethodGet, "/whoami", "", nil)
+
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		Expect(rec.Header().Get(echo.HeaderWWWAuthenticate)).To(Equal("Bearer"))
+		Expect(problemOf(rec).Detail).To(Equal("authentication required"))
+	})
+
+	It("should authenticate API keys until they are revoked", func() {
+		key, err := apiKeyService.CreateAPIKey(ctx, "ci-bot", "CI pipeline")
+		Expect(err).To(BeNil())
+		Expect(key.Key).To(HavePrefix(auth.APIKeyPrefix))
+		Expect(key.Key).To(HavePrefix(key.Prefix))
+
+		principal := principalOf(request(http.MethodGet, "/whoami", "", http.Header{auth.HeaderAPIKey: {key.Key}}))
+		Expect(principal.Subject).To(Equal("ci-bot"))
+		Expect(principal.Method).To(Equal(auth.MethodAPIKey))
+		Expect(principalOf(request(http.MethodGet, "/whoami", "", bearer(key.Key))).Subject).To(Equal("ci-bot"))
+
+		Expect(apiKeyService.RevokeAPIKey(ctx, key.ID)).To(Succeed())
+		rec := request(http.MethodGet, "/whoami", "", http.Header{auth.HeaderAPIKey: {key.Key}})
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		Expect(problemOf(rec).Detail).To(Equal("invalid credentials"))
+	})
+
+	It("should authenticate RS256 and HS256 tokens from the key set", func() {
+		principal := principalOf(request(http.MethodGet, "/whoami", "", bearer(sign(jwt.SigningMethodRS256, "rsa-1", claims(time.Hour), rsaKey))))
+		Expect(principal.Subject).To(Equal("alice"))
+		Expect(principal.Method).To(Equal(auth.MethodJWT))
+		Expect(principal.KeyID).To(Equal("rsa-1"))
+		Expect(principal.Roles).To(ConsistOf(HaveField("Role", models.RoleEditor)))
+
+		principal = principalOf(request(http.MethodGet, "/whoami", "", bearer(sign(jwt.SigningMethodHS256, "hmac-1", claims(time.Hour), hmacSecret))))
+		Expect(principal.KeyID).To(Equal("hmac-1"))
+	})
+
+	It("should reject expired, misaddressed and wrongly signed tokens", func() {
+		wrongAudience := claims(time.Hour)
+		wrongAudience.Audience = jwt.ClaimStrings{"other-service"}
+		noExpiry := claims(time.Hour)
+		noExpiry.ExpiresAt = nil
+
+		for _, token := range []string{
+			sign(jwt.SigningMethodRS256, "rsa-1", claims(-time.Hour), rsaKey),
+			sign(jwt.SigningMethodRS256, "rsa-1", wrongAudience, rsaKey),
+			sign(jwt.SigningMethodRS256, "rsa-1", noExpiry, rsaKey),
+			sign(jwt.SigningMethodRS256, "rsa-2", claims(time.Hour), rsaKey),
+			// An HS256 token "signed" with the RSA key ID must not be
+			// verified with the public key as the secret.
+			sign(jwt.SigningMethodHS256, "rsa-1", claims(time.Hour), hmacSecret),
+			"not-a-token",
+		} {
+			rec := request(http.MethodGet, "/whoami", "", bearer(token))
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized), token)
+			Expect(problemOf(rec).Detail).To(Equal("invalid credentials"))
+		}
+	})
+
+	It("should attribute audited changes to the principal", func() {
+		rec := request(http.MethodPost, "/users",
+			`{"user_name":"john_doe","email":"john@example.com","first_name":"John","last_name":"Doe","status":"A","department":"IT"}`,
+			http.Header{
+				echo.HeaderAuthorization: {"Bearer " + sign(jwt.SigningMethodHS256, "hmac-1", claims(time.Hour), hmacSecret)},
+				"X-Actor":                {"mallory"},
+			})
+		Expect(rec.Code).To(Equal(http.StatusCreated))
+
+		page, err := userService.ListAuditEvents(ctx, models.AuditListParams{})
+		Expect(err).To(BeNil())
+		Expect(page.Events).To(HaveLen(1))
+		Expect(page.Events[0].Actor).To(Equal("alice"))
+	})
+
+	It("should mint, list and revoke API keys through the admin endpoints", func() {
+		rootClaims := claims(time.Hour)
+		rootClaims.Subject = "root"
+		admin := bearer(sign(jwt.SigningMethodRS256, "rsa-1", rootClaims, rsaKey))
+
+		rec := request(http.MethodPost, "/admin/api-keys", `{"name":"reporting","subject":"reports"}`, admin)
+		Expect(rec.Code).To(Equal(http.StatusCreated))
+		var created models.NewAPIKey
+		Expect(json.Unmarshal(rec.Body.Bytes(), &created)).To(Succeed())
+		Expect(created.Key).NotTo(BeEmpty())
+		Expect(principalOf(request(http.MethodGet, "/whoami", "", bearer(created.Key))).Subject).To(Equal("reports"))
+
+		rec = request(http.MethodGet, "/admin/api-keys", "", admin)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).NotTo(ContainSubstring(created.Key))
+		var keys []models.APIKey
+		Expect(json.Unmarshal(rec.Body.Bytes(), &keys)).To(Succeed())
+		Expect(keys).To(HaveLen(1))
+		Expect(keys[0].Prefix).To(Equal(created.Prefix))
+
+		rec = request(http.MethodPost, "/admin/api-keys", `{"name":"no subject"}`, admin)
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		Expect(problemOf(rec).Errors).To(ContainElement(HaveField("Field", "subject")))
+
+		Expect(request(http.MethodDelete, "/admin/api-keys/1", "", admin).Code).To(Equal(http.StatusNoContent))
+		Expect(request(http.MethodDelete, "/admin/api-keys/1", "", admin).Code).To(Equal(http.StatusNotFound))
+		Expect(request(http.MethodGet, "/whoami", "", bearer(created.Key)).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should only let admins manage API keys, whatever the routes require", func() {
+		editor := bearer(sign(jwt.SigningMethodRS256, "rsa-1", claims(time.Hour), rsaKey))
+		key, err := apiKeyService.CreateAPIKey(ctx, "ci-bot", "CI pipeline")
+		Expect(err).To(BeNil())
+
+		rec := request(http.MethodPost, "/admin/api-keys", `{"name":"escalate","subject":"root"}`, editor)
+		Expect(rec.Code).To(Equal(http.StatusForbidden))
+		Expect(problemOf(rec).Detail).To(Equal("permission api-keys:manage required"))
+		Expect(request(http.MethodGet, "/admin/api-keys", "", editor).Code).To(Equal(http.StatusForbidden))
+		Expect(request(http.MethodDelete, "/admin/api-keys/1", "", editor).Code).To(Equal(http.StatusForbidden))
+
+		keys, err := apiKeyService.ListAPIKeys(ctx)
+		Expect(err).To(BeNil())
+		Expect(keys).To(ConsistOf(HaveField("ID", key.ID)))
+		Expect(keys[0].RevokedAt).To(BeNil())
+	})
+})
@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"user-service/controllers"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Request contexts", func() {
+	var userService *services.UserService
+
+	BeforeEach(func() {
+		userService = services.NewUserService(repositories.NewUserRepository(openUserSQLite()))
+		Expect(userService.CreateUser(ctx, &models.User{UserName: "ann", Email: "ann@example.com", FirstName: "A", LastName: "N", Status: "A", Department: "IT"})).To(Succeed())
+	})
+
+	It("should stop the database work of a canceled context", func() {
+		canceled, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := userService.ListUsers(canceled, models.UserListParams{})
+		Expect(err).To(MatchError(context.Canceled))
+		err = userService.CreateUser(canceled, &models.User{UserName: "bob", Email: "bob@example.com", FirstName: "B", LastName: "O", Status: "A", Department: "IT"})
+		Expect(err).To(MatchError(context.Canceled))
+
+		users, err := userService.GetAllUsers(ctx)
+		Expect(err).To(BeNil())
+		Expect(users).To(HaveLen(1))
+	})
+
+	Describe("over HTTP", func() {
+		serve := func(timeout time.Duration, target string) *httptest.ResponseRecorder {
+			e := echo.New()
+			e.HTTPErrorHandler = controllers.ErrorHandler
+			e.Use(controllers.Timeout(timeout))
+			e.GET("/users", controllers.GetUsers(userService))
+			e.GET("/users/:id", controllers.GetUser(userService))
+			e.GET("/slow", func(c echo.Context) error {
+				<-c.Request().Context().Done()
+				return errors.New("interrupted")
+			})
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+			return rec
+		}
+
+		It("should answer requests that run out of time with 504", func() {
+			rec := serve(time.Nanosecond, "/users")
+			Expect(rec.Code).To(Equal(http.StatusGatewayTimeout))
+			Expect(problemOf(rec).Detail).To(Equal("the request timed out"))
+
+			Expect(serve(10*time.Millisecond, "/slow").Code).To(Equal(http.StatusGatewayTimeout))
+		})
+
+		It("should leave requests that finish in time alone", func() {
+			Expect(serve(time.Minute, "/users").Code).To(Equal(http.StatusOK))
+			Expect(serve(0, "/users").Code).To(Equal(http.StatusOK))
+
+			rec := serve(time.Minute, "/users/9")
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+			Expect(problemOf(rec).Detail).To(Equal("user with id 9 not found"))
+		})
+	})
+})
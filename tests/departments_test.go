@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"errors"
+
+	"user-service/apperrors"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/services"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Departments", func() {
+	It("should derive department codes from free text", func() {
+		for value, code := range map[string]string{
+			"IT":                "IT",
+			"it":                "IT",
+			"I.T.":              "IT",
+			" Human Resources ": "HUMAN-RESOURCES",
+			"R&D":               "RD",
+			"sales_emea":        "SALES-EMEA",
+			"...":               "",
+		} {
+			Expect(services.DepartmentCode(value)).To(Equal(code), value)
+		}
+	})
+
+	forEachStoreOver(openMigratedSQLite, func(newStores func() testStores) {
+		var (
+			userStore         repositories.UserStore
+			userService       *services.UserService
+			departmentService *services.DepartmentService
+		)
+
+		newUserIn := func(userName, department string) *models.User {
+			user := newUser(userName)
+			user.Department = department
+			return user
+		}
+
+		// createLegacyUser stores a user as databases created before
+		// departments were managed may hold it, past the foreign key
+		// that SQLite enforces since
+		createLegacyUser := func(user *models.User) {
+			if sqlStore, ok := userStore.(*repositories.UserRepository); ok {
+				_, err := sqlStore.DB.Exec("PRAGMA foreign_keys = OFF")
+				Expect(err).To(BeNil())
+				defer sqlStore.DB.Exec("PRAGMA foreign_keys = ON")
+			}
+			Expect(userStore.CreateUser(ctx, user)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			stores := newStores()
+			userStore = stores.Users
+			departmentService = services.NewDepartmentService(stores.Departments)
+			userService = services.NewUserService(userStore)
+			userService.Departments = stores.Departments
+			Expect(departmentService.CreateDepartment(ctx, &models.Department{Code: "CORP", Name: "Corporate"})).To(Succeed())
+			Expect(departmentService.CreateDepartment(ctx, &models.Department{Code: "IT", Name: "Information Technology", ParentCode: "CORP", CostCenter: "CC-100"})).To(Succeed())
+		})
+
+		It("should manage a hierarchy of departments", func() {
+			departments, err := departmentService.ListDepartments(ctx)
+			Expect(err).To(BeNil())
+			Expect(departments).To(HaveLen(2))
+			Expect(departments[1]).To(And(HaveField("Code", "IT"), HaveField("ParentCode", "CORP"), HaveField("CostCenter", "CC-100")))
+
+			Expect(departmentService.CreateDepartment(ctx, &models.Department{Code: "IT", Name: "Again"})).To(MatchError(repositories.ErrDuplicateDepartment))
+			Expect(departmentService.CreateDepartment(ctx, &models.Department{Code: "OPS", Name: "Ops", ParentCode: "NOPE"})).To(MatchError(repositories.ErrUnknownParentDepartment))
+			Expect(departmentService.UpdateDepartment(ctx, &models.Department{Code: "CORP", Name: "Corporate", ParentCode: "IT"})).To(MatchError(repositories.ErrDepartmentCycle))
+			Expect(departmentService.UpdateDepartment(ctx, &models.Department{Code: "NOPE", Name: "Nope"})).To(MatchError(repositories.ErrDepartmentNotFound))
+
+			updated := &models.Department{Code: "IT", Name: "Technology", CostCenter: "CC-200"}
+			Expect(departmentService.UpdateDepartment(ctx, updated)).To(Succeed())
+			stored, err := departmentService.GetDepartment(ctx, "IT")
+			Expect(err).To(BeNil())
+			Expect(stored.Name).To(Equal("Technology"))
+			Expect(stored.ParentCode).To(BeEmpty())
+
+			Expect(departmentService.CreateDepartment(ctx, &models.Department{Code: "OPS", Name: "Ops", ParentCode: "CORP"})).To(Succeed())
+			Expect(departmentService.DeleteDepartment(ctx, "CORP")).To(MatchError(repositories.ErrDepartmentInUse))
+			Expect(userService.CreateUser(ctx, newUserIn("ann", "OPS"))).To(Succeed())
+			Expect(departmentService.DeleteDepartment(ctx, "OPS")).To(MatchError(repositories.ErrDepartmentInUse))
+			Expect(departmentService.DeleteDepartment(ctx, "IT")).To(Succeed())
+			Expect(departmentService.DeleteDepartment(ctx, "IT")).To(MatchError(repositories.ErrDepartmentNotFound))
+		})
+
+		It("should only write users with existing departments", func() {
+			err := userService.CreateUser(ctx, newUserIn("ann", "it"))
+			Expect(err).To(MatchError(ContainSubstring("validation failed")))
+			var appErr *apperrors.Error
+			Expect(errors.As(err, &appErr)).To(BeTrue())
+			Expect(appErr.Fields).To(ConsistOf(HaveField("Field", "department")))
+
+			user := newUserIn("ann", "IT")
+			Expect(userService.CreateUser(ctx, user)).To(Succeed())
+			user.Department = "I.T."
+			Expect(userService.UpdateUser(ctx, user)).To(MatchError(ContainSubstring("validation failed")))
+			_, err = userService.PatchUser(ctx, user.ID, 0, "application/merge-patch+json", []byte(`{"department":"HR"}`))
+			Expect(err).To(MatchError(ContainSubstring("validation failed")))
+			_, err = userService.PatchUser(ctx, user.ID, 0, "application/merge-patch+json", []byte(`{"department":"CORP"}`))
+			Expect(err).To(BeNil())
+		})
+
+		It("should map free-text departments of existing users onto departments", func() {
+			// Users created before departments were managed
+			for userName, department := range map[string]string{"ann": "IT", "bob": "it", "cid": "I.T.", "dee": "Human Resources", "eve": "human resources", "fay": "People"} {
+				createLegacyUser(newUserIn(userName, department))
+			}
+			deleted := newUserIn("gus", "it")
+			createLegacyUser(deleted)
+			Expect(userStore.DeleteUser(ctx, deleted.ID, 0)).To(Succeed())
+
+			plan, err := departmentService.PlanUserDepartmentMapping(ctx, map[string]string{"People": "HUMAN-RESOURCES"})
+			Expect(err).To(BeNil())
+			Expect(plan).To(ConsistOf(
+				services.DepartmentMapping{Value: "I.T.", Code: "IT", Users: 1},
+				services.DepartmentMapping{Value: "it", Code: "IT", Users: 2},
+				services.DepartmentMapping{Value: "Human Resources", Code: "HUMAN-RESOURCES", Users: 1, Create: true, Name: "Human Resources"},
+				services.DepartmentMapping{Value: "human resources", Code: "HUMAN-RESOURCES", Users: 1, Create: true, Name: "Human Resources"},
+				services.DepartmentMapping{Value: "People", Code: "HUMAN-RESOURCES", Users: 1, Create: true, Name: "Human Resources"},
+			))
+
+			mapped, err := departmentService.MapUserDepartments(ctx, plan)
+			Expect(err).To(BeNil())
+			Expect(mapped).To(Equal(6))
+
+			counts, err := departmentService.Repo.UserDepartmentCounts(ctx)
+			Expect(err).To(BeNil())
+			Expect(counts).To(Equal(map[string]int{"IT": 4, "HUMAN-RESOURCES": 3}))
+			department, err := departmentService.GetDepartment(ctx, "HUMAN-RESOURCES")
+			Expect(err).To(BeNil())
+			Expect(department.Name).To(Equal("Human Resources"))
+
+			page, err := userService.ListAuditEvents(ctx, models.AuditListParams{Field: "department", Operation: models.AuditUpdate})
+			Expect(err).To(BeNil())
+			Expect(page.Total).To(Equal(6))
+			Expect(page.Events[0].Actor).To(Equal(repositories.SystemActor))
+
+			plan, err = departmentService.PlanUserDepartmentMapping(ctx, nil)
+			Expect(err).To(BeNil())
+			Expect(plan).To(BeEmpty())
+		})
+
+		It("should refuse values no code can be derived from", func() {
+			createLegacyUser(newUserIn("ann", "???"))
+			_, err := departmentService.PlanUserDepartmentMapping(ctx, nil)
+			Expect(err).To(MatchError(ContainSubstring(`"???"`)))
+		})
+	})
+})
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"user-service/apperrors"
+	"user-service/controllers"
+	"user-service/models"
+	"user-service/repositories"
+
+	"github.com/labstack/echo/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Errors", func() {
+	It("should classify domain errors by kind", func() {
+		err := fmt.Errorf("loading user: %w", &repositories.UserNotFoundError{ID: 7})
+		Expect(errors.Is(err, repositories.ErrUserNotFound)).To(BeTrue())
+		Expect(errors.Is(err, apperrors.NotFound)).To(BeTrue())
+		Expect(errors.Is(err, apperrors.Conflict)).To(BeFalse())
+		Expect(apperrors.KindOf(err)).To(Equal(apperrors.NotFound))
+		Expect(apperrors.KindOf(errors.New("boom"))).To(Equal(apperrors.Internal))
+	})
+
+	It("should report validation failures per field", func() {
+		err := repositories.NewMemoryUserRepository().CreateUser(ctx, &models.User{UserName: "john_doe", Email: "nope", FirstName: "John", LastName: "Doe", Status: "X", Department: "IT"})
+
+		var appErr *apperrors.Error
+		Expect(errors.As(err, &appErr)).To(BeTrue())
+		Expect(appErr.Kind).To(Equal(apperrors.Invalid))
+		Expect(appErr.Fields).To(ConsistOf(
+			apperrors.FieldError{Field: "email", Rule: "email", Message: "must be a valid email address"},
+			apperrors.FieldError{Field: "status", Rule: "oneof", Message: "must be one of A, I, T"},
+		))
+	})
+
+	It("should show clients the message of an error but not its cause", func() {
+		cause := errors.New("disk on fire")
+		Expect(apperrors.PublicMessage(apperrors.New(apperrors.Conflict, "user is locked"))).To(Equal("user is locked"))
+		Expect(apperrors.PublicMessage(apperrors.Wrap(cause, apperrors.Conflict, "user is locked"))).To(Equal("user is locked"))
+		Expect(apperrors.PublicMessage(fmt.Errorf("deleting user 7: %w", &repositories.UserNotFoundError{ID: 7}))).To(Equal("deleting user 7: user with id 7 not found"))
+		Expect(apperrors.PublicMessage(cause)).To(Equal("internal error"))
+	})
+
+	Describe("ErrorHandler", func() {
+		respond := func(err error) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/users/7", nil), rec)
+			controllers.ErrorHandler(err, c)
+			return rec
+		}
+
+		It("should write domain errors as problem details", func() {
+			rec := respond(&repositories.UserNotFoundError{ID: 7})
+
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+			Expect(problemOf(rec)).To(Equal(controllers.Problem{
+				Type:     "about:blank",
+				Title:    "Not Found",
+				Status:   http.StatusNotFound,
+				Detail:   "user with id 7 not found",
+				Instance: "/users/7",
+			}))
+		})
+
+		It("should hide the causes of errors", func() {
+			rec := respond(apperrors.Wrap(errors.New("secret"), apperrors.Invalid, "invalid input"))
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+			Expect(problemOf(rec).Detail).To(Equal("invalid input"))
+
+			rec = respond(fmt.Errorf("failed to execute query: %w", errors.New("secret")))
+			Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+			Expect(problemOf(rec).Detail).To(BeEmpty())
+		})
+
+		It("should answer timeouts with 504", func() {
+			rec := respond(fmt.Errorf("failed to execute query: %w", context.DeadlineExceeded))
+			Expect(rec.Code).To(Equal(http.StatusGatewayTimeout))
+			Expect(problemOf(rec).Detail).To(Equal("the request timed out"))
+		})
+
+		It("should write Echo errors as problem details", func() {
+			rec := respond(echo.ErrMethodNotAllowed)
+			Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+			Expect(problemOf(rec).Title).To(Equal("Method Not Allowed"))
+		})
+	})
+})
@@ -0,0 +1,189 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/services"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Events", func() {
+
+	forEachStore(func(newStores func() testStores) {
+		var (
+			userService *services.UserService
+			outbox      repositories.OutboxStore
+		)
+
+		BeforeEach(func() {
+			stores := newStores()
+			outbox = stores.Outbox
+			userService = services.NewUserService(stores.Users).WithAudit(models.AuditContext{Actor: "alice", RequestID: "req-1"})
+		})
+
+		It("should write an event for every change of a user", func() {
+			user := newUser("ann")
+			Expect(userService.CreateUser(ctx, user)).To(Succeed())
+			Expect(userService.CreateUser(ctx, newUser("ann"))).NotTo(Succeed())
+			user.FirstName = "Ann"
+			Expect(userService.UpdateUser(ctx, user)).To(Succeed())
+			_, _, err := userService.ChangeStatus(ctx, user.ID, 0, models.StatusChange{Status: "I"})
+			Expect(err).To(BeNil())
+			Expect(userService.DeleteUser(ctx, user.ID, 0)).To(Succeed())
+			Expect(userService.PurgeUser(ctx, user.ID)).To(Succeed())
+
+			events, err := outbox.PendingEvents(ctx, "events", 10)
+			Expect(err).To(BeNil())
+			var types []string
+			for _, event := range events {
+				types = append(types, event.Type)
+			}
+			Expect(types).To(Equal([]string{models.EventUserCreated, models.EventUserUpdated, models.EventUserUpdated, models.EventUserDeleted, models.EventUserDeleted}))
+
+			Expect(events[0].ID).To(BeNumerically("<", events[1].ID))
+			Expect(events[0].Actor).To(Equal("alice"))
+			Expect(events[0].RequestID).To(Equal("req-1"))
+			Expect(events[0].User.UserName).To(Equal("ann"))
+			Expect(events[2].User.Status).To(Equal("I"))
+			Expect(events[2].Changes).To(Equal(map[string]models.FieldChange{"status": {Before: "A", After: "I"}}))
+			Expect(events[4].User.DeletedAt).NotTo(BeNil())
+		})
+
+		Describe("relayed to a file", func() {
+			var (
+				relay *services.EventRelay
+				path  string
+			)
+
+			lines := func() []models.Event {
+				f, err := os.Open(path)
+				Expect(err).To(BeNil())
+				defer f.Close()
+				var events []models.Event
+				scanner := bufio.NewScanner(f)
+				for scanner.Scan() {
+					var event models.Event
+					Expect(json.Unmarshal(scanner.Bytes(), &event)).To(Succeed())
+					events = append(events, event)
+				}
+				return events
+			}
+
+			BeforeEach(func() {
+				path = filepath.Join(GinkgoT().TempDir(), "events.ndjson")
+				sink, err := services.NewFileSink(path)
+				Expect(err).To(BeNil())
+				DeferCleanup(sink.Close)
+				relay = services.NewEventRelay(outbox, map[string]services.EventSink{"events": sink})
+				relay.BatchSize = 2
+			})
+
+			It("should deliver every event once, in order", func() {
+				for _, userName := range []string{"ann", "bob", "cid"} {
+					Expect(userService.CreateUser(ctx, newUser(userName))).To(Succeed())
+				}
+				Expect(relay.Relay(ctx)).To(Equal(3))
+				Expect(relay.Relay(ctx)).To(Equal(0))
+				Expect(userService.DeleteUser(ctx, 2, 0)).To(Succeed())
+				Expect(relay.Relay(ctx)).To(Equal(1))
+
+				events := lines()
+				Expect(events).To(HaveLen(4))
+				for i, event := range events {
+					Expect(event.ID).To(Equal(i + 1))
+				}
+				Expect(events[3].Type).To(Equal(models.EventUserDeleted))
+				Expect(events[3].User.UserName).To(Equal("bob"))
+				Expect(outbox.PendingEvents(ctx, "events", 10)).To(BeEmpty())
+			})
+
+			It("should prune delivered events after the retention", func() {
+				Expect(userService.CreateUser(ctx, newUser("ann"))).To(Succeed())
+				Expect(relay.Relay(ctx)).To(Equal(1))
+				Expect(outbox.PruneDelivered(ctx, []string{"events"}, time.Now().Add(-time.Hour))).To(Equal(0))
+
+				relay.Retention = time.Nanosecond
+				Expect(userService.CreateUser(ctx, newUser("bob"))).To(Succeed())
+				Expect(relay.Relay(ctx)).To(Equal(1))
+				Expect(outbox.PruneDelivered(ctx, []string{"events"}, time.Now().Add(time.Hour))).To(Equal(0))
+			})
+
+			It("should keep events until every sink has been delivered them", func() {
+				Expect(userService.CreateUser(ctx, newUser("ann"))).To(Succeed())
+				Expect(userService.CreateUser(ctx, newUser("bob"))).To(Succeed())
+				Expect(relay.Relay(ctx)).To(Equal(2))
+				Expect(outbox.PendingEvents(ctx, "other", 10)).To(HaveLen(2))
+
+				later := time.Now().Add(time.Hour)
+				Expect(outbox.PruneDelivered(ctx, []string{"events", "other"}, later)).To(Equal(0))
+				Expect(outbox.MarkFailed(ctx, "other", 1, "down")).To(Succeed())
+				Expect(outbox.PruneDelivered(ctx, []string{"events", "other"}, later)).To(Equal(0))
+				Expect(outbox.MarkDelivered(ctx, "other", 1)).To(Succeed())
+				Expect(outbox.PendingEvents(ctx, "other", 10)).To(HaveLen(1))
+				Expect(outbox.PruneDelivered(ctx, []string{"events", "other"}, later)).To(Equal(1))
+				Expect(outbox.PendingEvents(ctx, "other", 10)).To(HaveLen(1))
+				Expect(outbox.PruneDelivered(ctx, []string{"events"}, later)).To(Equal(1))
+				Expect(outbox.PendingEvents(ctx, "other", 10)).To(BeEmpty())
+			})
+		})
+
+		It("should deliver over HTTP again after a failure", func() {
+			var (
+				mu       sync.Mutex
+				received []string
+				fail     = true
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+				var event models.Event
+				Expect(json.NewDecoder(r.Body).Decode(&event)).To(Succeed())
+				Expect(r.Header.Get("X-Event-Type")).To(Equal(event.Type))
+				received = append(received, r.Header.Get("X-Event-Id")+" "+event.User.UserName)
+				if fail && len(received) == 2 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			DeferCleanup(server.Close)
+			relay := services.NewEventRelay(outbox, map[string]services.EventSink{"events": services.NewHTTPSink(server.URL, time.Second)})
+
+			for _, userName := range []string{"ann", "bob", "cid"} {
+				Expect(userService.CreateUser(ctx, newUser(userName))).To(Succeed())
+			}
+			delivered, err := relay.Relay(ctx)
+			Expect(err).To(MatchError(ContainSubstring("503 Service Unavailable")))
+			Expect(delivered).To(Equal(1))
+			Expect(outbox.PendingEvents(ctx, "events", 10)).To(HaveLen(2))
+
+			mu.Lock()
+			fail = false
+			mu.Unlock()
+			Expect(relay.Relay(ctx)).To(Equal(2))
+			Expect(received).To(Equal([]string{"1 ann", "2 bob", "2 bob", "3 cid"}))
+		})
+	})
+
+	It("should not write the events of a rolled back unit of work", func() {
+		db := openUserSQLite()
+		outbox := repositories.NewOutboxRepository(db)
+		err := repositories.NewTransactor(db).WithTx(context.Background(), func(tx repositories.Repos) error {
+			Expect(tx.Users.CreateUser(ctx, newUser("ann"))).To(Succeed())
+			return repositories.ErrVersionConflict
+		})
+		Expect(err).To(MatchError(repositories.ErrVersionConflict))
+		Expect(outbox.PendingEvents(ctx, "events", 10)).To(BeEmpty())
+	})
+})
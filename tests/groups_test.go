@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"user-service/controllers"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Groups", func() {
+
+	forEachStore(func(newStores func() testStores) {
+		var (
+			userService  *services.UserService
+			groupService *services.GroupService
+			users        []*models.User
+			admins       *models.Group
+			devs         *models.Group
+		)
+
+		groupNames := func(groups []models.Group) []string {
+			names := []string{}
+			for _, g := range groups {
+				names = append(names, g.Name)
+			}
+			return names
+		}
+
+		BeforeEach(func() {
+			stores := newStores()
+			userService = services.NewUserService(stores.Users)
+			groupService = services.NewGroupService(stores.Groups)
+
+			users = nil
+			for _, userName := range []string{"ann", "bob", "cid"} {
+				user := newUser(userName)
+				Expect(userService.CreateUser(ctx, user)).To(Succeed())
+				users = append(users, user)
+			}
+			admins = &models.Group{Name: "admins", Description: "Administrators"}
+			devs = &models.Group{Name: "devs"}
+			Expect(groupService.CreateGroup(ctx, admins)).To(Succeed())
+			Expect(groupService.CreateGroup(ctx, devs)).To(Succeed())
+		})
+
+		It("should create, rename and delete groups", func() {
+			Expect(groupService.CreateGroup(ctx, &models.Group{Name: "admins"})).To(MatchError(repositories.ErrDuplicateGroupName))
+			Expect(groupService.CreateGroup(ctx, &models.Group{})).To(MatchError(ContainSubstring("validation failed")))
+
+			page, err := groupService.ListGroups(ctx, 1, 1)
+			Expect(err).To(BeNil())
+			Expect(page.Total).To(Equal(2))
+			Expect(groupNames(page.Groups)).To(Equal([]string{"devs"}))
+
+			renamed := &models.Group{ID: devs.ID, Name: "engineering", Description: "Engineers"}
+			Expect(groupService.UpdateGroup(ctx, renamed)).To(Succeed())
+			Expect(renamed.CreatedAt).To(BeTemporally("~", devs.CreatedAt))
+			Expect(groupService.UpdateGroup(ctx, &models.Group{ID: devs.ID, Name: "admins"})).To(MatchError(repositories.ErrDuplicateGroupName))
+			Expect(groupService.UpdateGroup(ctx, &models.Group{ID: 99, Name: "x"})).To(MatchError(repositories.ErrGroupNotFound))
+
+			Expect(groupService.AddMember(ctx, devs.ID, users[0].ID)).To(Succeed())
+			Expect(groupService.DeleteGroup(ctx, devs.ID)).To(Succeed())
+			Expect(groupService.DeleteGroup(ctx, devs.ID)).To(MatchError(repositories.ErrGroupNotFound))
+			_, err = groupService.GetGroup(ctx, devs.ID)
+			Expect(err).To(MatchError(repositories.ErrGroupNotFound))
+			groups, err := groupService.ListUserGroups(ctx, users[0].ID)
+			Expect(err).To(BeNil())
+			Expect(groups).To(BeEmpty())
+		})
+
+		It("should add, list and remove members", func() {
+			for _, user := range users {
+				Expect(groupService.AddMember(ctx, devs.ID, user.ID)).To(Succeed())
+			}
+			Expect(groupService.AddMember(ctx, devs.ID, users[0].ID)).To(Succeed())
+			Expect(groupService.AddMember(ctx, admins.ID, users[0].ID)).To(Succeed())
+			Expect(groupService.AddMember(ctx, admins.ID, 99)).To(MatchError(repositories.ErrUserNotFound))
+			Expect(groupService.AddMember(ctx, 99, users[0].ID)).To(MatchError(repositories.ErrGroupNotFound))
+
+			page, err := groupService.ListMembers(ctx, devs.ID, 2, 1)
+			Expect(err).To(BeNil())
+			Expect(page.Total).To(Equal(3))
+			Expect(page.Users).To(HaveLen(2))
+			Expect(page.Users[0].UserName).To(Equal("bob"))
+			Expect(page.Users[1].UserName).To(Equal("cid"))
+
+			groups, err := groupService.ListUserGroups(ctx, users[0].ID)
+			Expect(err).To(BeNil())
+			Expect(groupNames(groups)).To(Equal([]string{"admins", "devs"}))
+
+			Expect(groupService.RemoveMember(ctx, devs.ID, users[1].ID)).To(Succeed())
+			Expect(groupService.RemoveMember(ctx, devs.ID, users[1].ID)).To(MatchError(repositories.ErrNotGroupMember))
+			Expect(groupService.RemoveMember(ctx, 99, users[1].ID)).To(MatchError(repositories.ErrGroupNotFound))
+			page, err = groupService.ListMembers(ctx, devs.ID, 0, 0)
+			Expect(err).To(BeNil())
+			Expect(page.Total).To(Equal(2))
+		})
+
+		It("should remove deleted and terminated users from their groups", func() {
+			for _, user := range users {
+				Expect(groupService.AddMember(ctx, devs.ID, user.ID)).To(Succeed())
+				Expect(groupService.AddMember(ctx, admins.ID, user.ID)).To(Succeed())
+			}
+
+			Expect(userService.DeleteUser(ctx, users[0].ID, 0)).To(Succeed())
+			terminated := *users[1]
+			terminated.Status = models.StatusTerminated
+			Expect(userService.UpdateUser(ctx, &terminated)).To(Succeed())
+			_, err := userService.PatchUser(ctx, users[2].ID, 0, "application/merge-patch+json", []byte(`{"status":"I"}`))
+			Expect(err).To(BeNil())
+
+			page, err := groupService.ListMembers(ctx, devs.ID, 0, 0)
+			Expect(err).To(BeNil())
+			Expect(page.Users).To(ConsistOf(HaveField("UserName", "cid")))
+			_, err = groupService.ListUserGroups(ctx, users[0].ID)
+			Expect(err).To(MatchError(repositories.ErrUserNotFound))
+			groups, err := groupService.ListUserGroups(ctx, users[1].ID)
+			Expect(err).To(BeNil())
+			Expect(groups).To(BeEmpty())
+
+			Expect(groupService.AddMember(ctx, devs.ID, users[1].ID)).To(MatchError(repositories.ErrUserCannotJoin))
+			Expect(groupService.AddMember(ctx, devs.ID, users[0].ID)).To(MatchError(repositories.ErrUserCannotJoin))
+
+			// Restoring a user does not bring back its memberships
+			_, err = userService.RestoreUser(ctx, users[0].ID, 0)
+			Expect(err).To(BeNil())
+			groups, err = groupService.ListUserGroups(ctx, users[0].ID)
+			Expect(err).To(BeNil())
+			Expect(groups).To(BeEmpty())
+
+			_, err = userService.PatchUser(ctx, users[2].ID, 0, "application/merge-patch+json", []byte(`{"status":"T"}`))
+			Expect(err).To(BeNil())
+			page, err = groupService.ListMembers(ctx, admins.ID, 0, 0)
+			Expect(err).To(BeNil())
+			Expect(page.Users).To(BeEmpty())
+		})
+	})
+
+	Describe("routes", func() {
+		var e *echo.Echo
+
+		BeforeEach(func() {
+			users := repositories.NewMemoryUserRepository()
+			groupService := services.NewGroupService(repositories.NewMemoryGroupRepository(users))
+			Expect(users.CreateUser(ctx, &models.User{UserName: "ann", Email: "ann@example.com", FirstName: "A", LastName: "N", Status: "A", Department: "IT"})).To(Succeed())
+
+			e = echo.New()
+			e.HTTPErrorHandler = controllers.ErrorHandler
+			e.POST("/groups", controllers.CreateGroup(groupService))
+			e.GET("/groups/:id", controllers.GetGroup(groupService))
+			e.GET("/groups/:id/members", controllers.GetGroupMembers(groupService))
+			e.PUT("/groups/:id/members/:user_id", controllers.AddGroupMember(groupService))
+			e.DELETE("/groups/:id/members/:user_id", controllers.RemoveGroupMember(groupService))
+			e.GET("/users/:id/groups", controllers.GetUserGroups(groupService))
+		})
+
+		request := func(method, target, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, target, strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec
+		}
+
+		It("should manage memberships over HTTP", func() {
+			rec := request(http.MethodPost, "/groups", `{"name":"devs"}`)
+			Expect(rec.Code).To(Equal(http.StatusCreated))
+			Expect(request(http.MethodPost, "/groups", `{"name":"devs"}`).Code).To(Equal(http.StatusConflict))
+
+			Expect(request(http.MethodPut, "/groups/1/members/1", "").Code).To(Equal(http.StatusNoContent))
+			Expect(request(http.MethodPut, "/groups/1/members/2", "").Code).To(Equal(http.StatusNotFound))
+			Expect(request(http.MethodPut, "/groups/x/members/1", "").Code).To(Equal(http.StatusBadRequest))
+
+			rec = request(http.MethodGet, "/groups/1/members?limit=10", "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			var page models.UserPage
+			Expect(json.Unmarshal(rec.Body.Bytes(), &page)).To(Succeed())
+			Expect(page.Total).To(Equal(1))
+			Expect(page.Limit).To(Equal(10))
+			Expect(request(http.MethodGet, "/groups/1/members?offset=-1", "").Code).To(Equal(http.StatusBadRequest))
+
+			rec = request(http.MethodGet, "/users/1/groups", "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"name":"devs"`))
+
+			Expect(request(http.MethodDelete, "/groups/1/members/1", "").Code).To(Equal(http.StatusNoContent))
+			rec = request(http.MethodDelete, "/groups/1/members/1", "")
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+			Expect(problemOf(rec).Detail).To(Equal("user is not a member of the group"))
+		})
+	})
+})
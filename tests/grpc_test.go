@@ -0,0 +1,258 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+
+	"user-service/auth"
+	"user-service/models"
+	userv1 "user-service/proto/user/v1"
+	"user-service/repositories"
+	"user-service/rpc"
+	"user-service/services"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+var _ = Describe("gRPC API", func() {
+	var (
+		users       *repositories.MemoryUserRepository
+		userService *services.UserService
+		broker      *services.ChangeBroker
+	)
+
+	// dial serves the API with opts and returns a client of it.
+	dial := func(opts rpc.Options) userv1.UserServiceClient {
+		lis := bufconn.Listen(1 << 20)
+		server := rpc.NewServer(userService, broker, opts)
+		go server.Serve(lis)
+		DeferCleanup(server.Stop)
+
+		conn, err := grpc.NewClient("passthrough:///bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).To(BeNil())
+		DeferCleanup(conn.Close)
+		return userv1.NewUserServiceClient(conn)
+	}
+
+	codeOf := func(err error) codes.Code {
+		return status.Code(err)
+	}
+
+	newRPCUser := func(userName, department string) *userv1.User {
+		return &userv1.User{UserName: userName, Email: userName + "@example.com", FirstName: "F", LastName: "L", Status: "A", Department: department}
+	}
+
+	BeforeEach(func() {
+		users = repositories.NewMemoryUserRepository()
+		userService = services.NewUserService(users)
+		broker = services.NewChangeBroker(repositories.NewMemoryChangeRepository(users))
+	})
+
+	Context("without authentication", func() {
+		var client userv1.UserServiceClient
+
+		BeforeEach(func() {
+			client = dial(rpc.Options{Timeout: 5 * time.Second})
+		})
+
+		It("should create, read, update, patch and delete users", func() {
+			ctx := context.Background()
+			created, err := client.Create(ctx, &userv1.CreateRequest{User: newRPCUser("jdoe", "IT")})
+			Expect(err).To(BeNil())
+			Expect(created.Id).NotTo(BeZero())
+			Expect(created.Version).To(Equal(int64(1)))
+
+			got, err := client.Get(ctx, &userv1.GetRequest{Id: created.Id})
+			Expect(err).To(BeNil())
+			Expect(proto.Equal(got, created)).To(BeTrue())
+
+			got.FirstName = "John"
+			updated, err := client.Update(ctx, &userv1.UpdateRequest{User: got, Version: proto.Int64(got.Version)})
+			Expect(err).To(BeNil())
+			Expect(updated.FirstName).To(Equal("John"))
+			Expect(updated.Version).To(Equal(int64(2)))
+
+			// Fields outside the mask are left alone, and fields in it but
+			// unset are cleared
+			manager, err := client.Create(ctx, &userv1.CreateRequest{User: newRPCUser("boss", "IT")})
+			Expect(err).To(BeNil())
+			patched, err := client.Patch(ctx, &userv1.PatchRequest{
+				Id:         created.Id,
+				Version:    proto.Int64(0),
+				User:       &userv1.User{LastName: "Doe", FirstName: "ignored", ManagerId: proto.Int64(manager.Id)},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"last_name", "manager_id"}},
+			})
+			Expect(err).To(BeNil())
+			Expect(patched.FirstName).To(Equal("John"))
+			Expect(patched.LastName).To(Equal("Doe"))
+			Expect(patched.GetManagerId()).To(Equal(manager.Id))
+			patched, err = client.Patch(ctx, &userv1.PatchRequest{
+				Id:         created.Id,
+				Version:    proto.Int64(patched.Version),
+				User:       &userv1.User{},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"manager_id"}},
+			})
+			Expect(err).To(BeNil())
+			Expect(patched.ManagerId).To(BeNil())
+
+			_, err = client.Delete(ctx, &userv1.DeleteRequest{Id: created.Id, Version: proto.Int64(patched.Version)})
+			Expect(err).To(BeNil())
+			_, err = client.Get(ctx, &userv1.GetRequest{Id: created.Id})
+			Expect(codeOf(err)).To(Equal(codes.NotFound))
+			deleted, err := client.Get(ctx, &userv1.GetRequest{Id: created.Id, IncludeDeleted: true})
+			Expect(err).To(BeNil())
+			Expect(deleted.DeletedAt).NotTo(BeNil())
+		})
+
+		It("should stream the users matching a list request", func() {
+			for _, u := range []*userv1.User{newRPCUser("carol", "IT"), newRPCUser("alice", "HR"), newRPCUser("bob", "IT")} {
+				_, err := client.Create(context.Background(), &userv1.CreateRequest{User: u})
+				Expect(err).To(BeNil())
+			}
+
+			stream, err := client.List(context.Background(), &userv1.ListRequest{Department: "IT", Sort: "user_name"})
+			Expect(err).To(BeNil())
+			var names []string
+			for {
+				user, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				Expect(err).To(BeNil())
+				names = append(names, user.UserName)
+			}
+			Expect(names).To(Equal([]string{"bob", "carol"}))
+		})
+
+		It("should map errors to status codes", func() {
+			ctx := context.Background()
+			created, err := client.Create(ctx, &userv1.CreateRequest{User: newRPCUser("jdoe", "IT")})
+			Expect(err).To(BeNil())
+
+			_, err = client.Create(ctx, &userv1.CreateRequest{User: newRPCUser("jdoe", "IT")})
+			Expect(codeOf(err)).To(Equal(codes.AlreadyExists))
+
+			invalid := newRPCUser("asmith", "IT")
+			invalid.Email = "not-an-email"
+			_, err = client.Create(ctx, &userv1.CreateRequest{User: invalid})
+			Expect(codeOf(err)).To(Equal(codes.InvalidArgument))
+			details := status.Convert(err).Details()
+			Expect(details).To(HaveLen(1))
+			Expect(details[0]).To(BeAssignableToTypeOf(&errdetails.BadRequest{}))
+			Expect(details[0].(*errdetails.BadRequest).FieldViolations).To(ContainElement(HaveField("Field", "email")))
+
+			_, err = client.Update(ctx, &userv1.UpdateRequest{User: created})
+			Expect(codeOf(err)).To(Equal(codes.FailedPrecondition))
+			_, err = client.Delete(ctx, &userv1.DeleteRequest{Id: created.Id, Version: proto.Int64(created.Version + 1)})
+			Expect(codeOf(err)).To(Equal(codes.Aborted))
+			_, err = client.Patch(ctx, &userv1.PatchRequest{Id: created.Id, Version: proto.Int64(0), User: created,
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"version"}}})
+			Expect(codeOf(err)).To(Equal(codes.InvalidArgument))
+			_, err = client.Get(ctx, &userv1.GetRequest{Id: 999})
+			Expect(codeOf(err)).To(Equal(codes.NotFound))
+		})
+
+		It("should return the request ID", func() {
+			var header metadata.MD
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "req-1", "x-actor", "hr-portal")
+			created, err := client.Create(ctx, &userv1.CreateRequest{User: newRPCUser("jdoe", "IT")}, grpc.Header(&header))
+			Expect(err).To(BeNil())
+			Expect(header.Get("x-request-id")).To(Equal([]string{"req-1"}))
+
+			history, err := userService.UserHistory(ctx, int(created.Id), 10, 0)
+			Expect(err).To(BeNil())
+			Expect(history.Events).To(ContainElement(And(HaveField("Actor", "hr-portal"), HaveField("RequestID", "req-1"))))
+		})
+
+		It("should watch changes after a sequence number", func() {
+			runCtx, stop := context.WithCancel(context.Background())
+			DeferCleanup(stop)
+			go broker.Run(runCtx, 10*time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			DeferCleanup(cancel)
+			_, err := client.Create(ctx, &userv1.CreateRequest{User: newRPCUser("alice", "IT")})
+			Expect(err).To(BeNil())
+
+			stream, err := client.Watch(ctx, &userv1.WatchRequest{After: proto.Int64(0)})
+			Expect(err).To(BeNil())
+			change, err := stream.Recv()
+			Expect(err).To(BeNil())
+			Expect(change.Seq).To(Equal(int64(1)))
+			Expect(change.Type).To(Equal(models.EventUserCreated))
+			Expect(change.User.UserName).To(Equal("alice"))
+
+			_, err = client.Create(ctx, &userv1.CreateRequest{User: newRPCUser("bob", "IT")})
+			Expect(err).To(BeNil())
+			change, err = stream.Recv()
+			Expect(err).To(BeNil())
+			Expect(change.Seq).To(Equal(int64(2)))
+			Expect(change.User.UserName).To(Equal("bob"))
+		})
+	})
+
+	Context("with authentication", func() {
+		var (
+			client         userv1.UserServiceClient
+			viewer, editor string
+		)
+
+		BeforeEach(func() {
+			apiKeys := services.NewAPIKeyService(repositories.NewMemoryAPIKeyRepository())
+			roles := repositories.NewMemoryRoleRepository()
+			Expect(roles.AssignRole(ctx, &models.RoleAssignment{Subject: "vera", Role: models.RoleViewer})).To(Succeed())
+			Expect(roles.AssignRole(ctx, &models.RoleAssignment{Subject: "eddie", Role: models.RoleEditor, Department: "IT"})).To(Succeed())
+			key, err := apiKeys.CreateAPIKey(ctx, "vera", "test")
+			Expect(err).To(BeNil())
+			viewer = key.Key
+			key, err = apiKeys.CreateAPIKey(ctx, "eddie", "test")
+			Expect(err).To(BeNil())
+			editor = key.Key
+
+			client = dial(rpc.Options{Authenticator: &auth.Authenticator{APIKeys: apiKeys.Repo, Roles: roles}})
+		})
+
+		as := func(key string) context.Context {
+			return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+		}
+
+		It("should require credentials", func() {
+			_, err := client.Get(context.Background(), &userv1.GetRequest{Id: 1})
+			Expect(codeOf(err)).To(Equal(codes.Unauthenticated))
+			_, err = client.Get(as("usk_bogus"), &userv1.GetRequest{Id: 1})
+			Expect(codeOf(err)).To(Equal(codes.Unauthenticated))
+		})
+
+		It("should require the permission of each call and the caller's department scope", func() {
+			_, err := client.Create(as(viewer), &userv1.CreateRequest{User: newRPCUser("jdoe", "IT")})
+			Expect(codeOf(err)).To(Equal(codes.PermissionDenied))
+
+			_, err = client.Create(as(editor), &userv1.CreateRequest{User: newRPCUser("asmith", "HR")})
+			Expect(codeOf(err)).To(Equal(codes.PermissionDenied))
+
+			created, err := client.Create(as(editor), &userv1.CreateRequest{User: newRPCUser("jdoe", "IT")})
+			Expect(err).To(BeNil())
+			got, err := client.Get(as(viewer), &userv1.GetRequest{Id: created.Id})
+			Expect(err).To(BeNil())
+			Expect(got.UserName).To(Equal("jdoe"))
+
+			history, err := userService.UserHistory(ctx, int(created.Id), 10, 0)
+			Expect(err).To(BeNil())
+			Expect(history.Events).To(ContainElement(HaveField("Actor", "eddie")))
+		})
+	})
+})
@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"errors"
+
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/services"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UserService with the in-memory repository", func() {
+	var userService *services.UserService
+
+	newUserIn := func(userName, department string) *models.User {
+		user := newUser(userName)
+		user.Department = department
+		return user
+	}
+
+	BeforeEach(func() {
+		userService = services.NewUserService(repositories.NewMemoryUserRepository())
+	})
+
+	It("should assign IDs and return created users", func() {
+		user := newUserIn("john_doe", "IT")
+		Expect(userService.CreateUser(ctx, user)).To(Succeed())
+		Expect(user.ID).To(Equal(1))
+
+		found, err := userService.GetUser(ctx, user.ID, false)
+		Expect(err).To(BeNil())
+		Expect(found.UserName).To(Equal("john_doe"))
+	})
+
+	It("should reject duplicate user names", func() {
+		Expect(userService.CreateUser(ctx, newUserIn("john_doe", "IT"))).To(Succeed())
+		err := userService.CreateUser(ctx, newUserIn("john_doe", "HR"))
+		Expect(errors.Is(err, repositories.ErrDuplicateUsername)).To(BeTrue())
+	})
+
+	It("should reject invalid users", func() {
+		user := newUserIn("john_doe", "IT")
+		user.Status = "X"
+		Expect(userService.CreateUser(ctx, user)).To(MatchError(HavePrefix("validation failed:")))
+	})
+
+	It("should update and delete users", func() {
+		user := newUserIn("john_doe", "IT")
+		Expect(userService.CreateUser(ctx, user)).To(Succeed())
+
+		user.Department = "HR"
+		Expect(userService.UpdateUser(ctx, user)).To(Succeed())
+		found, _ := userService.GetUser(ctx, user.ID, false)
+		Expect(found.Department).To(Equal("HR"))
+
+		Expect(user.Version).To(Equal(2))
+
+		Expect(userService.DeleteUser(ctx, user.ID, 0)).To(Succeed())
+		_, err := userService.GetUser(ctx, user.ID, false)
+		Expect(errors.Is(err, repositories.ErrUserNotFound)).To(BeTrue())
+		Expect(userService.DeleteUser(ctx, user.ID, 0)).To(MatchError(repositories.ErrUserNotFound))
+	})
+
+	It("should reject writes with a stale version", func() {
+		user := newUserIn("john_doe", "IT")
+		Expect(userService.CreateUser(ctx, user)).To(Succeed())
+		Expect(user.Version).To(Equal(1))
+
+		stale := *user
+		user.Department = "HR"
+		Expect(userService.UpdateUser(ctx, user)).To(Succeed())
+
+		stale.Department = "Sales"
+		Expect(userService.UpdateUser(ctx, &stale)).To(MatchError(repositories.ErrVersionConflict))
+		Expect(userService.DeleteUser(ctx, user.ID, 1)).To(MatchError(repositories.ErrVersionConflict))
+		Expect(userService.DeleteUser(ctx, user.ID, 2)).To(Succeed())
+	})
+
+	It("should filter, sort and page through users with a cursor", func() {
+		for _, name := range []string{"carol", "alice", "bob", "dave"} {
+			Expect(userService.CreateUser(ctx, newUserIn(name, "IT"))).To(Succeed())
+		}
+		Expect(userService.CreateUser(ctx, newUserIn("erin", "HR"))).To(Succeed())
+
+		params := models.UserListParams{Department: "IT", Sort: "user_name", Limit: 3}
+		page, err := userService.ListUsers(ctx, params)
+		Expect(err).To(BeNil())
+		Expect(page.Total).To(Equal(4))
+		Expect(page.Users).To(HaveLen(3))
+		Expect(page.Users[0].UserName).To(Equal("alice"))
+		Expect(page.NextCursor).NotTo(BeEmpty())
+
+		params.Cursor = page.NextCursor
+		page, err = userService.ListUsers(ctx, params)
+		Expect(err).To(BeNil())
+		Expect(page.Users).To(HaveLen(1))
+		Expect(page.Users[0].UserName).To(Equal("dave"))
+		Expect(page.NextCursor).To(BeEmpty())
+
+		params.Sort = "-user_name"
+		_, err = userService.ListUsers(ctx, params)
+		Expect(err).To(MatchError(repositories.ErrInvalidCursor))
+	})
+})
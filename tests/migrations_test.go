@@ -0,0 +1,198 @@
+package tests
+
+import (
+	"database/sql"
+	"time"
+
+	"user-service/migrations"
+	"user-service/models"
+	"user-service/repositories"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Migrations", func() {
+	var (
+		db       *sql.DB
+		migrator *migrations.Migrator
+	)
+
+	tableExists := func(name string) bool {
+		var count int
+		Expect(db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)).To(Succeed())
+		return count == 1
+	}
+
+	BeforeEach(func() {
+		db = openSQLite()
+		var err error
+		migrator, err = migrations.New(db, "sqlite")
+		Expect(err).To(BeNil())
+	})
+
+	It("should load the embedded migrations for every driver in order", func() {
+		Expect(migrator.Migrations).NotTo(BeEmpty())
+		for i, m := range migrator.Migrations {
+			Expect(m.Version).To(Equal(i + 1))
+		}
+
+		postgres, err := migrations.New(db, "postgres")
+		Expect(err).To(BeNil())
+		Expect(postgres.Latest()).To(Equal(migrator.Latest()))
+	})
+
+	It("should apply and revert migrations and track the version", func() {
+		count, err := migrator.Up()
+		Expect(err).To(BeNil())
+		Expect(count).To(Equal(len(migrator.Migrations)))
+		Expect(tableExists("users")).To(BeTrue())
+		Expect(migrator.Version()).To(Equal(migrator.Latest()))
+
+		count, err = migrator.Up()
+		Expect(err).To(BeNil())
+		Expect(count).To(Equal(0))
+
+		statuses, err := migrator.Status()
+		Expect(err).To(BeNil())
+		for _, s := range statuses {
+			Expect(s.AppliedAt).NotTo(BeNil())
+		}
+
+		_, err = migrator.Goto(0)
+		Expect(err).To(BeNil())
+		Expect(tableExists("users")).To(BeFalse())
+		Expect(migrator.Version()).To(Equal(0))
+	})
+
+	It("should revert one migration at a time with Down", func() {
+		_, err := migrator.Up()
+		Expect(err).To(BeNil())
+
+		count, err := migrator.Down(1)
+		Expect(err).To(BeNil())
+		Expect(count).To(Equal(1))
+		Expect(migrator.Version()).To(Equal(migrator.Latest() - 1))
+	})
+
+	It("should produce a schema the SQL user repository can use", func() {
+		repo := repositories.NewUserRepository(openUserSQLite())
+		user := &models.User{UserName: "john_doe", Email: "john@example.com", FirstName: "John", LastName: "Doe", Status: "A", Department: "IT"}
+		Expect(repo.CreateUser(ctx, user)).To(Succeed())
+
+		found, err := repo.GetUserByID(ctx, user.ID, false)
+		Expect(err).To(BeNil())
+		Expect(found.UserName).To(Equal("john_doe"))
+		Expect(found.Version).To(Equal(1))
+
+		found.Department = "HR"
+		Expect(repo.UpdateUser(ctx, found)).To(Succeed())
+		Expect(found.Version).To(Equal(2))
+		Expect(repo.PatchUser(ctx, &models.User{ID: user.ID, UserName: "john_doe", Email: "john@example.com", FirstName: "John", LastName: "Doe", Status: "I", Department: "HR", Version: 1},
+			[]string{"status"})).To(MatchError(repositories.ErrVersionConflict))
+		Expect(repo.DeleteUser(ctx, user.ID, 2)).To(Succeed())
+		Expect(repo.DeleteUser(ctx, user.ID, 2)).To(MatchError(repositories.ErrUserNotFound))
+	})
+
+	It("should index user names case-insensitively", func() {
+		db := openUserSQLite()
+		var id, parent, unused int
+		var detail string
+		Expect(db.QueryRow("EXPLAIN QUERY PLAN SELECT id FROM users WHERE LOWER(user_name) = ?", "jdoe").
+			Scan(&id, &parent, &unused, &detail)).To(Succeed())
+		Expect(detail).To(ContainSubstring("users_user_name_lower"))
+	})
+
+	It("should rebuild users with a foreign key to departments, keeping their rows", func() {
+		_, err := migrator.Goto(13)
+		Expect(err).To(BeNil())
+		// Users created before departments were managed, and a purged one
+		users := repositories.NewUserRepository(db)
+		for _, userName := range []string{"ann", "bob", "cid"} {
+			user := &models.User{UserName: userName, Email: userName + "@example.com", FirstName: "F", LastName: "L", Status: "A", Department: "it"}
+			Expect(users.CreateUser(ctx, user)).To(Succeed())
+		}
+		Expect(users.DeleteUser(ctx, 3, 0)).To(Succeed())
+		Expect(users.PurgeUser(ctx, 3, 0)).To(Succeed())
+		_, err = db.Exec("INSERT INTO groups (name, created_at, updated_at) VALUES ('staff', ?, ?)", time.Now(), time.Now())
+		Expect(err).To(BeNil())
+		_, err = db.Exec("INSERT INTO user_groups (group_id, user_id, added_at) VALUES (1, 1, ?)", time.Now())
+		Expect(err).To(BeNil())
+
+		Expect(migrator.Up()).To(Equal(len(migrator.Migrations) - 13))
+		found, err := users.GetUserByID(ctx, 1, false)
+		Expect(err).To(BeNil())
+		Expect(found).To(And(HaveField("UserName", "ann"), HaveField("Department", "it"), HaveField("Version", 1)))
+		var members int
+		Expect(db.QueryRow("SELECT COUNT(*) FROM user_groups").Scan(&members)).To(Succeed())
+		Expect(members).To(Equal(1))
+		var foreignKeys bool
+		Expect(db.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys)).To(Succeed())
+		Expect(foreignKeys).To(BeTrue())
+
+		// New writes must refer to departments; IDs are not handed out again
+		dan := &models.User{UserName: "dan", Email: "dan@example.com", FirstName: "F", LastName: "L", Status: "A", Department: "it"}
+		Expect(users.CreateUser(ctx, dan)).NotTo(Succeed())
+		departments := repositories.NewDepartmentRepository(db)
+		Expect(departments.CreateDepartment(ctx, &models.Department{Code: "IT", Name: "IT"})).To(Succeed())
+		dan.Department = "IT"
+		Expect(users.CreateUser(ctx, dan)).To(Succeed())
+		Expect(dan.ID).To(Equal(4))
+
+		_, err = departments.MapUserDepartments(ctx, map[string]string{"it": "IT"})
+		Expect(err).To(BeNil())
+		Expect(departments.DeleteDepartment(ctx, "IT")).To(MatchError(repositories.ErrDepartmentInUse))
+
+		Expect(migrator.Down(1)).To(Equal(1))
+		Expect(db.QueryRow("SELECT COUNT(*) FROM users").Scan(&members)).To(Succeed())
+		Expect(members).To(Equal(3))
+	})
+
+	It("should start the outbox cursors after the events delivered so far", func() {
+		_, err := migrator.Goto(15)
+		Expect(err).To(BeNil())
+		Expect(repositories.NewDepartmentRepository(db).CreateDepartment(ctx, &models.Department{Code: "IT", Name: "IT"})).To(Succeed())
+		users := repositories.NewUserRepository(db)
+		for _, userName := range []string{"ann", "bob"} {
+			user := &models.User{UserName: userName, Email: userName + "@example.com", FirstName: "F", LastName: "L", Status: "A", Department: "IT"}
+			Expect(users.CreateUser(ctx, user)).To(Succeed())
+		}
+		_, err = db.Exec("UPDATE outbox SET delivered_at = ? WHERE id = 1", time.Now())
+		Expect(err).To(BeNil())
+
+		Expect(migrator.Up()).To(Equal(len(migrator.Migrations) - 15))
+		outbox := repositories.NewOutboxRepository(db)
+		for _, consumer := range []string{"webhooks", "events"} {
+			events, err := outbox.PendingEvents(ctx, consumer, 10)
+			Expect(err).To(BeNil())
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].User.UserName).To(Equal("bob"))
+		}
+
+		Expect(outbox.MarkDelivered(ctx, "webhooks", 2)).To(Succeed())
+		_, err = migrator.Goto(15)
+		Expect(err).To(BeNil())
+		var pending int
+		Expect(db.QueryRow("SELECT COUNT(*) FROM outbox WHERE delivered_at IS NULL").Scan(&pending)).To(Succeed())
+		Expect(pending).To(Equal(1))
+		Expect(tableExists("outbox_cursors")).To(BeFalse())
+	})
+
+	It("should fail to map departments while users refer to ones that do not exist", func() {
+		db = openMigratedSQLite()
+		_, err := db.Exec("PRAGMA foreign_keys = OFF")
+		Expect(err).To(BeNil())
+		users := repositories.NewUserRepository(db)
+		Expect(users.CreateUser(ctx, &models.User{UserName: "ann", Email: "ann@example.com", FirstName: "F", LastName: "L", Status: "A", Department: "it"})).To(Succeed())
+		_, err = db.Exec("PRAGMA foreign_keys = ON")
+		Expect(err).To(BeNil())
+
+		_, err = repositories.NewDepartmentRepository(db).MapUserDepartments(ctx, map[string]string{"other": "IT"})
+		Expect(err).To(MatchError(ContainSubstring("users 1 have a department that does not exist")))
+	})
+
+	It("should reject unknown versions", func() {
+		_, err := migrator.Goto(9999)
+		Expect(err).To(MatchError(migrations.ErrUnknownVersion))
+	})
+})
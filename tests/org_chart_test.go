@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"user-service/controllers"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Org chart", func() {
+
+	forEachStore(func(newStores func() testStores) {
+		var (
+			userService *services.UserService
+			// ceo <- cto <- (dev1 <- intern, dev2); ceo <- cfo
+			ceo, cto, cfo, dev1, dev2, intern *models.User
+		)
+
+		userNames := func(users []models.User) []string {
+			names := []string{}
+			for _, u := range users {
+				names = append(names, u.UserName)
+			}
+			return names
+		}
+
+		create := func(userName string, manager *models.User) *models.User {
+			user := newUser(userName)
+			if manager != nil {
+				user.ManagerID = &manager.ID
+			}
+			Expect(userService.CreateUser(ctx, user)).To(Succeed())
+			return user
+		}
+
+		reportTo := func(user, manager *models.User) error {
+			stored, err := userService.GetUser(ctx, user.ID, false)
+			Expect(err).To(BeNil())
+			stored.ManagerID = &manager.ID
+			return userService.UpdateUser(ctx, stored)
+		}
+
+		BeforeEach(func() {
+			userService = services.NewUserService(newStores().Users)
+			ceo = create("ceo", nil)
+			cto = create("cto", ceo)
+			cfo = create("cfo", ceo)
+			dev1 = create("dev1", cto)
+			dev2 = create("dev2", cto)
+			intern = create("intern", dev1)
+		})
+
+		It("should return the reporting chain, direct reports and subtree", func() {
+			chain, err := userService.ReportingChain(ctx, intern.ID)
+			Expect(err).To(BeNil())
+			Expect(userNames(chain)).To(Equal([]string{"dev1", "cto", "ceo"}))
+
+			reports, err := userService.DirectReports(ctx, ceo.ID)
+			Expect(err).To(BeNil())
+			Expect(userNames(reports)).To(Equal([]string{"cto", "cfo"}))
+
+			subtree, err := userService.Repo.Subtree(ctx, ceo.ID)
+			Expect(err).To(BeNil())
+			Expect(userNames(subtree)).To(Equal([]string{"cto", "cfo", "dev1", "dev2", "intern"}))
+
+			node, err := userService.Subtree(ctx, cto.ID)
+			Expect(err).To(BeNil())
+			Expect(node.UserName).To(Equal("cto"))
+			Expect(node.Reports).To(HaveLen(2))
+			Expect(node.Reports[0].UserName).To(Equal("dev1"))
+			Expect(node.Reports[0].Reports[0].UserName).To(Equal("intern"))
+
+			_, err = userService.ReportingChain(ctx, 99)
+			Expect(err).To(MatchError(repositories.ErrUserNotFound))
+		})
+
+		It("should reject unknown managers and cycles", func() {
+			missing := 99
+			user := &models.User{UserName: "x", Email: "x@example.com", FirstName: "F", LastName: "L", Status: "A", Department: "IT", ManagerID: &missing}
+			Expect(userService.CreateUser(ctx, user)).To(MatchError(repositories.ErrUnknownManager))
+
+			Expect(reportTo(ceo, ceo)).To(MatchError(repositories.ErrManagerCycle))
+			Expect(reportTo(ceo, intern)).To(MatchError(repositories.ErrManagerCycle))
+			Expect(reportTo(cto, cfo)).To(Succeed())
+
+			// Deleted users still count, since they can be restored.
+			Expect(userService.DeleteUser(ctx, dev1.ID, 0)).To(Succeed())
+			Expect(reportTo(cfo, intern)).To(MatchError(repositories.ErrManagerCycle))
+			Expect(reportTo(dev2, dev1)).To(MatchError(repositories.ErrUnknownManager))
+		})
+
+		It("should leave out deleted users and detach the reports of purged ones", func() {
+			Expect(userService.DeleteUser(ctx, cto.ID, 0)).To(Succeed())
+
+			chain, err := userService.ReportingChain(ctx, intern.ID)
+			Expect(err).To(BeNil())
+			Expect(userNames(chain)).To(Equal([]string{"dev1"}))
+			subtree, err := userService.Repo.Subtree(ctx, ceo.ID)
+			Expect(err).To(BeNil())
+			Expect(userNames(subtree)).To(Equal([]string{"cfo"}))
+
+			roots, err := userService.OrgChart(ctx)
+			Expect(err).To(BeNil())
+			Expect(roots).To(HaveLen(3))
+			Expect(roots[0].UserName).To(Equal("ceo"))
+			Expect(roots[1].UserName).To(Equal("dev1"))
+			Expect(roots[2].UserName).To(Equal("dev2"))
+
+			Expect(userService.PurgeUser(ctx, cto.ID)).To(Succeed())
+			stored, err := userService.GetUser(ctx, dev1.ID, false)
+			Expect(err).To(BeNil())
+			Expect(stored.ManagerID).To(BeNil())
+			Expect(stored.Version).To(Equal(2))
+			page, err := userService.ListAuditEvents(ctx, models.AuditListParams{UserID: dev1.ID, Field: "manager_id"})
+			Expect(err).To(BeNil())
+			Expect(page.Total).To(Equal(2))
+		})
+	})
+
+	Context("over data holding a cycle", func() {
+		It("should stop at the users already visited", func() {
+			db := openUserSQLite()
+			DeferCleanup(db.Close)
+			userService := services.NewUserService(newSQLiteStores(db).Users)
+			create := func(userName string, manager *models.User) *models.User {
+				user := newUser(userName)
+				if manager != nil {
+					user.ManagerID = &manager.ID
+				}
+				Expect(userService.CreateUser(ctx, user)).To(Succeed())
+				return user
+			}
+			ceo := create("ceo", nil)
+			cto := create("cto", ceo)
+			dev := create("dev", cto)
+			intern := create("intern", dev)
+			// Only data written around the checks can hold a cycle.
+			_, err := db.Exec("UPDATE users SET manager_id = ? WHERE id = ?", intern.ID, ceo.ID)
+			Expect(err).To(BeNil())
+
+			chain, err := userService.ReportingChain(ctx, dev.ID)
+			Expect(err).To(BeNil())
+			Expect(chain).To(HaveLen(3))
+			Expect(chain[0].UserName).To(Equal("cto"))
+			Expect(chain[2].UserName).To(Equal("intern"))
+
+			node, err := userService.Subtree(ctx, cto.ID)
+			Expect(err).To(BeNil())
+			Expect(node.UserName).To(Equal("cto"))
+			Expect(node.Reports[0].UserName).To(Equal("dev"))
+			Expect(node.Reports[0].Reports[0].UserName).To(Equal("intern"))
+			Expect(node.Reports[0].Reports[0].Reports[0].UserName).To(Equal("ceo"))
+			Expect(node.Reports[0].Reports[0].Reports[0].Reports).To(BeEmpty())
+		})
+	})
+
+	Context("over HTTP", func() {
+		var e *echo.Echo
+
+		BeforeEach(func() {
+			userService := services.NewUserService(repositories.NewMemoryUserRepository())
+			manager := &models.User{UserName: "ann", Email: "ann@example.com", FirstName: "Ann", LastName: `O"Neil`, Status: "A", Department: "IT"}
+			Expect(userService.CreateUser(ctx, manager)).To(Succeed())
+			Expect(userService.CreateUser(ctx, &models.User{UserName: "bob", Email: "bob@example.com", FirstName: "Bob", LastName: "B", Status: "A", Department: "IT", ManagerID: &manager.ID})).To(Succeed())
+
+			e = echo.New()
+			e.HTTPErrorHandler = controllers.ErrorHandler
+			e.GET("/users/:id/chain", controllers.GetReportingChain(userService))
+			e.GET("/users/:id/reports", controllers.GetDirectReports(userService))
+			e.GET("/users/:id/subtree", controllers.GetSubtree(userService))
+			e.GET("/org-chart", controllers.GetOrgChart(userService))
+		})
+
+		request := func(target string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+			return rec
+		}
+
+		It("should serve the chain, reports and subtree", func() {
+			rec := request("/users/2/chain")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"user_name":"ann"`))
+
+			rec = request("/users/1/reports")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"manager_id":1`))
+
+			rec = request("/users/1/subtree")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			var node models.OrgNode
+			Expect(json.Unmarshal(rec.Body.Bytes(), &node)).To(Succeed())
+			Expect(node.UserName).To(Equal("ann"))
+			Expect(node.Reports).To(HaveLen(1))
+
+			Expect(request("/users/9/subtree").Code).To(Equal(http.StatusNotFound))
+			Expect(request("/users/x/reports").Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should export the org chart as JSON and DOT", func() {
+			rec := request("/org-chart")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			var roots []models.OrgNode
+			Expect(json.Unmarshal(rec.Body.Bytes(), &roots)).To(Succeed())
+			Expect(roots).To(HaveLen(1))
+			Expect(roots[0].Reports[0].UserName).To(Equal("bob"))
+
+			rec = request("/org-chart?format=dot")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get(echo.HeaderContentType)).To(Equal("text/vnd.graphviz"))
+			Expect(rec.Body.String()).To(Equal("digraph org {\n" +
+				"\tnode [shape=box];\n" +
+				"\tu1 [label=\"Ann O\\\"Neil\\nann\"];\n" +
+				"\tu1 -> u2;\n" +
+				"\tu2 [label=\"Bob B\\nbob\"];\n" +
+				"}\n"))
+
+			Expect(request("/org-chart?format=svg").Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
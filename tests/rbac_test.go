@@ -0,0 +1,205 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"user-service/auth"
+	"user-service/controllers"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Role-based authorization", func() {
+	var (
+		e           *echo.Echo
+		userService *services.UserService
+		roleService *services.RoleService
+		keys        map[string]string
+		itUser      *models.User
+		hrUser      *models.User
+	)
+
+	BeforeEach(func() {
+		apiKeyService := services.NewAPIKeyService(repositories.NewMemoryAPIKeyRepository())
+		roleService = services.NewRoleService(repositories.NewRoleRepository(openMigratedSQLite()))
+		userService = services.NewUserService(repositories.NewMemoryUserRepository())
+
+		keys = map[string]string{}
+		for _, a := range []models.RoleAssignment{
+			{Subject: "vic", Role: models.RoleViewer},
+			{Subject: "eve", Role: models.RoleEditor, Department: "IT"},
+			{Subject: "hal", Role: models.RoleHRAdmin},
+			{Subject: "ada", Role: models.RoleAuditor},
+			{Subject: "root", Role: models.RoleAdmin},
+			{Subject: "nobody"},
+		} {
+			if a.Role != "" {
+				Expect(roleService.AssignRole(ctx, &a)).To(Succeed())
+			}
+			key, err := apiKeyService.CreateAPIKey(ctx, a.Subject, "test")
+			Expect(err).To(BeNil())
+			keys[a.Subject] = key.Key
+		}
+
+		itUser = &models.User{UserName: "it_user", Email: "it@example.com", FirstName: "I", LastName: "T", Status: "A", Department: "IT"}
+		hrUser = &models.User{UserName: "hr_user", Email: "hr@example.com", FirstName: "H", LastName: "R", Status: "A", Department: "HR"}
+		Expect(userService.CreateUser(ctx, itUser)).To(Succeed())
+		Expect(userService.CreateUser(ctx, hrUser)).To(Succeed())
+
+		e = echo.New()
+		e.HTTPErrorHandler = controllers.ErrorHandler
+		api := e.Group("", auth.Middleware(&auth.Authenticator{APIKeys: apiKeyService.Repo, Roles: roleService.Repo}))
+		api.GET("/users", controllers.GetUsers(userService), auth.Require(auth.PermReadUsers))
+		api.POST("/users", controllers.CreateUser(userService), auth.Require(auth.PermWriteUsers))
+		api.PUT("/users/:id", controllers.UpdateUser(userService), auth.Require(auth.PermWriteUsers))
+		api.PATCH("/users/:id", controllers.PatchUser(userService), auth.Require(auth.PermWriteUsers))
+		api.DELETE("/users/:id", controllers.DeleteUser(userService), auth.Require(auth.PermWriteUsers))
+		api.DELETE("/admin/users/:id", controllers.PurgeUser(userService), auth.Require(auth.PermPurgeUsers))
+		api.POST("/users/:id/activate", controllers.ActivateUser(userService), auth.Require(auth.PermWriteUsers))
+		api.POST("/users/:id/terminate", controllers.TerminateUser(userService), auth.Require(auth.PermWriteUsers))
+		api.GET("/audit", controllers.GetAuditEvents(userService), auth.Require(auth.PermReadAudit))
+		api.GET("/admin/roles", controllers.ListRoleAssignments(roleService), auth.Require(auth.PermManageRoles))
+		api.POST("/admin/roles", controllers.AssignRole(roleService), auth.Require(auth.PermManageRoles))
+	})
+
+	request := func(subject, method, target, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, contentType)
+		req.Header.Set(auth.HeaderAPIKey, keys[subject])
+		req.Header.Set("If-Match", "*")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	userJSON := func(userName, department string) string {
+		return fmt.Sprintf(`{"user_name":%q,"email":"%s@example.com","first_name":"A","last_name":"B","status":"A","department":%q}`,
+			userName, userName, department)
+	}
+
+	It("should allow each role only the routes it is granted", func() {
+		Expect(request("vic", http.MethodGet, "/users", "", "").Code).To(Equal(http.StatusOK))
+		Expect(request("ada", http.MethodGet, "/audit", "", "").Code).To(Equal(http.StatusOK))
+		Expect(request("hal", http.MethodGet, "/audit", "", "").Code).To(Equal(http.StatusOK))
+
+		for _, denied := range []struct{ subject, method, target string }{
+			{"nobody", http.MethodGet, "/users"},
+			{"vic", http.MethodPost, "/users"},
+			{"vic", http.MethodGet, "/audit"},
+			{"eve", http.MethodGet, "/audit"},
+			{"ada", http.MethodDelete, "/users/1"},
+			{"eve", http.MethodDelete, "/admin/users/1"},
+			{"hal", http.MethodGet, "/admin/roles"},
+		} {
+			rec := request(denied.subject, denied.method, denied.target, echo.MIMEApplicationJSON, userJSON("x", "IT"))
+			Expect(rec.Code).To(Equal(http.StatusForbidden), "%s %s by %s", denied.method, denied.target, denied.subject)
+			problem := problemOf(rec)
+			Expect(problem.Title).To(Equal("Forbidden"))
+			Expect(problem.Detail).To(HavePrefix("permission "))
+		}
+	})
+
+	It("should limit editors to users of their own department", func() {
+		Expect(request("eve", http.MethodPost, "/users", echo.MIMEApplicationJSON, userJSON("new_it", "IT")).Code).To(Equal(http.StatusCreated))
+		Expect(request("eve", http.MethodPatch, fmt.Sprintf("/users/%d", itUser.ID), "application/merge-patch+json", `{"status":"I"}`).Code).To(Equal(http.StatusOK))
+
+		for _, rec := range []*httptest.ResponseRecorder{
+			request("eve", http.MethodPost, "/users", echo.MIMEApplicationJSON, userJSON("new_hr", "HR")),
+			request("eve", http.MethodPut, fmt.Sprintf("/users/%d", hrUser.ID), echo.MIMEApplicationJSON, userJSON("hr_user", "IT")),
+			request("eve", http.MethodPut, fmt.Sprintf("/users/%d", itUser.ID), echo.MIMEApplicationJSON, userJSON("it_user", "HR")),
+			request("eve", http.MethodPatch, fmt.Sprintf("/users/%d", itUser.ID), "application/merge-patch+json", `{"department":"HR"}`),
+			request("eve", http.MethodDelete, fmt.Sprintf("/users/%d", hrUser.ID), "", ""),
+		} {
+			Expect(rec.Code).To(Equal(http.StatusForbidden))
+			Expect(problemOf(rec).Detail).To(ContainSubstring("department"))
+		}
+
+		user, err := userService.GetUser(ctx, hrUser.ID, false)
+		Expect(err).To(BeNil())
+		Expect(user.Department).To(Equal("HR"))
+		user, err = userService.GetUser(ctx, itUser.ID, false)
+		Expect(err).To(BeNil())
+		Expect(user.Department).To(Equal("IT"))
+
+		Expect(request("hal", http.MethodDelete, fmt.Sprintf("/users/%d", hrUser.ID), "", "").Code).To(Equal(http.StatusNoContent))
+		Expect(request("hal", http.MethodDelete, fmt.Sprintf("/admin/users/%d", hrUser.ID), "", "").Code).To(Equal(http.StatusNoContent))
+	})
+
+	It("should not write users moved out of an editor's department after the check", func() {
+		editor := &auth.Principal{Subject: "eve", Roles: []models.RoleAssignment{{Subject: "eve", Role: models.RoleEditor, Department: "IT"}}}
+		guard := editor.Guard(auth.PermWriteUsers)
+		// racing returns eve's service, with which the user is moved to HR
+		// right after the first check lets it pass
+		racing := func() *services.UserService {
+			moved := false
+			return userService.WithGuard(func(user *models.User) error {
+				if err := guard(user); err != nil || moved {
+					return err
+				}
+				moved = true
+				hr := *user
+				hr.Department = "HR"
+				return userService.UpdateUser(ctx, &hr)
+			})
+		}
+
+		Expect(racing().DeleteUser(ctx, itUser.ID, 0)).To(MatchError(repositories.ErrVersionConflict))
+		stored, err := userService.GetUser(ctx, itUser.ID, false)
+		Expect(err).To(BeNil())
+		Expect(stored.Department).To(Equal("HR"))
+
+		stored.Department = "IT"
+		Expect(userService.UpdateUser(ctx, stored)).To(Succeed())
+		update := *stored
+		update.FirstName = "Changed"
+		update.Version = 0
+		Expect(racing().UpdateUser(ctx, &update)).To(MatchError(repositories.ErrVersionConflict))
+		stored, err = userService.GetUser(ctx, itUser.ID, false)
+		Expect(err).To(BeNil())
+		Expect(stored.Department).To(Equal("HR"))
+		Expect(stored.FirstName).To(Equal("I"))
+	})
+
+	It("should require an override permission to reactivate terminated users", func() {
+		target := fmt.Sprintf("/users/%d/", itUser.ID)
+		Expect(request("eve", http.MethodPost, target+"terminate", "", "").Code).To(Equal(http.StatusOK))
+		override := `{"override":true,"reason":"rehired"}`
+		Expect(request("eve", http.MethodPost, target+"activate", "", "").Code).To(Equal(http.StatusConflict))
+		rec := request("eve", http.MethodPost, target+"activate", echo.MIMEApplicationJSON, override)
+		Expect(rec.Code).To(Equal(http.StatusForbidden))
+		Expect(problemOf(rec).Detail).To(Equal("permission users:override-status required"))
+		Expect(request("hal", http.MethodPost, target+"activate", echo.MIMEApplicationJSON, override).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should manage role assignments stored in the database", func() {
+		rec := request("root", http.MethodPost, "/admin/roles", echo.MIMEApplicationJSON, `{"subject":"vic","role":"auditor"}`)
+		Expect(rec.Code).To(Equal(http.StatusCreated))
+		Expect(request("vic", http.MethodGet, "/audit", "", "").Code).To(Equal(http.StatusOK))
+
+		rec = request("root", http.MethodPost, "/admin/roles", echo.MIMEApplicationJSON, `{"subject":"vic","role":"auditor"}`)
+		Expect(rec.Code).To(Equal(http.StatusConflict))
+
+		rec = request("root", http.MethodPost, "/admin/roles", echo.MIMEApplicationJSON, `{"subject":"vic","role":"editor"}`)
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		Expect(problemOf(rec).Errors).To(ConsistOf(HaveField("Field", "department")))
+
+		rec = request("root", http.MethodPost, "/admin/roles", echo.MIMEApplicationJSON, `{"subject":"vic","role":"superuser"}`)
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		Expect(problemOf(rec).Errors).To(ConsistOf(HaveField("Field", "role")))
+
+		assignments, err := roleService.ListRoleAssignments(ctx, "vic")
+		Expect(err).To(BeNil())
+		Expect(assignments).To(HaveLen(2))
+		Expect(roleService.UnassignRole(ctx, assignments[1].ID)).To(Succeed())
+		Expect(request("vic", http.MethodGet, "/audit", "", "").Code).To(Equal(http.StatusForbidden))
+		Expect(roleService.UnassignRole(ctx, assignments[1].ID)).To(MatchError(repositories.ErrRoleAssignmentNotFound))
+	})
+})
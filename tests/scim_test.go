@@ -0,0 +1,491 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"user-service/auth"
+	"user-service/controllers"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/scim"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SCIM", func() {
+	Context("filters", func() {
+		resource := map[string]interface{}{
+			"userName": "bjensen",
+			"name":     map[string]interface{}{"givenName": "Barbara", "familyName": "Jensen"},
+			"emails": []interface{}{
+				map[string]interface{}{"value": "bjensen@example.com", "type": "work"},
+				map[string]interface{}{"value": "babs@home.example", "type": "home"},
+			},
+			"active":                  true,
+			"meta":                    map[string]interface{}{"version": `W/"3"`},
+			scim.SchemaEnterpriseUser: map[string]interface{}{"department": "IT"},
+		}
+
+		DescribeTable("should match resources",
+			func(filter string, matches bool) {
+				f, err := scim.ParseFilter(filter)
+				Expect(err).To(BeNil())
+				Expect(f.Match(resource)).To(Equal(matches))
+			},
+			Entry(nil, `userName eq "bjensen"`, true),
+			Entry(nil, `UserName EQ "BJensen"`, true),
+			Entry(nil, `userName ne "bjensen"`, false),
+			Entry(nil, `userName sw "bj" and name.familyName co "ens"`, true),
+			Entry(nil, `userName ew "x" or name.givenName eq "barbara"`, true),
+			Entry(nil, `not (active eq true)`, false),
+			Entry(nil, `active eq false`, false),
+			Entry(nil, `emails co "home.example"`, true),
+			Entry(nil, `emails[type eq "work" and value ew "example.com"]`, true),
+			Entry(nil, `emails[type eq "other"]`, false),
+			Entry(nil, `title pr`, false),
+			Entry(nil, `name.givenName pr`, true),
+			Entry(nil, `userName gt "a" and userName lt "c"`, true),
+			Entry(nil, `urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bjensen"`, true),
+			Entry(nil, `urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department eq "IT"`, true),
+			Entry(nil, `(userName eq "x" or userName eq "bjensen") and active eq true`, true),
+		)
+
+		DescribeTable("should reject invalid filters",
+			func(filter string) {
+				_, err := scim.ParseFilter(filter)
+				Expect(err).To(MatchError(scim.ErrInvalidFilter))
+				Expect(scim.TypeOf(err)).To(Equal("invalidFilter"))
+			},
+			Entry(nil, ``),
+			Entry(nil, `userName`),
+			Entry(nil, `userName eq`),
+			Entry(nil, `userName is "x"`),
+			Entry(nil, `userName eq "x`),
+			Entry(nil, `(userName eq "x"`),
+			Entry(nil, `userName eq "x" bogus`),
+			Entry(nil, `emails[type eq "work"`),
+			Entry(nil, `urn:example:foo eq "x"`),
+		)
+	})
+
+	DescribeTable("should find the value a filter requires of an attribute",
+		func(filter, attribute, value string, ok bool) {
+			f, err := scim.ParseFilter(filter)
+			Expect(err).To(BeNil())
+			v, found := scim.EqualValue(f, attribute)
+			Expect(found).To(Equal(ok))
+			Expect(v).To(Equal(value))
+		},
+		Entry(nil, `userName eq "bjensen"`, "userName", "bjensen", true),
+		Entry(nil, `USERNAME eq "bjensen"`, "userName", "bjensen", true),
+		Entry(nil, `urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bjensen"`, "userName", "bjensen", true),
+		Entry(nil, `active eq true and id eq "5"`, "id", "5", true),
+		Entry(nil, `userName eq "bjensen"`, "id", "", false),
+		Entry(nil, `userName sw "bj"`, "userName", "", false),
+		Entry(nil, `userName eq "x" or userName eq "y"`, "userName", "", false),
+		Entry(nil, `not (userName eq "x")`, "userName", "", false),
+		Entry(nil, `id eq 5`, "id", "", false),
+	)
+
+	DescribeTable("should tell whether a filter refers to an attribute",
+		func(filter string, refers bool) {
+			f, err := scim.ParseFilter(filter)
+			Expect(err).To(BeNil())
+			Expect(scim.Refers(f, "members")).To(Equal(refers))
+		},
+		Entry(nil, `displayName eq "admins"`, false),
+		Entry(nil, `members pr`, true),
+		Entry(nil, `members.value eq "1"`, true),
+		Entry(nil, `displayName eq "admins" or not (members[value eq "1"])`, true),
+	)
+
+	forEachStore(func(newStores func() testStores) {
+		var service *services.SCIMService
+
+		BeforeEach(func() {
+			stores := newStores()
+			userService := services.NewUserService(stores.Users)
+			groupService := services.NewGroupService(stores.Groups)
+			service = services.NewSCIMService(userService, groupService)
+			for _, userName := range []string{"ann", "BJensen", "bjensen_x"} {
+				Expect(userService.CreateUser(ctx, newUser(userName))).To(Succeed())
+			}
+			for i, name := range []string{"admins", "devs", "ops"} {
+				group := &models.Group{Name: name}
+				Expect(groupService.CreateGroup(ctx, group)).To(Succeed())
+				Expect(groupService.AddMember(ctx, group.ID, i+1)).To(Succeed())
+			}
+		})
+
+		userNames := func(page *scim.ListResponse) []string {
+			var names []string
+			for _, resource := range page.Resources {
+				names = append(names, resource.(*scim.User).UserName)
+			}
+			return names
+		}
+
+		It("should look users up by userName and id", func() {
+			page, err := service.ListUsers(ctx, `userName eq "bjensen"`, 1, 10)
+			Expect(err).To(BeNil())
+			Expect(userNames(page)).To(Equal([]string{"BJensen"}))
+
+			page, err = service.ListUsers(ctx, `userName eq "bjensen" and active eq false`, 1, 10)
+			Expect(err).To(BeNil())
+			Expect(page.TotalResults).To(Equal(0))
+
+			page, err = service.ListUsers(ctx, `id eq "3" or userName eq "ann"`, 1, 10)
+			Expect(err).To(BeNil())
+			Expect(userNames(page)).To(Equal([]string{"ann", "bjensen_x"}))
+
+			page, err = service.ListUsers(ctx, `id eq "3"`, 1, 10)
+			Expect(err).To(BeNil())
+			Expect(userNames(page)).To(Equal([]string{"bjensen_x"}))
+
+			page, err = service.ListUsers(ctx, `id eq "x"`, 1, 10)
+			Expect(err).To(BeNil())
+			Expect(page.TotalResults).To(Equal(0))
+			Expect(page.Resources).To(BeEmpty())
+		})
+
+		It("should leave paging to the store without a filter or with a lone lookup", func() {
+			stores := newStores()
+			userService := services.NewUserService(unstreamedUsers{stores.Users})
+			service := services.NewSCIMService(userService, nil)
+			for _, userName := range []string{"ann", "bob", "cy", "dee"} {
+				Expect(userService.CreateUser(ctx, newUser(userName))).To(Succeed())
+			}
+			Expect(userService.DeleteUser(ctx, 2, 0)).To(Succeed())
+
+			page, err := service.ListUsers(ctx, "", 2, 1)
+			Expect(err).To(BeNil())
+			Expect(page.TotalResults).To(Equal(3))
+			Expect(page.StartIndex).To(Equal(2))
+			Expect(userNames(page)).To(Equal([]string{"cy"}))
+
+			page, err = service.ListUsers(ctx, "", 3, 5)
+			Expect(err).To(BeNil())
+			Expect(page.TotalResults).To(Equal(3))
+			Expect(userNames(page)).To(Equal([]string{"dee"}))
+
+			page, err = service.ListUsers(ctx, "", 0, 0)
+			Expect(err).To(BeNil())
+			Expect(page.TotalResults).To(Equal(3))
+			Expect(page.StartIndex).To(Equal(1))
+			Expect(page.Resources).To(BeEmpty())
+
+			page, err = service.ListUsers(ctx, `userName eq "CY"`, 1, 10)
+			Expect(err).To(BeNil())
+			Expect(page.TotalResults).To(Equal(1))
+			Expect(userNames(page)).To(Equal([]string{"cy"}))
+
+			page, err = service.ListUsers(ctx, `id eq "4"`, 1, 10)
+			Expect(err).To(BeNil())
+			Expect(userNames(page)).To(Equal([]string{"dee"}))
+
+			_, err = service.ListUsers(ctx, `userName eq "cy" and active eq true`, 1, 10)
+			Expect(err).To(MatchError(errUnstreamed))
+		})
+
+		It("should list groups with their members", func() {
+			page, err := service.ListGroups(ctx, "", 2, 1)
+			Expect(err).To(BeNil())
+			Expect(page.TotalResults).To(Equal(3))
+			Expect(page.Resources).To(HaveLen(1))
+			group := page.Resources[0].(*scim.Group)
+			Expect(group.DisplayName).To(Equal("devs"))
+			Expect(group.Members).To(ConsistOf(HaveField("Display", "BJensen")))
+
+			page, err = service.ListGroups(ctx, `members[value eq "3"]`, 1, 10)
+			Expect(err).To(BeNil())
+			Expect(page.Resources).To(HaveLen(1))
+			Expect(page.Resources[0].(*scim.Group).DisplayName).To(Equal("ops"))
+		})
+	})
+
+	Context("patches", func() {
+		active := true
+		user := func() *scim.User {
+			return &scim.User{
+				Schemas:    []string{scim.SchemaUser},
+				UserName:   "bjensen",
+				Name:       &scim.Name{GivenName: "Barbara", FamilyName: "Jensen"},
+				Emails:     []scim.MultiValue{{Value: "bjensen@example.com", Type: "work", Primary: true}},
+				Active:     &active,
+				Enterprise: &scim.EnterpriseUser{Department: "IT"},
+			}
+		}
+		patch := func(ops ...scim.PatchOperation) (*scim.User, error) {
+			return scim.PatchUser(user(), scim.PatchRequest{Schemas: []string{scim.SchemaPatchOp}, Operations: ops})
+		}
+
+		It("should apply operations with and without paths", func() {
+			patched, err := patch(
+				scim.PatchOperation{Op: "Replace", Path: "name.givenName", Value: "Babs"},
+				scim.PatchOperation{Op: "replace", Path: `emails[type eq "work"].value`, Value: "babs@example.com"},
+				scim.PatchOperation{Op: "add", Path: "emails", Value: []interface{}{map[string]interface{}{"value": "b@home.example", "type": "home"}}},
+				scim.PatchOperation{Op: "replace", Value: map[string]interface{}{
+					"active":                  "False",
+					scim.SchemaEnterpriseUser: map[string]interface{}{"department": "HR"},
+				}},
+			)
+			Expect(err).To(BeNil())
+			Expect(patched.Name.GivenName).To(Equal("Babs"))
+			Expect(patched.Emails).To(Equal([]scim.MultiValue{
+				{Value: "babs@example.com", Type: "work", Primary: true},
+				{Value: "b@home.example", Type: "home"},
+			}))
+			Expect(*patched.Active).To(BeFalse())
+			Expect(patched.Enterprise.Department).To(Equal("HR"))
+			Expect(patched.UserName).To(Equal("bjensen"))
+
+			patched, err = patch(
+				scim.PatchOperation{Op: "remove", Path: `emails[type eq "work"]`},
+				scim.PatchOperation{Op: "add", Path: `emails[type eq "other"].value`, Value: "o@example.com"},
+				scim.PatchOperation{Op: "add", Path: scim.SchemaEnterpriseUser + ":manager.value", Value: "7"},
+			)
+			Expect(err).To(BeNil())
+			Expect(patched.Emails).To(Equal([]scim.MultiValue{{Value: "o@example.com", Type: "other"}}))
+			Expect(patched.Enterprise.Manager.Value).To(Equal("7"))
+		})
+
+		It("should reject invalid operations", func() {
+			_, err := patch(scim.PatchOperation{Op: "move", Path: "userName"})
+			Expect(scim.TypeOf(err)).To(Equal("invalidSyntax"))
+			_, err = patch(scim.PatchOperation{Op: "remove"})
+			Expect(scim.TypeOf(err)).To(Equal("noTarget"))
+			_, err = patch(scim.PatchOperation{Op: "remove", Path: `emails[type eq "home"]`})
+			Expect(scim.TypeOf(err)).To(Equal("noTarget"))
+			_, err = patch(scim.PatchOperation{Op: "replace", Path: `emails[type eq ]`, Value: "x"})
+			Expect(scim.TypeOf(err)).To(Equal("invalidPath"))
+			_, err = patch(scim.PatchOperation{Op: "replace", Path: "userName.first", Value: "x"})
+			Expect(scim.TypeOf(err)).To(Equal("invalidPath"))
+			_, err = patch(scim.PatchOperation{Op: "replace", Path: "active", Value: "maybe"})
+			Expect(scim.TypeOf(err)).To(Equal("invalidValue"))
+			_, err = scim.PatchUser(user(), scim.PatchRequest{Schemas: []string{scim.SchemaUser}, Operations: []scim.PatchOperation{{Op: "add"}}})
+			Expect(scim.TypeOf(err)).To(Equal("invalidSyntax"))
+		})
+	})
+
+	Context("over HTTP", func() {
+		var (
+			e    *echo.Echo
+			keys map[string]string
+		)
+
+		BeforeEach(func() {
+			apiKeyService := services.NewAPIKeyService(repositories.NewMemoryAPIKeyRepository())
+			roleService := services.NewRoleService(repositories.NewMemoryRoleRepository())
+			keys = map[string]string{}
+			for _, a := range []models.RoleAssignment{
+				{Subject: "vic", Role: models.RoleViewer},
+				{Subject: "root", Role: models.RoleAdmin},
+			} {
+				Expect(roleService.AssignRole(ctx, &a)).To(Succeed())
+				key, err := apiKeyService.CreateAPIKey(ctx, a.Subject, "test")
+				Expect(err).To(BeNil())
+				keys[a.Subject] = key.Key
+			}
+
+			users := repositories.NewMemoryUserRepository()
+			userService := services.NewUserService(users)
+			groupService := services.NewGroupService(repositories.NewMemoryGroupRepository(users))
+			service := services.NewSCIMService(userService, groupService)
+			Expect(userService.CreateUser(ctx, &models.User{UserName: "ann", Email: "ann@example.com", FirstName: "Ann", LastName: "Lee", Status: "A", Department: "IT"})).To(Succeed())
+
+			e = echo.New()
+			e.HTTPErrorHandler = controllers.ErrorHandler
+			api := e.Group("/scim/v2", controllers.SCIMErrors, auth.Middleware(&auth.Authenticator{APIKeys: apiKeyService.Repo, Roles: roleService.Repo}))
+			api.GET("/ServiceProviderConfig", controllers.GetSCIMServiceProviderConfig)
+			api.GET("/Schemas", controllers.GetSCIMSchemas)
+			api.GET("/Schemas/:id", controllers.GetSCIMSchema)
+			api.GET("/ResourceTypes", controllers.GetSCIMResourceTypes)
+			api.GET("/Users", controllers.GetSCIMUsers(service), auth.Require(auth.PermReadUsers))
+			api.POST("/Users", controllers.CreateSCIMUser(service), auth.Require(auth.PermWriteUsers))
+			api.GET("/Users/:id", controllers.GetSCIMUser(service), auth.Require(auth.PermReadUsers))
+			api.PUT("/Users/:id", controllers.ReplaceSCIMUser(service), auth.Require(auth.PermWriteUsers))
+			api.PATCH("/Users/:id", controllers.PatchSCIMUser(service), auth.Require(auth.PermWriteUsers))
+			api.DELETE("/Users/:id", controllers.DeleteSCIMUser(service), auth.Require(auth.PermWriteUsers))
+			api.GET("/Groups", controllers.GetSCIMGroups(service), auth.Require(auth.PermReadUsers))
+			api.POST("/Groups", controllers.CreateSCIMGroup(service), auth.Require(auth.PermManageGroups))
+			api.GET("/Groups/:id", controllers.GetSCIMGroup(service), auth.Require(auth.PermReadUsers))
+			api.PATCH("/Groups/:id", controllers.PatchSCIMGroup(service), auth.Require(auth.PermManageGroups))
+			api.DELETE("/Groups/:id", controllers.DeleteSCIMGroup(service), auth.Require(auth.PermManageGroups))
+		})
+
+		request := func(subject, method, target, ifMatch, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, target, strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, scim.MediaType)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+keys[subject])
+			if ifMatch != "" {
+				req.Header.Set("If-Match", ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec
+		}
+
+		scimError := func(rec *httptest.ResponseRecorder) scim.Error {
+			Expect(rec.Header().Get(echo.HeaderContentType)).To(Equal(scim.MediaType))
+			var body scim.Error
+			Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+			Expect(body.Schemas).To(Equal([]string{scim.SchemaError}))
+			return body
+		}
+
+		list := func(target string) scim.ListResponse {
+			rec := request("vic", http.MethodGet, target, "", "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			var page scim.ListResponse
+			Expect(json.Unmarshal(rec.Body.Bytes(), &page)).To(Succeed())
+			return page
+		}
+
+		It("should provision users", func() {
+			rec := request("root", http.MethodPost, "/scim/v2/Users", "", `{
+				"schemas": ["`+scim.SchemaUser+`", "`+scim.SchemaEnterpriseUser+`"],
+				"userName": "bjensen",
+				"name": {"givenName": "Barbara", "familyName": "Jensen"},
+				"emails": [{"value": "bjensen@example.com", "type": "work", "primary": true}],
+				"`+scim.SchemaEnterpriseUser+`": {"department": "HR", "manager": {"value": "1"}}
+			}`)
+			Expect(rec.Code).To(Equal(http.StatusCreated))
+			Expect(rec.Header().Get(echo.HeaderContentType)).To(Equal(scim.MediaType))
+			Expect(rec.Header().Get(echo.HeaderLocation)).To(Equal("/scim/v2/Users/2"))
+			Expect(rec.Header().Get("ETag")).To(Equal(`W/"1"`))
+			var created scim.User
+			Expect(json.Unmarshal(rec.Body.Bytes(), &created)).To(Succeed())
+			Expect(created.ID).To(Equal("2"))
+			Expect(*created.Active).To(BeTrue())
+			Expect(created.Name.Formatted).To(Equal("Barbara Jensen"))
+			Expect(created.Enterprise.Manager.Value).To(Equal("1"))
+
+			page := list("/scim/v2/Users?filter=" + url.QueryEscape(`userName eq "BJENSEN"`))
+			Expect(page.TotalResults).To(Equal(1))
+			Expect(page.Resources[0]).To(HaveKeyWithValue("id", "2"))
+			page = list("/scim/v2/Users?startIndex=2&count=5")
+			Expect(page.TotalResults).To(Equal(2))
+			Expect(page.StartIndex).To(Equal(2))
+			Expect(page.ItemsPerPage).To(Equal(1))
+			Expect(list("/scim/v2/Users?count=0").Resources).To(BeEmpty())
+
+			rec = request("root", http.MethodPatch, "/scim/v2/Users/2", `W/"1"`, `{
+				"schemas": ["`+scim.SchemaPatchOp+`"],
+				"Operations": [
+					{"op": "Replace", "path": "active", "value": "False"},
+					{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "barbara@example.com"}
+				]
+			}`)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get("ETag")).To(Equal(`W/"2"`))
+			Expect(rec.Body.String()).To(ContainSubstring(`"active":false`))
+			Expect(rec.Body.String()).To(ContainSubstring(`barbara@example.com`))
+			Expect(request("root", http.MethodPatch, "/scim/v2/Users/2", `W/"1"`, `{"Operations":[{"op":"replace","path":"active","value":true}]}`).Code).To(Equal(http.StatusPreconditionFailed))
+
+			rec = request("root", http.MethodPut, "/scim/v2/Users/2", "", `{
+				"userName": "bjensen",
+				"name": {"givenName": "Babs", "familyName": "Jensen"},
+				"emails": [{"value": "babs@example.com"}],
+				"active": true
+			}`)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"givenName":"Babs"`))
+			Expect(rec.Body.String()).To(ContainSubstring(`"department":"HR"`))
+
+			Expect(request("root", http.MethodDelete, "/scim/v2/Users/2", "", "").Code).To(Equal(http.StatusNoContent))
+			Expect(scimError(request("vic", http.MethodGet, "/scim/v2/Users/2", "", "")).Status).To(Equal("404"))
+			Expect(list("/scim/v2/Users").TotalResults).To(Equal(1))
+		})
+
+		It("should follow the status transitions", func() {
+			rec := request("root", http.MethodPatch, "/scim/v2/Users/1", "", `{"Operations":[{"op":"replace","value":{"active":false}}]}`)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"active":false`))
+			Expect(request("root", http.MethodPatch, "/scim/v2/Users/1", "", `{"Operations":[{"op":"replace","path":"active","value":true}]}`).Code).To(Equal(http.StatusOK))
+		})
+
+		It("should manage group membership", func() {
+			rec := request("root", http.MethodPost, "/scim/v2/Groups", "", `{"displayName": "admins", "members": [{"value": "1"}]}`)
+			Expect(rec.Code).To(Equal(http.StatusCreated))
+			Expect(rec.Body.String()).To(ContainSubstring(`"display":"ann"`))
+
+			rec = request("root", http.MethodPatch, "/scim/v2/Groups/1", "", `{"Operations":[{"op":"remove","path":"members[value eq \"1\"]"}]}`)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).NotTo(ContainSubstring(`"members"`))
+
+			rec = request("root", http.MethodPatch, "/scim/v2/Groups/1", "", `{"Operations":[
+				{"op":"add","path":"members","value":[{"value":"1"}]},
+				{"op":"replace","path":"displayName","value":"operators"}
+			]}`)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			page := list("/scim/v2/Groups?filter=" + url.QueryEscape(`members[value eq "1"] and displayName eq "operators"`))
+			Expect(page.TotalResults).To(Equal(1))
+
+			Expect(request("root", http.MethodPatch, "/scim/v2/Groups/1", "", `{"Operations":[{"op":"remove","path":"members","value":[{"value":"1"}]}]}`).Code).To(Equal(http.StatusOK))
+			Expect(list("/scim/v2/Groups?filter=" + url.QueryEscape("members pr")).TotalResults).To(Equal(0))
+
+			body := scimError(request("root", http.MethodPatch, "/scim/v2/Groups/1", "", `{"Operations":[{"op":"add","path":"members","value":[{"value":"9"}]}]}`))
+			Expect(body.Status).To(Equal("400"))
+			Expect(body.ScimType).To(Equal("invalidValue"))
+			Expect(request("root", http.MethodDelete, "/scim/v2/Groups/1", "", "").Code).To(Equal(http.StatusNoContent))
+		})
+
+		It("should report errors in the SCIM format", func() {
+			body := scimError(request("root", http.MethodPost, "/scim/v2/Users", "", `{"userName": "ann", "name": {"givenName": "A", "familyName": "N"}, "emails": [{"value": "a@example.com"}], "`+scim.SchemaEnterpriseUser+`": {"department": "IT"}}`))
+			Expect(body.Status).To(Equal("409"))
+			Expect(body.ScimType).To(Equal("uniqueness"))
+
+			body = scimError(request("root", http.MethodPost, "/scim/v2/Users", "", `{"userName": "bob", "emails": [{"value": "b@example.com"}]}`))
+			Expect(body.Status).To(Equal("400"))
+			Expect(body.ScimType).To(Equal("invalidValue"))
+			Expect(body.Detail).To(ContainSubstring("name.givenName is required"))
+
+			body = scimError(request("vic", http.MethodGet, "/scim/v2/Users?filter="+url.QueryEscape(`userName is "x"`), "", ""))
+			Expect(body.Status).To(Equal("400"))
+			Expect(body.ScimType).To(Equal("invalidFilter"))
+
+			body = scimError(request("root", http.MethodPost, "/scim/v2/Users", "", `{`))
+			Expect(body.ScimType).To(Equal("invalidSyntax"))
+
+			Expect(scimError(request("vic", http.MethodPost, "/scim/v2/Users", "", `{}`)).Status).To(Equal("403"))
+			Expect(scimError(request("nobody", http.MethodGet, "/scim/v2/Users", "", "")).Status).To(Equal("401"))
+			Expect(scimError(request("vic", http.MethodGet, "/scim/v2/Users/abc", "", "")).Status).To(Equal("404"))
+		})
+
+		It("should describe the service", func() {
+			rec := request("vic", http.MethodGet, "/scim/v2/ServiceProviderConfig", "", "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"patch":{"supported":true}`))
+			Expect(rec.Body.String()).To(ContainSubstring(`"maxResults":200`))
+
+			page := list("/scim/v2/Schemas")
+			Expect(page.TotalResults).To(Equal(3))
+			rec = request("vic", http.MethodGet, "/scim/v2/Schemas/"+scim.SchemaGroup, "", "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"displayName"`))
+			Expect(scimError(request("vic", http.MethodGet, "/scim/v2/Schemas/urn:example", "", "")).Status).To(Equal("404"))
+			Expect(list("/scim/v2/ResourceTypes").TotalResults).To(Equal(2))
+		})
+	})
+})
+
+var errUnstreamed = errors.New("users streamed")
+
+// unstreamedUsers is a user store that fails to stream users, for listings
+// expected to page them in the store instead.
+type unstreamedUsers struct {
+	repositories.UserStore
+}
+
+func (unstreamedUsers) StreamUsers(context.Context, models.UserListParams, func(models.User) error) error {
+	return errUnstreamed
+}
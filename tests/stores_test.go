@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	userdb "user-service/db"
+	"user-service/migrations"
+	"user-service/models"
+	"user-service/repositories"
+
+	_ "github.com/mattn/go-sqlite3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// ctx is the context of the store and service calls that tests make outside
+// of a request.
+var ctx = context.Background()
+
+// openSQLite returns an empty in-memory SQLite database that enforces foreign
+// keys, like those db.Connect opens. A single connection is used because
+// every connection to ":memory:" gets its own database.
+func openSQLite() *sql.DB {
+	db, err := sql.Open("sqlite3", userdb.SQLiteDSN(":memory:"))
+	Expect(err).To(BeNil())
+	db.SetMaxOpenConns(1)
+	DeferCleanup(db.Close)
+	return db
+}
+
+// openMigratedSQLite returns an in-memory SQLite database with every
+// migration applied.
+func openMigratedSQLite() *sql.DB {
+	db := openSQLite()
+	migrator, err := migrations.New(db, "sqlite")
+	Expect(err).To(BeNil())
+	_, err = migrator.Up()
+	Expect(err).To(BeNil())
+	return db
+}
+
+// testDepartments are the departments the tests put users in.
+var testDepartments = []string{"IT", "HR", "Sales"}
+
+// openUserSQLite returns a migrated in-memory SQLite database holding
+// testDepartments, since users must refer to existing departments.
+func openUserSQLite() *sql.DB {
+	db := openMigratedSQLite()
+	for _, code := range testDepartments {
+		_, err := db.Exec("INSERT INTO departments (code, name, created_at, updated_at) VALUES (?, ?, ?, ?)", code, code, time.Now(), time.Now())
+		Expect(err).To(BeNil())
+	}
+	return db
+}
+
+// testStores are the stores of one backend, all over the same data.
+type testStores struct {
+	Users       repositories.UserStore
+	Groups      repositories.GroupStore
+	Departments repositories.DepartmentStore
+	Transitions repositories.TransitionStore
+	Outbox      repositories.OutboxStore
+	Changes     repositories.ChangeStore
+	Webhooks    repositories.WebhookStore
+	UnitOfWork  repositories.UnitOfWork
+}
+
+func newMemoryStores() testStores {
+	users := repositories.NewMemoryUserRepository()
+	transitions := repositories.NewMemoryTransitionRepository()
+	return testStores{
+		Users:       users,
+		Groups:      repositories.NewMemoryGroupRepository(users),
+		Departments: repositories.NewMemoryDepartmentRepository(users),
+		Transitions: transitions,
+		Outbox:      repositories.NewMemoryOutboxRepository(users),
+		Changes:     repositories.NewMemoryChangeRepository(users),
+		Webhooks:    repositories.NewMemoryWebhookRepository(),
+		UnitOfWork:  repositories.NewMemoryTransactor(users, transitions),
+	}
+}
+
+func newSQLiteStores(db *sql.DB) testStores {
+	return testStores{
+		Users:       repositories.NewUserRepository(db),
+		Groups:      repositories.NewGroupRepository(db),
+		Departments: repositories.NewDepartmentRepository(db),
+		Transitions: repositories.NewTransitionRepository(db),
+		Outbox:      repositories.NewOutboxRepository(db),
+		Changes:     repositories.NewChangeRepository(db),
+		Webhooks:    repositories.NewWebhookRepository(db),
+		UnitOfWork:  repositories.NewTransactor(db),
+	}
+}
+
+// forEachStore declares specs once for the memory stores and once for the
+// SQLite ones, each in a Context of its own. specs gets the function that
+// returns a fresh set of stores, to call in a BeforeEach.
+func forEachStore(specs func(newStores func() testStores)) {
+	forEachStoreOver(openUserSQLite, specs)
+}
+
+// forEachStoreOver is forEachStore with the SQLite stores over the databases
+// that open returns.
+func forEachStoreOver(open func() *sql.DB, specs func(newStores func() testStores)) {
+	Context("with the memory store", func() {
+		specs(newMemoryStores)
+	})
+	Context("with the sqlite store", func() {
+		specs(func() testStores { return newSQLiteStores(open()) })
+	})
+}
+
+// newUser returns a valid user named userName, in the IT department.
+func newUser(userName string) *models.User {
+	return &models.User{UserName: userName, Email: userName + "@example.com", FirstName: "F", LastName: "L", Status: "A", Department: "IT"}
+}
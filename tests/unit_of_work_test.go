@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/migrations"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/scim"
+	"user-service/services"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Unit of work", func() {
+
+	forEachStore(func(newStores func() testStores) {
+		var s testStores
+
+		BeforeEach(func() {
+			s = newStores()
+		})
+
+		// writeAll creates a user, a group with the user as member and a
+		// transition of the user, then returns fail.
+		writeAll := func(tx repositories.Repos, fail error) error {
+			user := newUser("ann")
+			if err := tx.Users.CreateUser(ctx, user); err != nil {
+				return err
+			}
+			group := &models.Group{Name: "admins"}
+			if err := tx.Groups.CreateGroup(ctx, group); err != nil {
+				return err
+			}
+			if err := tx.Groups.AddMember(ctx, group.ID, user.ID); err != nil {
+				return err
+			}
+			now := time.Now()
+			transition := &models.StatusTransition{UserID: user.ID, Status: "I", EffectiveAt: now, RequestedBy: "test", State: models.TransitionApplied, FinishedAt: &now}
+			if err := tx.Transitions.CreateTransition(ctx, transition); err != nil {
+				return err
+			}
+			return fail
+		}
+
+		expectWritten := func(written bool) {
+			users, err := s.Users.GetAllUsers(ctx)
+			Expect(err).To(BeNil())
+			groups, err := s.Groups.ListGroups(ctx, 10, 0)
+			Expect(err).To(BeNil())
+			events, err := s.Users.ListAuditEvents(ctx, models.AuditListParams{})
+			Expect(err).To(BeNil())
+			if !written {
+				Expect(users).To(BeEmpty())
+				Expect(groups.Total).To(BeZero())
+				Expect(events.Total).To(BeZero())
+				transitions, err := s.Transitions.ListTransitions(ctx, 1)
+				Expect(err).To(BeNil())
+				Expect(transitions).To(BeEmpty())
+				return
+			}
+			Expect(users).To(HaveLen(1))
+			Expect(groups.Total).To(Equal(1))
+			Expect(events.Total).To(Equal(1))
+			memberships, err := s.Groups.ListUserGroups(ctx, users[0].ID)
+			Expect(err).To(BeNil())
+			Expect(memberships).To(HaveLen(1))
+			transitions, err := s.Transitions.ListTransitions(ctx, users[0].ID)
+			Expect(err).To(BeNil())
+			Expect(transitions).To(HaveLen(1))
+		}
+
+		It("should commit the writes of a unit of work together", func() {
+			Expect(s.UnitOfWork.WithTx(ctx, func(tx repositories.Repos) error {
+				return writeAll(tx, nil)
+			})).To(Succeed())
+			expectWritten(true)
+		})
+
+		It("should roll back every write when the function fails", func() {
+			err := s.UnitOfWork.WithTx(ctx, func(tx repositories.Repos) error {
+				return writeAll(tx, errors.New("boom"))
+			})
+			Expect(err).To(MatchError("boom"))
+			expectWritten(false)
+		})
+
+		It("should roll back every write when the function panics", func() {
+			Expect(func() {
+				s.UnitOfWork.WithTx(ctx, func(tx repositories.Repos) error {
+					Expect(writeAll(tx, nil)).To(Succeed())
+					panic("boom")
+				})
+			}).To(PanicWith("boom"))
+			expectWritten(false)
+
+			Expect(s.UnitOfWork.WithTx(ctx, func(tx repositories.Repos) error {
+				return writeAll(tx, nil)
+			})).To(Succeed())
+			expectWritten(true)
+		})
+
+		It("should keep the writes made outside of a unit of work that fails", func() {
+			written := make(chan error, 1)
+			err := s.UnitOfWork.WithTx(ctx, func(tx repositories.Repos) error {
+				Expect(tx.Users.CreateUser(ctx, newUser("ann"))).To(Succeed())
+				go func() {
+					defer GinkgoRecover()
+					now := time.Now()
+					bob := newUser("bob")
+					err := s.Users.CreateUser(ctx, bob)
+					if err == nil {
+						err = s.Transitions.CreateTransition(ctx, &models.StatusTransition{UserID: bob.ID, Status: "I", EffectiveAt: now, RequestedBy: "test", State: models.TransitionApplied, FinishedAt: &now})
+					}
+					written <- err
+				}()
+				// Gives the write outside a chance to go ahead
+				time.Sleep(20 * time.Millisecond)
+				return errors.New("boom")
+			})
+			Expect(err).To(MatchError("boom"))
+			Eventually(written).Should(Receive(BeNil()))
+
+			users, err := s.Users.GetAllUsers(ctx)
+			Expect(err).To(BeNil())
+			Expect(users).To(ConsistOf(HaveField("UserName", "bob")))
+			transitions, err := s.Transitions.ListTransitions(ctx, users[0].ID)
+			Expect(err).To(BeNil())
+			Expect(transitions).To(HaveLen(1))
+		})
+
+		It("should undo only a failed write that the function gets past", func() {
+			Expect(s.UnitOfWork.WithTx(ctx, func(tx repositories.Repos) error {
+				Expect(tx.Users.CreateUser(ctx, newUser("ann"))).To(Succeed())
+				Expect(tx.Users.CreateUser(ctx, newUser("ann"))).To(MatchError(repositories.ErrDuplicateUsername))
+				errs, err := tx.Users.WriteUsers(ctx, []repositories.UserWrite{{User: newUser("bob")}, {User: newUser("bob")}})
+				Expect(err).To(BeNil())
+				Expect(errs[1]).To(MatchError(repositories.ErrDuplicateUsername))
+				return nil
+			})).To(Succeed())
+
+			users, err := s.Users.GetAllUsers(ctx)
+			Expect(err).To(BeNil())
+			Expect(users).To(HaveLen(2))
+		})
+
+		Describe("in services", func() {
+			var userService *services.UserService
+
+			BeforeEach(func() {
+				userService = services.NewUserService(s.Users)
+				userService.Transitions = s.Transitions
+				userService.UnitOfWork = s.UnitOfWork
+				Expect(userService.CreateUser(ctx, newUser("ann"))).To(Succeed())
+			})
+
+			It("should change a status and record its transition together", func() {
+				user, transition, err := userService.ChangeStatus(ctx, 1, 0, models.StatusChange{Status: "I"})
+				Expect(err).To(BeNil())
+				Expect(user.Status).To(Equal("I"))
+				transitions, err := userService.ListTransitions(ctx, 1)
+				Expect(err).To(BeNil())
+				Expect(transitions).To(ConsistOf(HaveField("ID", transition.ID)))
+			})
+
+			It("should create SCIM groups with all of their members or not at all", func() {
+				service := services.NewSCIMService(userService, services.NewGroupService(s.Groups))
+				_, err := service.CreateGroup(ctx, &scim.Group{DisplayName: "admins", Members: []scim.MultiValue{{Value: "1"}, {Value: "9"}}})
+				Expect(apperrors.KindOf(err)).To(Equal(apperrors.Invalid))
+				groups, err := s.Groups.ListGroups(ctx, 10, 0)
+				Expect(err).To(BeNil())
+				Expect(groups.Total).To(BeZero())
+
+				group, err := service.CreateGroup(ctx, &scim.Group{DisplayName: "admins", Members: []scim.MultiValue{{Value: "1"}}})
+				Expect(err).To(BeNil())
+				Expect(group.Members).To(HaveLen(1))
+			})
+		})
+	})
+
+	Context("with a busy SQLite database", func() {
+		var (
+			db     *sql.DB
+			locker *sql.Conn
+		)
+
+		BeforeEach(func() {
+			// Without a busy timeout, SQLite reports a locked database at
+			// once instead of waiting for it.
+			dsn := filepath.Join(GinkgoT().TempDir(), "users.db") + "?_busy_timeout=0"
+			var err error
+			db, err = sql.Open("sqlite3", dsn)
+			Expect(err).To(BeNil())
+			DeferCleanup(db.Close)
+			migrator, err := migrations.New(db, "sqlite")
+			Expect(err).To(BeNil())
+			_, err = migrator.Up()
+			Expect(err).To(BeNil())
+
+			other, err := sql.Open("sqlite3", dsn)
+			Expect(err).To(BeNil())
+			DeferCleanup(other.Close)
+			locker, err = other.Conn(ctx)
+			Expect(err).To(BeNil())
+			DeferCleanup(locker.Close)
+			_, err = locker.ExecContext(ctx, "BEGIN IMMEDIATE")
+			Expect(err).To(BeNil())
+		})
+
+		It("should retry a transaction until the database is free", func() {
+			go func() {
+				time.Sleep(30 * time.Millisecond)
+				locker.ExecContext(ctx, "ROLLBACK")
+			}()
+			attempts := 0
+			Expect(repositories.NewTransactor(db).WithTx(ctx, func(tx repositories.Repos) error {
+				attempts++
+				return tx.Users.CreateUser(ctx, newUser("ann"))
+			})).To(Succeed())
+			Expect(attempts).To(BeNumerically(">", 1))
+		})
+
+		It("should give up after a few attempts", func() {
+			attempts := 0
+			err := repositories.NewTransactor(db).WithTx(ctx, func(tx repositories.Repos) error {
+				attempts++
+				return tx.Users.CreateUser(ctx, newUser("ann"))
+			})
+			Expect(err).To(MatchError(ContainSubstring("database is locked")))
+			Expect(attempts).To(Equal(5))
+		})
+	})
+})
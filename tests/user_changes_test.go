@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"user-service/controllers"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/websocket"
+)
+
+var _ = Describe("User change feed", func() {
+
+	forEachStore(func(newStores func() testStores) {
+		var (
+			s           testStores
+			userService *services.UserService
+		)
+
+		BeforeEach(func() {
+			s = newStores()
+			userService = services.NewUserService(s.Users)
+		})
+
+		It("should keep the last change of each user in sequence", func() {
+			latest, err := s.Changes.LatestChange(ctx)
+			Expect(err).To(BeNil())
+			Expect(latest).To(Equal(0))
+
+			alice, bob := newUser("alice"), newUser("bob")
+			Expect(userService.CreateUser(ctx, alice)).To(Succeed())
+			Expect(userService.CreateUser(ctx, bob)).To(Succeed())
+			alice.FirstName = "Alice"
+			Expect(userService.UpdateUser(ctx, alice)).To(Succeed())
+
+			changes, err := s.Changes.ListChanges(ctx, 0, 10)
+			Expect(err).To(BeNil())
+			Expect(changes).To(HaveLen(2))
+			Expect(changes[0]).To(And(HaveField("Seq", 2), HaveField("Type", models.EventUserCreated), HaveField("User.UserName", "bob")))
+			Expect(changes[1]).To(And(HaveField("Seq", 3), HaveField("Type", models.EventUserUpdated), HaveField("User.FirstName", "Alice")))
+			latest, err = s.Changes.LatestChange(ctx)
+			Expect(err).To(BeNil())
+			Expect(latest).To(Equal(3))
+
+			changes, err = s.Changes.ListChanges(ctx, 2, 10)
+			Expect(err).To(BeNil())
+			Expect(changes).To(ConsistOf(HaveField("User.ID", alice.ID)))
+			changes, err = s.Changes.ListChanges(ctx, 0, 1)
+			Expect(err).To(BeNil())
+			Expect(changes).To(ConsistOf(HaveField("User.ID", bob.ID)))
+
+			Expect(userService.DeleteUser(ctx, bob.ID, bob.Version)).To(Succeed())
+			changes, err = s.Changes.ListChanges(ctx, 3, 10)
+			Expect(err).To(BeNil())
+			Expect(changes).To(HaveLen(1))
+			Expect(changes[0]).To(And(HaveField("Seq", 4), HaveField("Type", models.EventUserDeleted), HaveField("User.DeletedAt", Not(BeNil()))))
+
+			// Purged users leave the feed
+			Expect(userService.PurgeUser(ctx, bob.ID)).To(Succeed())
+			changes, err = s.Changes.ListChanges(ctx, 0, 10)
+			Expect(err).To(BeNil())
+			Expect(changes).To(ConsistOf(HaveField("User.ID", alice.ID)))
+		})
+
+		It("should hand polled changes to every subscriber and drop those that fall behind", func() {
+			broker := services.NewChangeBroker(s.Changes)
+			Expect(userService.CreateUser(ctx, newUser("alice"))).To(Succeed())
+
+			fast, err := broker.Subscribe(context.Background())
+			Expect(err).To(BeNil())
+			Expect(fast.After()).To(Equal(1))
+			broker.Buffer = 1
+			slow, err := broker.Subscribe(context.Background())
+			Expect(err).To(BeNil())
+			Expect(broker.Subscribers()).To(Equal(2))
+
+			Expect(userService.CreateUser(ctx, newUser("bob"))).To(Succeed())
+			Expect(userService.CreateUser(ctx, newUser("carol"))).To(Succeed())
+			polled, err := broker.Poll(context.Background())
+			Expect(err).To(BeNil())
+			Expect(polled).To(Equal(2))
+
+			Expect(fast.C()).To(Receive(HaveField("User.UserName", "bob")))
+			Expect(fast.C()).To(Receive(HaveField("User.UserName", "carol")))
+			Expect(slow.C()).To(Receive(HaveField("User.UserName", "bob")))
+			Expect(slow.C()).To(BeClosed())
+			Expect(slow.Lagged()).To(BeTrue())
+			Expect(broker.Subscribers()).To(Equal(1))
+
+			// Changes are polled once
+			polled, err = broker.Poll(context.Background())
+			Expect(err).To(BeNil())
+			Expect(polled).To(Equal(0))
+
+			broker.Unsubscribe(fast)
+			Expect(fast.C()).To(BeClosed())
+			Expect(fast.Lagged()).To(BeFalse())
+		})
+	})
+
+	Describe("over HTTP", func() {
+		var (
+			userService *services.UserService
+			broker      *services.ChangeBroker
+			server      *httptest.Server
+		)
+
+		BeforeEach(func() {
+			users := repositories.NewMemoryUserRepository()
+			userService = services.NewUserService(users)
+			broker = services.NewChangeBroker(repositories.NewMemoryChangeRepository(users))
+			ctx, cancel := context.WithCancel(context.Background())
+			DeferCleanup(cancel)
+			go broker.Run(ctx, 10*time.Millisecond)
+
+			e := echo.New()
+			e.HTTPErrorHandler = controllers.ErrorHandler
+			e.GET("/users/changes", controllers.StreamUserChanges(broker))
+			server = httptest.NewServer(e)
+			DeferCleanup(server.Close)
+		})
+
+		// event is a Server-Sent Event.
+		type event struct {
+			id, typ, data string
+		}
+		readEvent := func(r *bufio.Reader) event {
+			var ev event
+			for {
+				line, err := r.ReadString('\n')
+				Expect(err).To(BeNil())
+				line = strings.TrimSuffix(line, "\n")
+				if line == "" {
+					if ev.id != "" {
+						return ev
+					}
+					continue
+				}
+				field, value, _ := strings.Cut(line, ": ")
+				switch field {
+				case "id":
+					ev.id = value
+				case "event":
+					ev.typ = value
+				case "data":
+					ev.data = value
+				}
+			}
+		}
+		stream := func(lastEventID string) (*http.Response, *bufio.Reader) {
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/users/changes", nil)
+			Expect(err).To(BeNil())
+			if lastEventID != "" {
+				req.Header.Set("Last-Event-ID", lastEventID)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			DeferCleanup(cancel)
+			res, err := http.DefaultClient.Do(req.WithContext(ctx))
+			Expect(err).To(BeNil())
+			DeferCleanup(res.Body.Close)
+			return res, bufio.NewReader(res.Body)
+		}
+
+		It("should stream changes as Server-Sent Events and resume after the last event", func() {
+			alice, bob := newUser("alice"), newUser("bob")
+			Expect(userService.CreateUser(ctx, alice)).To(Succeed())
+			Expect(userService.CreateUser(ctx, bob)).To(Succeed())
+
+			res, r := stream("1")
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header.Get(echo.HeaderContentType)).To(Equal("text/event-stream"))
+			ev := readEvent(r)
+			Expect(ev.id).To(Equal("2"))
+			Expect(ev.typ).To(Equal(models.EventUserCreated))
+			Expect(ev.data).To(ContainSubstring(`"user_name":"bob"`))
+
+			alice.Status = "I"
+			Expect(userService.UpdateUser(ctx, alice)).To(Succeed())
+			ev = readEvent(r)
+			Expect(ev.id).To(Equal("3"))
+			Expect(ev.typ).To(Equal(models.EventUserUpdated))
+			Expect(ev.data).To(ContainSubstring(`"status":"I"`))
+
+			// Without Last-Event-ID the stream starts with the next change
+			_, fresh := stream("")
+			Expect(userService.DeleteUser(ctx, bob.ID, bob.Version)).To(Succeed())
+			ev = readEvent(fresh)
+			Expect(ev.id).To(Equal(strconv.Itoa(4)))
+			Expect(ev.typ).To(Equal(models.EventUserDeleted))
+			Expect(readEvent(r)).To(Equal(ev))
+		})
+
+		It("should reject an invalid Last-Event-ID", func() {
+			res, err := http.Get(server.URL + "/users/changes?last_event_id=soon")
+			Expect(err).To(BeNil())
+			defer res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should stream changes over a WebSocket", func() {
+			Expect(userService.CreateUser(ctx, newUser("alice"))).To(Succeed())
+
+			url := "ws" + strings.TrimPrefix(server.URL, "http") + "/users/changes?last_event_id=0"
+			ws, err := websocket.Dial(url, "", server.URL)
+			Expect(err).To(BeNil())
+			defer ws.Close()
+			Expect(ws.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+
+			var change models.UserChange
+			Expect(websocket.JSON.Receive(ws, &change)).To(Succeed())
+			Expect(change).To(And(HaveField("Seq", 1), HaveField("Type", models.EventUserCreated), HaveField("User.UserName", "alice")))
+
+			Expect(userService.CreateUser(ctx, newUser("bob"))).To(Succeed())
+			Expect(websocket.JSON.Receive(ws, &change)).To(Succeed())
+			Expect(change).To(And(HaveField("Seq", 2), HaveField("User.UserName", "bob")))
+		})
+	})
+})
@@ -4,14 +4,16 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
-	"errors"
 	"net/http"
 	"net/http/httptest"
+	"time"
 
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/labstack/echo/v4"
+	"github.com/mattn/go-sqlite3"
+	"user-service/apperrors"
 	"user-service/controllers"
 	"user-service/models"
 	"user-service/repositories"
@@ -26,6 +28,69 @@ func TestUserService(t *testing.T) {
 	RunSpecs(t, "UserService Suite")
 }
 
+// expectUserLoad expects a mutation's transaction to read the user with the
+// given id, which is missing if user is nil.
+func expectUserLoad(mock sqlmock.Sqlmock, id int, user *models.User) {
+	rows := sqlmock.NewRows([]string{"id", "user_name", "email", "first_name", "last_name", "user_status", "department", "manager_id", "version", "deleted_at"})
+	if user != nil {
+		rows.AddRow(user.ID, user.UserName, user.Email, user.FirstName, user.LastName, user.Status, user.Department, user.ManagerID, user.Version, user.DeletedAt)
+	}
+	mock.ExpectQuery(`SELECT (.+) FROM users WHERE id = \?`).
+		WithArgs(id).
+		WillReturnRows(rows)
+}
+
+// expectAuditEvent expects a mutation's transaction to read back the changed
+// user, record an audit event, an outbox event and its change for it and
+// commit.
+func expectAuditEvent(mock sqlmock.Sqlmock, operation string, user models.User) {
+	expectUserLoad(mock, user.ID, &user)
+	mock.ExpectExec(`INSERT INTO audit_events`).
+		WithArgs(user.ID, operation, sqlmock.AnyArg(), sqlmock.AnyArg(), user.Status, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	eventType := models.EventUserUpdated
+	if operation == models.AuditCreate {
+		eventType = models.EventUserCreated
+	}
+	expectOutboxEvent(mock, eventType, user.ID)
+	expectChange(mock, eventType, user.ID)
+	mock.ExpectCommit()
+}
+
+// expectOutboxEvent expects a mutation's transaction to write an event of the
+// given type to the outbox.
+func expectOutboxEvent(mock sqlmock.Sqlmock, eventType string, userID int) {
+	mock.ExpectExec(`INSERT INTO outbox`).
+		WithArgs(eventType, userID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// expectChange expects a mutation's transaction to take the next change
+// sequence number and record the change of the given type on the user.
+func expectChange(mock sqlmock.Sqlmock, eventType string, userID int) {
+	mock.ExpectQuery(`UPDATE user_change_sequence SET value = value \+ 1 RETURNING value`).
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(1))
+	mock.ExpectExec(`UPDATE users SET change_seq = \?, change_type = \? WHERE id = \?`).
+		WithArgs(1, eventType, userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// handle runs handler the way Echo does, writing any error it returns with the
+// central error handler.
+func handle(handler echo.HandlerFunc, c echo.Context) {
+	if err := handler(c); err != nil {
+		controllers.ErrorHandler(err, c)
+	}
+}
+
+// problemOf decodes the problem+json body of an error response.
+func problemOf(rec *httptest.ResponseRecorder) controllers.Problem {
+	Expect(rec.Header().Get(echo.HeaderContentType)).To(Equal(controllers.MIMEProblemJSON))
+	var problem controllers.Problem
+	Expect(json.Unmarshal(rec.Body.Bytes(), &problem)).To(Succeed())
+	return problem
+}
+
 var _ = Describe("UserController and UserService with Squirrel Repository", func() {
 	var (
 		db          *sql.DB
@@ -55,12 +120,16 @@ var _ = Describe("UserController and UserService with Squirrel Repository", func
 			It("should call the repository's CreateUser method successfully", func() {
 				// Arrange
 				user := &models.User{UserName: "john_doe", Email: "john@example.com", FirstName: "John", LastName: "Doe", Status: "A", Department: "IT"}
+				mock.ExpectBegin()
 				mock.ExpectExec("INSERT INTO users").
-					WithArgs(user.UserName, user.Email, user.FirstName, user.LastName, user.Status, user.Department).
+					WithArgs(user.UserName, user.Email, user.FirstName, user.LastName, user.Status, user.Department, user.ManagerID).
 					WillReturnResult(sqlmock.NewResult(1, 1))
+				created := *user
+				created.ID, created.Version = 1, 1
+				expectAuditEvent(mock, models.AuditCreate, created)
 
 				// Act
-				err := userService.CreateUser(user)
+				err := userService.CreateUser(ctx, user)
 
 				// Assert
 				Expect(err).To(BeNil())
@@ -70,15 +139,17 @@ var _ = Describe("UserController and UserService with Squirrel Repository", func
 			It("should return an error if the repository returns an error", func() {
 				// Arrange
 				user := &models.User{UserName: "john_doe", Email: "john@example.com", FirstName: "Jane", LastName: "Doe", Status: "I", Department: "IT"}
+				mock.ExpectBegin()
 				mock.ExpectExec("INSERT INTO users").
-					WithArgs(user.UserName, user.Email, user.FirstName, user.LastName, user.Status, user.Department).
-					WillReturnError(errors.New("duplicate username"))
+					WithArgs(user.UserName, user.Email, user.FirstName, user.LastName, user.Status, user.Department, user.ManagerID).
+					WillReturnError(sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique})
+				mock.ExpectRollback()
 
 				// Act
-				err := userService.CreateUser(user)
+				err := userService.CreateUser(ctx, user)
 
 				// Assert
-				Expect(err).To(MatchError("duplicate username"))
+				Expect(err).To(MatchError(repositories.ErrDuplicateUsername))
 				Expect(mock.ExpectationsWereMet()).To(BeNil())
 			})
 		})
@@ -96,9 +167,13 @@ var _ = Describe("UserController and UserService with Squirrel Repository", func
 					Status:     "A",
 					Department: "IT",
 				}
+				mock.ExpectBegin()
 				mock.ExpectExec("INSERT INTO users").
-					WithArgs(user.UserName, user.Email, user.FirstName, user.LastName, user.Status, user.Department).
+					WithArgs(user.UserName, user.Email, user.FirstName, user.LastName, user.Status, user.Department, user.ManagerID).
 					WillReturnResult(sqlmock.NewResult(1, 1))
+				created := user
+				created.ID, created.Version = 1, 1
+				expectAuditEvent(mock, models.AuditCreate, created)
 
 				handler := controllers.CreateUser(userService)
 				body, _ := json.Marshal(user)
@@ -107,10 +182,9 @@ var _ = Describe("UserController and UserService with Squirrel Repository", func
 				c := e.NewContext(req, rec)
 
 				// Act
-				err := handler(c)
+				handle(handler, c)
 
 				// Assert
-				Expect(err).To(BeNil())
 				Expect(rec.Code).To(Equal(http.StatusCreated))
 				var createdUser models.User
 				json.Unmarshal(rec.Body.Bytes(), &createdUser)
@@ -129,9 +203,11 @@ var _ = Describe("UserController and UserService with Squirrel Repository", func
 					Status:     "A",
 					Department: "IT",
 				}
+				mock.ExpectBegin()
 				mock.ExpectExec("INSERT INTO users").
-					WithArgs(user.UserName, user.Email, user.FirstName, user.LastName, user.Status, user.Department).
-					WillReturnError(errors.New("duplicate username"))
+					WithArgs(user.UserName, user.Email, user.FirstName, user.LastName, user.Status, user.Department, user.ManagerID).
+					WillReturnError(sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique})
+				mock.ExpectRollback()
 
 				handler := controllers.CreateUser(userService)
 				body, _ := json.Marshal(user)
@@ -140,14 +216,11 @@ var _ = Describe("UserController and UserService with Squirrel Repository", func
 				c := e.NewContext(req, rec)
 
 				// Act
-				err := handler(c)
+				handle(handler, c)
 
 				// Assert
-				Expect(err).NotTo(BeNil())
-				httpErr, ok := err.(*echo.HTTPError)
-				Expect(ok).To(BeTrue())
-				Expect(httpErr.Code).To(Equal(http.StatusConflict))
-				Expect(httpErr.Message).To(Equal("username already exists"))
+				Expect(rec.Code).To(Equal(http.StatusConflict))
+				Expect(problemOf(rec).Detail).To(Equal("duplicate username"))
 				Expect(mock.ExpectationsWereMet()).To(BeNil())
 			})
 
@@ -160,13 +233,13 @@ var _ = Describe("UserController and UserService with Squirrel Repository", func
 				c := e.NewContext(req, rec)
 
 				// Act
-				err := handler(c)
+				handle(handler, c)
 
 				// Assert
-				httpErr, ok := err.(*echo.HTTPError)
-				Expect(ok).To(BeTrue())
-				Expect(httpErr.Code).To(Equal(http.StatusBadRequest))
-				Expect(httpErr.Message).To(Equal("Invalid input"))
+				Expect(rec.Code).To(Equal(http.StatusBadRequest))
+				problem := problemOf(rec)
+				Expect(problem.Detail).To(Equal("validation failed"))
+				Expect(problem.Errors).To(ContainElement(apperrors.FieldError{Field: "user_name", Rule: "required", Message: "is required"}))
 			})
 		})
 		Describe("UpdateUser", func() {
@@ -185,24 +258,86 @@ var _ = Describe("UserController and UserService with Squirrel Repository", func
 				body, _ := json.Marshal(user)
 				req := httptest.NewRequest(http.MethodPut, "/users/1", bytes.NewReader(body))
 				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+				req.Header.Set("If-Match", `"1"`)
 				c := e.NewContext(req, rec)
 				c.SetParamNames("id")
 				c.SetParamValues("1")
 
-				mock.ExpectExec(`UPDATE users SET user_name = \?, email = \?, first_name = \?, last_name = \?, user_status = \?, department = \? WHERE id = \?`).
+				stored := &models.User{ID: 1, UserName: "john_doe", Email: "john@example.com", FirstName: "John", LastName: "Doe", Status: "A", Department: "IT", Version: 1}
+				expectUserLoad(mock, 1, stored)
+				mock.ExpectBegin()
+				expectUserLoad(mock, 1, stored)
+				mock.ExpectQuery(`UPDATE users SET user_name = \?, email = \?, first_name = \?, last_name = \?, user_status = \?, department = \?, manager_id = \?, version = version \+ 1 WHERE deleted_at IS NULL AND id = \? AND version = \? RETURNING version`).
 					WithArgs(user.UserName, user.Email, user.FirstName, user.LastName, user.Status,
-						user.Department, 1).
-					WillReturnResult(sqlmock.NewResult(1, 1))
+						user.Department, user.ManagerID, 1, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+				updated := user
+				updated.Version = 2
+				expectAuditEvent(mock, models.AuditUpdate, updated)
 
 				// Act
-				err := handler(c)
+				handle(handler, c)
 
 				// Assert
-				Expect(err).To(BeNil())
 				Expect(rec.Code).To(Equal(http.StatusOK))
+				Expect(rec.Header().Get("ETag")).To(Equal(`"2"`))
 				Expect(mock.ExpectationsWereMet()).To(BeNil())
 			})
 
+			It("should return 412 when the user has been modified since it was read", func() {
+				// Arrange
+				user := models.User{
+					UserName:   "updated_user",
+					Email:      "updated_email@example.com",
+					FirstName:  "Updated",
+					LastName:   "User",
+					Status:     "A",
+					Department: "IT",
+				}
+				handler := controllers.UpdateUser(userService)
+				body, _ := json.Marshal(user)
+				req := httptest.NewRequest(http.MethodPut, "/users/1", bytes.NewReader(body))
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+				req.Header.Set("If-Match", `"1"`)
+				c := e.NewContext(req, rec)
+				c.SetParamNames("id")
+				c.SetParamValues("1")
+
+				stored := &models.User{ID: 1, UserName: "john_doe", Email: "john@example.com", FirstName: "John", LastName: "Doe", Status: "A", Department: "IT", Version: 2}
+				expectUserLoad(mock, 1, stored)
+				mock.ExpectBegin()
+				expectUserLoad(mock, 1, stored)
+				mock.ExpectQuery(`UPDATE users SET (.+) WHERE deleted_at IS NULL AND id = \? AND version = \? RETURNING version`).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}))
+				mock.ExpectQuery(`SELECT version FROM users WHERE id = \?`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+				mock.ExpectRollback()
+
+				// Act
+				handle(handler, c)
+
+				// Assert
+				Expect(rec.Code).To(Equal(http.StatusPreconditionFailed))
+				Expect(mock.ExpectationsWereMet()).To(BeNil())
+			})
+
+			It("should return 428 when If-Match is missing", func() {
+				// Arrange
+				handler := controllers.UpdateUser(userService)
+				req := httptest.NewRequest(http.MethodPut, "/users/1", bytes.NewReader([]byte(`{}`)))
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+				c := e.NewContext(req, rec)
+				c.SetParamNames("id")
+				c.SetParamValues("1")
+
+				// Act
+				handle(handler, c)
+
+				// Assert
+				Expect(rec.Code).To(Equal(http.StatusPreconditionRequired))
+			})
+
 			It("should return 404 when the user is not found", func() {
 				// Arrange
 				user := models.User{
@@ -218,24 +353,153 @@ var _ = Describe("UserController and UserService with Squirrel Repository", func
 				body, _ := json.Marshal(user)
 				req := httptest.NewRequest(http.MethodPut, "/users/999", bytes.NewReader(body))
 				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+				req.Header.Set("If-Match", `"1"`)
 				c := e.NewContext(req, rec)
 				c.SetParamNames("id")
 				c.SetParamValues("999")
 
-				mock.ExpectExec(`UPDATE users SET user_name = \?, email = \?, first_name = \?, last_name = \?, user_status = \?, department = \? WHERE id = \?`).
-					WithArgs(user.UserName, user.Email, user.FirstName, user.LastName, user.Status,
-						user.Department, 999).
-					WillReturnError(errors.New("user not found"))
+				expectUserLoad(mock, 999, nil)
 
 				// Act
-				err := handler(c)
+				handle(handler, c)
 
 				// Assert
-				Expect(err).To(BeNil())
 				Expect(rec.Code).To(Equal(http.StatusNotFound))
-				var response map[string]string
-				json.Unmarshal(rec.Body.Bytes(), &response)
-				Expect(response["error"]).To(Equal("User not found"))
+				Expect(problemOf(rec).Detail).To(MatchRegexp("user (with id [0-9]+ )?not found"))
+				Expect(mock.ExpectationsWereMet()).To(BeNil())
+			})
+		})
+
+		Describe("GetUsers", func() {
+			columns := []string{"id", "user_name", "email", "first_name", "last_name", "user_status", "department", "manager_id", "version", "deleted_at"}
+
+			It("should return a page of users with the total count and next cursor", func() {
+				// Arrange
+				handler := controllers.GetUsers(userService)
+				req := httptest.NewRequest(http.MethodGet, "/users?limit=1&status=A&sort=-user_name", nil)
+				c := e.NewContext(req, rec)
+
+				mock.ExpectQuery(`SELECT COUNT\(\*\) FROM users WHERE deleted_at IS NULL AND user_status = \?`).
+					WithArgs("A").
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+				mock.ExpectQuery(`SELECT (.+) FROM users WHERE deleted_at IS NULL AND user_status = \? ORDER BY user_name DESC, id DESC LIMIT 2`).
+					WithArgs("A").
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(2, "zoe", "zoe@example.com", "Zoe", "Z", "A", "IT", nil, 1, nil).
+						AddRow(1, "adam", "adam@example.com", "Adam", "A", "A", "IT", nil, 1, nil))
+
+				// Act
+				handle(handler, c)
+
+				// Assert
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				var page models.UserPage
+				json.Unmarshal(rec.Body.Bytes(), &page)
+				Expect(page.Total).To(Equal(2))
+				Expect(page.Users).To(HaveLen(1))
+				Expect(page.Users[0].UserName).To(Equal("zoe"))
+				Expect(page.NextCursor).NotTo(BeEmpty())
+				Expect(mock.ExpectationsWereMet()).To(BeNil())
+
+				// The cursor resumes after the last user of the previous page
+				rec = httptest.NewRecorder()
+				req = httptest.NewRequest(http.MethodGet, "/users?limit=1&status=A&sort=-user_name&cursor="+page.NextCursor, nil)
+				c = e.NewContext(req, rec)
+
+				mock.ExpectQuery(`SELECT COUNT\(\*\) FROM users`).
+					WithArgs("A").
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+				mock.ExpectQuery(`SELECT (.+) FROM users WHERE deleted_at IS NULL AND user_status = \? AND \(user_name < \? OR \(user_name = \? AND id < \?\)\) ORDER BY user_name DESC, id DESC LIMIT 2`).
+					WithArgs("A", "zoe", "zoe", 2).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(1, "adam", "adam@example.com", "Adam", "A", "A", "IT", nil, 1, nil))
+
+				Expect(handler(c)).To(BeNil())
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				var next models.UserPage
+				json.Unmarshal(rec.Body.Bytes(), &next)
+				Expect(next.Users).To(HaveLen(1))
+				Expect(next.Users[0].UserName).To(Equal("adam"))
+				Expect(next.NextCursor).To(BeEmpty())
+				Expect(mock.ExpectationsWereMet()).To(BeNil())
+			})
+
+			It("should return 400 for a sort field that is not allowed", func() {
+				// Arrange
+				handler := controllers.GetUsers(userService)
+				req := httptest.NewRequest(http.MethodGet, "/users?sort=password", nil)
+				c := e.NewContext(req, rec)
+
+				// Act
+				handle(handler, c)
+
+				// Assert
+				Expect(rec.Code).To(Equal(http.StatusBadRequest))
+				Expect(problemOf(rec).Detail).To(Equal("invalid sort field"))
+			})
+		})
+
+		Describe("GetUser", func() {
+			It("should return 200 with the user when found", func() {
+				// Arrange
+				handler := controllers.GetUser(userService)
+				req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+				c := e.NewContext(req, rec)
+				c.SetParamNames("id")
+				c.SetParamValues("1")
+
+				rows := sqlmock.NewRows([]string{"id", "user_name", "email", "first_name", "last_name", "user_status", "department", "manager_id", "version", "deleted_at"}).
+					AddRow(1, "john_doe", "john@example.com", "John", "Doe", "A", "IT", nil, 3, nil)
+				mock.ExpectQuery(`SELECT id, user_name, email, first_name, last_name, user_status, department, manager_id, version, deleted_at FROM users WHERE id = \? AND deleted_at IS NULL`).
+					WithArgs(1).
+					WillReturnRows(rows)
+
+				// Act
+				handle(handler, c)
+
+				// Assert
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				var user models.User
+				json.Unmarshal(rec.Body.Bytes(), &user)
+				Expect(user.ID).To(Equal(1))
+				Expect(user.UserName).To(Equal("john_doe"))
+				Expect(rec.Header().Get("ETag")).To(Equal(`"3"`))
+				Expect(mock.ExpectationsWereMet()).To(BeNil())
+			})
+
+			It("should return 404 when the user is not found", func() {
+				// Arrange
+				handler := controllers.GetUser(userService)
+				req := httptest.NewRequest(http.MethodGet, "/users/999", nil)
+				c := e.NewContext(req, rec)
+				c.SetParamNames("id")
+				c.SetParamValues("999")
+
+				mock.ExpectQuery(`SELECT (.+) FROM users WHERE id = \?`).
+					WithArgs(999).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_name", "email", "first_name", "last_name", "user_status", "department", "manager_id", "version", "deleted_at"}))
+
+				// Act
+				handle(handler, c)
+
+				// Assert
+				Expect(rec.Code).To(Equal(http.StatusNotFound))
+				Expect(problemOf(rec).Detail).To(MatchRegexp("user (with id [0-9]+ )?not found"))
+			})
+
+			It("should return 400 when the ID is not a number", func() {
+				// Arrange
+				handler := controllers.GetUser(userService)
+				req := httptest.NewRequest(http.MethodGet, "/users/abc", nil)
+				c := e.NewContext(req, rec)
+				c.SetParamNames("id")
+				c.SetParamValues("abc")
+
+				// Act
+				handle(handler, c)
+
+				// Assert
+				Expect(rec.Code).To(Equal(http.StatusBadRequest))
 			})
 		})
 
@@ -244,20 +508,35 @@ var _ = Describe("UserController and UserService with Squirrel Repository", func
 				// Arrange
 				handler := controllers.DeleteUser(userService)
 				req := httptest.NewRequest(http.MethodDelete, "/users/1", nil)
+				req.Header.Set("If-Match", `"1"`)
 				c := e.NewContext(req, rec)
 				c.SetParamNames("id")
 				c.SetParamValues("1")
 
 				// mock service behavior
-				mock.ExpectExec("DELETE FROM users WHERE id = ?").
+				user := models.User{ID: 1, UserName: "john_doe", Email: "john@example.com", FirstName: "John", LastName: "Doe", Status: "A", Department: "IT", Version: 1}
+				mock.ExpectBegin()
+				expectUserLoad(mock, 1, &user)
+				mock.ExpectQuery(`UPDATE users SET deleted_at = \?, version = version \+ 1 WHERE deleted_at IS NULL AND id = \? AND version = \? RETURNING version`).
+					WithArgs(sqlmock.AnyArg(), 1, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+				deletedAt := time.Now().UTC()
+				user.Version, user.DeletedAt = 2, &deletedAt
+				expectUserLoad(mock, 1, &user)
+				mock.ExpectExec(`DELETE FROM user_groups WHERE user_id = \?`).
 					WithArgs(1).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`INSERT INTO audit_events`).
+					WithArgs(1, models.AuditDelete, sqlmock.AnyArg(), sqlmock.AnyArg(), "A", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectOutboxEvent(mock, models.EventUserDeleted, 1)
+				expectChange(mock, models.EventUserDeleted, 1)
+				mock.ExpectCommit()
 
 				// Act
-				err := handler(c)
+				handle(handler, c)
 
 				// Assert
-				Expect(err).To(BeNil())
 				Expect(rec.Code).To(Equal(http.StatusNoContent))
 			})
 
@@ -265,24 +544,28 @@ var _ = Describe("UserController and UserService with Squirrel Repository", func
 				// Arrange
 				handler := controllers.DeleteUser(userService)
 				req := httptest.NewRequest(http.MethodDelete, "/users/999", nil)
+				req.Header.Set("If-Match", `"1"`)
 				c := e.NewContext(req, rec)
 				c.SetParamNames("id")
 				c.SetParamValues("999")
 
 				// Mock service behavior
-				mock.ExpectExec("DELETE FROM users WHERE id = ?").
+				mock.ExpectBegin()
+				expectUserLoad(mock, 999, nil)
+				mock.ExpectQuery(`UPDATE users SET deleted_at = \?(.+) RETURNING version`).
+					WithArgs(sqlmock.AnyArg(), 999, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}))
+				mock.ExpectQuery(`SELECT version FROM users WHERE id = \?`).
 					WithArgs(999).
-					WillReturnError(errors.New("user not found"))
+					WillReturnRows(sqlmock.NewRows([]string{"version"}))
+				mock.ExpectRollback()
 
 				// Act
-				err := handler(c)
+				handle(handler, c)
 
 				// Assert
-				Expect(err).To(BeNil())
 				Expect(rec.Code).To(Equal(http.StatusNotFound))
-				var response map[string]string
-				json.Unmarshal(rec.Body.Bytes(), &response)
-				Expect(response["error"]).To(Equal("User not found"))
+				Expect(problemOf(rec).Detail).To(MatchRegexp("user (with id [0-9]+ )?not found"))
 			})
 		})
 	})
@@ -0,0 +1,261 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/controllers"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("User export", func() {
+
+	newService := func(store repositories.UserStore) *services.UserService {
+		userService := services.NewUserService(store)
+		Expect(userService.CreateUser(ctx, &models.User{UserName: "ann", Email: "ann@example.com", FirstName: "Ann", LastName: "Lee", Status: "A", Department: "IT"})).To(Succeed())
+		manager := 1
+		Expect(userService.CreateUser(ctx, &models.User{UserName: "bob", Email: "bob@example.org", FirstName: "Bob", LastName: "O'Neil, Jr.", Status: "I", Department: "IT", ManagerID: &manager})).To(Succeed())
+		Expect(userService.CreateUser(ctx, &models.User{UserName: "cid", Email: "cid@example.com", FirstName: "Cid", LastName: "<Moe>", Status: "A", Department: "HR"})).To(Succeed())
+		Expect(userService.DeleteUser(ctx, 3, 0)).To(Succeed())
+		return userService
+	}
+
+	forEachStore(func(newStores func() testStores) {
+		var userService *services.UserService
+
+		export := func(opts models.ExportOptions) string {
+			var buf bytes.Buffer
+			Expect(userService.ExportUsers(ctx, &buf, opts)).To(Succeed())
+			return buf.String()
+		}
+
+		BeforeEach(func() {
+			userService = newService(newStores().Users)
+		})
+
+		It("should export every column as CSV by default", func() {
+			Expect(export(models.ExportOptions{})).To(Equal(
+				"id,user_name,email,first_name,last_name,status,department,manager_id,version,deleted_at\n" +
+					"1,ann,ann@example.com,Ann,Lee,A,IT,,1,\n" +
+					"2,bob,bob@example.org,Bob,\"O'Neil, Jr.\",I,IT,1,1,\n"))
+		})
+
+		It("should select columns and apply the filters and sort of the list endpoint", func() {
+			Expect(export(models.ExportOptions{
+				Columns: []string{"user_name", "department"},
+				Filter:  models.UserListParams{Sort: "-user_name", IncludeDeleted: true},
+			})).To(Equal("user_name,department\ncid,HR\nbob,IT\nann,IT\n"))
+			Expect(export(models.ExportOptions{
+				Columns: []string{"user_name"},
+				Filter:  models.UserListParams{EmailDomain: "example.com", Status: "A"},
+			})).To(Equal("user_name\nann\n"))
+		})
+
+		It("should export NDJSON with the columns in order", func() {
+			Expect(export(models.ExportOptions{Format: models.ExportNDJSON, Columns: []string{"user_name", "manager_id", "id"}})).To(Equal(
+				`{"user_name":"ann","manager_id":null,"id":1}` + "\n" +
+					`{"user_name":"bob","manager_id":1,"id":2}` + "\n"))
+
+			out := export(models.ExportOptions{Format: models.ExportNDJSON, Columns: []string{"deleted_at"}, Filter: models.UserListParams{IncludeDeleted: true, Department: "HR"}})
+			Expect(out).To(MatchRegexp(`^\{"deleted_at":"\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ"\}\n$`))
+		})
+
+		It("should export an Excel workbook", func() {
+			data := []byte(export(models.ExportOptions{Format: models.ExportXLSX, Columns: []string{"id", "last_name", "manager_id"}, Filter: models.UserListParams{IncludeDeleted: true}}))
+			archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			Expect(err).To(BeNil())
+
+			var names []string
+			var sheet string
+			for _, f := range archive.File {
+				names = append(names, f.Name)
+				if f.Name == "xl/worksheets/sheet1.xml" {
+					r, err := f.Open()
+					Expect(err).To(BeNil())
+					b, _ := io.ReadAll(r)
+					sheet = string(b)
+				}
+			}
+			Expect(names).To(ConsistOf("[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"))
+			Expect(sheet).To(ContainSubstring(`<row r="1"><c r="A1" t="inlineStr"><is><t xml:space="preserve">id</t></is></c>`))
+			Expect(sheet).To(ContainSubstring(`<row r="3"><c r="A3"><v>2</v></c><c r="B3" t="inlineStr"><is><t xml:space="preserve">O&#39;Neil, Jr.</t></is></c><c r="C3"><v>1</v></c></row>`))
+			Expect(sheet).To(ContainSubstring(`<t xml:space="preserve">&lt;Moe&gt;</t></is></c></row></sheetData></worksheet>`))
+		})
+
+		It("should keep spreadsheets from evaluating what users entered", func() {
+			for i, name := range []string{`=HYPERLINK("http://evil.example","x")`, "+cmd|' /C calc'!A0", "-2+3", "@SUM(A1)", "\tTab", "\rCR"} {
+				user := newUser(fmt.Sprintf("user%d", i))
+				user.FirstName = name
+				Expect(userService.CreateUser(ctx, user)).To(Succeed())
+			}
+			Expect(export(models.ExportOptions{Columns: []string{"first_name"}, Filter: models.UserListParams{UserNamePrefix: "user"}})).To(Equal(
+				"first_name\n" +
+					"\"'=HYPERLINK(\"\"http://evil.example\"\",\"\"x\"\")\"\n" +
+					"'+cmd|' /C calc'!A0\n" +
+					"'-2+3\n" +
+					"'@SUM(A1)\n" +
+					"'\tTab\n" +
+					"\"'\rCR\"\n"))
+
+			data := []byte(export(models.ExportOptions{Format: models.ExportXLSX, Columns: []string{"first_name"}, Filter: models.UserListParams{UserNamePrefix: "user"}}))
+			archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			Expect(err).To(BeNil())
+			sheet, err := archive.Open("xl/worksheets/sheet1.xml")
+			Expect(err).To(BeNil())
+			b, _ := io.ReadAll(sheet)
+			Expect(string(b)).To(ContainSubstring(`<t xml:space="preserve">&#39;=HYPERLINK(&#34;http://evil.example&#34;,&#34;x&#34;)</t>`))
+			Expect(string(b)).To(ContainSubstring(`<t xml:space="preserve">&#39;@SUM(A1)</t>`))
+
+			// NDJSON is not opened as a spreadsheet, so it holds values as they are
+			Expect(export(models.ExportOptions{Format: models.ExportNDJSON, Columns: []string{"first_name"}, Filter: models.UserListParams{UserNamePrefix: "user2"}})).To(Equal(`{"first_name":"-2+3"}` + "\n"))
+		})
+
+		It("should reject unknown formats and columns", func() {
+			var buf bytes.Buffer
+			err := userService.ExportUsers(ctx, &buf, models.ExportOptions{Format: "pdf"})
+			Expect(apperrors.KindOf(err)).To(Equal(apperrors.Invalid))
+			err = userService.ExportUsers(ctx, &buf, models.ExportOptions{Columns: []string{"user_name", "password"}})
+			Expect(err).To(MatchError(services.ErrInvalidExport))
+			Expect(err).To(HaveField("Message", `unknown column "password"`))
+			err = userService.ExportUsers(ctx, &buf, models.ExportOptions{Columns: []string{"id", "id"}})
+			Expect(apperrors.KindOf(err)).To(Equal(apperrors.Invalid))
+			Expect(buf.Len()).To(BeZero())
+		})
+	})
+
+	It("should read users a page at a time, holding no statement open while they are written", func() {
+		store := repositories.NewUserRepository(openUserSQLite())
+		for i := 0; i < 1100; i++ {
+			Expect(store.CreateUser(ctx, newUser(fmt.Sprintf("user%04d", i)))).To(Succeed())
+		}
+
+		for _, sort := range []string{"id", "-user_name"} {
+			// The database has a single connection, which the lookups need.
+			timeout, cancel := context.WithTimeout(ctx, 10*time.Second)
+			defer cancel()
+			var userNames []string
+			err := store.StreamUsers(timeout, models.UserListParams{Sort: sort}, func(user models.User) error {
+				_, err := store.GetUserByID(timeout, user.ID, false)
+				userNames = append(userNames, user.UserName)
+				return err
+			})
+			Expect(err).To(BeNil())
+			Expect(userNames).To(HaveLen(1100))
+			if sort == "id" {
+				Expect(userNames[0]).To(Equal("user0000"))
+				Expect(userNames[1099]).To(Equal("user1099"))
+			} else {
+				Expect(userNames[0]).To(Equal("user1099"))
+				Expect(userNames[1099]).To(Equal("user0000"))
+			}
+		}
+	})
+
+	Context("over HTTP", func() {
+		var e *echo.Echo
+
+		BeforeEach(func() {
+			e = echo.New()
+			e.HTTPErrorHandler = controllers.ErrorHandler
+			e.GET("/users/export", controllers.ExportUsers(newService(repositories.NewMemoryUserRepository())))
+		})
+
+		get := func(target string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+			return rec
+		}
+
+		It("should stream the export as an attachment", func() {
+			rec := get("/users/export?columns=user_name,+email&department=IT&status=I")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get(echo.HeaderContentType)).To(Equal("text/csv"))
+			Expect(rec.Header().Get(echo.HeaderContentDisposition)).To(Equal(`attachment; filename="users.csv"`))
+			Expect(rec.Body.String()).To(Equal("user_name,email\nbob,bob@example.org\n"))
+
+			rec = get("/users/export?format=xlsx")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get(echo.HeaderContentDisposition)).To(Equal(`attachment; filename="users.xlsx"`))
+			Expect(rec.Body.Bytes()[:2]).To(Equal([]byte("PK")))
+		})
+
+		It("should not cut off exports that take longer than the server's write timeout", func() {
+			userService := services.NewUserService(repositories.NewUserRepository(openUserSQLite()))
+			for i := 0; i < 300; i++ {
+				userName := fmt.Sprintf("user%03d", i)
+				Expect(userService.CreateUser(ctx, newUser(userName))).To(Succeed())
+			}
+			slow := echo.New()
+			slow.HTTPErrorHandler = controllers.ErrorHandler
+			slow.GET("/users/export", controllers.ExportUsers(userService), func(next echo.HandlerFunc) echo.HandlerFunc {
+				return func(c echo.Context) error {
+					c.Response().After(func() { time.Sleep(10 * time.Millisecond) })
+					return next(c)
+				}
+			})
+			server := httptest.NewUnstartedServer(slow)
+			server.Config.WriteTimeout = 50 * time.Millisecond
+			server.Start()
+			DeferCleanup(server.Close)
+
+			res, err := http.Get(server.URL + "/users/export?format=ndjson")
+			Expect(err).To(BeNil())
+			defer res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			body, err := io.ReadAll(res.Body)
+			Expect(err).To(BeNil())
+			lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+			Expect(lines).To(HaveLen(300))
+			Expect(lines[299]).To(ContainSubstring(`"user_name":"user299"`))
+		})
+
+		It("should cut off exports that take longer than the export timeout", func() {
+			userService := services.NewUserService(repositories.NewUserRepository(openUserSQLite()))
+			for i := 0; i < 300; i++ {
+				Expect(userService.CreateUser(ctx, newUser(fmt.Sprintf("user%03d", i)))).To(Succeed())
+			}
+			slow := echo.New()
+			slow.HTTPErrorHandler = controllers.ErrorHandler
+			slow.GET("/users/export", controllers.ExportUsers(userService), controllers.Timeout(100*time.Millisecond), func(next echo.HandlerFunc) echo.HandlerFunc {
+				return func(c echo.Context) error {
+					c.Response().After(func() { time.Sleep(10 * time.Millisecond) })
+					return next(c)
+				}
+			})
+			server := httptest.NewServer(slow)
+			DeferCleanup(server.Close)
+
+			start := time.Now()
+			res, err := http.Get(server.URL + "/users/export?format=ndjson")
+			Expect(err).To(BeNil())
+			defer res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			body, _ := io.ReadAll(res.Body)
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			Expect(strings.Count(string(body), "\n")).To(BeNumerically("<", 300))
+		})
+
+		It("should answer invalid requests with a problem", func() {
+			for _, target := range []string{"/users/export?format=pdf", "/users/export?columns=secret", "/users/export?sort=password", "/users/export?include_deleted=maybe"} {
+				rec := get(target)
+				Expect(rec.Code).To(Equal(http.StatusBadRequest), target)
+				Expect(rec.Header().Get(echo.HeaderContentType)).To(Equal(controllers.MIMEProblemJSON))
+				Expect(rec.Header().Get(echo.HeaderContentDisposition)).To(BeEmpty())
+			}
+		})
+	})
+})
@@ -0,0 +1,257 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"user-service/apperrors"
+	"user-service/controllers"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("User import", func() {
+
+	const header = "user_name,email,first_name,last_name,status,department\n"
+
+	forEachStore(func(newStores func() testStores) {
+		var userService *services.UserService
+
+		importCSV := func(csv string, opts models.ImportOptions) *models.ImportReport {
+			report, err := userService.ImportUsers(ctx, strings.NewReader(csv), services.CSVType, opts)
+			Expect(err).To(BeNil())
+			return report
+		}
+
+		userNames := func() []string {
+			users, err := userService.GetAllUsers(ctx)
+			Expect(err).To(BeNil())
+			var names []string
+			for _, user := range users {
+				names = append(names, user.UserName)
+			}
+			return names
+		}
+
+		BeforeEach(func() {
+			userService = services.NewUserService(newStores().Users)
+			Expect(userService.CreateUser(ctx, &models.User{UserName: "ann", Email: "ann@example.com", FirstName: "A", LastName: "N", Status: "A", Department: "IT"})).To(Succeed())
+			Expect(userService.CreateUser(ctx, &models.User{UserName: "bob", Email: "bob@example.com", FirstName: "B", LastName: "O", Status: "A", Department: "IT"})).To(Succeed())
+		})
+
+		It("should create new users and skip existing ones", func() {
+			report := importCSV(header+
+				"ann,new@example.com,A,N,A,IT\n"+
+				"cid,cid@example.com,C,I,,HR\n", models.ImportOptions{})
+			Expect(report.Mode).To(Equal(models.ImportCreate))
+			Expect(report.Created).To(Equal(1))
+			Expect(report.Skipped).To(Equal(1))
+			Expect(report.Rows[0]).To(Equal(models.ImportRow{Line: 2, UserName: "ann", UserID: 1, Result: models.ImportSkipped}))
+			Expect(report.Rows[1].Result).To(Equal(models.ImportCreated))
+			Expect(report.Rows[1].UserID).NotTo(BeZero())
+
+			created, err := userService.GetUser(ctx, report.Rows[1].UserID, false)
+			Expect(err).To(BeNil())
+			Expect(created.Status).To(Equal(models.StatusActive))
+			Expect(created.Department).To(Equal("HR"))
+			ann, _ := userService.GetUser(ctx, 1, false)
+			Expect(ann.Email).To(Equal("ann@example.com"))
+		})
+
+		It("should update only the given fields in upsert mode and skip unchanged users", func() {
+			report := importCSV("user_name,email\nann,ann@example.org\nbob,bob@example.com\n", models.ImportOptions{Mode: models.ImportUpsert})
+			Expect(report.Updated).To(Equal(1))
+			Expect(report.Skipped).To(Equal(1))
+
+			ann, _ := userService.GetUser(ctx, 1, false)
+			Expect(ann.Email).To(Equal("ann@example.org"))
+			Expect(ann.FirstName).To(Equal("A"))
+			Expect(ann.Version).To(Equal(2))
+			bob, _ := userService.GetUser(ctx, 2, false)
+			Expect(bob.Version).To(Equal(1))
+		})
+
+		It("should delete the users missing from the import in replace mode", func() {
+			report := importCSV("user_name,email\nann,ann@example.com\n", models.ImportOptions{Mode: models.ImportReplace})
+			Expect(report.Skipped).To(Equal(1))
+			Expect(report.Deleted).To(Equal(1))
+			Expect(report.Rows[1]).To(Equal(models.ImportRow{UserName: "bob", UserID: 2, Result: models.ImportDeleted}))
+			Expect(userNames()).To(ConsistOf("ann"))
+		})
+
+		It("should write nothing in a dry run", func() {
+			report := importCSV(header+"cid,cid@example.com,C,I,A,HR\n", models.ImportOptions{Mode: models.ImportReplace, DryRun: true})
+			Expect(report.DryRun).To(BeTrue())
+			Expect(report.Created).To(Equal(1))
+			Expect(report.Deleted).To(Equal(2))
+			Expect(userNames()).To(ConsistOf("ann", "bob"))
+		})
+
+		It("should report the rows that fail and apply the others", func() {
+			report := importCSV(header+
+				"cid,not-an-email,C,I,A,HR\n"+
+				"dan,dan@example.com,D,A,A,HR\n"+
+				"dan,dan2@example.com,D,A,A,HR\n"+
+				"eve,eve@example.com\n"+
+				"bob,bob@example.com,B,O,X,IT\n", models.ImportOptions{Mode: models.ImportUpsert})
+			Expect(report.Created).To(Equal(1))
+			Expect(report.Errored).To(Equal(4))
+
+			Expect(report.Rows[0].Result).To(Equal(models.ImportError))
+			Expect(report.Rows[0].Errors).To(ContainElement(HaveField("Field", "email")))
+			Expect(report.Rows[2].Error).To(Equal("user_name dan is also on line 3"))
+			Expect(report.Rows[3].Error).To(Equal("expected 6 values, got 2"))
+			Expect(report.Rows[4].Errors).To(ContainElement(HaveField("Field", "status")))
+			Expect(userNames()).To(ConsistOf("ann", "bob", "dan"))
+		})
+
+		It("should refuse status transitions that are not allowed", func() {
+			Expect(importCSV("user_name,status\nann,T\n", models.ImportOptions{Mode: models.ImportUpsert}).Updated).To(Equal(1))
+			report := importCSV("user_name,status\nann,A\n", models.ImportOptions{Mode: models.ImportUpsert})
+			Expect(report.Errored).To(Equal(1))
+			ann, _ := userService.GetUser(ctx, 1, false)
+			Expect(ann.Status).To(Equal(models.StatusTerminated))
+		})
+
+		It("should map columns and apply the writes in batches", func() {
+			report := importCSV("\ufeffLogin,Mail,First,Last,Dept\n"+
+				"cid,cid@example.com,C,I,HR\n"+
+				"dan,dan@example.com,D,A,HR\n"+
+				"eve,eve@example.com,E,V,HR\n", models.ImportOptions{
+				BatchSize: 2,
+				Mapping:   map[string]string{"Login": "user_name", "Mail": "email", "First": "first_name", "Last": "last_name", "Dept": "department"},
+			})
+			Expect(report.Created).To(Equal(3))
+			Expect(userNames()).To(ConsistOf("ann", "bob", "cid", "dan", "eve"))
+		})
+
+		It("should report the batches written before one failed as a whole", func() {
+			userService.Repo = newFailingWrites(userService.Repo, 1)
+			report, err := userService.ImportUsers(ctx, strings.NewReader(header+
+				"cid,cid@example.com,C,I,A,HR\n"+
+				"dan,dan@example.com,D,A,A,HR\n"+
+				"eve,eve@example.com,E,V,A,HR\n"), services.CSVType, models.ImportOptions{BatchSize: 2})
+			Expect(err).To(MatchError(errWritesFailed))
+			Expect(report.Created).To(Equal(2))
+			Expect(report.NotApplied).To(Equal(1))
+			Expect(report.Rows[1].UserID).NotTo(BeZero())
+			Expect(report.Rows[2]).To(Equal(models.ImportRow{Line: 4, UserName: "eve", Result: models.ImportNotApplied}))
+			Expect(userNames()).To(ConsistOf("ann", "bob", "cid", "dan"))
+		})
+
+		It("should read NDJSON", func() {
+			report, err := userService.ImportUsers(ctx, strings.NewReader(
+				`{"user_name":"cid","email":"cid@example.com","first_name":"C","last_name":"I","department":"HR","manager_id":1}`+"\n"+
+					"\n"+
+					`{"user_name":"dan","nickname":"d"}`+"\n"+
+					`not json`+"\n"), services.NDJSONType, models.ImportOptions{})
+			Expect(err).To(BeNil())
+			Expect(report.Created).To(Equal(1))
+			Expect(report.Errored).To(Equal(2))
+			Expect(report.Rows[1]).To(HaveField("Line", 3))
+			Expect(report.Rows[1].Error).To(Equal(`unknown column "nickname"`))
+
+			cid, _ := userService.GetUser(ctx, report.Rows[0].UserID, false)
+			Expect(*cid.ManagerID).To(Equal(1))
+		})
+
+		It("should reject invalid imports as a whole", func() {
+			_, err := userService.ImportUsers(ctx, strings.NewReader("email\nx@example.com\n"), services.CSVType, models.ImportOptions{})
+			Expect(apperrors.KindOf(err)).To(Equal(apperrors.Invalid))
+			_, err = userService.ImportUsers(ctx, strings.NewReader(header), services.CSVType, models.ImportOptions{Mode: "merge"})
+			Expect(apperrors.KindOf(err)).To(Equal(apperrors.Invalid))
+			_, err = userService.ImportUsers(ctx, strings.NewReader(header), "application/json", models.ImportOptions{})
+			Expect(err).To(MatchError(services.ErrUnsupportedImportType))
+		})
+	})
+
+	Context("over HTTP", func() {
+		var e *echo.Echo
+
+		BeforeEach(func() {
+			userService := services.NewUserService(repositories.NewMemoryUserRepository())
+			e = echo.New()
+			e.HTTPErrorHandler = controllers.ErrorHandler
+			e.POST("/users/import", controllers.ImportUsers(userService))
+		})
+
+		request := func(target, contentType, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, contentType)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec
+		}
+
+		It("should report every row", func() {
+			rec := request("/users/import?dry_run=true&mapping=Login:user_name", "text/csv; charset=utf-8",
+				"Login,email,first_name,last_name,department\nann,ann@example.com,A,N,IT\nbob,bad,B,O,IT\n")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"dry_run":true,"created":1,"updated":0,"skipped":0,"deleted":0,"errored":1`))
+			Expect(rec.Body.String()).To(ContainSubstring(`"field":"email"`))
+
+			rec = request("/users/import", "application/ndjson", `{"user_name":"ann","email":"ann@example.com","first_name":"A","last_name":"N","department":"IT"}`)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"created":1`))
+		})
+
+		It("should answer with the report and the failure of a partial import", func() {
+			userService := services.NewUserService(newFailingWrites(repositories.NewMemoryUserRepository(), 1))
+			e = echo.New()
+			e.HTTPErrorHandler = controllers.ErrorHandler
+			e.POST("/users/import", controllers.ImportUsers(userService))
+
+			rec := request("/users/import?batch_size=1", "text/csv", header+
+				"cid,cid@example.com,C,I,A,HR\n"+
+				"dan,dan@example.com,D,A,A,HR\n")
+			Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+			Expect(rec.Body.String()).To(ContainSubstring(`"created":1,`))
+			Expect(rec.Body.String()).To(ContainSubstring(`"not_applied":1,"error":"Internal Server Error"`))
+
+			rec = request("/users/import", "text/csv", header+"eve,eve@example.com,E,V,A,HR\n")
+			Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+			Expect(rec.Header().Get(echo.HeaderContentType)).To(Equal(controllers.MIMEProblemJSON))
+		})
+
+		It("should reject invalid requests", func() {
+			Expect(request("/users/import", "application/json", "{}").Code).To(Equal(http.StatusUnsupportedMediaType))
+			Expect(request("/users/import?mode=merge", "text/csv", header).Code).To(Equal(http.StatusBadRequest))
+			Expect(request("/users/import?batch_size=-1", "text/csv", header).Code).To(Equal(http.StatusBadRequest))
+			Expect(request("/users/import?mapping=Login", "text/csv", header).Code).To(Equal(http.StatusBadRequest))
+			Expect(request("/users/import", "text/csv", "").Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
+
+var errWritesFailed = errors.New("writes failed")
+
+// failingWrites is a user store whose batch writes fail once a number of
+// them have gone through, counted across the stores it returns.
+type failingWrites struct {
+	repositories.UserStore
+	succeed *int
+}
+
+func newFailingWrites(store repositories.UserStore, succeed int) *failingWrites {
+	return &failingWrites{UserStore: store, succeed: &succeed}
+}
+
+func (s *failingWrites) WithAudit(ac models.AuditContext) repositories.UserStore {
+	return &failingWrites{UserStore: s.UserStore.WithAudit(ac), succeed: s.succeed}
+}
+
+func (s *failingWrites) WriteUsers(ctx context.Context, writes []repositories.UserWrite) ([]error, error) {
+	if *s.succeed == 0 {
+		return nil, errWritesFailed
+	}
+	*s.succeed--
+	return s.UserStore.WriteUsers(ctx, writes)
+}
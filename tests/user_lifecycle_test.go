@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"time"
+
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/services"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("User soft delete lifecycle", func() {
+
+	forEachStore(func(newStores func() testStores) {
+		var (
+			userService *services.UserService
+			user        *models.User
+		)
+
+		BeforeEach(func() {
+			userService = services.NewUserService(newStores().Users)
+			user = &models.User{UserName: "john_doe", Email: "john@example.com", FirstName: "John", LastName: "Doe", Status: "A", Department: "IT"}
+			Expect(userService.CreateUser(ctx, user)).To(Succeed())
+			Expect(userService.DeleteUser(ctx, user.ID, user.Version)).To(Succeed())
+		})
+
+		It("should hide deleted users unless asked for", func() {
+			_, err := userService.GetUser(ctx, user.ID, false)
+			Expect(err).To(MatchError(repositories.ErrUserNotFound))
+
+			deleted, err := userService.GetUser(ctx, user.ID, true)
+			Expect(err).To(BeNil())
+			Expect(deleted.DeletedAt).NotTo(BeNil())
+			Expect(deleted.Version).To(Equal(2))
+
+			page, err := userService.ListUsers(ctx, models.UserListParams{})
+			Expect(err).To(BeNil())
+			Expect(page.Total).To(Equal(0))
+			page, err = userService.ListUsers(ctx, models.UserListParams{IncludeDeleted: true})
+			Expect(err).To(BeNil())
+			Expect(page.Total).To(Equal(1))
+		})
+
+		It("should not allow updates or a second delete of a deleted user", func() {
+			user.Version = 0
+			Expect(userService.UpdateUser(ctx, user)).To(MatchError(repositories.ErrUserNotFound))
+			Expect(userService.DeleteUser(ctx, user.ID, 0)).To(MatchError(repositories.ErrUserNotFound))
+		})
+
+		It("should restore deleted users", func() {
+			Expect(userService.PurgeUser(ctx, user.ID)).To(Succeed())
+			_, err := userService.RestoreUser(ctx, user.ID, 0)
+			Expect(err).To(MatchError(repositories.ErrUserNotFound))
+		})
+
+		It("should restore a deleted user once", func() {
+			_, err := userService.RestoreUser(ctx, user.ID, 1)
+			Expect(err).To(MatchError(repositories.ErrVersionConflict))
+
+			restored, err := userService.RestoreUser(ctx, user.ID, 2)
+			Expect(err).To(BeNil())
+			Expect(restored.DeletedAt).To(BeNil())
+			Expect(restored.Version).To(Equal(3))
+
+			_, err = userService.RestoreUser(ctx, user.ID, 0)
+			Expect(err).To(MatchError(repositories.ErrUserNotDeleted))
+			Expect(userService.PurgeUser(ctx, user.ID)).To(MatchError(repositories.ErrUserNotDeleted))
+		})
+
+		It("should purge users deleted longer ago than the retention period", func() {
+			purged, err := userService.PurgeDeletedUsers(ctx, time.Hour)
+			Expect(err).To(BeNil())
+			Expect(purged).To(Equal(0))
+
+			purged, err = userService.PurgeDeletedUsers(ctx, -time.Minute)
+			Expect(err).To(BeNil())
+			Expect(purged).To(Equal(1))
+			_, err = userService.GetUser(ctx, user.ID, true)
+			Expect(err).To(MatchError(repositories.ErrUserNotFound))
+		})
+	})
+})
@@ -0,0 +1,40 @@
+package tests
+
+import (
+	"user-service/models"
+	"user-service/services"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("User listing", func() {
+
+	forEachStore(func(newStores func() testStores) {
+		var userService *services.UserService
+
+		userNames := func(params models.UserListParams) []string {
+			page, err := userService.ListUsers(ctx, params)
+			Expect(err).To(BeNil())
+			names := []string{}
+			for _, user := range page.Users {
+				names = append(names, user.UserName)
+			}
+			return names
+		}
+
+		BeforeEach(func() {
+			userService = services.NewUserService(newStores().Users)
+			for _, userName := range []string{"JDoe", "jdoe_2", "jane", "Jd_x", "bob"} {
+				Expect(userService.CreateUser(ctx, newUser(userName))).To(Succeed())
+			}
+		})
+
+		It("should match user name prefixes case-insensitively", func() {
+			Expect(userNames(models.UserListParams{UserNamePrefix: "jdoe", Sort: "id"})).To(Equal([]string{"JDoe", "jdoe_2"}))
+			Expect(userNames(models.UserListParams{UserNamePrefix: "JD", Sort: "id"})).To(Equal([]string{"JDoe", "jdoe_2", "Jd_x"}))
+			Expect(userNames(models.UserListParams{UserNamePrefix: "jd_", Sort: "id"})).To(Equal([]string{"Jd_x"}))
+			Expect(userNames(models.UserListParams{UserNamePrefix: "BOB", Sort: "id"})).To(Equal([]string{"bob"}))
+		})
+	})
+})
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"user-service/controllers"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/services"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PatchUser", func() {
+	var (
+		userService *services.UserService
+		e           *echo.Echo
+		user        *models.User
+		ifMatch     string
+	)
+
+	patch := func(contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/users/1", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, contentType)
+		req.Header.Set("If-Match", ifMatch)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		handle(controllers.PatchUser(userService), c)
+		return rec
+	}
+
+	BeforeEach(func() {
+		userService = services.NewUserService(repositories.NewMemoryUserRepository())
+		e = echo.New()
+		user = &models.User{UserName: "john_doe", Email: "john@example.com", FirstName: "John", LastName: "Doe", Status: "A", Department: "IT"}
+		Expect(userService.CreateUser(ctx, user)).To(Succeed())
+		ifMatch = `"1"`
+	})
+
+	It("should apply a JSON Merge Patch", func() {
+		rec := patch("application/merge-patch+json", `{"department": "HR", "last_name": "Smith"}`)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		var patched models.User
+		json.Unmarshal(rec.Body.Bytes(), &patched)
+		Expect(patched.Department).To(Equal("HR"))
+		Expect(patched.LastName).To(Equal("Smith"))
+		Expect(patched.UserName).To(Equal("john_doe"))
+
+		Expect(rec.Header().Get("ETag")).To(Equal(`"2"`))
+		stored, _ := userService.GetUser(ctx, 1, false)
+		Expect(stored.Department).To(Equal("HR"))
+		Expect(stored.Version).To(Equal(2))
+	})
+
+	It("should return 412 for a stale If-Match and 428 without one", func() {
+		Expect(patch("application/merge-patch+json", `{"department": "HR"}`).Code).To(Equal(http.StatusOK))
+		Expect(patch("application/merge-patch+json", `{"department": "Sales"}`).Code).To(Equal(http.StatusPreconditionFailed))
+
+		ifMatch = ""
+		Expect(patch("application/merge-patch+json", `{"department": "Sales"}`).Code).To(Equal(http.StatusPreconditionRequired))
+
+		ifMatch = "*"
+		Expect(patch("application/merge-patch+json", `{"department": "Sales"}`).Code).To(Equal(http.StatusOK))
+	})
+
+	It("should apply a JSON Patch", func() {
+		rec := patch("application/json-patch+json; charset=utf-8",
+			`[{"op": "test", "path": "/status", "value": "A"}, {"op": "replace", "path": "/status", "value": "I"}]`)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		stored, _ := userService.GetUser(ctx, 1, false)
+		Expect(stored.Status).To(Equal("I"))
+	})
+
+	It("should return 409 when a JSON Patch test operation fails", func() {
+		rec := patch("application/json-patch+json", `[{"op": "test", "path": "/status", "value": "T"}]`)
+		Expect(rec.Code).To(Equal(http.StatusConflict))
+	})
+
+	It("should re-validate the patched user", func() {
+		rec := patch("application/merge-patch+json", `{"email": "not-an-email"}`)
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+
+		rec = patch("application/merge-patch+json", `{"department": null}`)
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+
+		stored, _ := userService.GetUser(ctx, 1, false)
+		Expect(stored.Email).To(Equal("john@example.com"))
+		Expect(stored.Department).To(Equal("IT"))
+	})
+
+	It("should reject unknown fields, ID changes and other media types", func() {
+		Expect(patch("application/merge-patch+json", `{"salary": 1}`).Code).To(Equal(http.StatusBadRequest))
+		Expect(patch("application/merge-patch+json", `{"id": 2}`).Code).To(Equal(http.StatusBadRequest))
+		Expect(patch("application/json", `{"department": "HR"}`).Code).To(Equal(http.StatusUnsupportedMediaType))
+	})
+
+	It("should write only the changed columns", func() {
+		db, mock, err := sqlmock.New()
+		Expect(err).To(BeNil())
+		defer db.Close()
+		userService = services.NewUserService(repositories.NewUserRepository(db))
+
+		user := models.User{ID: 1, UserName: "john_doe", Email: "john@example.com", FirstName: "John", LastName: "Doe", Status: "A", Department: "IT", Version: 1}
+		expectUserLoad(mock, 1, &user)
+		mock.ExpectBegin()
+		expectUserLoad(mock, 1, &user)
+		mock.ExpectQuery(`UPDATE users SET department = \?, version = version \+ 1 WHERE deleted_at IS NULL AND id = \? AND version = \? RETURNING version`).
+			WithArgs("HR", 1, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+		user.Department, user.Version = "HR", 2
+		expectAuditEvent(mock, models.AuditUpdate, user)
+
+		rec := patch("application/merge-patch+json", `{"department": "HR"}`)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(mock.ExpectationsWereMet()).To(BeNil())
+	})
+})
@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/controllers"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/scim"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("User status transitions", func() {
+
+	forEachStore(func(newStores func() testStores) {
+		var (
+			userService *services.UserService
+			user        *models.User
+		)
+
+		status := func() string {
+			stored, err := userService.GetUser(ctx, user.ID, true)
+			Expect(err).To(BeNil())
+			return stored.Status
+		}
+
+		change := func(to string) error {
+			_, _, err := userService.ChangeStatus(ctx, user.ID, 0, models.StatusChange{Status: to})
+			return err
+		}
+
+		BeforeEach(func() {
+			stores := newStores()
+			userService = services.NewUserService(stores.Users)
+			userService.Transitions = stores.Transitions
+			user = &models.User{UserName: "ann", Email: "ann@example.com", FirstName: "A", LastName: "N", Status: "A", Department: "IT"}
+			Expect(userService.CreateUser(ctx, user)).To(Succeed())
+		})
+
+		It("should allow only the defined transitions", func() {
+			Expect(change(models.StatusInactive)).To(Succeed())
+			Expect(change(models.StatusInactive)).To(MatchError(services.ErrInvalidTransition))
+			Expect(change(models.StatusActive)).To(Succeed())
+			Expect(change(models.StatusTerminated)).To(Succeed())
+			Expect(change(models.StatusInactive)).To(MatchError(services.ErrInvalidTransition))
+			Expect(change(models.StatusActive)).To(MatchError(services.ErrInvalidTransition))
+
+			// Full and partial updates follow the same rules, without overrides
+			stored, err := userService.GetUser(ctx, user.ID, false)
+			Expect(err).To(BeNil())
+			stored.Status = models.StatusActive
+			Expect(userService.UpdateUser(ctx, stored)).To(MatchError(services.ErrInvalidTransition))
+			_, err = userService.PatchUser(ctx, user.ID, 0, services.MergePatchType, []byte(`{"status":"A"}`))
+			Expect(err).To(MatchError(services.ErrInvalidTransition))
+
+			_, _, err = userService.ChangeStatus(ctx, user.ID, 0, models.StatusChange{Status: models.StatusActive, Override: true})
+			Expect(apperrors.KindOf(err)).To(Equal(apperrors.Invalid))
+			reactivated, transition, err := userService.ChangeStatus(ctx, user.ID, 0, models.StatusChange{Status: models.StatusActive, Override: true, Reason: "rehired"})
+			Expect(err).To(BeNil())
+			Expect(reactivated.Status).To(Equal(models.StatusActive))
+			Expect(transition.State).To(Equal(models.TransitionApplied))
+
+			transitions, err := userService.ListTransitions(ctx, user.ID)
+			Expect(err).To(BeNil())
+			Expect(transitions).To(HaveLen(4))
+			Expect(transitions[0].Reason).To(Equal("rehired"))
+			Expect(transitions[0].Override).To(BeTrue())
+		})
+
+		It("should check the version", func() {
+			_, _, err := userService.ChangeStatus(ctx, user.ID, user.Version+1, models.StatusChange{Status: models.StatusInactive})
+			Expect(err).To(MatchError(repositories.ErrVersionConflict))
+			changed, _, err := userService.ChangeStatus(ctx, user.ID, user.Version, models.StatusChange{Status: models.StatusInactive})
+			Expect(err).To(BeNil())
+			Expect(changed.Version).To(Equal(user.Version + 1))
+		})
+
+		It("should apply scheduled transitions when they fall due", func() {
+			effective := time.Now().Add(time.Hour)
+			changed, transition, err := userService.ChangeStatus(ctx, user.ID, 0, models.StatusChange{Status: models.StatusTerminated, EffectiveAt: &effective})
+			Expect(err).To(BeNil())
+			Expect(changed).To(BeNil())
+			Expect(transition.State).To(Equal(models.TransitionPending))
+			Expect(status()).To(Equal(models.StatusActive))
+			Expect(change(models.StatusInactive)).To(MatchError(repositories.ErrTransitionPending))
+			Expect(status()).To(Equal(models.StatusActive))
+
+			_, _, err = userService.ChangeStatus(ctx, user.ID, 0, models.StatusChange{Status: models.StatusInactive, EffectiveAt: &effective})
+			Expect(err).To(MatchError(repositories.ErrTransitionPending))
+
+			applied, err := userService.ApplyDueTransitions(ctx, time.Now())
+			Expect(err).To(BeNil())
+			Expect(applied).To(Equal(0))
+
+			applied, err = userService.ApplyDueTransitions(ctx, effective.Add(time.Second))
+			Expect(err).To(BeNil())
+			Expect(applied).To(Equal(1))
+			Expect(status()).To(Equal(models.StatusTerminated))
+			transitions, err := userService.ListTransitions(ctx, user.ID)
+			Expect(err).To(BeNil())
+			Expect(transitions[0].State).To(Equal(models.TransitionApplied))
+			Expect(transitions[0].FinishedAt).NotTo(BeNil())
+		})
+
+		It("should keep every update from changing the status while a transition is pending", func() {
+			effective := time.Now().Add(time.Hour)
+			_, _, err := userService.ChangeStatus(ctx, user.ID, 0, models.StatusChange{Status: models.StatusTerminated, EffectiveAt: &effective})
+			Expect(err).To(BeNil())
+
+			stored, err := userService.GetUser(ctx, user.ID, false)
+			Expect(err).To(BeNil())
+			stored.Status = models.StatusInactive
+			Expect(userService.UpdateUser(ctx, stored)).To(MatchError(repositories.ErrTransitionPending))
+			_, err = userService.PatchUser(ctx, user.ID, 0, services.MergePatchType, []byte(`{"status":"I"}`))
+			Expect(err).To(MatchError(repositories.ErrTransitionPending))
+
+			scimService := services.NewSCIMService(userService, nil)
+			resource, err := scimService.GetUser(ctx, strconv.Itoa(user.ID))
+			Expect(err).To(BeNil())
+			inactive := false
+			resource.Active = &inactive
+			_, err = scimService.ReplaceUser(ctx, resource.ID, 0, resource)
+			Expect(err).To(MatchError(repositories.ErrTransitionPending))
+			_, err = scimService.PatchUser(ctx, resource.ID, 0, scim.PatchRequest{
+				Schemas:    []string{scim.SchemaPatchOp},
+				Operations: []scim.PatchOperation{{Op: "replace", Path: "active", Value: false}},
+			})
+			Expect(err).To(MatchError(repositories.ErrTransitionPending))
+
+			report, err := userService.ImportUsers(ctx, strings.NewReader("user_name,status\nann,I\n"), services.CSVType, models.ImportOptions{Mode: models.ImportUpsert})
+			Expect(err).To(BeNil())
+			Expect(report.Errored).To(Equal(1))
+			Expect(report.Rows[0].Error).To(Equal(repositories.ErrTransitionPending.Error()))
+			Expect(status()).To(Equal(models.StatusActive))
+
+			// Other fields can still change.
+			_, err = userService.PatchUser(ctx, user.ID, 0, services.MergePatchType, []byte(`{"first_name":"Ann"}`))
+			Expect(err).To(BeNil())
+		})
+
+		It("should fail transitions that are no longer allowed and cancel pending ones", func() {
+			effective := time.Now().Add(time.Hour)
+			_, transition, err := userService.ChangeStatus(ctx, user.ID, 0, models.StatusChange{Status: models.StatusInactive, EffectiveAt: &effective})
+			Expect(err).To(BeNil())
+			// The services keep users with pending transitions from changing
+			// status, but a change may still have slipped in before.
+			stored, err := userService.GetUser(ctx, user.ID, false)
+			Expect(err).To(BeNil())
+			stored.Status = models.StatusTerminated
+			Expect(userService.Repo.PatchUser(ctx, stored, []string{"status"})).To(Succeed())
+
+			applied, err := userService.ApplyDueTransitions(ctx, effective)
+			Expect(err).To(BeNil())
+			Expect(applied).To(Equal(0))
+			Expect(status()).To(Equal(models.StatusTerminated))
+			transitions, err := userService.ListTransitions(ctx, user.ID)
+			Expect(err).To(BeNil())
+			Expect(transitions).To(HaveLen(1))
+			Expect(transitions[0].ID).To(Equal(transition.ID))
+			Expect(transitions[0].State).To(Equal(models.TransitionFailed))
+			Expect(transitions[0].Error).To(ContainSubstring("cannot change status from T to I"))
+
+			_, transition, err = userService.ChangeStatus(ctx, user.ID, 0, models.StatusChange{Status: models.StatusActive, EffectiveAt: &effective, Override: true, Reason: "rehired"})
+			Expect(err).To(BeNil())
+			Expect(userService.CancelTransition(ctx, user.ID+1, transition.ID)).To(MatchError(repositories.ErrTransitionNotFound))
+			Expect(userService.CancelTransition(ctx, user.ID, transition.ID)).To(Succeed())
+			Expect(userService.CancelTransition(ctx, user.ID, transition.ID)).To(MatchError(repositories.ErrTransitionNotPending))
+			applied, err = userService.ApplyDueTransitions(ctx, effective)
+			Expect(err).To(BeNil())
+			Expect(applied).To(Equal(0))
+			Expect(status()).To(Equal(models.StatusTerminated))
+		})
+	})
+
+	Context("over HTTP", func() {
+		var e *echo.Echo
+
+		BeforeEach(func() {
+			userService := services.NewUserService(repositories.NewMemoryUserRepository())
+			userService.Transitions = repositories.NewMemoryTransitionRepository()
+			Expect(userService.CreateUser(ctx, &models.User{UserName: "ann", Email: "ann@example.com", FirstName: "A", LastName: "N", Status: "A", Department: "IT"})).To(Succeed())
+
+			e = echo.New()
+			e.HTTPErrorHandler = controllers.ErrorHandler
+			e.POST("/users/:id/deactivate", controllers.DeactivateUser(userService))
+			e.POST("/users/:id/terminate", controllers.TerminateUser(userService))
+			e.GET("/users/:id/transitions", controllers.GetUserTransitions(userService))
+			e.DELETE("/users/:id/transitions/:transition_id", controllers.CancelUserTransition(userService))
+		})
+
+		request := func(method, target, ifMatch, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, target, strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if ifMatch != "" {
+				req.Header.Set("If-Match", ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec
+		}
+
+		It("should apply immediate changes and schedule future ones", func() {
+			Expect(request(http.MethodPost, "/users/1/deactivate", "", "").Code).To(Equal(http.StatusPreconditionRequired))
+			Expect(request(http.MethodPost, "/users/1/deactivate", `"2"`, "").Code).To(Equal(http.StatusPreconditionFailed))
+
+			rec := request(http.MethodPost, "/users/1/deactivate", `"1"`, "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get("ETag")).To(Equal(`"2"`))
+			Expect(rec.Body.String()).To(ContainSubstring(`"status":"I"`))
+			Expect(request(http.MethodPost, "/users/1/deactivate", "*", "").Code).To(Equal(http.StatusConflict))
+
+			effective := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+			rec = request(http.MethodPost, "/users/1/terminate", "*", `{"effective_at":"`+effective+`","reason":"contract ends"}`)
+			Expect(rec.Code).To(Equal(http.StatusAccepted))
+			Expect(rec.Body.String()).To(ContainSubstring(`"state":"pending"`))
+			Expect(request(http.MethodPost, "/users/1/terminate", "*", `{"effective_at":"`+effective+`"}`).Code).To(Equal(http.StatusConflict))
+			Expect(request(http.MethodPost, "/users/1/terminate", "*", "").Code).To(Equal(http.StatusConflict))
+
+			rec = request(http.MethodGet, "/users/1/transitions", "", "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"reason":"contract ends"`))
+
+			Expect(request(http.MethodDelete, "/users/1/transitions/2", "", "").Code).To(Equal(http.StatusNoContent))
+			Expect(request(http.MethodDelete, "/users/1/transitions/2", "", "").Code).To(Equal(http.StatusConflict))
+			Expect(request(http.MethodDelete, "/users/1/transitions/9", "", "").Code).To(Equal(http.StatusNotFound))
+			Expect(request(http.MethodPost, "/users/1/terminate", "*", `{"effective_at":"tomorrow"}`).Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
@@ -0,0 +1,484 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"user-service/apperrors"
+	"user-service/controllers"
+	"user-service/models"
+	"user-service/repositories"
+	"user-service/services"
+
+	"github.com/labstack/echo/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Webhooks", func() {
+
+	// receiver is a webhook endpoint that records the deliveries it gets and
+	// answers them with status.
+	type receiver struct {
+		mu       sync.Mutex
+		status   int
+		requests []*http.Request
+		bodies   [][]byte
+	}
+	newReceiver := func() (*receiver, *httptest.Server) {
+		r := &receiver{status: http.StatusOK}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, err := io.ReadAll(req.Body)
+			Expect(err).To(BeNil())
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.requests = append(r.requests, req)
+			r.bodies = append(r.bodies, body)
+			w.WriteHeader(r.status)
+		}))
+		DeferCleanup(server.Close)
+		return r, server
+	}
+	answer := func(r *receiver, status int) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.status = status
+	}
+	received := func(r *receiver) int {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.requests)
+	}
+
+	forEachStore(func(newStores func() testStores) {
+		var (
+			s              testStores
+			userService    *services.UserService
+			webhookService *services.WebhookService
+			relay          *services.EventRelay
+		)
+
+		BeforeEach(func() {
+			s = newStores()
+			userService = services.NewUserService(s.Users)
+			webhookService = services.NewWebhookService(s.Webhooks)
+			relay = services.NewEventRelay(s.Outbox, map[string]services.EventSink{"webhooks": webhookService.Sink()})
+		})
+
+		It("should register, update and delete webhooks", func() {
+			created, err := webhookService.CreateWebhook(ctx, &models.Webhook{URL: "https://example.com/hook", Events: []string{models.EventUserCreated}})
+			Expect(err).To(BeNil())
+			Expect(created.Secret).To(HavePrefix(services.WebhookSecretPrefix))
+			encoded, err := json.Marshal(created)
+			Expect(err).To(BeNil())
+			Expect(string(encoded)).To(ContainSubstring(created.Secret))
+
+			own, err := webhookService.CreateWebhook(ctx, &models.Webhook{URL: "http://example.com/other", Events: []string{models.EventUserDeleted}, Secret: "s3cret"})
+			Expect(err).To(BeNil())
+			Expect(own.Secret).To(Equal("s3cret"))
+
+			webhooks, err := webhookService.ListWebhooks(ctx)
+			Expect(err).To(BeNil())
+			Expect(webhooks).To(HaveLen(2))
+			encoded, err = json.Marshal(webhooks)
+			Expect(err).To(BeNil())
+			Expect(string(encoded)).NotTo(ContainSubstring("s3cret"))
+			Expect(string(encoded)).NotTo(ContainSubstring(created.Secret))
+
+			update := &models.Webhook{ID: own.ID, URL: "https://example.com/moved", Events: []string{models.EventUserCreated, models.EventUserUpdated}}
+			Expect(webhookService.UpdateWebhook(ctx, update)).To(Succeed())
+			webhook, err := webhookService.GetWebhook(ctx, own.ID)
+			Expect(err).To(BeNil())
+			Expect(webhook.URL).To(Equal("https://example.com/moved"))
+			Expect(webhook.Events).To(Equal([]string{models.EventUserCreated, models.EventUserUpdated}))
+			Expect(webhook.Secret).To(Equal("s3cret"))
+			Expect(webhook.CreatedAt).To(BeTemporally("~", own.CreatedAt, time.Second))
+
+			Expect(webhookService.DeleteWebhook(ctx, own.ID)).To(Succeed())
+			Expect(webhookService.DeleteWebhook(ctx, own.ID)).To(MatchError(repositories.ErrWebhookNotFound))
+			_, err = webhookService.GetWebhook(ctx, own.ID)
+			Expect(err).To(MatchError(repositories.ErrWebhookNotFound))
+			Expect(webhookService.UpdateWebhook(ctx, update)).To(MatchError(repositories.ErrWebhookNotFound))
+		})
+
+		It("should reject invalid webhooks", func() {
+			_, err := webhookService.CreateWebhook(ctx, &models.Webhook{URL: "ftp://example.com", Events: []string{models.EventUserCreated}})
+			Expect(err).To(MatchError(services.ErrWebhookURL))
+			_, err = webhookService.CreateWebhook(ctx, &models.Webhook{URL: "https://example.com", Events: []string{"user.renamed"}})
+			Expect(apperrors.KindOf(err)).To(Equal(apperrors.Invalid))
+			_, err = webhookService.CreateWebhook(ctx, &models.Webhook{URL: "https://example.com"})
+			Expect(apperrors.KindOf(err)).To(Equal(apperrors.Invalid))
+			Expect(webhookService.ListWebhooks(ctx)).To(BeEmpty())
+		})
+
+		It("should POST signed events to the webhooks subscribed to them", func() {
+			r, server := newReceiver()
+			created, err := webhookService.CreateWebhook(ctx, &models.Webhook{URL: server.URL, Events: []string{models.EventUserCreated, models.EventUserDeleted}})
+			Expect(err).To(BeNil())
+
+			user := newUser("ann")
+			Expect(userService.CreateUser(ctx, user)).To(Succeed())
+			user.FirstName = "Ann"
+			Expect(userService.UpdateUser(ctx, user)).To(Succeed())
+			Expect(userService.DeleteUser(ctx, user.ID, 0)).To(Succeed())
+			Expect(relay.Relay(ctx)).To(Equal(3))
+			Expect(webhookService.Dispatch(ctx)).To(Equal(2))
+			Expect(webhookService.Dispatch(ctx)).To(Equal(0))
+
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			Expect(r.requests).To(HaveLen(2))
+			var types []string
+			for i, req := range r.requests {
+				var event models.Event
+				Expect(json.Unmarshal(r.bodies[i], &event)).To(Succeed())
+				Expect(event.User.UserName).To(Equal("ann"))
+				Expect(req.Header.Get("Content-Type")).To(Equal("application/json"))
+				Expect(req.Header.Get("X-Event-Type")).To(Equal(event.Type))
+				Expect(req.Header.Get("X-Event-Id")).To(Equal(strconv.Itoa(event.ID)))
+				Expect(req.Header.Get("X-Webhook-Id")).To(Equal(strconv.Itoa(created.ID)))
+
+				signature := req.Header.Get("X-Webhook-Signature")
+				timestamp, err := strconv.ParseInt(strings.TrimPrefix(strings.Split(signature, ",")[0], "t="), 10, 64)
+				Expect(err).To(BeNil())
+				Expect(time.Unix(timestamp, 0)).To(BeTemporally("~", time.Now(), time.Minute))
+				Expect(signature).To(Equal(services.SignWebhook(created.Secret, time.Unix(timestamp, 0), r.bodies[i])))
+				Expect(signature).NotTo(Equal(services.SignWebhook("other", time.Unix(timestamp, 0), r.bodies[i])))
+				types = append(types, event.Type)
+			}
+			Expect(types).To(ConsistOf(models.EventUserCreated, models.EventUserDeleted))
+
+			page, err := webhookService.ListDeliveries(ctx, models.WebhookDeliveryListParams{WebhookID: created.ID})
+			Expect(err).To(BeNil())
+			Expect(page.Total).To(Equal(2))
+			Expect(page.Deliveries[0].EventType).To(Equal(models.EventUserDeleted))
+			Expect(page.Deliveries[0].State).To(Equal(models.DeliverySucceeded))
+			Expect(page.Deliveries[0].Attempts).To(Equal(1))
+			Expect(page.Deliveries[0].LastStatus).To(Equal(http.StatusOK))
+			Expect(page.Deliveries[0].FinishedAt).NotTo(BeNil())
+			Expect(page.Deliveries[0].NextAttemptAt).To(BeNil())
+		})
+
+		It("should attempt due deliveries at once, up to the number of workers", func() {
+			// The receiver holds every request until it has four at a time.
+			var (
+				mu       sync.Mutex
+				inFlight int
+			)
+			all := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				mu.Lock()
+				if inFlight++; inFlight == 4 {
+					close(all)
+				}
+				mu.Unlock()
+				select {
+				case <-all:
+				case <-time.After(time.Second):
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			DeferCleanup(server.Close)
+			for range 2 {
+				_, err := webhookService.CreateWebhook(ctx, &models.Webhook{URL: server.URL, Events: []string{models.EventUserCreated}})
+				Expect(err).To(BeNil())
+			}
+			Expect(userService.CreateUser(ctx, newUser("ann"))).To(Succeed())
+			Expect(userService.CreateUser(ctx, newUser("bob"))).To(Succeed())
+			Expect(relay.Relay(ctx)).To(Equal(2))
+
+			webhookService.Workers = 4
+			start := time.Now()
+			Expect(webhookService.Dispatch(ctx)).To(Equal(4))
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+
+		It("should queue events for webhooks while another sink fails", func() {
+			r, server := newReceiver()
+			_, err := webhookService.CreateWebhook(ctx, &models.Webhook{URL: server.URL, Events: []string{models.EventUserCreated}})
+			Expect(err).To(BeNil())
+			down := &flakySink{err: errors.New("sink is down")}
+			relay.Sinks["events"] = down
+
+			Expect(userService.CreateUser(ctx, newUser("ann"))).To(Succeed())
+			Expect(userService.CreateUser(ctx, newUser("bob"))).To(Succeed())
+			delivered, err := relay.Relay(ctx)
+			Expect(err).To(MatchError(ContainSubstring("sink is down")))
+			Expect(delivered).To(Equal(2))
+			Expect(webhookService.Dispatch(ctx)).To(Equal(2))
+			Expect(received(r)).To(Equal(2))
+			Expect(s.Outbox.PendingEvents(ctx, "webhooks", 10)).To(BeEmpty())
+			Expect(s.Outbox.PendingEvents(ctx, "events", 10)).To(HaveLen(2))
+
+			down.recover()
+			Expect(relay.Relay(ctx)).To(Equal(2))
+			Expect(down.published).To(Equal([]int{1, 2}))
+			Expect(webhookService.Dispatch(ctx)).To(Equal(0))
+			Expect(received(r)).To(Equal(2))
+		})
+
+		It("should queue an event relayed twice once", func() {
+			_, server := newReceiver()
+			created, err := webhookService.CreateWebhook(ctx, &models.Webhook{URL: server.URL, Events: []string{models.EventUserCreated}})
+			Expect(err).To(BeNil())
+			Expect(userService.CreateUser(ctx, newUser("ann"))).To(Succeed())
+
+			events, err := s.Outbox.PendingEvents(ctx, "webhooks", 10)
+			Expect(err).To(BeNil())
+			Expect(events).To(HaveLen(1))
+			sink := webhookService.Sink()
+			Expect(sink.Publish(context.Background(), events[0])).To(Succeed())
+			Expect(sink.Publish(context.Background(), events[0])).To(Succeed())
+
+			page, err := webhookService.ListDeliveries(ctx, models.WebhookDeliveryListParams{WebhookID: created.ID})
+			Expect(err).To(BeNil())
+			Expect(page.Total).To(Equal(1))
+			Expect(page.Deliveries[0].State).To(Equal(models.DeliveryPending))
+		})
+
+		It("should retry failed deliveries with backoff and dead-letter them", func() {
+			r, server := newReceiver()
+			answer(r, http.StatusInternalServerError)
+			created, err := webhookService.CreateWebhook(ctx, &models.Webhook{URL: server.URL, Events: []string{models.EventUserCreated}})
+			Expect(err).To(BeNil())
+			webhookService.MaxAttempts = 3
+			webhookService.RetryDelay = 20 * time.Millisecond
+			webhookService.MaxRetryDelay = 30 * time.Millisecond
+
+			Expect(userService.CreateUser(ctx, newUser("ann"))).To(Succeed())
+			Expect(relay.Relay(ctx)).To(Equal(1))
+			Expect(webhookService.Dispatch(ctx)).To(Equal(0))
+			delivery := func() models.WebhookDelivery {
+				page, err := webhookService.ListDeliveries(ctx, models.WebhookDeliveryListParams{WebhookID: created.ID})
+				Expect(err).To(BeNil())
+				Expect(page.Deliveries).To(HaveLen(1))
+				return page.Deliveries[0]
+			}
+			first := delivery()
+			Expect(first.State).To(Equal(models.DeliveryPending))
+			Expect(first.Attempts).To(Equal(1))
+			Expect(first.LastStatus).To(Equal(http.StatusInternalServerError))
+			Expect(first.LastError).To(ContainSubstring("500 Internal Server Error"))
+			Expect(*first.NextAttemptAt).To(BeTemporally("~", time.Now().Add(20*time.Millisecond), 15*time.Millisecond))
+
+			// Not due yet.
+			Expect(webhookService.Dispatch(ctx)).To(Equal(0))
+			Expect(received(r)).To(Equal(1))
+
+			time.Sleep(25 * time.Millisecond)
+			Expect(webhookService.Dispatch(ctx)).To(Equal(0))
+			second := delivery()
+			Expect(second.Attempts).To(Equal(2))
+			Expect(*second.NextAttemptAt).To(BeTemporally("~", time.Now().Add(30*time.Millisecond), 15*time.Millisecond))
+
+			time.Sleep(35 * time.Millisecond)
+			Expect(webhookService.Dispatch(ctx)).To(Equal(0))
+			dead := delivery()
+			Expect(dead.State).To(Equal(models.DeliveryDead))
+			Expect(dead.Attempts).To(Equal(3))
+			Expect(dead.NextAttemptAt).To(BeNil())
+			Expect(dead.FinishedAt).NotTo(BeNil())
+			Expect(received(r)).To(Equal(3))
+
+			page, err := webhookService.ListDeliveries(ctx, models.WebhookDeliveryListParams{WebhookID: created.ID, State: models.DeliveryDead})
+			Expect(err).To(BeNil())
+			Expect(page.Total).To(Equal(1))
+			page, err = webhookService.ListDeliveries(ctx, models.WebhookDeliveryListParams{WebhookID: created.ID, State: models.DeliveryPending})
+			Expect(err).To(BeNil())
+			Expect(page.Total).To(BeZero())
+
+			answer(r, http.StatusNoContent)
+			redelivered, err := webhookService.Redeliver(ctx, created.ID, dead.ID)
+			Expect(err).To(BeNil())
+			Expect(redelivered.State).To(Equal(models.DeliveryPending))
+			Expect(redelivered.Attempts).To(BeZero())
+			Expect(redelivered.FinishedAt).To(BeNil())
+			Expect(delivery()).To(Equal(*redelivered))
+			Expect(received(r)).To(Equal(3))
+
+			Expect(webhookService.Dispatch(ctx)).To(Equal(1))
+			succeeded := delivery()
+			Expect(succeeded.State).To(Equal(models.DeliverySucceeded))
+			Expect(succeeded.Attempts).To(Equal(1))
+			Expect(succeeded.LastStatus).To(Equal(http.StatusNoContent))
+			Expect(succeeded.LastError).To(BeEmpty())
+			Expect(received(r)).To(Equal(4))
+
+			_, err = webhookService.Redeliver(ctx, created.ID+1, dead.ID)
+			Expect(err).To(MatchError(repositories.ErrWebhookNotFound))
+			_, err = webhookService.Redeliver(ctx, created.ID, dead.ID+1)
+			Expect(err).To(MatchError(repositories.ErrDeliveryNotFound))
+		})
+
+		It("should redeliver only deliveries left as they were read", func() {
+			_, server := newReceiver()
+			created, err := webhookService.CreateWebhook(ctx, &models.Webhook{URL: server.URL, Events: []string{models.EventUserCreated}})
+			Expect(err).To(BeNil())
+			Expect(userService.CreateUser(ctx, newUser("ann"))).To(Succeed())
+			Expect(relay.Relay(ctx)).To(Equal(1))
+			due, err := s.Webhooks.DueDeliveries(ctx, time.Now(), 10)
+			Expect(err).To(BeNil())
+			Expect(due).To(HaveLen(1))
+
+			// An attempt ends after the delivery was redelivered: its outcome
+			// is dropped and the delivery stays queued.
+			_, err = webhookService.Redeliver(ctx, created.ID, due[0].ID)
+			Expect(err).To(BeNil())
+			attempted := due[0]
+			attempted.Attempts, attempted.State = 1, models.DeliveryDead
+			Expect(s.Webhooks.UpdateDelivery(ctx, &attempted)).To(MatchError(repositories.ErrDeliveryChanged))
+			Expect(s.Webhooks.DueDeliveries(ctx, time.Now(), 10)).To(HaveLen(1))
+
+			// A delivery is redelivered only in the state it was read in.
+			Expect(webhookService.Dispatch(ctx)).To(Equal(1))
+			Expect(s.Webhooks.RequeueDelivery(ctx, &due[0], time.Now())).To(MatchError(repositories.ErrDeliveryChanged))
+			found, err := s.Webhooks.GetDelivery(ctx, due[0].ID)
+			Expect(err).To(BeNil())
+			Expect(found.State).To(Equal(models.DeliverySucceeded))
+		})
+
+		It("should record deliveries to unreachable webhooks", func() {
+			_, server := newReceiver()
+			server.Close()
+			created, err := webhookService.CreateWebhook(ctx, &models.Webhook{URL: server.URL, Events: []string{models.EventUserCreated}})
+			Expect(err).To(BeNil())
+			Expect(userService.CreateUser(ctx, newUser("ann"))).To(Succeed())
+			Expect(relay.Relay(ctx)).To(Equal(1))
+			Expect(webhookService.Dispatch(ctx)).To(Equal(0))
+
+			page, err := webhookService.ListDeliveries(ctx, models.WebhookDeliveryListParams{WebhookID: created.ID})
+			Expect(err).To(BeNil())
+			Expect(page.Deliveries[0].State).To(Equal(models.DeliveryPending))
+			Expect(page.Deliveries[0].LastStatus).To(BeZero())
+			Expect(page.Deliveries[0].LastError).To(ContainSubstring("connection refused"))
+			Expect(page.Deliveries[0].LastError).NotTo(ContainSubstring(server.URL))
+		})
+
+		It("should prune finished deliveries and delete those of deleted webhooks", func() {
+			_, server := newReceiver()
+			created, err := webhookService.CreateWebhook(ctx, &models.Webhook{URL: server.URL, Events: []string{models.EventUserCreated}})
+			Expect(err).To(BeNil())
+			Expect(userService.CreateUser(ctx, newUser("ann"))).To(Succeed())
+			Expect(userService.CreateUser(ctx, newUser("bob"))).To(Succeed())
+			Expect(relay.Relay(ctx)).To(Equal(2))
+			Expect(webhookService.Dispatch(ctx)).To(Equal(2))
+			Expect(userService.CreateUser(ctx, newUser("cid"))).To(Succeed())
+			Expect(relay.Relay(ctx)).To(Equal(1))
+
+			Expect(s.Webhooks.PruneDeliveries(ctx, time.Now().Add(-time.Hour))).To(Equal(0))
+			Expect(s.Webhooks.PruneDeliveries(ctx, time.Now().Add(time.Hour))).To(Equal(2))
+			page, err := webhookService.ListDeliveries(ctx, models.WebhookDeliveryListParams{WebhookID: created.ID})
+			Expect(err).To(BeNil())
+			Expect(page.Total).To(Equal(1))
+
+			Expect(webhookService.DeleteWebhook(ctx, created.ID)).To(Succeed())
+			Expect(s.Webhooks.DueDeliveries(ctx, time.Now(), 10)).To(BeEmpty())
+		})
+	})
+
+	Describe("over HTTP", func() {
+		var (
+			webhookService *services.WebhookService
+			e              *echo.Echo
+		)
+
+		serve := func(method, target, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, target, strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec
+		}
+
+		BeforeEach(func() {
+			webhookService = services.NewWebhookService(repositories.NewMemoryWebhookRepository())
+			e = echo.New()
+			e.HTTPErrorHandler = controllers.ErrorHandler
+			e.GET("/webhooks", controllers.ListWebhooks(webhookService))
+			e.POST("/webhooks", controllers.CreateWebhook(webhookService))
+			e.GET("/webhooks/:id", controllers.GetWebhook(webhookService))
+			e.PUT("/webhooks/:id", controllers.UpdateWebhook(webhookService))
+			e.DELETE("/webhooks/:id", controllers.DeleteWebhook(webhookService))
+			e.GET("/webhooks/:id/deliveries", controllers.GetWebhookDeliveries(webhookService))
+			e.POST("/webhooks/:id/deliveries/:delivery_id/redeliver", controllers.RedeliverWebhook(webhookService))
+		})
+
+		It("should manage webhooks and their deliveries", func() {
+			rec := serve(http.MethodPost, "/webhooks", `{"url": "https://example.com/hook", "events": ["user.created"], "secret": "s3cret"}`)
+			Expect(rec.Code).To(Equal(http.StatusCreated))
+			var created models.NewWebhook
+			Expect(json.Unmarshal(rec.Body.Bytes(), &created)).To(Succeed())
+			Expect(created.Secret).To(Equal("s3cret"))
+
+			rec = serve(http.MethodPut, "/webhooks/1", `{"url": "https://example.com/moved", "events": ["user.updated"]}`)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).NotTo(ContainSubstring("s3cret"))
+			rec = serve(http.MethodGet, "/webhooks/1", "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring("https://example.com/moved"))
+			Expect(rec.Body.String()).NotTo(ContainSubstring("s3cret"))
+
+			rec = serve(http.MethodGet, "/webhooks/1/deliveries?state=pending", "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			var page models.WebhookDeliveryPage
+			Expect(json.Unmarshal(rec.Body.Bytes(), &page)).To(Succeed())
+			Expect(page.Deliveries).To(BeEmpty())
+			Expect(page.Limit).To(Equal(repositories.DefaultPageSize))
+
+			Expect(serve(http.MethodGet, "/webhooks/1/deliveries?state=lost", "").Code).To(Equal(http.StatusBadRequest))
+			Expect(serve(http.MethodGet, "/webhooks/2/deliveries", "").Code).To(Equal(http.StatusNotFound))
+			Expect(serve(http.MethodPost, "/webhooks/1/deliveries/1/redeliver", "").Code).To(Equal(http.StatusNotFound))
+			Expect(webhookService.Sink().Publish(ctx, models.Event{ID: 1, Type: models.EventUserUpdated})).To(Succeed())
+			rec = serve(http.MethodPost, "/webhooks/1/deliveries/1/redeliver", "")
+			Expect(rec.Code).To(Equal(http.StatusAccepted))
+			var delivery models.WebhookDelivery
+			Expect(json.Unmarshal(rec.Body.Bytes(), &delivery)).To(Succeed())
+			Expect(delivery.State).To(Equal(models.DeliveryPending))
+			Expect(delivery.Attempts).To(BeZero())
+			Expect(serve(http.MethodDelete, "/webhooks/1", "").Code).To(Equal(http.StatusNoContent))
+			Expect(serve(http.MethodGet, "/webhooks", "").Body.String()).To(MatchJSON(`[]`))
+		})
+
+		It("should reject invalid webhooks", func() {
+			rec := serve(http.MethodPost, "/webhooks", `{"url": "https://example.com/hook", "events": ["user.renamed"]}`)
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+			Expect(problemOf(rec).Errors).To(ContainElement(HaveField("Rule", "oneof")))
+
+			rec = serve(http.MethodPost, "/webhooks", `{"url": "mailto:ops@example.com", "events": ["user.created"]}`)
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+			Expect(problemOf(rec).Detail).To(Equal(services.ErrWebhookURL.Message))
+		})
+	})
+})
+
+// flakySink is an EventSink that fails with err until it recovers and records
+// the IDs of the events it accepts.
+type flakySink struct {
+	mu        sync.Mutex
+	err       error
+	published []int
+}
+
+func (s *flakySink) Publish(ctx context.Context, event models.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.published = append(s.published, event.ID)
+	return nil
+}
+
+func (s *flakySink) recover() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = nil
+}